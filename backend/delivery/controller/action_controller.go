@@ -1,33 +1,52 @@
 package controller
 
 import (
+	"errors"
+	"fmt"
 	"github/chera/fix-it/domain"
 	"github/chera/fix-it/infrastructure"
 	"github/chera/fix-it/usecases"
+	"io"
 	"log"
 	"net/http"
-	"time"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type ActionController struct {
-	actionUsecase usecases.ActionUsecase
-	viewusecase   usecases.ViewUsecase
+	actionUsecase  usecases.ActionUsecase
+	viewusecase    usecases.ViewUsecase
+	jobusecase     usecases.JobUsecase
+	attemptusecase usecases.AttemptUsecase
+	reviewusecase  usecases.ReviewUsecase
 }
 
-func NewActionController(actionusecase usecases.ActionUsecase, viewusecase usecases.ViewUsecase) *ActionController {
+func NewActionController(actionusecase usecases.ActionUsecase, viewusecase usecases.ViewUsecase, jobusecase usecases.JobUsecase, attemptusecase usecases.AttemptUsecase, reviewusecase usecases.ReviewUsecase) *ActionController {
 
 	return &ActionController{
-		actionUsecase: actionusecase,
-		viewusecase:   viewusecase,
+		actionUsecase:  actionusecase,
+		viewusecase:    viewusecase,
+		jobusecase:     jobusecase,
+		attemptusecase: attemptusecase,
+		reviewusecase:  reviewusecase,
 	}
 
 }
 
+// the multipart framing and the other form fields come on top of the file
+const uploadFormOverhead = 1 << 20
+
 func (a *ActionController) UploadPDF(ctx *gin.Context) {
 
-	log.Println("Header", ctx.Request.Header)
+	limits := a.actionUsecase.UploadLimits()
+
+	// stop reading as soon as the body is over the limit instead of
+	// buffering whatever the client sends
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, limits.MaxBytes+uploadFormOverhead)
 
 	file, header, err := ctx.Request.FormFile("file")
 	userID, exist := ctx.Get("user_id")
@@ -38,6 +57,12 @@ func (a *ActionController) UploadPDF(ctx *gin.Context) {
 		return
 	}
 
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		uploadError(ctx, &infrastructure.UploadError{Code: infrastructure.UploadTooLarge, Message: fmt.Sprintf("files can be at most %d MB", limits.MaxBytes>>20)})
+		return
+	}
+
 	if err != nil {
 		log.Println(err.Error())
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "File not uploaded"})
@@ -46,33 +71,48 @@ func (a *ActionController) UploadPDF(ctx *gin.Context) {
 
 	defer file.Close()
 
-	filename := infrastructure.GetUniqueFileName()
-
-	link, err := a.actionUsecase.GetPdfLink(ctx, file, header.Filename)
+	content, err := io.ReadAll(io.LimitReader(file, limits.MaxBytes+1))
 
 	if err != nil {
 		log.Println(err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Could not upload your file"})
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Could not read your file"})
 		return
 	}
 
-	processedText, err := a.actionUsecase.ProcessPDF(ctx, link)
+	if _, err := a.actionUsecase.ValidateUpload(ctx, content, header.Filename); err != nil {
+		uploadError(ctx, err)
+		return
+	}
+
+	selection, err := pageSelectionFromForm(ctx)
 
 	if err != nil {
-		log.Println(err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "There is problem processing your pdf, try again!"})
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page selection " + err.Error()})
 		return
 	}
 
-	questions, conversation, err := a.actionUsecase.UploadForGemini(processedText)
+	options, err := quizOptionsFromForm(ctx)
+
+	if err == nil {
+		err = a.actionUsecase.PrepareQuizOptions(&options)
+	}
 
 	if err != nil {
 		log.Println(err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "There is problem processing your pdf, try again!"})
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid quiz options " + err.Error()})
 		return
 	}
 
-	questionsID, err := a.actionUsecase.UploadQuestions(ctx, questions, userID.(string))
+	forceRegenerate := false
+	if value := ctx.PostForm("force_regenerate"); value != "" {
+		forceRegenerate, err = strconv.ParseBool(value)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "force_regenerate must be true or false"})
+			return
+		}
+	}
+
+	jobID, err := a.jobusecase.EnqueueUpload(ctx, userID.(string), header.Filename, content, options, selection, forceRegenerate)
 
 	if err != nil {
 		log.Println(err.Error())
@@ -80,97 +120,206 @@ func (a *ActionController) UploadPDF(ctx *gin.Context) {
 		return
 	}
 
-	conversationdID, err := a.actionUsecase.UploadConversation(ctx, conversation)
+	ctx.JSON(http.StatusAccepted, gin.H{
+		"job_id":  jobID,
+		"message": "Your pdf is being processed",
+	})
 
-	if err != nil {
+}
+
+var uploadErrorStatus = map[string]int{
+	infrastructure.UploadTooLarge:           http.StatusRequestEntityTooLarge,
+	infrastructure.UploadUnsupportedType:    http.StatusUnsupportedMediaType,
+	infrastructure.UploadTypeMismatch:       http.StatusUnsupportedMediaType,
+	infrastructure.UploadEncrypted:          http.StatusUnprocessableEntity,
+	infrastructure.UploadCorrupt:            http.StatusUnprocessableEntity,
+	infrastructure.UploadTooManyPages:       http.StatusUnprocessableEntity,
+	infrastructure.UploadInfected:           http.StatusUnprocessableEntity,
+	infrastructure.UploadScannerUnavailable: http.StatusServiceUnavailable,
+}
+
+// uploadError answers a refused upload with its code, so clients can tell
+// the user what to fix.
+func uploadError(ctx *gin.Context, err error) {
+	var refused *infrastructure.UploadError
+	if !errors.As(err, &refused) {
 		log.Println(err.Error())
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "There is problem processing your pdf, try again!"})
 		return
 	}
 
-	oldfilename := header.Filename
+	ctx.JSON(uploadErrorStatus[refused.Code], gin.H{"error": refused.Message, "code": refused.Code})
+}
+
+// quizOptionsFromForm reads question_count, difficulty and question_types
+// from the upload form. question_types may be repeated or comma separated.
+func quizOptionsFromForm(ctx *gin.Context) (domain.QuizOptions, error) {
+	var options domain.QuizOptions
+
+	if count := ctx.PostForm("question_count"); count != "" {
+		value, err := strconv.Atoi(count)
+		if err != nil {
+			return options, errors.New("question_count must be a number")
+		}
+		options.QuestionCount = value
+	}
+
+	options.Difficulty = ctx.PostForm("difficulty")
+
+	for _, value := range ctx.PostFormArray("question_types") {
+		for _, questionType := range strings.Split(value, ",") {
+			if strings.TrimSpace(questionType) != "" {
+				options.QuestionTypes = append(options.QuestionTypes, questionType)
+			}
+		}
+	}
+
+	return options, nil
+}
+
+// pageSelectionFromForm reads pages, ranges like "3-10,12", and chapters
+// from the upload form. chapters may be repeated or comma separated.
+func pageSelectionFromForm(ctx *gin.Context) (domain.PageSelection, error) {
+	selection := domain.PageSelection{Pages: ctx.PostForm("pages")}
+
+	for _, value := range ctx.PostFormArray("chapters") {
+		for _, chapter := range strings.Split(value, ",") {
+			if strings.TrimSpace(chapter) == "" {
+				continue
+			}
+			number, err := strconv.Atoi(strings.TrimSpace(chapter))
+			if err != nil {
+				return selection, errors.New("chapters must be numbers")
+			}
+			selection.Chapters = append(selection.Chapters, number)
+		}
+	}
+
+	return selection, infrastructure.CheckPageRanges(selection.Pages)
+}
+
+type documentSectionRequest struct {
+	domain.PageSelection
+	domain.QuizOptions
+	ForceRegenerate bool `json:"force_regenerate"`
+}
+
+// CreateDocumentSection makes a new section on part of a document the user
+// already uploaded, so one document can hold a quiz per chapter.
+func (a *ActionController) CreateDocumentSection(ctx *gin.Context) {
+	documentID := ctx.Param("id")
+	userID, exist := ctx.Get("user_id")
+
+	if !exist {
+		log.Println("User ID does not exist, token problem")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized : invalid Credential"})
+		return
+	}
 
-	pdf := domain.PDF{
-		Title:   oldfilename,
-		DropBox: filename,
-		Created: time.Now().Format(time.RFC3339),
+	var request documentSectionRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil && err != io.EOF {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Please check your input"})
+		return
 	}
 
-	pdfId, err := a.actionUsecase.UploadPDF(ctx, pdf)
+	document, err := a.actionUsecase.GetDocument(ctx, documentID, userID.(string))
 
 	if err != nil {
 		log.Println(err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "There is problem processing your pdf, try again!"})
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "No such document"})
 		return
 	}
 
-	section := domain.Section{
-		SectionName:    oldfilename,
-		PDFID:          pdfId,
-		QuestionsID:    questionsID,
-		ExplanationsID: conversationdID,
-		CreatedBy:      userID.(string),
+	if err := checkSelection(request.PageSelection, document); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page selection " + err.Error()})
+		return
 	}
 
-	sectionID, err := a.actionUsecase.UploadSection(ctx, section)
+	if err := a.actionUsecase.PrepareQuizOptions(&request.QuizOptions); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid quiz options " + err.Error()})
+		return
+	}
+
+	jobID, err := a.jobusecase.EnqueueSection(ctx, userID.(string), document, request.QuizOptions, request.PageSelection, request.ForceRegenerate)
 
 	if err != nil {
 		log.Println(err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "There is problem processing your pdf, try again!"})
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "There is problem processing your document, try again!"})
 		return
 	}
 
-	ctx.JSON(200, gin.H{
-		"section_id": sectionID,
-		"message":    "Your pdf is processed successfully",
+	ctx.JSON(http.StatusAccepted, gin.H{
+		"job_id":  jobID,
+		"message": "Your section is being created",
 	})
-
 }
 
-func (a *ActionController) QuizAnswer(ctx *gin.Context) {
+// checkSelection checks a selection against what is known of a document.
+// Documents stored before page counts were kept are checked by the job.
+func checkSelection(selection domain.PageSelection, document domain.Document) error {
+	if err := infrastructure.CheckPageRanges(selection.Pages); err != nil {
+		return err
+	}
 
-	sectionID := ctx.DefaultQuery("section_id", "")
-	userID, exist := ctx.Get("user_id")
+	if document.PageCount > 0 {
+		if _, err := infrastructure.ParsePageRanges(selection.Pages, document.PageCount); err != nil {
+			return err
+		}
+	}
 
-	if sectionID == "" {
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": "Section id is required",
-		})
-		return
+	for _, chapter := range selection.Chapters {
+		if len(document.Chapters) > 0 && (chapter < 1 || chapter > len(document.Chapters)) {
+			return errors.New("chapter " + strconv.Itoa(chapter) + " does not exist")
+		}
 	}
 
+	return nil
+}
+
+func (a *ActionController) GetJob(ctx *gin.Context) {
+	jobID := ctx.Param("id")
+	userID, exist := ctx.Get("user_id")
+
 	if !exist {
 		log.Println("User ID does not exist, token problem")
 		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized : invalid Credential"})
 		return
 	}
 
-	section, err := a.viewusecase.GetSection(ctx, sectionID, userID.(string))
+	if !primitive.IsValidObjectID(jobID) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job id"})
+		return
+	}
 
-	if err != nil {
-		log.Println(err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "There is Problem loading your data, try again!"})
+	job, err := a.jobusecase.GetJob(ctx, jobID, userID.(string))
+
+	if err == mongo.ErrNoDocuments {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "No such job"})
 		return
 	}
 
-	var answers domain.AnswerList
-	if err := ctx.ShouldBindJSON(&answers); err != nil {
+	if err != nil {
 		log.Println(err.Error())
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Please check your input, answer all the questions"})
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Problem accessing the database"})
 		return
 	}
 
-	score, taken, err := a.actionUsecase.QuizAnswer(ctx, section.QuestionsID, answers.Answers)
+	ctx.JSON(http.StatusOK, job)
+}
 
-	if err != nil {
-		log.Println(err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "There is Problem saving your answers"})
+func (a *ActionController) QuizAnswer(ctx *gin.Context) {
+
+	submission, ok := a.gradeSubmission(ctx)
+	if !ok {
 		return
 	}
 
-	if score != 20 {
-		if !taken {
-			answerID, err := a.actionUsecase.CreateExplanation(ctx, section.ExplanationsID, answers)
+	section, grade := submission.section, submission.grade
+
+	if grade.Score != grade.Total {
+		if !submission.taken {
+			answerID, err := a.actionUsecase.CreateExplanation(ctx, section.ExplanationsID, submission.answers, nil)
 			if err != nil {
 				log.Println(err.Error())
 				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Error occured may be the content of your pdf is too large."})
@@ -187,15 +336,191 @@ func (a *ActionController) QuizAnswer(ctx *gin.Context) {
 				return
 			}
 
-			ctx.JSON(http.StatusOK, gin.H{"score": score, "section_id": sectionID})
+			ctx.JSON(http.StatusOK, submission.result())
 			return
 
 		}
-		ctx.JSON(http.StatusOK, gin.H{"score": score, "section_id": sectionID, "message": "You have already taken this quiz, There would be no explanation for wrong answers"})
+		ctx.JSON(http.StatusOK, submission.result())
+		return
+
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"score": "Good Job you answer all of it", "attempt_id": submission.attemptID, "official": !submission.taken})
+
+}
+
+// QuizAnswerStream grades like QuizAnswer but answers with server-sent
+// events: a grade event, the explanation as token events while it is
+// generated, then a done event carrying the parsed explanation.
+func (a *ActionController) QuizAnswerStream(ctx *gin.Context) {
+
+	submission, ok := a.gradeSubmission(ctx)
+	if !ok {
+		return
+	}
+
+	section, grade := submission.section, submission.grade
+
+	startStream(ctx)
+	sendEvent(ctx, "grade", submission.result())
+
+	if grade.Score == grade.Total || submission.taken {
+		sendEvent(ctx, "done", gin.H{"explanation": []domain.QeustionAnswer{}})
+		return
+	}
+
+	answerID, err := a.actionUsecase.CreateExplanation(ctx.Request.Context(), section.ExplanationsID, submission.answers, streamChunks(ctx))
+
+	if err != nil {
+		log.Println(err.Error())
+		sendEvent(ctx, "error", gin.H{"error": "Error occured may be the content of your pdf is too large."})
+		return
+	}
+
+	section.AnswersID = answerID
+
+	if err := a.actionUsecase.UpdateSection(ctx.Request.Context(), section); err != nil {
+		log.Println(err.Error())
+		sendEvent(ctx, "error", gin.H{"error": "Problem accessing the database"})
+		return
+	}
+
+	explanation, err := a.viewusecase.GetExplanation(ctx.Request.Context(), section.ExplanationsID)
+
+	if err != nil || len(explanation.Turns) < 2 {
+		sendEvent(ctx, "error", gin.H{"error": "There is Problem loading your explanation"})
+		return
+	}
+
+	sendEvent(ctx, "done", gin.H{"explanation": infrastructure.AttachCitations(infrastructure.ParseExplanationResponse(explanation.Turns[1].Gemini), explanation.Turns[1].Sources)})
+}
+
+type quizSubmission struct {
+	section   domain.Section
+	answers   domain.AnswerList
+	grade     domain.QuizGrade
+	taken     bool
+	attemptID string
+}
+
+func (s quizSubmission) result() gin.H {
+	result := gin.H{"score": s.grade.Score, "total": s.grade.Total, "results": s.grade.Results, "section_id": s.section.ID.Hex(), "attempt_id": s.attemptID, "official": !s.taken}
+	if s.taken {
+		result["message"] = "You have already taken this quiz, this attempt is kept as practice"
+	}
+	return result
+}
+
+// gradeSubmission grades the posted answers and records the attempt. It
+// writes the error response itself and reports false when it fails.
+func (a *ActionController) gradeSubmission(ctx *gin.Context) (quizSubmission, bool) {
+
+	sectionID := ctx.DefaultQuery("section_id", "")
+	userID, exist := ctx.Get("user_id")
+
+	if sectionID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Section id is required",
+		})
+		return quizSubmission{}, false
+	}
+
+	if !exist {
+		log.Println("User ID does not exist, token problem")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized : invalid Credential"})
+		return quizSubmission{}, false
+	}
+
+	section, err := a.viewusecase.GetSection(ctx, sectionID, userID.(string))
+
+	if err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "There is Problem loading your data, try again!"})
+		return quizSubmission{}, false
+	}
+
+	var answers domain.AnswerList
+	if err := ctx.ShouldBindJSON(&answers); err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Please check your input, answer all the questions"})
+		return quizSubmission{}, false
+	}
+
+	grade, taken, err := a.actionUsecase.QuizAnswer(ctx, section.QuestionsID, answers.Answers)
+
+	if err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "There is Problem saving your answers"})
+		return quizSubmission{}, false
+	}
+
+	// only the first submission counts, later ones are kept as practice
+	attemptID, err := a.attemptusecase.RecordAttempt(ctx, section, userID.(string), answers, grade, !taken)
+
+	if err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "There is Problem saving your answers"})
+		return quizSubmission{}, false
+	}
+
+	// the review queue catches up on the next submission, so don't fail this one
+	if err := a.reviewusecase.RecordQuiz(ctx, section, userID.(string), grade); err != nil {
+		log.Println(err.Error())
+	}
+
+	return quizSubmission{section: section, answers: answers, grade: grade, taken: taken, attemptID: attemptID}, true
+}
+
+type followUpRequest struct {
+	Topics []domain.Topic `json:"topics"`
+	domain.QuizOptions
+}
+
+// FollowUpQuiz creates the next round of a section from its weak points. The
+// weak points can be sent in the body, otherwise the generated topics of the
+// section are used.
+func (a *ActionController) FollowUpQuiz(ctx *gin.Context) {
+	sectionID := ctx.Param("id")
+	userID, exist := ctx.Get("user_id")
+
+	if !exist {
+		log.Println("User ID does not exist, token problem")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized : invalid Credential"})
+		return
+	}
+
+	var request followUpRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil && err != io.EOF {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Please check your input"})
+		return
+	}
+
+	section, err := a.viewusecase.GetSection(ctx, sectionID, userID.(string))
+
+	if err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "No such section"})
 		return
+	}
+
+	topics := domain.TopicList{Topics: request.Topics}
 
+	if len(topics.Topics) == 0 {
+		topics, err = a.viewusecase.GetTopic(ctx, section.ExplanationsID)
+		if err != nil || len(topics.Topics) == 0 {
+			ctx.JSON(http.StatusConflict, gin.H{"error": "Please generate your weak points first"})
+			return
+		}
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{"score": "Good Job you answer all of it"})
+	followUpID, err := a.actionUsecase.CreateFollowUpQuiz(ctx, section, topics, request.QuizOptions)
+
+	if err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create your follow-up quiz, try again!"})
+		return
+	}
 
+	ctx.JSON(http.StatusCreated, gin.H{"section_id": followUpID, "parent_id": sectionID})
 }
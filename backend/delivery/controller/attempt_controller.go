@@ -0,0 +1,144 @@
+package controller
+
+import (
+	"github/chera/fix-it/usecases"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AttemptController struct {
+	attemptusecase usecases.AttemptUsecase
+	viewusecase    usecases.ViewUsecase
+}
+
+func NewAttemptController(attemptusecase usecases.AttemptUsecase, viewusecase usecases.ViewUsecase) *AttemptController {
+	return &AttemptController{
+		attemptusecase: attemptusecase,
+		viewusecase:    viewusecase,
+	}
+}
+
+func (a *AttemptController) ListAttempts(ctx *gin.Context) {
+	sectionID := ctx.Param("id")
+	userID, exist := ctx.Get("user_id")
+
+	if !exist {
+		log.Println("User ID does not exist, token problem")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized : invalid Credential"})
+		return
+	}
+
+	if _, err := a.viewusecase.GetSection(ctx, sectionID, userID.(string)); err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "No such section"})
+		return
+	}
+
+	attempts, err := a.attemptusecase.ListAttempts(ctx, sectionID, userID.(string))
+
+	if err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "There is Problem loading your data, try again!"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, attempts)
+}
+
+func (a *AttemptController) GetAttempt(ctx *gin.Context) {
+	attemptID := ctx.Param("id")
+	userID, exist := ctx.Get("user_id")
+
+	if !exist {
+		log.Println("User ID does not exist, token problem")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized : invalid Credential"})
+		return
+	}
+
+	attempt, err := a.attemptusecase.GetAttempt(ctx, attemptID, userID.(string))
+
+	if err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "No such attempt"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, attempt)
+}
+
+func (a *AttemptController) ExplainAttempt(ctx *gin.Context) {
+	attemptID := ctx.Param("id")
+	userID, exist := ctx.Get("user_id")
+
+	if !exist {
+		log.Println("User ID does not exist, token problem")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized : invalid Credential"})
+		return
+	}
+
+	explanation, err := a.attemptusecase.ExplainAttempt(ctx, attemptID, userID.(string), nil)
+
+	if err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Could not explain this attempt, try again!"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, explanation)
+}
+
+func (a *AttemptController) SectionProgress(ctx *gin.Context) {
+	sectionID := ctx.Param("id")
+	userID, exist := ctx.Get("user_id")
+
+	if !exist {
+		log.Println("User ID does not exist, token problem")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized : invalid Credential"})
+		return
+	}
+
+	section, err := a.viewusecase.GetSection(ctx, sectionID, userID.(string))
+
+	if err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "No such section"})
+		return
+	}
+
+	progress, err := a.attemptusecase.SectionProgress(ctx, section, userID.(string))
+
+	if err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "There is Problem loading your data, try again!"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"rounds": progress})
+}
+
+// ExplainAttemptStream is ExplainAttempt over server-sent events: token
+// events while a new explanation is generated, then a done event.
+func (a *AttemptController) ExplainAttemptStream(ctx *gin.Context) {
+	attemptID := ctx.Param("id")
+	userID, exist := ctx.Get("user_id")
+
+	if !exist {
+		log.Println("User ID does not exist, token problem")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized : invalid Credential"})
+		return
+	}
+
+	startStream(ctx)
+
+	explanation, err := a.attemptusecase.ExplainAttempt(ctx.Request.Context(), attemptID, userID.(string), streamChunks(ctx))
+
+	if err != nil {
+		log.Println(err.Error())
+		sendEvent(ctx, "error", gin.H{"error": "Could not explain this attempt, try again!"})
+		return
+	}
+
+	sendEvent(ctx, "done", gin.H{"explanation": explanation})
+}
@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"github/chera/fix-it/domain"
+	"github/chera/fix-it/usecases"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// long messages would crowd the section context out of the chat window
+const maxChatMessage = 4000
+
+type ChatController struct {
+	actionusecase usecases.ActionUsecase
+	viewusecase   usecases.ViewUsecase
+}
+
+func NewChatController(actionusecase usecases.ActionUsecase, viewusecase usecases.ViewUsecase) *ChatController {
+	return &ChatController{
+		actionusecase: actionusecase,
+		viewusecase:   viewusecase,
+	}
+}
+
+type chatMessage struct {
+	Message string `json:"message" binding:"required"`
+}
+
+func (c *ChatController) SendMessage(ctx *gin.Context) {
+	sectionID := ctx.Param("id")
+	userID, exist := ctx.Get("user_id")
+
+	if !exist {
+		log.Println("User ID does not exist, token problem")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized : invalid Credential"})
+		return
+	}
+
+	var message chatMessage
+	if err := ctx.ShouldBindJSON(&message); err != nil || strings.TrimSpace(message.Message) == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Message is required"})
+		return
+	}
+
+	if len(message.Message) > maxChatMessage {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Your message is too long"})
+		return
+	}
+
+	section, err := c.viewusecase.GetSection(ctx, sectionID, userID.(string))
+
+	if err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "No such section"})
+		return
+	}
+
+	turn, err := c.actionusecase.Chat(ctx, section.ExplanationsID, strings.TrimSpace(message.Message))
+
+	if err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Could not answer your message, try again!"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, turn)
+}
+
+func (c *ChatController) History(ctx *gin.Context) {
+	sectionID := ctx.Param("id")
+	userID, exist := ctx.Get("user_id")
+
+	if !exist {
+		log.Println("User ID does not exist, token problem")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized : invalid Credential"})
+		return
+	}
+
+	section, err := c.viewusecase.GetSection(ctx, sectionID, userID.(string))
+
+	if err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "No such section"})
+		return
+	}
+
+	conversation, err := c.viewusecase.GetExplanation(ctx, section.ExplanationsID)
+
+	if err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "There is Problem loading your data, try again!"})
+		return
+	}
+
+	chat := conversation.Chat
+	if chat == nil {
+		chat = []domain.ChatTurn{}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"chat": chat})
+}
@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"errors"
+	"github/chera/fix-it/domain"
+	"github/chera/fix-it/infrastructure"
+	usescases "github/chera/fix-it/usecases"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OIDCController logs users in with the OpenID Connect provider of the
+// school.
+type OIDCController struct {
+	oidcUsecase usescases.OIDCUsecase
+	userUsecase usescases.UserUsecase
+}
+
+func NewOIDCController(oidcusecase usescases.OIDCUsecase, userusecase usescases.UserUsecase) *OIDCController {
+	return &OIDCController{
+		oidcUsecase: oidcusecase,
+		userUsecase: userusecase,
+	}
+}
+
+// oidcStateCookie keeps the state of a login in the browser that started
+// it, a code and state sent from any other browser don't log in.
+const oidcStateCookie = "oidc_state"
+
+func setOIDCStateCookie(ctx *gin.Context, state string, maxAge int) {
+	secure := ctx.Request.TLS != nil || ctx.GetHeader("X-Forwarded-Proto") == "https"
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(oidcStateCookie, state, maxAge, "/u/oidc", "", secure, true)
+}
+
+// Start returns the URL of the provider for the front end to send the user
+// to.
+func (o *OIDCController) Start(ctx *gin.Context) {
+	url, state, err := o.oidcUsecase.Start(ctx)
+
+	if errors.Is(err, usescases.ErrOIDCDisabled) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Something Went wrong Please try again"})
+		return
+	}
+
+	// a session cookie, the login itself expires on the server
+	setOIDCStateCookie(ctx, state, 0)
+	ctx.JSON(http.StatusOK, gin.H{"authorization_url": url})
+}
+
+type oidcCallbackRequest struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+// Callback takes the code and state the provider sent the user back to the
+// front end with. New users get a signup_token for /u/oidc/complete.
+func (o *OIDCController) Callback(ctx *gin.Context) {
+	var request oidcCallbackRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil || request.Code == "" || request.State == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "code and state are required"})
+		return
+	}
+
+	browserState, _ := ctx.Cookie(oidcStateCookie)
+	setOIDCStateCookie(ctx, "", -1)
+
+	result, err := o.oidcUsecase.Callback(ctx, request.Code, request.State, browserState)
+
+	switch {
+	case errors.Is(err, usescases.ErrOIDCDisabled):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, usescases.ErrInvalidOIDCState), errors.Is(err, usescases.ErrOIDCLoginFailed), errors.Is(err, usescases.ErrEmailNotVerified):
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		log.Println(err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Something Went wrong Please try again"})
+		return
+	}
+
+	if result.SignupToken != "" {
+		ctx.JSON(http.StatusOK, gin.H{
+			"profile_required": true,
+			"signup_token":     result.SignupToken,
+			"email":            result.Email,
+			"username":         result.Username,
+			"message":          "Complete your profile to finish signing up",
+		})
+		return
+	}
+
+	logIn(ctx, o.userUsecase, result.UserID)
+}
+
+type completeProfileRequest struct {
+	SignupToken string `json:"signup_token"`
+	Username    string `json:"username"`
+	Age         int    `json:"age"`
+	Academic    string `json:"academic"`
+}
+
+// CompleteProfile creates the account of a new user of the provider and
+// logs them in.
+func (o *OIDCController) CompleteProfile(ctx *gin.Context) {
+	var request completeProfileRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil || request.SignupToken == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "signup_token is required"})
+		return
+	}
+
+	profile := domain.User{Username: request.Username, Age: request.Age, Academic: request.Academic}
+
+	if err := infrastructure.ProfileValidateUser(profile); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input " + err.Error()})
+		return
+	}
+
+	userid, err := o.oidcUsecase.CompleteProfile(ctx, request.SignupToken, profile)
+
+	if errors.Is(err, usescases.ErrInvalidSignup) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err != nil {
+		log.Println("Error creating user: ", err)
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	logIn(ctx, o.userUsecase, userid)
+}
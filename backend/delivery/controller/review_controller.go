@@ -0,0 +1,81 @@
+package controller
+
+import (
+	"github/chera/fix-it/usecases"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ReviewController struct {
+	reviewusecase usecases.ReviewUsecase
+}
+
+func NewReviewController(reviewusecase usecases.ReviewUsecase) *ReviewController {
+	return &ReviewController{
+		reviewusecase: reviewusecase,
+	}
+}
+
+type reviewGrade struct {
+	Answer  *string `json:"answer"`
+	Quality *int    `json:"quality"`
+}
+
+func (r *ReviewController) DueReviews(ctx *gin.Context) {
+	userID, exist := ctx.Get("user_id")
+
+	if !exist {
+		log.Println("User ID does not exist, token problem")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized : invalid Credential"})
+		return
+	}
+
+	items, err := r.reviewusecase.DueReviews(ctx, userID.(string))
+
+	if err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "There is Problem loading your data, try again!"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, items)
+}
+
+func (r *ReviewController) GradeReview(ctx *gin.Context) {
+	reviewID := ctx.Param("id")
+	userID, exist := ctx.Get("user_id")
+
+	if !exist {
+		log.Println("User ID does not exist, token problem")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized : invalid Credential"})
+		return
+	}
+
+	var grade reviewGrade
+	if err := ctx.ShouldBindJSON(&grade); err != nil || (grade.Answer == nil && grade.Quality == nil) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Send either your answer or a quality between 0 and 5"})
+		return
+	}
+
+	if grade.Quality != nil && (*grade.Quality < 0 || *grade.Quality > 5) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Quality must be between 0 and 5"})
+		return
+	}
+
+	item, correct, err := r.reviewusecase.GradeReview(ctx, reviewID, userID.(string), grade.Answer, grade.Quality)
+
+	if err != nil {
+		log.Println(err.Error())
+		if strings.Contains(err.Error(), "no documents") {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "No such review"})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "There is Problem saving your review"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"correct": correct, "review": item})
+}
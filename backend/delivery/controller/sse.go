@@ -0,0 +1,39 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// startStream switches the response to server-sent events. Errors after
+// this point have to be sent as events since the status is already written.
+func startStream(ctx *gin.Context) {
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	// keeps reverse proxies like nginx from buffering the stream
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.Status(http.StatusOK)
+	ctx.Writer.Flush()
+}
+
+// sendEvent writes one event and flushes it. It fails once the client has
+// gone away, which is what cancels the generation behind the stream.
+func sendEvent(ctx *gin.Context, event string, data interface{}) error {
+	if err := ctx.Request.Context().Err(); err != nil {
+		return err
+	}
+
+	ctx.SSEvent(event, data)
+	ctx.Writer.Flush()
+
+	return nil
+}
+
+// streamChunks sends every generated chunk as a token event.
+func streamChunks(ctx *gin.Context) func(chunk string) error {
+	return func(chunk string) error {
+		return sendEvent(ctx, "token", gin.H{"text": chunk})
+	}
+}
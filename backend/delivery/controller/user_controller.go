@@ -1,12 +1,15 @@
 package controller
 
 import (
+	"errors"
 	"github/chera/fix-it/domain"
 	"github/chera/fix-it/infrastructure"
 	usescases "github/chera/fix-it/usecases"
 	"log"
+	"math"
 	"net/http"
 	"os"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -85,7 +88,14 @@ func (u *UserController) Login(ctx *gin.Context) {
 		return
 	}
 
-	userid, err := u.userUsecase.Login(ctx, user)
+	userid, err := u.userUsecase.Login(ctx, user, ctx.ClientIP())
+
+	var throttled *usescases.LoginThrottledError
+	if errors.As(err, &throttled) {
+		ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		ctx.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed logins, try again later"})
+		return
+	}
 
 	if err != nil {
 		log.Println(err.Error())
@@ -93,15 +103,384 @@ func (u *UserController) Login(ctx *gin.Context) {
 		return
 	}
 
-	token, err := u.userUsecase.GenerateToken(userid)
+	logIn(ctx, u.userUsecase, userid)
+}
+
+// logIn answers a login that checked who the user is, with tokens or with
+// an MFA challenge.
+func logIn(ctx *gin.Context, userUsecase usescases.UserUsecase, userid string) {
+	// accounts with two-factor authentication get their tokens from
+	// /u/mfa/verify with a code
+	challenge, err := userUsecase.MFAChallenge(ctx, userid)
+
+	if err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Something Went wrong Please try again"})
+		return
+	}
+
+	if challenge != "" {
+		ctx.JSON(http.StatusOK, gin.H{
+			"mfa_required": true,
+			"mfa_token":    challenge,
+			"message":      "Enter the code of your authenticator app",
+		})
+		return
+	}
+
+	tokens, err := userUsecase.IssueTokens(ctx, userid)
+
+	if err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Something Went wrong Please try again"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+		"message":       "Logged In successfully",
+	})
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (u *UserController) Refresh(ctx *gin.Context) {
+	var request refreshRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil || request.RefreshToken == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "refresh_token is required"})
+		return
+	}
+
+	tokens, err := u.userUsecase.Refresh(ctx, request.RefreshToken)
+
+	if errors.Is(err, usescases.ErrInvalidRefreshToken) || errors.Is(err, usescases.ErrRefreshTokenReused) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Something Went wrong Please try again"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, tokens)
+}
+
+// Logout ends the current session. The refresh token is optional, without
+// it only the access token is revoked.
+func (u *UserController) Logout(ctx *gin.Context) {
+	userID, exist := ctx.Get("user_id")
+
+	if !exist {
+		log.Println("User ID does not exist, token problem")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized : invalid Credential"})
+		return
+	}
+
+	var request refreshRequest
+	if ctx.Request.ContentLength != 0 {
+		if err := ctx.ShouldBindJSON(&request); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input " + err.Error()})
+			return
+		}
+	}
+
+	err := u.userUsecase.Logout(ctx, userID.(string), request.RefreshToken, ctx.GetString("token_id"), ctx.GetTime("token_expires_at"))
 
 	if err != nil {
 		log.Println(err.Error())
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Something Went wrong Please try again"})
 		return
 	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+func (u *UserController) LogoutAll(ctx *gin.Context) {
+	userID, exist := ctx.Get("user_id")
+
+	if !exist {
+		log.Println("User ID does not exist, token problem")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized : invalid Credential"})
+		return
+	}
+
+	err := u.userUsecase.LogoutAll(ctx, userID.(string), ctx.GetString("token_id"), ctx.GetTime("token_expires_at"))
+
+	if err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Something Went wrong Please try again"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Logged out of all sessions"})
+}
+
+type forgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+func (u *UserController) ForgotPassword(ctx *gin.Context) {
+	var request forgotPasswordRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil || request.Email == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
+		return
+	}
+
+	if err := u.userUsecase.ForgotPassword(ctx, request.Email); err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Something Went wrong Please try again"})
+		return
+	}
+
+	// the same answer whether the email has an account or not
+	ctx.JSON(http.StatusOK, gin.H{"message": "If the email belongs to an account, a link to reset the password was sent to it"})
+}
+
+func (u *UserController) ResendVerification(ctx *gin.Context) {
+	var request forgotPasswordRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil || request.Email == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
+		return
+	}
+
+	err := u.userUsecase.ResendVerification(ctx, request.Email)
+
+	// a throttled resend answers like any other, or it would tell which
+	// emails have a registration waiting
+	if errors.Is(err, usescases.ErrResendThrottled) {
+		log.Println(err.Error())
+	} else if err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Something Went wrong Please try again"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "If the email has a registration waiting for verification, a new link was sent to it"})
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func (u *UserController) ResetPassword(ctx *gin.Context) {
+	var request resetPasswordRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil || request.Token == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "token and password are required"})
+		return
+	}
+
+	if err := infrastructure.ValidatePassword(request.Password); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input " + err.Error()})
+		return
+	}
+
+	err := u.userUsecase.ResetPassword(ctx, request.Token, request.Password)
+
+	if errors.Is(err, usescases.ErrInvalidResetToken) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Something Went wrong Please try again"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Password changed, log in with your new password"})
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+func (u *UserController) ChangePassword(ctx *gin.Context) {
+	userID, exist := ctx.Get("user_id")
+
+	if !exist {
+		log.Println("User ID does not exist, token problem")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized : invalid Credential"})
+		return
+	}
+
+	var request changePasswordRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input " + err.Error()})
+		return
+	}
+
+	if err := infrastructure.ValidatePassword(request.NewPassword); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input " + err.Error()})
+		return
+	}
+
+	err := u.userUsecase.ChangePassword(ctx, userID.(string), request.CurrentPassword, request.NewPassword, ctx.GetString("token_id"))
+
+	if errors.Is(err, usescases.ErrWrongPassword) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Something Went wrong Please try again"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Password changed, your other sessions were logged out"})
+}
+
+func (u *UserController) EnrollMFA(ctx *gin.Context) {
+	userID, exist := ctx.Get("user_id")
+
+	if !exist {
+		log.Println("User ID does not exist, token problem")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized : invalid Credential"})
+		return
+	}
+
+	secret, uri, err := u.userUsecase.EnrollMFA(ctx, userID.(string))
+
+	if errors.Is(err, usescases.ErrMFAEnabled) {
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Something Went wrong Please try again"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_uri": uri,
+		"message":     "Add the secret to your authenticator app and confirm with a code",
+	})
+}
+
+type mfaCodeRequest struct {
+	Code     string `json:"code"`
+	Password string `json:"password"`
+	MFAToken string `json:"mfa_token"`
+}
+
+func (u *UserController) ConfirmMFA(ctx *gin.Context) {
+	userID, exist := ctx.Get("user_id")
+
+	if !exist {
+		log.Println("User ID does not exist, token problem")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized : invalid Credential"})
+		return
+	}
+
+	var request mfaCodeRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil || request.Code == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "code is required"})
+		return
+	}
+
+	codes, err := u.userUsecase.ConfirmMFA(ctx, userID.(string), request.Code)
+
+	switch {
+	case errors.Is(err, usescases.ErrMFAEnabled):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, usescases.ErrMFANotEnrolled), errors.Is(err, usescases.ErrInvalidMFACode):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		log.Println(err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Something Went wrong Please try again"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"recovery_codes": codes,
+		"message":        "Two-factor authentication is on, keep the recovery codes somewhere safe, they are only shown once",
+	})
+}
+
+func (u *UserController) DisableMFA(ctx *gin.Context) {
+	userID, exist := ctx.Get("user_id")
+
+	if !exist {
+		log.Println("User ID does not exist, token problem")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized : invalid Credential"})
+		return
+	}
+
+	var request mfaCodeRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input " + err.Error()})
+		return
+	}
+
+	err := u.userUsecase.DisableMFA(ctx, userID.(string), request.Password, request.Code)
+
+	var throttled *usescases.LoginThrottledError
+	if errors.As(err, &throttled) {
+		ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		ctx.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed attempts, try again later"})
+		return
+	}
+
+	switch {
+	case errors.Is(err, usescases.ErrWrongPassword):
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "password is incorrect"})
+		return
+	case errors.Is(err, usescases.ErrInvalidMFACode):
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		log.Println(err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Something Went wrong Please try again"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication is off"})
+}
+
+// VerifyMFA is the second step of a login to an account with two-factor
+// authentication.
+func (u *UserController) VerifyMFA(ctx *gin.Context) {
+	var request mfaCodeRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil || request.MFAToken == "" || request.Code == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "mfa_token and code are required"})
+		return
+	}
+
+	tokens, err := u.userUsecase.VerifyMFA(ctx, request.MFAToken, request.Code)
+
+	var throttled *usescases.LoginThrottledError
+	if errors.As(err, &throttled) {
+		ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		ctx.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed logins, try again later"})
+		return
+	}
+
+	if errors.Is(err, usescases.ErrInvalidChallenge) || errors.Is(err, usescases.ErrInvalidMFACode) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Something Went wrong Please try again"})
+		return
+	}
+
 	ctx.JSON(http.StatusOK, gin.H{
-		"token":   token,
-		"message": "Logged In successfully",
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+		"message":       "Logged In successfully",
 	})
 }
@@ -1,13 +1,17 @@
 package controller
 
 import (
+	"errors"
 	"github/chera/fix-it/domain"
 	"github/chera/fix-it/infrastructure"
 	"github/chera/fix-it/usecases"
 	"log"
+	"mime"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type ViewController struct {
@@ -55,7 +59,7 @@ func (v *ViewController) ViewExplanation(ctx *gin.Context) {
 		ctx.JSON(http.StatusNotFound, gin.H{"error": "No explanation found"})
 		return
 	}
-	ctx.JSON(http.StatusOK, infrastructure.ParseGeminiAnswer(explanation.Turns[1].Gemini))
+	ctx.JSON(http.StatusOK, infrastructure.AttachCitations(infrastructure.ParseExplanationResponse(explanation.Turns[1].Gemini), explanation.Turns[1].Sources))
 }
 
 func (v *ViewController) ViewQuiz(ctx *gin.Context) {
@@ -118,7 +122,7 @@ func (v *ViewController) CreateTopic(ctx *gin.Context) {
 		return
 	}
 
-	_, err = v.actionsusecase.CreateTopic(ctx, section.AnswersID, section.ExplanationsID)
+	_, err = v.actionsusecase.CreateTopic(ctx, section.AnswersID, section.ExplanationsID, nil)
 
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -130,6 +134,54 @@ func (v *ViewController) CreateTopic(ctx *gin.Context) {
 	})
 }
 
+// CreateTopicStream is CreateTopic over server-sent events: token events
+// while the topics are generated, then a done event with the parsed topics.
+func (v *ViewController) CreateTopicStream(ctx *gin.Context) {
+
+	sectionID := ctx.DefaultQuery("section_id", "")
+
+	if sectionID == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Section id is required",
+		})
+		return
+	}
+
+	userID, exist := ctx.Get("user_id")
+
+	if !exist {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "No user found"})
+		return
+	}
+
+	section, err := v.viewusecase.GetSection(ctx, sectionID, userID.(string))
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	startStream(ctx)
+
+	_, err = v.actionsusecase.CreateTopic(ctx.Request.Context(), section.AnswersID, section.ExplanationsID, streamChunks(ctx))
+
+	if err != nil {
+		log.Println(err.Error())
+		sendEvent(ctx, "error", gin.H{"error": err.Error()})
+		return
+	}
+
+	topics, err := v.viewusecase.GetTopic(ctx.Request.Context(), section.ExplanationsID)
+
+	if err != nil {
+		log.Println(err.Error())
+		sendEvent(ctx, "error", gin.H{"error": "There is problem Loading your data"})
+		return
+	}
+
+	sendEvent(ctx, "done", gin.H{"topics": topics})
+}
+
 func (v *ViewController) ViewTopics(ctx *gin.Context) {
 	sectionID := ctx.DefaultQuery("section_id", "")
 	if sectionID == "" {
@@ -243,3 +295,105 @@ func (v *ViewController) SectionDetail(ctx *gin.Context) {
 	})
 
 }
+
+// ViewPDF serves the original file of a section, whatever its format, only
+// PDFs are shown in place and the others are downloaded.
+// Range requests are answered with partial content so viewers can load the
+// pages they show.
+func (v *ViewController) ViewPDF(ctx *gin.Context) {
+	sectionID := ctx.Param("section_id")
+	userID, exist := ctx.Get("user_id")
+
+	if !exist {
+		log.Println("User ID does not exist, token problem")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized : invalid Credential"})
+		return
+	}
+
+	section, err := v.viewusecase.GetSection(ctx, sectionID, userID.(string))
+
+	if err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "No such section"})
+		return
+	}
+
+	document, file, err := v.viewusecase.OpenDocument(ctx.Request.Context(), section.PDFID)
+
+	if err == mongo.ErrNoDocuments || errors.Is(err, infrastructure.ErrBlobNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "The file of this section is not available"})
+		return
+	}
+
+	if err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "There is problem loading your file, try again!"})
+		return
+	}
+
+	defer file.Close()
+
+	// documents uploaded before other formats are all PDFs
+	contentType := document.MediaType
+	if contentType == "" {
+		contentType = domain.MediaTypePDF
+	}
+
+	modified, _ := time.Parse(time.RFC3339, document.Created)
+
+	// only PDFs are shown in place, an uploaded html page opened from the api
+	// origin would run its scripts there
+	disposition := "attachment"
+	if contentType == domain.MediaTypePDF {
+		disposition = "inline"
+	}
+
+	ctx.Header("Content-Type", contentType)
+	ctx.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": document.Title}))
+	ctx.Header("X-Content-Type-Options", "nosniff")
+	ctx.Header("Content-Security-Policy", "sandbox")
+	ctx.Header("Cache-Control", "private, max-age=3600")
+	// the stored file never changes, so its key identifies the content
+	ctx.Header("ETag", `"`+document.DropBox+`"`)
+
+	http.ServeContent(ctx.Writer, ctx.Request, document.Title, modified, file)
+}
+
+// ViewDocument describes a document of the user with the chapters a new
+// section can be made from.
+func (v *ViewController) ViewDocument(ctx *gin.Context) {
+	documentID := ctx.Param("id")
+	userID, exist := ctx.Get("user_id")
+
+	if !exist {
+		log.Println("User ID does not exist, token problem")
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized : invalid Credential"})
+		return
+	}
+
+	document, err := v.actionsusecase.GetDocument(ctx, documentID, userID.(string))
+
+	if err != nil {
+		log.Println(err.Error())
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "No such document"})
+		return
+	}
+
+	chapters := document.Chapters
+	if chapters == nil {
+		chapters = []domain.Chapter{}
+	}
+
+	mediaType := document.MediaType
+	if mediaType == "" {
+		mediaType = domain.MediaTypePDF
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"id":         document.ID.Hex(),
+		"title":      document.Title,
+		"media_type": mediaType,
+		"page_count": document.PageCount,
+		"chapters":   chapters,
+	})
+}
@@ -3,43 +3,89 @@ package router
 import (
 	"github/chera/fix-it/delivery/controller"
 	"github/chera/fix-it/infrastructure"
+	"log"
+	"os"
+	"strings"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
-func SetUpRouter(usercontroller *controller.UserController, actioncontroller *controller.ActionController, viewcontroller *controller.ViewController) *gin.Engine {
+func SetUpRouter(usercontroller *controller.UserController, actioncontroller *controller.ActionController, viewcontroller *controller.ViewController, attemptcontroller *controller.AttemptController, reviewcontroller *controller.ReviewController, chatcontroller *controller.ChatController, oidccontroller *controller.OIDCController, denylist infrastructure.TokenDenylist, limiter infrastructure.RateLimiter) *gin.Engine {
 
 	router := gin.New()
 
+	// X-Forwarded-For is only believed from the proxies in TRUSTED_PROXIES,
+	// otherwise clients could pick their address and get around the limits
+	var proxies []string
+	if trusted := os.Getenv("TRUSTED_PROXIES"); trusted != "" {
+		proxies = strings.Split(trusted, ",")
+	}
+	if err := router.SetTrustedProxies(proxies); err != nil {
+		log.Fatalf("invalid TRUSTED_PROXIES: %v", err)
+	}
+
 	router.Use(cors.New(cors.Config{
 		AllowAllOrigins:  true,
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Content-Type", "Authorization", "Origin", "X-Requested-With"},
+		AllowHeaders:     []string{"Content-Type", "Authorization", "Origin", "X-Requested-With", "Range", "If-Range"},
 		AllowCredentials: true,
-		ExposeHeaders:    []string{"Content-Length"},
+		ExposeHeaders:    []string{"Content-Length", "Content-Range", "Accept-Ranges", "ETag"},
 		MaxAge:           12 * 60 * 60,
 	}))
 
-	user := router.Group("/u")
+	auth := infrastructure.AuthMiddleWare(denylist)
+
+	user := router.Group("/u", infrastructure.RateLimitMiddleware(limiter))
 	user.POST("/register", usercontroller.Register)
 	user.POST("/login", usercontroller.Login)
 	user.GET("/verify", usercontroller.Verify)
+	user.POST("/resend-verification", usercontroller.ResendVerification)
+	user.POST("/refresh", usercontroller.Refresh)
+	user.POST("/logout", auth, usercontroller.Logout)
+	user.POST("/logout-all", auth, usercontroller.LogoutAll)
+	user.POST("/forgot-password", usercontroller.ForgotPassword)
+	user.POST("/reset-password", usercontroller.ResetPassword)
+	user.POST("/change-password", auth, usercontroller.ChangePassword)
+	user.POST("/mfa/enroll", auth, usercontroller.EnrollMFA)
+	user.POST("/mfa/confirm", auth, usercontroller.ConfirmMFA)
+	user.POST("/mfa/disable", auth, usercontroller.DisableMFA)
+	user.POST("/mfa/verify", usercontroller.VerifyMFA)
+	user.GET("/oidc/start", oidccontroller.Start)
+	user.POST("/oidc/callback", oidccontroller.Callback)
+	user.POST("/oidc/complete", oidccontroller.CompleteProfile)
 
 	// add an endpoint to upload a pdf and should have token of the user
 	action := router.Group("/a")
-	action.POST("/upload", infrastructure.AuthMiddleWare(), actioncontroller.UploadPDF)
-	action.POST("/quiz_answer", infrastructure.AuthMiddleWare(), actioncontroller.QuizAnswer)
-	action.GET("/more", infrastructure.AuthMiddleWare(), viewcontroller.CreateTopic) // should be section id
+	action.POST("/upload", auth, actioncontroller.UploadPDF)
+	action.GET("/jobs/:id", auth, actioncontroller.GetJob)
+	action.POST("/quiz_answer", auth, actioncontroller.QuizAnswer)
+	action.POST("/quiz_answer/stream", auth, actioncontroller.QuizAnswerStream)
+	action.GET("/more", auth, viewcontroller.CreateTopic) // should be section id
+	action.GET("/more/stream", auth, viewcontroller.CreateTopicStream)
+	action.POST("/attempts/:id/explanation", auth, attemptcontroller.ExplainAttempt)
+	action.POST("/attempts/:id/explanation/stream", auth, attemptcontroller.ExplainAttemptStream)
+	action.POST("/documents/:id/sections", auth, actioncontroller.CreateDocumentSection)
+	action.POST("/section/:id/followup-quiz", auth, actioncontroller.FollowUpQuiz)
+	action.POST("/section/:id/chat", auth, chatcontroller.SendMessage)
+	action.POST("/reviews/:id/grade", auth, reviewcontroller.GradeReview)
 
 	// end points to retreive the results
 	result := router.Group("/r")
 
-	result.GET("/explanation", infrastructure.AuthMiddleWare(), viewcontroller.ViewExplanation) // should be section id
-	result.GET("/quiz", infrastructure.AuthMiddleWare(), viewcontroller.ViewQuiz)
-	result.GET("/topic", infrastructure.AuthMiddleWare(), viewcontroller.ViewTopics)
-	result.GET("/sections", infrastructure.AuthMiddleWare(), viewcontroller.SectionList)
-	result.GET("/section_detail", infrastructure.AuthMiddleWare(), viewcontroller.SectionDetail)
+	result.GET("/explanation", auth, viewcontroller.ViewExplanation) // should be section id
+	result.GET("/quiz", auth, viewcontroller.ViewQuiz)
+	result.GET("/topic", auth, viewcontroller.ViewTopics)
+	result.GET("/sections", auth, viewcontroller.SectionList)
+	result.GET("/section_detail", auth, viewcontroller.SectionDetail)
+	result.GET("/pdf/:section_id", auth, viewcontroller.ViewPDF)
+	result.GET("/document/:section_id", auth, viewcontroller.ViewPDF)
+	result.GET("/documents/:id", auth, viewcontroller.ViewDocument)
+	result.GET("/section/:id/attempts", auth, attemptcontroller.ListAttempts)
+	result.GET("/section/:id/progress", auth, attemptcontroller.SectionProgress)
+	result.GET("/section/:id/chat", auth, chatcontroller.History)
+	result.GET("/attempts/:id", auth, attemptcontroller.GetAttempt)
+	result.GET("/reviews/due", auth, reviewcontroller.DueReviews)
 
 	return router
 
@@ -0,0 +1,328 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github/chera/fix-it/delivery/controller"
+	"github/chera/fix-it/domain"
+	"github/chera/fix-it/infrastructure"
+	"github/chera/fix-it/repository"
+	"github/chera/fix-it/usecases"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// memoryAttempts keeps attempts the way the mongo repository does, lists
+// leave the explanation out.
+type memoryAttempts struct {
+	attempts []domain.Attempt
+}
+
+func (m *memoryAttempts) CreateAttempt(ctx context.Context, attempt domain.Attempt) (string, error) {
+	attempt.ID = primitive.NewObjectID()
+	attempt.CreatedAt = time.Now()
+	m.attempts = append(m.attempts, attempt)
+	return attempt.ID.Hex(), nil
+}
+
+func (m *memoryAttempts) ListAttempts(ctx context.Context, sectionID, userID string) ([]domain.Attempt, error) {
+	attempts := []domain.Attempt{}
+	for _, attempt := range m.attempts {
+		if attempt.SectionID == sectionID && attempt.UserID == userID {
+			attempt.Explanation = nil
+			attempts = append(attempts, attempt)
+		}
+	}
+	return attempts, nil
+}
+
+func (m *memoryAttempts) GetAttempt(ctx context.Context, attemptID, userID string) (domain.Attempt, error) {
+	for _, attempt := range m.attempts {
+		if attempt.ID.Hex() == attemptID && attempt.UserID == userID {
+			return attempt, nil
+		}
+	}
+	return domain.Attempt{}, errors.New("repository/attempt_repository: " + mongo.ErrNoDocuments.Error())
+}
+
+func (m *memoryAttempts) OfficialAttempt(ctx context.Context, sectionID, userID string) (domain.Attempt, error) {
+	for _, attempt := range m.attempts {
+		if attempt.SectionID == sectionID && attempt.UserID == userID && attempt.Official {
+			return attempt, nil
+		}
+	}
+	return domain.Attempt{}, mongo.ErrNoDocuments
+}
+
+func (m *memoryAttempts) SetExplanation(ctx context.Context, attemptID string, explanation []domain.QeustionAnswer) error {
+	for i, attempt := range m.attempts {
+		if attempt.ID.Hex() == attemptID {
+			m.attempts[i].Explanation = explanation
+		}
+	}
+	return nil
+}
+
+// memorySections holds the sections of users and their conversations.
+type memorySections struct {
+	repository.ViewRepository
+	sections      []domain.Section
+	conversations map[string]domain.Conversation
+}
+
+func (m *memorySections) GetSection(ctx context.Context, sectionID, userID string) (domain.Section, error) {
+	for _, section := range m.sections {
+		if section.ID.Hex() == sectionID && section.CreatedBy == userID {
+			return section, nil
+		}
+	}
+	return domain.Section{}, errors.New("repository/view_repository: " + mongo.ErrNoDocuments.Error())
+}
+
+func (m *memorySections) GetExplanation(ctx context.Context, explanationID string) (domain.Conversation, error) {
+	conversation, ok := m.conversations[explanationID]
+	if !ok {
+		return domain.Conversation{}, mongo.ErrNoDocuments
+	}
+	return conversation, nil
+}
+
+func (m *memorySections) SectionRounds(ctx context.Context, rootID, userID string) ([]domain.Section, error) {
+	var rounds []domain.Section
+	for _, section := range m.sections {
+		if (section.ID.Hex() == rootID || section.RootID == rootID) && section.CreatedBy == userID {
+			rounds = append(rounds, section)
+		}
+	}
+	slices.SortFunc(rounds, func(a, b domain.Section) int { return a.Round - b.Round })
+	return rounds, nil
+}
+
+// explainer explains answers the way the model would, streaming the
+// response.
+type explainer struct {
+	repository.ActionRepository
+	calls int
+}
+
+func (e *explainer) ExplainAnswers(ctx context.Context, conversationID string, answers []domain.Answer, onChunk func(chunk string) error) (string, []domain.Citation, error) {
+	e.calls++
+
+	raw := infrastructure.EncodeExplanations([]domain.QeustionAnswer{
+		{QuestionNumber: 1, CorrectAnswer: "B", YourAnswer: answers[0].Answer, Explanation: "mitosis makes two cells"},
+	})
+	if onChunk != nil {
+		if err := onChunk(raw); err != nil {
+			return "", nil, err
+		}
+	}
+	return raw, []domain.Citation{{QuestionNO: 1, FirstPage: 2, LastPage: 2, Snippet: "cells divide by mitosis"}}, nil
+}
+
+// attemptFixture is a user with a section, a follow-up round of it, and a
+// section of somebody else.
+type attemptFixture struct {
+	attempts *memoryAttempts
+	sections *memorySections
+	explain  *explainer
+	usecase  usecases.AttemptUsecase
+	root     domain.Section
+	followUp domain.Section
+	other    domain.Section
+}
+
+func newAttemptFixture() *attemptFixture {
+	root := domain.Section{ID: primitive.NewObjectID(), SectionName: "Cells", QuestionsID: "quiz-1", ExplanationsID: "conversation-1", CreatedBy: "abebe", Round: 1}
+	followUp := domain.Section{ID: primitive.NewObjectID(), SectionName: "Cells, round 2", QuestionsID: "quiz-2", ExplanationsID: "conversation-2", CreatedBy: "abebe", ParentID: root.ID.Hex(), RootID: root.ID.Hex(), Round: 2}
+	other := domain.Section{ID: primitive.NewObjectID(), SectionName: "Plants", QuestionsID: "quiz-3", CreatedBy: "kebede", Round: 1}
+
+	fixture := &attemptFixture{
+		attempts: &memoryAttempts{},
+		sections: &memorySections{sections: []domain.Section{followUp, root, other}, conversations: map[string]domain.Conversation{}},
+		explain:  &explainer{},
+		root:     root,
+		followUp: followUp,
+		other:    other,
+	}
+	fixture.usecase = usecases.NewAttemptUsecase(fixture.attempts, fixture.explain, fixture.sections)
+	return fixture
+}
+
+func (f *attemptFixture) record(t *testing.T, section domain.Section, userID string, score int, official bool) string {
+	t.Helper()
+
+	answers := domain.AnswerList{Answers: []domain.Answer{{QuestionNO: 1, Answer: "A"}, {QuestionNO: 2, Answer: "C"}}, DurationSeconds: 90}
+	grade := domain.QuizGrade{Score: score, Total: 2, Results: []domain.QuestionResult{{QuestionNO: 1, Correct: score > 1}, {QuestionNO: 2, Correct: score > 0}}}
+
+	attemptID, err := f.usecase.RecordAttempt(context.Background(), section, userID, answers, grade, official)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return attemptID
+}
+
+func TestRecordAttempt(t *testing.T) {
+	f := newAttemptFixture()
+	ctx := context.Background()
+
+	official := f.record(t, f.root, "abebe", 1, true)
+
+	// a clock running backwards doesn't make a negative duration
+	practice, err := f.usecase.RecordAttempt(ctx, f.root, "abebe", domain.AnswerList{Answers: []domain.Answer{{QuestionNO: 1, Answer: "B"}}, DurationSeconds: -5}, domain.QuizGrade{Score: 2, Total: 2}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attempt, err := f.usecase.GetAttempt(ctx, official, "abebe")
+	if err != nil || attempt.SectionID != f.root.ID.Hex() || attempt.QuizID != "quiz-1" || !attempt.Official || attempt.Score != 1 || attempt.DurationSeconds != 90 || len(attempt.Answers) != 2 {
+		t.Errorf("unexpected official attempt %+v %v", attempt, err)
+	}
+
+	if attempt, _ := f.usecase.GetAttempt(ctx, practice, "abebe"); attempt.Official || attempt.DurationSeconds != 0 {
+		t.Errorf("expected a practice attempt without duration, got %+v", attempt)
+	}
+
+	if _, err := f.usecase.GetAttempt(ctx, official, "kebede"); err == nil {
+		t.Error("expected the attempt of another user to be hidden")
+	}
+
+	attempts, err := f.usecase.ListAttempts(ctx, f.root.ID.Hex(), "abebe")
+	if err != nil || len(attempts) != 2 || attempts[0].ID.Hex() != official {
+		t.Errorf("expected both attempts in order, got %+v %v", attempts, err)
+	}
+}
+
+func TestExplainAttempt(t *testing.T) {
+	f := newAttemptFixture()
+	ctx := context.Background()
+
+	// the official attempt reuses the explanation made when the quiz was submitted
+	f.sections.conversations["conversation-1"] = domain.Conversation{Turns: []domain.ConversationTurn{
+		{User: "quiz"},
+		{
+			User:    "explain",
+			Gemini:  `{"answers":[{"question_number":1,"correct_answer":"B","your_answer":"A","correctness":false,"explanation":"stored explanation"}]}`,
+			Sources: []domain.Citation{{QuestionNO: 1, FirstPage: 3, LastPage: 3, Snippet: "stored source"}},
+		},
+	}}
+
+	official := f.record(t, f.root, "abebe", 1, true)
+
+	explanation, err := f.usecase.ExplainAttempt(ctx, official, "abebe", nil)
+	if err != nil || len(explanation) != 1 || explanation[0].Explanation != "stored explanation" || len(explanation[0].Sources) != 1 {
+		t.Fatalf("expected the stored explanation with its source, got %+v %v", explanation, err)
+	}
+	if f.explain.calls != 0 {
+		t.Errorf("expected no generation for the official attempt, got %d", f.explain.calls)
+	}
+
+	// practice attempts are explained on their own, streamed the first time
+	practice := f.record(t, f.root, "abebe", 0, false)
+
+	var streamed string
+	explanation, err = f.usecase.ExplainAttempt(ctx, practice, "abebe", func(chunk string) error {
+		streamed += chunk
+		return nil
+	})
+	if err != nil || len(explanation) != 1 || explanation[0].Explanation != "mitosis makes two cells" || explanation[0].Sources[0].Snippet != "cells divide by mitosis" {
+		t.Fatalf("expected a generated explanation with its source, got %+v %v", explanation, err)
+	}
+	if streamed == "" {
+		t.Error("expected the explanation to be streamed")
+	}
+
+	again, err := f.usecase.ExplainAttempt(ctx, practice, "abebe", nil)
+	if err != nil || len(again) != 1 || f.explain.calls != 1 {
+		t.Errorf("expected the stored explanation the second time, got %+v after %d generations", again, f.explain.calls)
+	}
+
+	if _, err := f.usecase.ExplainAttempt(ctx, practice, "kebede", nil); err == nil {
+		t.Error("expected another user not to get the explanation")
+	}
+}
+
+func TestSectionProgress(t *testing.T) {
+	f := newAttemptFixture()
+	ctx := context.Background()
+
+	f.record(t, f.root, "abebe", 1, true)
+	f.record(t, f.root, "abebe", 2, false)
+
+	// the rounds are the same asked from any of them
+	for _, section := range []domain.Section{f.root, f.followUp} {
+		progress, err := f.usecase.SectionProgress(ctx, section, "abebe")
+		if err != nil || len(progress) != 2 {
+			t.Fatalf("expected two rounds, got %+v %v", progress, err)
+		}
+
+		if first := progress[0]; first.SectionID != f.root.ID.Hex() || !first.Taken || first.Score != 1 || first.Total != 2 {
+			t.Errorf("expected the official score of the first round, got %+v", first)
+		}
+
+		if second := progress[1]; second.SectionID != f.followUp.ID.Hex() || second.Round != 2 || second.Taken {
+			t.Errorf("expected the follow-up not to be taken, got %+v", second)
+		}
+	}
+}
+
+func TestAttemptController(t *testing.T) {
+	f := newAttemptFixture()
+	attempts := controller.NewAttemptController(f.usecase, usecases.NewViewUsecase(f.sections))
+
+	official := f.record(t, f.root, "abebe", 1, true)
+	other := f.record(t, f.other, "kebede", 2, true)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(ctx *gin.Context) { ctx.Set("user_id", "abebe") })
+	router.GET("/section/:id/attempts", attempts.ListAttempts)
+	router.GET("/section/:id/progress", attempts.SectionProgress)
+	router.GET("/attempts/:id", attempts.GetAttempt)
+	router.POST("/attempts/:id/explanation", attempts.ExplainAttempt)
+
+	request := func(method, path string, body any) int {
+		t.Helper()
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+		if body != nil && recorder.Code == http.StatusOK {
+			if err := json.NewDecoder(recorder.Body).Decode(body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return recorder.Code
+	}
+
+	var list []domain.Attempt
+	if status := request(http.MethodGet, "/section/"+f.root.ID.Hex()+"/attempts", &list); status != http.StatusOK || len(list) != 1 || list[0].ID.Hex() != official {
+		t.Errorf("expected the attempt of the section, got %d %+v", status, list)
+	}
+
+	if status := request(http.MethodGet, "/section/"+f.other.ID.Hex()+"/attempts", nil); status != http.StatusNotFound {
+		t.Errorf("expected the section of another user to be not found, got %d", status)
+	}
+
+	if status := request(http.MethodGet, "/attempts/"+other, nil); status != http.StatusNotFound {
+		t.Errorf("expected the attempt of another user to be not found, got %d", status)
+	}
+
+	var explanation []domain.QeustionAnswer
+	if status := request(http.MethodPost, "/attempts/"+official+"/explanation", &explanation); status != http.StatusOK || len(explanation) != 1 {
+		t.Errorf("expected the explanation, got %d %+v", status, explanation)
+	}
+
+	var progress struct {
+		Rounds []domain.RoundProgress `json:"rounds"`
+	}
+	if status := request(http.MethodGet, "/section/"+f.followUp.ID.Hex()+"/progress", &progress); status != http.StatusOK || len(progress.Rounds) != 2 || !progress.Rounds[0].Taken {
+		t.Errorf("expected the progress of both rounds, got %d %+v", status, progress)
+	}
+}
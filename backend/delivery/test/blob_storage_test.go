@@ -0,0 +1,130 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"github/chera/fix-it/infrastructure"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLocalBlobStorage(t *testing.T) {
+	storage, err := infrastructure.NewLocalBlobStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	if err := storage.Put(ctx, "book.pdf", []byte("%PDF-1.4 hello"), "application/pdf"); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := storage.Open(ctx, "book.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file.Seek(9, io.SeekStart)
+	rest, _ := io.ReadAll(file)
+	file.Close()
+
+	if string(rest) != "hello" {
+		t.Errorf("expected to read from the offset, got %q", rest)
+	}
+
+	if err := storage.Put(ctx, "../escape.pdf", []byte("x"), ""); err == nil {
+		t.Error("expected keys outside the storage to be rejected")
+	}
+
+	if err := storage.Delete(ctx, "book.pdf"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storage.Open(ctx, "book.pdf"); !errors.Is(err, infrastructure.ErrBlobNotFound) {
+		t.Errorf("expected a deleted blob to be missing, got %v", err)
+	}
+}
+
+// fakeS3 keeps objects in memory and answers ranges like S3 does.
+func fakeS3(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	objects := map[string][]byte{}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=minio/") || !strings.Contains(auth, "/us-east-1/s3/aws4_request") || r.Header.Get("X-Amz-Date") == "" {
+			t.Errorf("unsigned request %s %s: %q", r.Method, r.URL.Path, auth)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.Method {
+		case "PUT":
+			body, _ := io.ReadAll(r.Body)
+			objects[r.URL.Path] = body
+		case "DELETE":
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		case "HEAD", "GET":
+			body, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if r.Method == "HEAD" {
+				w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+				return
+			}
+			start, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(r.Header.Get("Range"), "bytes="), "-"))
+			w.Header().Set("Content-Range", "bytes "+strconv.Itoa(start)+"-"+strconv.Itoa(len(body)-1)+"/"+strconv.Itoa(len(body)))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(body[start:])
+		}
+	}))
+}
+
+func TestS3BlobStorageServesRanges(t *testing.T) {
+	server := fakeS3(t)
+	defer server.Close()
+
+	storage := infrastructure.NewS3BlobStorage(infrastructure.S3Config{Endpoint: server.URL, Bucket: "fix-it", AccessKey: "minio", SecretKey: "minio123"})
+	ctx := context.Background()
+
+	if err := storage.Put(ctx, "book.pdf", []byte("0123456789"), "application/pdf"); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := storage.Open(ctx, "book.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	req := httptest.NewRequest("GET", "/r/pdf/1", nil)
+	req.Header.Set("Range", "bytes=3-5")
+	recorder := httptest.NewRecorder()
+
+	http.ServeContent(recorder, req, "book.pdf", time.Time{}, file)
+
+	if recorder.Code != http.StatusPartialContent || recorder.Body.String() != "345" || recorder.Header().Get("Content-Range") != "bytes 3-5/10" {
+		t.Errorf("unexpected range response %d %q %q", recorder.Code, recorder.Body.String(), recorder.Header().Get("Content-Range"))
+	}
+
+	if err := storage.Delete(ctx, "book.pdf"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storage.Open(ctx, "book.pdf"); !errors.Is(err, infrastructure.ErrBlobNotFound) {
+		t.Errorf("expected a deleted object to be missing, got %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package test
+
+import (
+	"github/chera/fix-it/domain"
+	"github/chera/fix-it/infrastructure"
+	"strings"
+	"testing"
+)
+
+func TestChatOverflowKeepsNewestTurns(t *testing.T) {
+	turns := make([]domain.ChatTurn, 10)
+	for i := range turns {
+		turns[i] = domain.ChatTurn{User: "question", Tutor: "answer"}
+	}
+
+	if overflow := infrastructure.ChatOverflow(turns, 4, 10000); overflow != 6 {
+		t.Errorf("expected 6 turns over the turn limit, got %d", overflow)
+	}
+
+	// each turn is about 4 tokens
+	if overflow := infrastructure.ChatOverflow(turns, 100, 10); overflow != 8 {
+		t.Errorf("expected 8 turns over the token budget, got %d", overflow)
+	}
+
+	huge := []domain.ChatTurn{{User: strings.Repeat("x", 1000), Tutor: "ok"}}
+	if overflow := infrastructure.ChatOverflow(huge, 4, 10); overflow != 0 {
+		t.Errorf("expected the newest turn to be kept, got an overflow of %d", overflow)
+	}
+}
+
+func TestChatHistoryUsesSummaryAndWindow(t *testing.T) {
+	conversation := domain.Conversation{
+		Turns: []domain.ConversationTurn{{User: "quiz prompt", Gemini: "quiz"}},
+		Chat: []domain.ChatTurn{
+			{User: "first", Tutor: "1"},
+			{User: "second", Tutor: "2"},
+			{User: "third", Tutor: "3"},
+		},
+		ChatSummary:    "we talked about the first question",
+		ChatSummarized: 1,
+	}
+
+	history := infrastructure.ChatHistory(conversation, 0)
+
+	if len(history) != 4 {
+		t.Fatalf("expected quiz, summary and two chat turns, got %d turns", len(history))
+	}
+
+	if history[0].User != "quiz prompt" || !strings.Contains(history[1].User, conversation.ChatSummary) {
+		t.Errorf("expected the quiz turn then the summary, got %+v", history[:2])
+	}
+
+	if history[2].User != "second" || history[3].Gemini != "3" {
+		t.Errorf("expected the unsummarized chat turns, got %+v", history[2:])
+	}
+
+	if skipped := infrastructure.ChatHistory(conversation, 1); len(skipped) != 3 || skipped[2].User != "third" {
+		t.Errorf("expected skipped turns to be left out, got %+v", skipped)
+	}
+}
@@ -0,0 +1,125 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"github/chera/fix-it/domain"
+	"github/chera/fix-it/infrastructure"
+	"github/chera/fix-it/repository"
+	"github/chera/fix-it/usecases"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestChunkPagesRespectsBudget(t *testing.T) {
+	paragraph := strings.Repeat("word ", 100)
+	pages := []domain.DocumentPage{
+		{Number: 1, Text: "CHAPTER ONE\n" + paragraph + "\n\n" + paragraph},
+		{Number: 2, Text: "2.1 Second Topic\n" + paragraph + "\n\n" + strings.Repeat("long ", 400)},
+	}
+
+	chunks := infrastructure.ChunkPages(pages, 200)
+
+	if len(chunks) < 3 {
+		t.Fatalf("expected the document to be split, got %d chunks", len(chunks))
+	}
+
+	for i, chunk := range chunks {
+		if chunk.Index != i {
+			t.Errorf("chunk %d has index %d", i, chunk.Index)
+		}
+		if infrastructure.EstimateTokens(chunk.Text) > 200 {
+			t.Errorf("chunk %d is over budget: %d tokens", i, infrastructure.EstimateTokens(chunk.Text))
+		}
+	}
+
+	if chunks[0].Heading != "CHAPTER ONE" || chunks[0].FirstPage != 1 {
+		t.Errorf("unexpected first chunk %+v", chunks[0])
+	}
+
+	if chunks[len(chunks)-1].LastPage != 2 {
+		t.Errorf("expected the last chunk to end on page 2, got %d", chunks[len(chunks)-1].LastPage)
+	}
+}
+
+func TestChunkPagesKeepsSmallDocumentWhole(t *testing.T) {
+	pages := []domain.DocumentPage{{Number: 1, Text: "short"}, {Number: 2, Text: "text"}}
+
+	chunks := infrastructure.ChunkPages(pages, 6000)
+
+	if len(chunks) != 1 || chunks[0].FirstPage != 1 || chunks[0].LastPage != 2 {
+		t.Fatalf("expected one chunk covering both pages, got %+v", chunks)
+	}
+}
+
+func TestMergeQuestionsDeduplicates(t *testing.T) {
+	perChunk := [][]domain.Question{
+		{{Question: "What is the capital of France?"}, {Question: "Who wrote Hamlet?"}},
+		{{Question: "what is the capital of France", SourceChunk: 1}, {Question: "What is photosynthesis?", SourceChunk: 1}},
+	}
+
+	merged := infrastructure.MergeQuestions(perChunk, 10)
+
+	if len(merged) != 3 {
+		t.Fatalf("expected 3 questions, got %d", len(merged))
+	}
+
+	if merged[1].Question != "What is photosynthesis?" || merged[1].SourceChunk != 1 {
+		t.Errorf("expected chunks to be merged round robin, got %+v", merged)
+	}
+
+	if len(infrastructure.MergeQuestions(perChunk, 2)) != 2 {
+		t.Errorf("expected the merge to respect the limit")
+	}
+}
+
+// chunkQuizzes generates one question on every chunk it is given.
+type chunkQuizzes struct {
+	repository.ActionRepository
+	calls atomic.Int32
+}
+
+func (c *chunkQuizzes) UploadForGemini(ctx context.Context, processedText string, options domain.QuizOptions) ([]domain.ConversationTurn, error) {
+	n := c.calls.Add(1)
+	question := domain.Question{Type: domain.QuestionTrueFalse, Question: fmt.Sprintf("Statement %d about %q", n, processedText[:20]), A: "True", B: "False", Answer: "A"}
+	return []domain.ConversationTurn{{User: processedText, Gemini: infrastructure.EncodeQuestions([]domain.Question{question})}}, nil
+}
+
+func (c *chunkQuizzes) FormatQeustion(question string) []domain.Question {
+	return infrastructure.ParseQuestionsResponse(question)
+}
+
+func TestUploadForGeminiKeepsTheDocumentOutOfTheQuizTurn(t *testing.T) {
+	pages := []domain.DocumentPage{
+		{Number: 1, Text: "Mitosis " + strings.Repeat("divides cells ", 150)},
+		{Number: 2, Text: "Meiosis " + strings.Repeat("makes gametes ", 150)},
+		{Number: 3, Text: "Osmosis " + strings.Repeat("moves water ", 150)},
+	}
+
+	generator := &chunkQuizzes{}
+	action := usecases.NewActionUsecase(generator, infrastructure.GenerationConfig{ChunkTokenBudget: 400, Concurrency: 2}, infrastructure.UploadLimits{}, nil)
+
+	questions, conversation, err := action.UploadForGemini(context.Background(), pages, domain.QuizOptions{QuestionCount: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if generator.calls.Load() < 3 || len(questions) != 3 || len(conversation) != 1 {
+		t.Fatalf("expected a question from each of the chunks, got %d calls, %d questions", generator.calls.Load(), len(questions))
+	}
+
+	// explanations and chat replay this turn, they retrieve the passages they need
+	if !strings.Contains(conversation[0].User, fmt.Sprintf("split into %d parts", generator.calls.Load())) {
+		t.Errorf("expected the quiz turn to name the parts, got %q", conversation[0].User)
+	}
+	for _, text := range []string{"[page 1]", "Mitosis divides cells", "Osmosis moves water"} {
+		if strings.Contains(conversation[0].User, text) {
+			t.Errorf("expected the quiz turn not to carry %q", text)
+		}
+	}
+
+	if stored := infrastructure.ParseQuestionsResponse(conversation[0].Gemini); len(stored) != 3 {
+		t.Errorf("expected the merged quiz in the turn, got %+v", stored)
+	}
+}
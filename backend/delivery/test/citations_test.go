@@ -0,0 +1,82 @@
+package test
+
+import (
+	"github/chera/fix-it/domain"
+	"github/chera/fix-it/infrastructure"
+	"testing"
+)
+
+func TestDecodeQuestionsReadsCitations(t *testing.T) {
+	raw := `{"questions":[{"question":"Q","a":"1","b":"2","c":"3","d":"4","answer":"A","pages":[4,3],"quote":"Cells divide by mitosis."}]}`
+
+	questions, err := infrastructure.DecodeQuestions(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := questions[0].Source
+	if source == nil || source.FirstPage != 3 || source.LastPage != 4 || source.Snippet != "Cells divide by mitosis." {
+		t.Fatalf("unexpected source %+v", source)
+	}
+
+	again, err := infrastructure.DecodeQuestions(infrastructure.EncodeQuestions(questions))
+	if err != nil || again[0].Source == nil || *again[0].Source != *source {
+		t.Errorf("expected the citation to survive encoding, got %+v %v", again, err)
+	}
+}
+
+func TestVerifyCitations(t *testing.T) {
+	document := infrastructure.PageChunks([]domain.DocumentPage{
+		{Number: 1, Text: "Plants make their food\nby photo-\nsynthesis in the leaves."},
+		{Number: 2, Text: "Animal cells divide by mitosis, producing two identical cells."},
+	})
+
+	questions := []domain.Question{
+		{Question: "cited right", Source: &domain.Citation{FirstPage: 2, LastPage: 2, Snippet: "Animal cells divide by mitosis"}},
+		{Question: "wrong page", Source: &domain.Citation{FirstPage: 7, LastPage: 7, Snippet: "make their food by photosynthesis"}},
+		{Question: "invented", Source: &domain.Citation{FirstPage: 1, LastPage: 1, Snippet: "Plants eat insects for their food"}},
+		{Question: "too short", Source: &domain.Citation{FirstPage: 2, LastPage: 2, Snippet: "mitosis"}},
+		{Question: "uncited"},
+	}
+
+	if rejected := infrastructure.VerifyCitations(questions, document); rejected != 2 {
+		t.Errorf("expected 2 citations to be rejected, got %d", rejected)
+	}
+
+	if source := questions[0].Source; source == nil || source.FirstPage != 2 {
+		t.Errorf("expected the correct citation to be kept, got %+v", source)
+	}
+
+	if source := questions[1].Source; source == nil || source.FirstPage != 1 || source.LastPage != 1 {
+		t.Errorf("expected the page to be corrected, got %+v", source)
+	}
+
+	if questions[2].Source != nil || questions[3].Source != nil || questions[4].Source != nil {
+		t.Errorf("expected unsupported citations to be dropped, got %+v", questions)
+	}
+}
+
+func TestVerifyCitationsAcrossPageBreak(t *testing.T) {
+	document := infrastructure.PageChunks([]domain.DocumentPage{
+		{Number: 4, Text: "The heart pumps blood through the arteries to every organ of the"},
+		{Number: 5, Text: "body, and the veins carry it back.\nThe lungs add oxy-"},
+		{Number: 6, Text: "gen to the blood on its way through them."},
+	})
+
+	questions := []domain.Question{
+		{Question: "cited right", Source: &domain.Citation{FirstPage: 4, LastPage: 5, Snippet: "to every organ of the body, and the veins"}},
+		{Question: "wrong page", Source: &domain.Citation{FirstPage: 1, LastPage: 1, Snippet: "the lungs add oxygen to the blood"}},
+	}
+
+	if rejected := infrastructure.VerifyCitations(questions, document); rejected != 0 {
+		t.Errorf("expected quotes over a page break to be found, %d were rejected", rejected)
+	}
+
+	if source := questions[0].Source; source == nil || source.FirstPage != 4 || source.LastPage != 5 {
+		t.Errorf("expected the citation to be kept, got %+v", source)
+	}
+
+	if source := questions[1].Source; source == nil || source.FirstPage != 5 || source.LastPage != 6 {
+		t.Errorf("expected the pages to be corrected to 5-6, got %+v", source)
+	}
+}
@@ -0,0 +1,197 @@
+package test
+
+import (
+	"context"
+	"github/chera/fix-it/domain"
+	"github/chera/fix-it/infrastructure"
+	"github/chera/fix-it/repository"
+	"github/chera/fix-it/usecases"
+	"io"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestContentHashIgnoresName(t *testing.T) {
+	first := infrastructure.ContentHash([]byte("%PDF-1.4 notes"))
+
+	if first != infrastructure.ContentHash([]byte("%PDF-1.4 notes")) || len(first) != 64 {
+		t.Errorf("expected a stable sha256 hash, got %s", first)
+	}
+
+	if first == infrastructure.ContentHash([]byte("%PDF-1.4 notes v2")) {
+		t.Error("expected different files to hash differently")
+	}
+}
+
+func TestSameQuizOptions(t *testing.T) {
+	requested := domain.QuizOptions{QuestionCount: 10, Difficulty: "medium", QuestionTypes: []string{"true_false", "single_choice"}}
+	if err := infrastructure.ValidateQuizOptions(&requested, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := domain.QuizOptions{QuestionCount: 10, Difficulty: "medium", QuestionTypes: []string{"single_choice", "true_false"}}
+
+	if !infrastructure.SameQuizOptions(stored, requested) {
+		t.Error("expected the order of question types not to matter")
+	}
+
+	stored.QuestionCount = 5
+	if infrastructure.SameQuizOptions(stored, requested) {
+		t.Error("expected a different question count to need a new quiz")
+	}
+}
+
+// uploads remembers the extractions, documents and sections of earlier
+// uploads and finds them by content hash the way the mongo repository does.
+type uploads struct {
+	repository.ActionRepository
+	extractions []domain.Extraction
+	documents   map[string]domain.Document
+	quizzes     map[string]domain.QuizOptions
+	sections    []domain.Section
+	extracted   int
+	generated   int
+}
+
+func newUploads() *uploads {
+	return &uploads{documents: map[string]domain.Document{}, quizzes: map[string]domain.QuizOptions{}}
+}
+
+func (u *uploads) FindSectionByJob(ctx context.Context, jobID string) (domain.Section, error) {
+	return domain.Section{}, mongo.ErrNoDocuments
+}
+
+func (u *uploads) ExtractText(ctx context.Context, file io.Reader, filename string) ([]domain.DocumentPage, error) {
+	u.extracted++
+	return []domain.DocumentPage{{Number: 1, Text: "Mitosis divides cells."}, {Number: 2, Text: "Meiosis makes gametes."}}, nil
+}
+
+func (u *uploads) CachedExtraction(ctx context.Context, hash, userID string) ([]domain.DocumentPage, error) {
+	for _, extraction := range u.extractions {
+		if extraction.Hash == hash && (userID == "" || extraction.CreatedBy == userID) {
+			return extraction.Pages, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (u *uploads) SaveExtraction(ctx context.Context, extraction domain.Extraction) error {
+	u.extractions = append(u.extractions, extraction)
+	return nil
+}
+
+func (u *uploads) FindSectionByHash(ctx context.Context, userID, hash string, quizOptions domain.QuizOptions, pages string) (domain.Section, error) {
+	for _, section := range u.sections {
+		if section.CreatedBy == userID && u.documents[section.PDFID].Hash == hash && section.Pages == pages && infrastructure.SameQuizOptions(u.quizzes[section.QuestionsID], quizOptions) {
+			return section, nil
+		}
+	}
+	return domain.Section{}, mongo.ErrNoDocuments
+}
+
+func (u *uploads) UploadForGemini(ctx context.Context, processedText string, options domain.QuizOptions) ([]domain.ConversationTurn, error) {
+	u.generated++
+	question := domain.Question{Type: domain.QuestionTrueFalse, Question: "Mitosis divides cells.", A: "True", B: "False", Answer: "A"}
+	return []domain.ConversationTurn{{User: processedText, Gemini: infrastructure.EncodeQuestions([]domain.Question{question})}}, nil
+}
+
+func (u *uploads) FormatQeustion(question string) []domain.Question {
+	return infrastructure.ParseQuestionsResponse(question)
+}
+
+func (u *uploads) UploadQuestions(ctx context.Context, questions []domain.Question, options domain.QuizOptions, userID string) (string, error) {
+	quizID := primitive.NewObjectID().Hex()
+	u.quizzes[quizID] = options
+	return quizID, nil
+}
+
+func (u *uploads) UploadConversation(ctx context.Context, conversation []domain.ConversationTurn) (string, error) {
+	return primitive.NewObjectID().Hex(), nil
+}
+
+func (u *uploads) UploadDocument(ctx context.Context, document domain.Document, content []byte) (string, error) {
+	pdfID := primitive.NewObjectID().Hex()
+	u.documents[pdfID] = document
+	return pdfID, nil
+}
+
+func (u *uploads) SaveChunks(ctx context.Context, pdfID string, pages []domain.DocumentPage, budget int) error {
+	return nil
+}
+
+func (u *uploads) UploadSection(ctx context.Context, section domain.Section) (string, error) {
+	section.ID = primitive.NewObjectID()
+	u.sections = append(u.sections, section)
+	return section.ID.Hex(), nil
+}
+
+func uploadJob(userID, filename string) domain.Job {
+	return domain.Job{ID: primitive.NewObjectID(), UserID: userID, Filename: filename, File: []byte("Mitosis divides cells.\fMeiosis makes gametes."), Options: domain.QuizOptions{QuestionCount: 2}}
+}
+
+func processUpload(t *testing.T, action usecases.ActionUsecase, job domain.Job) string {
+	t.Helper()
+
+	sectionID, err := action.ProcessUpload(context.Background(), job, func(stage string, progress int) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sectionID
+}
+
+func TestProcessUploadReusesEarlierUploads(t *testing.T) {
+	repo := newUploads()
+	action := usecases.NewActionUsecase(repo, infrastructure.GenerationConfig{QuestionCount: 5, ChunkTokenBudget: 1000, Concurrency: 1}, infrastructure.UploadLimits{}, nil)
+
+	first := processUpload(t, action, uploadJob("abebe", "notes.txt"))
+	if repo.extracted != 1 || repo.generated != 1 || len(repo.sections) != 1 {
+		t.Fatalf("expected the first upload to be extracted and generated, got %d extractions, %d generations", repo.extracted, repo.generated)
+	}
+
+	// the same file under another name is the same document
+	if again := processUpload(t, action, uploadJob("abebe", "notes (1).txt")); again != first {
+		t.Errorf("expected the section of the first upload, got %s", again)
+	}
+	if repo.extracted != 1 || repo.generated != 1 || len(repo.sections) != 1 {
+		t.Errorf("expected nothing to be extracted or generated again, got %d extractions, %d generations", repo.extracted, repo.generated)
+	}
+
+	// other quiz options need a quiz of their own, from the cached text
+	job := uploadJob("abebe", "notes.txt")
+	job.Options.QuestionCount = 1
+	if other := processUpload(t, action, job); other == first || repo.extracted != 1 || repo.generated != 2 {
+		t.Errorf("expected a new quiz from the cached text, got %d extractions, %d generations", repo.extracted, repo.generated)
+	}
+
+	job = uploadJob("abebe", "notes.txt")
+	job.ForceRegenerate = true
+	if forced := processUpload(t, action, job); forced == first || repo.extracted != 2 || repo.generated != 3 {
+		t.Errorf("expected force_regenerate to extract and generate again, got %d extractions, %d generations", repo.extracted, repo.generated)
+	}
+}
+
+func TestProcessUploadSharesExtractionsWhenConfigured(t *testing.T) {
+	repo := newUploads()
+	config := infrastructure.GenerationConfig{QuestionCount: 5, ChunkTokenBudget: 1000, Concurrency: 1}
+
+	processUpload(t, usecases.NewActionUsecase(repo, config, infrastructure.UploadLimits{}, nil), uploadJob("abebe", "notes.txt"))
+
+	// the extractions of other users aren't used by default
+	processUpload(t, usecases.NewActionUsecase(repo, config, infrastructure.UploadLimits{}, nil), uploadJob("kebede", "notes.txt"))
+	if repo.extracted != 2 {
+		t.Errorf("expected the file to be extracted for each user, got %d extractions", repo.extracted)
+	}
+
+	config.ShareExtractions = true
+	processUpload(t, usecases.NewActionUsecase(repo, config, infrastructure.UploadLimits{}, nil), uploadJob("almaz", "notes.txt"))
+	if repo.extracted != 2 {
+		t.Errorf("expected the shared extraction to be used, got %d extractions", repo.extracted)
+	}
+
+	// sections are never shared
+	if repo.generated != 3 || len(repo.sections) != 3 || repo.sections[2].CreatedBy != "almaz" {
+		t.Errorf("expected a quiz for each user, got %d generations, %+v", repo.generated, repo.sections)
+	}
+}
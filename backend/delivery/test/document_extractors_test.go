@@ -0,0 +1,183 @@
+package test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"github/chera/fix-it/delivery/controller"
+	"github/chera/fix-it/domain"
+	"github/chera/fix-it/infrastructure"
+	"github/chera/fix-it/usecases"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func zipFile(t *testing.T, files map[string]string) []byte {
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+
+	// epub readers expect the mimetype first
+	if content, ok := files["mimetype"]; ok {
+		writer, _ := archive.Create("mimetype")
+		writer.Write([]byte(content))
+	}
+
+	for name, content := range files {
+		if name == "mimetype" {
+			continue
+		}
+		writer, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer.Write([]byte(content))
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+var (
+	testDOCX = map[string]string{
+		"[Content_Types].xml": `<Types/>`,
+		"word/document.xml": `<w:document xmlns:w="w"><w:body>
+			<w:p><w:r><w:t>Cells divide</w:t></w:r><w:r><w:t xml:space="preserve"> by mitosis.</w:t></w:r></w:p>
+			<w:p><w:r><w:br w:type="page"/><w:t>Plants use photosynthesis.</w:t></w:r></w:p>
+		</w:body></w:document>`,
+	}
+	testPPTX = map[string]string{
+		"ppt/presentation.xml":   `<p:presentation xmlns:p="p"/>`,
+		"ppt/slides/slide10.xml": `<p:sld xmlns:p="p" xmlns:a="a"><a:p><a:r><a:t>Last slide</a:t></a:r></a:p></p:sld>`,
+		"ppt/slides/slide2.xml":  `<p:sld xmlns:p="p" xmlns:a="a"><a:p><a:r><a:t>First slide</a:t></a:r></a:p></p:sld>`,
+	}
+	testEPUB = map[string]string{
+		"mimetype":               "application/epub+zip",
+		"META-INF/container.xml": `<container><rootfiles><rootfile full-path="OEBPS/content.opf"/></rootfiles></container>`,
+		"OEBPS/content.opf": `<package><manifest>
+			<item id="cover" href="cover.xhtml"/><item id="one" href="text/chapter%201.xhtml"/><item id="two" href="text/two.xhtml"/>
+		</manifest><spine><itemref idref="cover"/><itemref idref="one"/><itemref idref="two"/></spine></package>`,
+		"OEBPS/cover.xhtml":          `<html><body><img src="cover.png"/></body></html>`,
+		"OEBPS/text/chapter 1.xhtml": `<html><head><title>skip me</title></head><body><h1>Chapter 1</h1><p>Atoms have a nucleus.</p></body></html>`,
+		"OEBPS/text/two.xhtml":       `<html><body><p>Electrons orbit it.</p><script>alert(1)</script></body></html>`,
+	}
+)
+
+func TestDetectMediaType(t *testing.T) {
+	cases := []struct {
+		content  []byte
+		filename string
+		expected string
+	}{
+		{[]byte("%PDF-1.7\n..."), "notes.bin", domain.MediaTypePDF},
+		{zipFile(t, testDOCX), "notes.pdf", domain.MediaTypeDOCX},
+		{zipFile(t, testPPTX), "slides", domain.MediaTypePPTX},
+		{zipFile(t, testEPUB), "book.zip", domain.MediaTypeEPUB},
+		{[]byte("<!DOCTYPE html><html><body>hi</body></html>"), "page.txt", domain.MediaTypeHTML},
+		{[]byte("# Title\n\nSome *text*."), "README.md", domain.MediaTypeMarkdown},
+		{[]byte("plain notes"), "notes", domain.MediaTypeText},
+	}
+
+	for _, c := range cases {
+		mediaType, err := infrastructure.DetectMediaType(c.content, c.filename)
+		if err != nil || mediaType != c.expected {
+			t.Errorf("%s: expected %s, got %s %v", c.filename, c.expected, mediaType, err)
+		}
+	}
+
+	for _, content := range [][]byte{{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1}, zipFile(t, map[string]string{"a.txt": "x"}), {0x89, 'P', 'N', 'G', 0, 0}} {
+		if _, err := infrastructure.DetectMediaType(content, "file.pdf"); err != infrastructure.ErrUnsupportedFormat {
+			t.Errorf("expected %q to be unsupported, got %v", content[:4], err)
+		}
+	}
+}
+
+func TestExtractorRegistry(t *testing.T) {
+	registry := infrastructure.NewExtractorRegistry(infrastructure.NewLocalPDFExtractor())
+
+	cases := []struct {
+		name     string
+		content  []byte
+		expected []string
+	}{
+		{"notes.docx", zipFile(t, testDOCX), []string{"Cells divide by mitosis.", "Plants use photosynthesis."}},
+		{"slides.pptx", zipFile(t, testPPTX), []string{"First slide", "Last slide"}},
+		{"book.epub", zipFile(t, testEPUB), []string{"Chapter 1\n\nAtoms have a nucleus.", "Electrons orbit it."}},
+		{"notes.md", []byte("# One\nSee [the docs](http://x) and **bold**.\n```\n# not a heading\n```\n## Two\n> quoted"), []string{"One\nSee the docs and bold.\n# not a heading", "Two\nquoted"}},
+		{"page.html", []byte("<html><body><h1>One</h1><p>First   part</p><h2>Two</h2><ul><li>a</li><li>b</li></ul></body></html>"), []string{"One\n\nFirst part", "Two\n\na\n\nb"}},
+		{"notes.txt", []byte("page one\fpage two"), []string{"page one", "page two"}},
+	}
+
+	for _, c := range cases {
+		pages, err := registry.Extract(context.Background(), bytes.NewReader(c.content), c.name)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+
+		texts := []string{}
+		for _, page := range pages {
+			texts = append(texts, page.Text)
+		}
+
+		if strings.Join(texts, "|") != strings.Join(c.expected, "|") {
+			t.Errorf("%s: expected %q, got %q", c.name, c.expected, texts)
+		}
+	}
+}
+
+// storedDocuments serves one document as the file of every section.
+type storedDocuments struct {
+	*memorySections
+	document domain.Document
+	content  string
+}
+
+type nopSeekCloser struct{ *strings.Reader }
+
+func (nopSeekCloser) Close() error { return nil }
+
+func (s *storedDocuments) OpenDocument(ctx context.Context, documentID string) (domain.Document, io.ReadSeekCloser, error) {
+	return s.document, nopSeekCloser{strings.NewReader(s.content)}, nil
+}
+
+func TestViewPDFServesUploadsSafely(t *testing.T) {
+	f := newAttemptFixture()
+	documents := &storedDocuments{memorySections: f.sections}
+	views := controller.NewViewController(usecases.NewViewUsecase(documents), nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(ctx *gin.Context) { ctx.Set("user_id", "abebe") })
+	router.GET("/document/:section_id", views.ViewPDF)
+
+	cases := []struct {
+		document    domain.Document
+		disposition string
+	}{
+		{domain.Document{Title: "notes.pdf", MediaType: domain.MediaTypePDF}, "inline"},
+		{domain.Document{Title: "notes.pdf"}, "inline"},
+		{domain.Document{Title: "page.html", MediaType: domain.MediaTypeHTML}, "attachment"},
+		{domain.Document{Title: "notes.md", MediaType: domain.MediaTypeMarkdown}, "attachment"},
+	}
+
+	for _, c := range cases {
+		documents.document, documents.content = c.document, "<script>alert(1)</script>"
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/document/"+f.root.ID.Hex(), nil))
+
+		if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get("Content-Disposition"), c.disposition+";") {
+			t.Errorf("%s: expected the file %s, got %d %q", c.document.Title, c.disposition, recorder.Code, recorder.Header().Get("Content-Disposition"))
+		}
+		if recorder.Header().Get("X-Content-Type-Options") != "nosniff" || recorder.Header().Get("Content-Security-Policy") != "sandbox" {
+			t.Errorf("%s: expected the file to be sandboxed, got %v", c.document.Title, recorder.Header())
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github/chera/fix-it/delivery/controller"
+	"github/chera/fix-it/domain"
+	"github/chera/fix-it/infrastructure"
+	"github/chera/fix-it/repository"
+	"github/chera/fix-it/usecases"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func (m *memorySections) GetTopic(ctx context.Context, conversationID string) (domain.TopicList, error) {
+	conversation, ok := m.conversations[conversationID]
+	if !ok || len(conversation.Turns) < 3 {
+		return domain.TopicList{}, mongo.ErrNoDocuments
+	}
+	return infrastructure.ParseTopicResponse(conversation.Turns[2].Gemini), nil
+}
+
+// followUps generates a question on every weak point and stores what it is
+// given.
+type followUps struct {
+	repository.ActionRepository
+	conversationID string
+	topics         domain.TopicList
+	sections       []domain.Section
+	removed        []string
+	failSection    bool
+}
+
+func (f *followUps) GenerateFollowUp(ctx context.Context, conversationID string, topics domain.TopicList, options domain.QuizOptions) ([]domain.ConversationTurn, error) {
+	f.conversationID, f.topics = conversationID, topics
+
+	var questions []domain.Question
+	for _, topic := range topics.Topics {
+		questions = append(questions, domain.Question{Type: domain.QuestionShortAnswer, Question: "Explain " + topic.Title, Answer: topic.Explanation})
+	}
+	return []domain.ConversationTurn{{User: "quiz on the weak points", Gemini: infrastructure.EncodeQuestions(questions)}}, nil
+}
+
+func (f *followUps) FormatQeustion(question string) []domain.Question {
+	return infrastructure.ParseQuestionsResponse(question)
+}
+
+func (f *followUps) DocumentChunks(ctx context.Context, pdfID string) ([]domain.DocumentChunk, error) {
+	return nil, nil
+}
+
+func (f *followUps) UploadQuestions(ctx context.Context, questions []domain.Question, options domain.QuizOptions, userID string) (string, error) {
+	return "quiz-follow-up", nil
+}
+
+func (f *followUps) UploadConversation(ctx context.Context, conversation []domain.ConversationTurn) (string, error) {
+	return "conversation-follow-up", nil
+}
+
+func (f *followUps) UploadSection(ctx context.Context, section domain.Section) (string, error) {
+	if f.failSection {
+		return "", errors.New("repository/action_repository: write concern error")
+	}
+	section.ID = primitive.NewObjectID()
+	f.sections = append(f.sections, section)
+	return section.ID.Hex(), nil
+}
+
+func (f *followUps) RemoveUpload(ctx context.Context, quizID, conversationID, pdfID string) error {
+	f.removed = append(f.removed, quizID, conversationID)
+	return nil
+}
+
+func TestCreateFollowUpQuiz(t *testing.T) {
+	f := newAttemptFixture()
+	repo := &followUps{}
+	action := usecases.NewActionUsecase(repo, infrastructure.GenerationConfig{QuestionCount: 5}, infrastructure.UploadLimits{}, nil)
+	ctx := context.Background()
+
+	topics := domain.TopicList{Topics: []domain.Topic{{Title: "Mitosis", Explanation: "how cells divide"}, {Title: "Meiosis", Explanation: "how gametes are made"}}}
+
+	if _, err := action.CreateFollowUpQuiz(ctx, f.root, domain.TopicList{}, domain.QuizOptions{}); err == nil {
+		t.Error("expected a follow-up without weak points to be refused")
+	}
+
+	secondID, err := action.CreateFollowUpQuiz(ctx, f.root, topics, domain.QuizOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if repo.conversationID != f.root.ExplanationsID || len(repo.topics.Topics) != 2 {
+		t.Errorf("expected the weak points on the conversation of the parent, got %q %+v", repo.conversationID, repo.topics)
+	}
+
+	second := repo.sections[0]
+	if second.ID.Hex() != secondID || second.ParentID != f.root.ID.Hex() || second.RootID != f.root.ID.Hex() || second.Round != 2 {
+		t.Errorf("expected the second round under the root, got %+v", second)
+	}
+	if second.QuestionsID != "quiz-follow-up" || second.ExplanationsID != "conversation-follow-up" || second.CreatedBy != "abebe" || second.PDFID != f.root.PDFID {
+		t.Errorf("expected the new quiz on the document of the parent, got %+v", second)
+	}
+
+	// a follow-up of a follow-up has its parent and the same root
+	if _, err := action.CreateFollowUpQuiz(ctx, second, topics, domain.QuizOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if third := repo.sections[1]; third.ParentID != secondID || third.RootID != f.root.ID.Hex() || third.Round != 3 {
+		t.Errorf("expected the third round under the same root, got %+v", third)
+	}
+
+	// nothing is left behind when the section can't be stored
+	repo.failSection = true
+	if _, err := action.CreateFollowUpQuiz(ctx, f.root, topics, domain.QuizOptions{}); err == nil {
+		t.Fatal("expected the storage error")
+	}
+	if strings.Join(repo.removed, ",") != "quiz-follow-up,conversation-follow-up" {
+		t.Errorf("expected the quiz and conversation to be removed, got %v", repo.removed)
+	}
+}
+
+func TestFollowUpQuizController(t *testing.T) {
+	f := newAttemptFixture()
+	repo := &followUps{}
+	action := usecases.NewActionUsecase(repo, infrastructure.GenerationConfig{QuestionCount: 5}, infrastructure.UploadLimits{}, nil)
+	actions := controller.NewActionController(action, usecases.NewViewUsecase(f.sections), nil, f.usecase, nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(ctx *gin.Context) { ctx.Set("user_id", "abebe") })
+	router.POST("/a/section/:id/followup-quiz", actions.FollowUpQuiz)
+
+	request := func(sectionID, body string) (int, map[string]string) {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/a/section/"+sectionID+"/followup-quiz", strings.NewReader(body)))
+
+		var response map[string]string
+		json.NewDecoder(recorder.Body).Decode(&response)
+		return recorder.Code, response
+	}
+
+	// without weak points in the body the generated ones are needed
+	if status, _ := request(f.root.ID.Hex(), ""); status != http.StatusConflict {
+		t.Errorf("expected to be asked for the weak points first, got %d", status)
+	}
+
+	f.sections.conversations[f.root.ExplanationsID] = domain.Conversation{Turns: []domain.ConversationTurn{
+		{User: "quiz"},
+		{User: "explain"},
+		{User: "topics", Gemini: `{"topics":[{"title":"Mitosis","explanation":"how cells divide"}]}`},
+	}}
+
+	status, response := request(f.root.ID.Hex(), "")
+	if status != http.StatusCreated || response["parent_id"] != f.root.ID.Hex() || response["section_id"] != repo.sections[0].ID.Hex() {
+		t.Fatalf("expected the follow-up of the section, got %d %v", status, response)
+	}
+	if len(repo.topics.Topics) != 1 || repo.topics.Topics[0].Title != "Mitosis" {
+		t.Errorf("expected the generated weak points, got %+v", repo.topics)
+	}
+
+	// weak points picked by the user replace the generated ones
+	status, _ = request(f.root.ID.Hex(), `{"topics":[{"Title":"Meiosis","Explanation":"how gametes are made"}],"question_count":3}`)
+	if status != http.StatusCreated || len(repo.topics.Topics) != 1 || repo.topics.Topics[0].Title != "Meiosis" {
+		t.Errorf("expected the weak points of the request, got %d %+v", status, repo.topics)
+	}
+
+	if status, _ := request(f.other.ID.Hex(), `{"topics":[{"Title":"Photosynthesis"}]}`); status != http.StatusNotFound {
+		t.Errorf("expected the section of another user to be not found, got %d", status)
+	}
+
+	if status, _ := request(f.root.ID.Hex(), `{"topics":`); status != http.StatusBadRequest {
+		t.Errorf("expected a broken body to be refused, got %d", status)
+	}
+}
@@ -0,0 +1,72 @@
+package test
+
+import (
+	"github/chera/fix-it/domain"
+	"github/chera/fix-it/infrastructure"
+	"testing"
+)
+
+func TestGradeQuizByQuestionType(t *testing.T) {
+	questions := []domain.Question{
+		{Question: "Legacy question", A: "1", B: "2", C: "3", D: "4", Answer: "B"},
+		{Type: domain.QuestionMultiSelect, Question: "Pick two", A: "1", B: "2", C: "3", D: "4", Answer: "A,C"},
+		{Type: domain.QuestionTrueFalse, Question: "The sky is blue.", A: "True", B: "False", Answer: "A"},
+		{Type: domain.QuestionFillBlank, Question: "Water boils at ____ degrees.", Answer: "100", AcceptedAnswers: []string{"one hundred"}},
+		{Type: domain.QuestionShortAnswer, Question: "What do plants need?", Answer: "Light and water", Keywords: []string{"light", "water", "carbon dioxide"}},
+		{Type: domain.QuestionSingleChoice, Question: "Unanswered", A: "1", B: "2", C: "3", D: "4", Answer: "D"},
+	}
+
+	answers := []domain.Answer{
+		{QuestionNO: 1, Answer: "b"},
+		{QuestionNO: 2, Answer: "C, A"},
+		{QuestionNO: 3, Answer: "True"},
+		{QuestionNO: 4, Answer: "One Hundred."},
+		{QuestionNO: 5, Answer: "They need water and some light."},
+	}
+
+	grade := infrastructure.GradeQuiz(questions, answers)
+
+	if grade.Total != 6 || grade.Score != 5 {
+		t.Fatalf("expected 5 out of 6, got %d out of %d", grade.Score, grade.Total)
+	}
+
+	if grade.Results[5].Correct || grade.Results[5].QuestionNO != 6 {
+		t.Errorf("expected the unanswered question to be wrong, got %+v", grade.Results[5])
+	}
+}
+
+func TestGradeAnswerRejectsPartialMultiSelect(t *testing.T) {
+	question := domain.Question{Type: domain.QuestionMultiSelect, Answer: "A,C"}
+
+	if infrastructure.GradeAnswer(question, "A") {
+		t.Error("expected a partial multi select answer to be wrong")
+	}
+
+	if infrastructure.GradeAnswer(question, "A,B,C") {
+		t.Error("expected an extra letter to make the answer wrong")
+	}
+}
+
+func TestValidateQuizOptions(t *testing.T) {
+	options := domain.QuizOptions{QuestionTypes: []string{"TRUE_FALSE", "true_false", "short_answer"}}
+
+	if err := infrastructure.ValidateQuizOptions(&options, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	if options.QuestionCount != 10 || options.Difficulty != domain.DifficultyMedium || len(options.QuestionTypes) != 2 {
+		t.Errorf("unexpected options %+v", options)
+	}
+
+	invalid := []domain.QuizOptions{
+		{QuestionCount: 100},
+		{Difficulty: "impossible"},
+		{QuestionTypes: []string{"essay"}},
+	}
+
+	for _, option := range invalid {
+		if err := infrastructure.ValidateQuizOptions(&option, 10); err == nil {
+			t.Errorf("expected %+v to be rejected", option)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"github/chera/fix-it/delivery/controller"
+	"github/chera/fix-it/domain"
+	"github/chera/fix-it/infrastructure"
+	"github/chera/fix-it/repository"
+	"github/chera/fix-it/usecases"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// memoryJobs is a job queue keeping the leases and claim tokens the way
+// the mongo repository does. Retried jobs run again at once.
+type memoryJobs struct {
+	mu      sync.Mutex
+	jobs    []domain.Job
+	retries []time.Duration
+	done    chan domain.Job
+}
+
+func newMemoryJobs(jobs ...domain.Job) *memoryJobs {
+	return &memoryJobs{jobs: jobs, done: make(chan domain.Job, len(jobs))}
+}
+
+func (m *memoryJobs) Enqueue(ctx context.Context, job domain.Job) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job.ID = primitive.NewObjectID()
+	job.Status = domain.JobQueued
+	m.jobs = append(m.jobs, job)
+	return job.ID.Hex(), nil
+}
+
+func (m *memoryJobs) Claim(ctx context.Context, lease time.Duration, maxAttempts int) (domain.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for i, job := range m.jobs {
+		if job.Status == domain.JobQueued || job.Status == domain.JobRunning && job.LockedUntil.Before(now) && job.Attempts < maxAttempts {
+			job.Status = domain.JobRunning
+			job.LockedUntil = now.Add(lease)
+			job.ClaimToken = primitive.NewObjectID().Hex()
+			job.Attempts++
+			m.jobs[i] = job
+			return job, nil
+		}
+	}
+	return domain.Job{}, repository.ErrNoJob
+}
+
+func (m *memoryJobs) FailAbandoned(ctx context.Context, maxAttempts int) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var failed int64
+	for i, job := range m.jobs {
+		if job.Status == domain.JobRunning && job.LockedUntil.Before(time.Now()) && job.Attempts >= maxAttempts {
+			job.Status = domain.JobFailed
+			job.Error = &domain.JobError{Code: domain.JobErrorInternal}
+			m.jobs[i] = job
+			m.done <- job
+			failed++
+		}
+	}
+	return failed, nil
+}
+
+func (m *memoryJobs) update(job domain.Job, change func(stored *domain.Job)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, stored := range m.jobs {
+		if stored.ID == job.ID && stored.Status == domain.JobRunning && stored.ClaimToken == job.ClaimToken {
+			change(&m.jobs[i])
+			return nil
+		}
+	}
+	return repository.ErrLeaseLost
+}
+
+func (m *memoryJobs) Renew(ctx context.Context, job domain.Job, lease time.Duration) error {
+	return m.update(job, func(stored *domain.Job) { stored.LockedUntil = time.Now().Add(lease) })
+}
+
+func (m *memoryJobs) UpdateProgress(ctx context.Context, job domain.Job, stage string, progress int, lease time.Duration) error {
+	return m.update(job, func(stored *domain.Job) {
+		stored.Stage = stage
+		stored.Progress = progress
+		stored.LockedUntil = time.Now().Add(lease)
+	})
+}
+
+func (m *memoryJobs) Complete(ctx context.Context, job domain.Job, sectionID string) error {
+	return m.update(job, func(stored *domain.Job) {
+		stored.Status = domain.JobSucceeded
+		stored.SectionID = sectionID
+		stored.Error = nil
+		m.done <- *stored
+	})
+}
+
+func (m *memoryJobs) Retry(ctx context.Context, job domain.Job, jobErr domain.JobError, nextRunAt time.Time) error {
+	return m.update(job, func(stored *domain.Job) {
+		stored.Status = domain.JobQueued
+		stored.Error = &jobErr
+		m.retries = append(m.retries, time.Until(nextRunAt))
+	})
+}
+
+func (m *memoryJobs) Fail(ctx context.Context, job domain.Job, jobErr domain.JobError) error {
+	return m.update(job, func(stored *domain.Job) {
+		stored.Status = domain.JobFailed
+		stored.Error = &jobErr
+		m.done <- *stored
+	})
+}
+
+func (m *memoryJobs) GetJob(ctx context.Context, jobID, userID string) (domain.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, job := range m.jobs {
+		if job.ID.Hex() == jobID && job.UserID == userID {
+			return job, nil
+		}
+	}
+	return domain.Job{}, mongo.ErrNoDocuments
+}
+
+// scriptedPipeline answers the uploads with the given outcomes in turn, a
+// nil error succeeds and a string panics.
+type scriptedPipeline struct {
+	usecases.ActionUsecase
+	outcomes []any
+	calls    int
+}
+
+func (s *scriptedPipeline) ProcessUpload(ctx context.Context, job domain.Job, progress func(stage string, progress int)) (string, error) {
+	outcome := s.outcomes[min(s.calls, len(s.outcomes)-1)]
+	s.calls++
+
+	progress(domain.JobStageGenerating, 30)
+
+	switch outcome := outcome.(type) {
+	case error:
+		return "", outcome
+	case string:
+		panic(outcome)
+	}
+	return "section-" + job.ID.Hex(), nil
+}
+
+// runJob starts a worker on the queue and waits for a job to finish.
+func runJob(t *testing.T, jobs *memoryJobs, pipeline usecases.ActionUsecase) domain.Job {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	usecases.NewJobUsecase(jobs, pipeline).StartWorkers(ctx, 1)
+
+	select {
+	case job := <-jobs.done:
+		return job
+	case <-time.After(5 * time.Second):
+		t.Fatal("the job never finished")
+	}
+	return domain.Job{}
+}
+
+func queuedJob() domain.Job {
+	return domain.Job{ID: primitive.NewObjectID(), UserID: "user", Status: domain.JobQueued}
+}
+
+func TestJobRetriesRetryableFailures(t *testing.T) {
+	jobs := newMemoryJobs(queuedJob())
+	pipeline := &scriptedPipeline{outcomes: []any{
+		&domain.JobError{Code: domain.JobErrorGeneration, Message: "model overloaded", Retryable: true},
+		nil,
+	}}
+
+	job := runJob(t, jobs, pipeline)
+
+	if job.Status != domain.JobSucceeded || job.SectionID != "section-"+job.ID.Hex() || job.Error != nil {
+		t.Errorf("expected the job to succeed on the second attempt, got %+v", job)
+	}
+	if job.Attempts != 2 || len(jobs.retries) != 1 {
+		t.Errorf("expected one retry, got %d attempts and retries %v", job.Attempts, jobs.retries)
+	}
+	if jobs.retries[0] < 25*time.Second || jobs.retries[0] > 30*time.Second {
+		t.Errorf("expected the retry to back off 30s, got %s", jobs.retries[0])
+	}
+}
+
+func TestJobFailsNonRetryableFailures(t *testing.T) {
+	jobs := newMemoryJobs(queuedJob())
+	pipeline := &scriptedPipeline{outcomes: []any{
+		&domain.JobError{Code: domain.JobErrorSelection, Message: "page 40 is past the end of the document"},
+	}}
+
+	job := runJob(t, jobs, pipeline)
+
+	if job.Status != domain.JobFailed || job.Error == nil || job.Error.Code != domain.JobErrorSelection {
+		t.Errorf("expected the job to fail with invalid_selection, got %+v", job)
+	}
+	if pipeline.calls != 1 || len(jobs.retries) != 0 {
+		t.Errorf("expected no retry, got %d calls", pipeline.calls)
+	}
+}
+
+func TestJobAttemptsCap(t *testing.T) {
+	jobs := newMemoryJobs(queuedJob())
+	pipeline := &scriptedPipeline{outcomes: []any{
+		"nil pointer dereference",
+		errors.New("connection reset"),
+		&domain.JobError{Code: domain.JobErrorGeneration, Message: "model overloaded", Retryable: true},
+	}}
+
+	job := runJob(t, jobs, pipeline)
+
+	if job.Status != domain.JobFailed || job.Error == nil || job.Error.Code != domain.JobErrorGeneration {
+		t.Errorf("expected the job to fail with the last error, got %+v", job)
+	}
+	if pipeline.calls != 3 || job.Attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d calls and %d attempts", pipeline.calls, job.Attempts)
+	}
+	if len(jobs.retries) != 2 || jobs.retries[1] < 55*time.Second {
+		t.Errorf("expected the backoff to double, got %v", jobs.retries)
+	}
+
+	// a job whose worker died on its last attempt isn't run again
+	abandoned := queuedJob()
+	abandoned.Status = domain.JobRunning
+	abandoned.Attempts = 3
+	abandoned.LockedUntil = time.Now().Add(-time.Minute)
+	jobs = newMemoryJobs(abandoned)
+	pipeline = &scriptedPipeline{outcomes: []any{nil}}
+
+	job = runJob(t, jobs, pipeline)
+
+	if job.Status != domain.JobFailed || job.Error.Code != domain.JobErrorInternal || pipeline.calls != 0 {
+		t.Errorf("expected the abandoned job to fail without running, got %+v after %d calls", job, pipeline.calls)
+	}
+}
+
+// finishedSections knows the section of one job, anything else the
+// pipeline would need panics.
+type finishedSections struct {
+	repository.ActionRepository
+	jobID string
+}
+
+func (f *finishedSections) FindSectionByJob(ctx context.Context, jobID string) (domain.Section, error) {
+	if jobID != f.jobID {
+		return domain.Section{}, mongo.ErrNoDocuments
+	}
+	return domain.Section{ID: primitive.NewObjectID()}, nil
+}
+
+func TestJobRerunReturnsExistingSection(t *testing.T) {
+	// the worker died after saving the section and before completing the job
+	crashed := queuedJob()
+	crashed.Status = domain.JobRunning
+	crashed.Attempts = 1
+	crashed.LockedUntil = time.Now().Add(-time.Minute)
+	jobs := newMemoryJobs(crashed)
+
+	pipeline := usecases.NewActionUsecase(&finishedSections{jobID: crashed.ID.Hex()}, infrastructure.GenerationConfig{}, infrastructure.UploadLimits{}, nil)
+
+	job := runJob(t, jobs, pipeline)
+
+	if job.Status != domain.JobSucceeded || job.SectionID == "" || job.Attempts != 2 {
+		t.Errorf("expected the section of the first attempt, got %+v", job)
+	}
+}
+
+// unreachableJobs fails every read the way a database that is down does.
+type unreachableJobs struct {
+	*memoryJobs
+}
+
+func (u unreachableJobs) GetJob(ctx context.Context, jobID, userID string) (domain.Job, error) {
+	return domain.Job{}, errors.New("repository/job_repository: server selection timeout")
+}
+
+func TestGetJobController(t *testing.T) {
+	job := queuedJob()
+	jobs := newMemoryJobs(job)
+
+	gin.SetMode(gin.TestMode)
+	request := func(jobs repository.JobRepository, jobID string) int {
+		actions := controller.NewActionController(nil, nil, usecases.NewJobUsecase(jobs, nil), nil, nil)
+
+		router := gin.New()
+		router.Use(func(ctx *gin.Context) { ctx.Set("user_id", "user") })
+		router.GET("/jobs/:id", actions.GetJob)
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/jobs/"+jobID, nil))
+		return recorder.Code
+	}
+
+	cases := []struct {
+		name   string
+		jobs   repository.JobRepository
+		jobID  string
+		status int
+	}{
+		{"found", jobs, job.ID.Hex(), http.StatusOK},
+		{"missing", jobs, primitive.NewObjectID().Hex(), http.StatusNotFound},
+		{"invalid id", jobs, "not-an-id", http.StatusBadRequest},
+		{"database down", unreachableJobs{jobs}, job.ID.Hex(), http.StatusInternalServerError},
+	}
+
+	for _, c := range cases {
+		if status := request(c.jobs, c.jobID); status != c.status {
+			t.Errorf("%s: expected %d, got %d", c.name, c.status, status)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"github/chera/fix-it/domain"
+	"github/chera/fix-it/infrastructure"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOpenAIClientSendsHistory(t *testing.T) {
+	var received struct {
+		Model    string `json:"model"`
+		Messages []struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"messages"`
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"hello"}}]}`))
+	}))
+	defer server.Close()
+
+	client := infrastructure.NewOpenAIClient(server.URL+"/v1", "", "llama")
+	history := []domain.ConversationTurn{{User: "first", Gemini: "reply"}}
+
+	result, err := client.GenerateWithHistory(context.Background(), history, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != "hello" {
+		t.Errorf("expected hello, got %s", result)
+	}
+
+	if received.Model != "llama" || len(received.Messages) != 3 {
+		t.Fatalf("unexpected request %+v", received)
+	}
+
+	if received.Messages[1].Role != "assistant" || received.Messages[2].Content != "second" {
+		t.Errorf("history was not mapped to messages: %+v", received.Messages)
+	}
+}
+
+func TestFakeLLMClientIsDeterministic(t *testing.T) {
+	client := infrastructure.NewFakeLLMClient("queued")
+
+	first, _ := client.Generate(context.Background(), "a")
+	second, _ := client.Generate(context.Background(), "b")
+	third, _ := client.Generate(context.Background(), "c")
+
+	if first != "queued" {
+		t.Errorf("expected queued response, got %s", first)
+	}
+
+	if second != third {
+		t.Errorf("expected the fallback response to be stable")
+	}
+
+	if len(infrastructure.ParseQuestions(second)) != 1 {
+		t.Errorf("expected the fallback response to be a parseable quiz")
+	}
+}
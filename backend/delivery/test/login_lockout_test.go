@@ -0,0 +1,292 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"github/chera/fix-it/domain"
+	"github/chera/fix-it/infrastructure"
+	"github/chera/fix-it/usecases"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLoginPolicyDelay(t *testing.T) {
+	policy := infrastructure.LoginPolicy{
+		FreeFailures:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        5 * time.Second,
+		LockoutFailures: 10,
+		LockoutDuration: 15 * time.Minute,
+	}
+
+	expected := map[int]time.Duration{
+		3: 0,
+		4: time.Second,
+		5: 2 * time.Second,
+		6: 4 * time.Second,
+		7: 5 * time.Second,
+		9: 5 * time.Second,
+	}
+
+	for failures, want := range expected {
+		if delay, locked := policy.Delay(failures); delay != want || locked {
+			t.Errorf("after %d failures expected %v, got %v %v", failures, want, delay, locked)
+		}
+	}
+
+	if delay, locked := policy.Delay(10); delay != 15*time.Minute || !locked {
+		t.Errorf("expected a lockout, got %v %v", delay, locked)
+	}
+}
+
+// memoryLoginAttempts counts failures the way the mongo repository does.
+type memoryLoginAttempts struct {
+	failures map[string]*domain.LoginFailures
+}
+
+func newMemoryLoginAttempts() *memoryLoginAttempts {
+	return &memoryLoginAttempts{failures: map[string]*domain.LoginFailures{}}
+}
+
+func (m *memoryLoginAttempts) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	if failures, ok := m.failures[key]; ok {
+		return failures.LockedUntil, nil
+	}
+	return time.Time{}, nil
+}
+
+func (m *memoryLoginAttempts) RecordFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+	failures, ok := m.failures[key]
+	if !ok {
+		failures = &domain.LoginFailures{Key: key}
+		m.failures[key] = failures
+	}
+	failures.Failures++
+	failures.ExpiresAt = time.Now().Add(window)
+	return failures.Failures, nil
+}
+
+func (m *memoryLoginAttempts) Lock(ctx context.Context, key string, until time.Time) error {
+	if failures := m.failures[key]; until.After(failures.LockedUntil) {
+		failures.LockedUntil = until
+	}
+	return nil
+}
+
+func (m *memoryLoginAttempts) Reset(ctx context.Context, key string) error {
+	delete(m.failures, key)
+	return nil
+}
+
+func (m *memoryLoginAttempts) EnsureIndexes(ctx context.Context) error { return nil }
+
+func TestLoginLockout(t *testing.T) {
+	t.Setenv("JWT_SECRET_KEY", "test-secret")
+
+	user := testUser(t, "password")
+	mail := &memoryMail{}
+	limits := infrastructure.LoginLimits{
+		Account: infrastructure.LoginPolicy{FreeFailures: 5, LockoutFailures: 3, LockoutDuration: time.Hour, Window: 2 * time.Hour},
+		IP:      infrastructure.LoginPolicy{FreeFailures: 5, LockoutFailures: 4, LockoutDuration: time.Hour, Window: 2 * time.Hour},
+	}
+	users := usecases.NewUseCase(newMemoryUsers(user), newMemoryTokens(), newMemoryLoginAttempts(), infrastructure.TokenConfig{}, limits, mail)
+	ctx := context.Background()
+
+	login := func(password, ip string) (string, error) {
+		return users.Login(ctx, domain.User{Email: "abebe@example.com", Password: password}, ip)
+	}
+
+	// a login that got its tokens forgets the earlier failures
+	login("wrong-password", "10.0.0.1")
+	userID, err := login("password", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("expected the login to work, got %v", err)
+	}
+	if _, err := users.IssueTokens(ctx, userID); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := login("wrong-password", "10.0.0.1"); err == nil || errors.Is(err, usecases.ErrLoginThrottled) {
+			t.Fatalf("expected failure %d to be a wrong password, got %v", i+1, err)
+		}
+	}
+
+	if len(mail.queued) != 1 {
+		t.Fatalf("expected an email about the lockout, got %v", mail.queued)
+	}
+	if email := mail.queued[0].(infrastructure.AccountLockedEmail); email.LockedFor != "1 hour" {
+		t.Errorf("unexpected lockout email %+v", email)
+	}
+
+	// locked out from anywhere, even with the right password
+	var throttled *usecases.LoginThrottledError
+	if _, err := login("password", "10.0.0.2"); !errors.As(err, &throttled) || throttled.RetryAfter <= 59*time.Minute {
+		t.Fatalf("expected the account to be locked, got %v", err)
+	}
+
+	// the first address made 4 failures with the one before the success
+	if _, err := users.Login(ctx, domain.User{Email: "nobody@example.com", Password: "password"}, "10.0.0.1"); !errors.Is(err, usecases.ErrLoginThrottled) {
+		t.Errorf("expected the address to be locked, got %v", err)
+	}
+	if _, err := users.Login(ctx, domain.User{Email: "nobody@example.com", Password: "password"}, "10.0.0.3"); err == nil || errors.Is(err, usecases.ErrLoginThrottled) {
+		t.Errorf("expected other addresses to be let through, got %v", err)
+	}
+}
+
+func TestMFAGuessesLockAccount(t *testing.T) {
+	t.Setenv("JWT_SECRET_KEY", "test-secret")
+
+	user := testUser(t, "password")
+	secret, _ := infrastructure.NewTOTPSecret()
+	user.MFA = &domain.MFA{Enabled: true, Secret: secret}
+
+	limits := infrastructure.LoginLimits{
+		Account: infrastructure.LoginPolicy{FreeFailures: 5, LockoutFailures: 3, LockoutDuration: time.Hour, Window: 2 * time.Hour},
+		IP:      infrastructure.LoginPolicy{FreeFailures: 50, LockoutFailures: 100, LockoutDuration: time.Hour, Window: 2 * time.Hour},
+	}
+	mail := &memoryMail{}
+	users := usecases.NewUseCase(newMemoryUsers(user), newMemoryTokens(), newMemoryLoginAttempts(), infrastructure.TokenConfig{}, limits, mail)
+	ctx := context.Background()
+
+	// the password is known, every guess at the code gets a new challenge
+	for i := 0; i < 3; i++ {
+		userID, err := users.Login(ctx, domain.User{Email: "abebe@example.com", Password: "password"}, "10.0.0.1")
+		if err != nil {
+			t.Fatalf("expected the password to be accepted at guess %d, got %v", i+1, err)
+		}
+
+		challenge, err := users.MFAChallenge(ctx, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := users.VerifyMFA(ctx, challenge, "abcdef"); !errors.Is(err, usecases.ErrInvalidMFACode) {
+			t.Fatalf("expected guess %d to be refused, got %v", i+1, err)
+		}
+	}
+
+	if len(mail.queued) != 1 {
+		t.Errorf("expected an email about the lockout, got %v", mail.queued)
+	}
+
+	if _, err := users.Login(ctx, domain.User{Email: "abebe@example.com", Password: "password"}, "10.0.0.1"); !errors.Is(err, usecases.ErrLoginThrottled) {
+		t.Errorf("expected the account to be locked after the wrong codes, got %v", err)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(infrastructure.RateLimitMiddleware(infrastructure.NewMemoryRateLimiter(1.0/60, 2)))
+	router.POST("/u/login", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	request := func(address string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/u/login", nil)
+		req.RemoteAddr = address + ":1234"
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := request("10.0.0.1").Code; code != http.StatusOK {
+			t.Fatalf("expected the burst to go through, got %d", code)
+		}
+	}
+
+	limited := request("10.0.0.1")
+	if limited.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", limited.Code)
+	}
+	if retry, _ := strconv.Atoi(limited.Header().Get("Retry-After")); retry < 59 || retry > 60 {
+		t.Errorf("expected to wait for a token a minute, got %q", limited.Header().Get("Retry-After"))
+	}
+
+	if code := request("10.0.0.2").Code; code != http.StatusOK {
+		t.Errorf("expected other addresses to have their own bucket, got %d", code)
+	}
+}
+
+// fakeRedis answers AUTH and replies to EVAL with a bucket that is empty
+// for another 1.5 seconds.
+func fakeRedis(t *testing.T) (string, chan []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	commands := make(chan []string, 10)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+
+		for {
+			header, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			count, _ := strconv.Atoi(strings.TrimSpace(header[1:]))
+
+			args := make([]string, count)
+			for i := range args {
+				line, _ := reader.ReadString('\n')
+				size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+				data := make([]byte, size+2)
+				if _, err := io.ReadFull(reader, data); err != nil {
+					return
+				}
+				args[i] = string(data[:size])
+			}
+			commands <- args
+
+			if args[0] == "AUTH" {
+				conn.Write([]byte("+OK\r\n"))
+			} else {
+				conn.Write([]byte("*2\r\n:0\r\n:1500\r\n"))
+			}
+		}
+	}()
+
+	return listener.Addr().String(), commands
+}
+
+func TestRedisRateLimiter(t *testing.T) {
+	address, commands := fakeRedis(t)
+
+	client, err := infrastructure.NewRedisClient("redis://:secret@" + address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limiter := infrastructure.NewRedisRateLimiter(client, "ratelimit:", 0.5, 10)
+	allowed, wait, err := limiter.Allow(context.Background(), "ip:10.0.0.1")
+	if err != nil || allowed || wait != 1500*time.Millisecond {
+		t.Fatalf("expected to wait 1.5s, got %v %v %v", allowed, wait, err)
+	}
+
+	if auth := <-commands; strings.Join(auth, " ") != "AUTH secret" {
+		t.Errorf("expected to authenticate first, got %v", auth)
+	}
+
+	eval := <-commands
+	if eval[0] != "EVAL" || eval[2] != "1" || eval[3] != "ratelimit:ip:10.0.0.1" || eval[4] != "0.0005" || eval[5] != "10" {
+		t.Errorf("unexpected command %q", eval[2:])
+	}
+}
@@ -0,0 +1,148 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"github/chera/fix-it/domain"
+	"github/chera/fix-it/infrastructure"
+	"github/chera/fix-it/repository"
+	"github/chera/fix-it/usecases"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRenderEmail(t *testing.T) {
+	email, err := infrastructure.RenderEmail("abebe@example.com", infrastructure.EmailPasswordReset, infrastructure.PasswordResetEmail{
+		Link:     "https://fixit.example/reset-password?token=a&b=<c>",
+		ValidFor: "1 hour",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if email.To != "abebe@example.com" || email.Subject != "Reset your password" {
+		t.Errorf("unexpected email %q %q", email.To, email.Subject)
+	}
+
+	if !strings.Contains(email.HTML, "token=a&amp;b=%3cc%3e") || !strings.Contains(email.HTML, "<h1>Fix It</h1>") {
+		t.Errorf("expected the link to be escaped in the layout, got %s", email.HTML)
+	}
+
+	if !strings.Contains(email.Text, "token=a&b=<c>") || strings.Contains(email.Text, "subject") {
+		t.Errorf("unexpected text body %s", email.Text)
+	}
+
+	digest, err := infrastructure.RenderEmail("abebe@example.com", infrastructure.EmailReviewDigest, infrastructure.ReviewDigestEmail{Username: "abebe", Due: 1, Link: "https://fixit.example"})
+	if err != nil || digest.Subject != "1 question to review on Fix It" {
+		t.Errorf("unexpected digest %q %v", digest.Subject, err)
+	}
+}
+
+func TestFileMailerWritesMultipartEmails(t *testing.T) {
+	dir := t.TempDir()
+
+	mailer, err := infrastructure.NewFileMailer(dir, "noreply@fixit.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	email, _ := infrastructure.RenderEmail("abebe@example.com", infrastructure.EmailVerification, infrastructure.VerificationEmail{Link: "https://fixit.example/u/verify?token=abc"})
+	email.ID = primitive.NewObjectID()
+
+	if err := mailer.Send(context.Background(), email); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("expected one email file, got %v", files)
+	}
+
+	content, _ := os.ReadFile(files[0])
+	for _, part := range []string{"To: abebe@example.com", "multipart/alternative", "text/plain", "text/html"} {
+		if !strings.Contains(string(content), part) {
+			t.Errorf("expected %q in the email", part)
+		}
+	}
+}
+
+// memoryOutbox is an outbox with a single email.
+type memoryOutbox struct {
+	mu      sync.Mutex
+	email   domain.Email
+	claimed bool
+	retries int
+	done    chan string
+}
+
+func (m *memoryOutbox) Enqueue(ctx context.Context, email domain.Email) error { return nil }
+
+func (m *memoryOutbox) Claim(ctx context.Context, lease time.Duration) (domain.Email, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.claimed {
+		return domain.Email{}, repository.ErrNoEmail
+	}
+	m.claimed = true
+	m.email.Attempts++
+	return m.email, nil
+}
+
+func (m *memoryOutbox) MarkSent(ctx context.Context, email domain.Email) error {
+	m.done <- domain.EmailSent
+	return nil
+}
+
+func (m *memoryOutbox) Retry(ctx context.Context, email domain.Email, lastError string, nextRunAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.retries++
+	m.claimed = false
+	return nil
+}
+
+func (m *memoryOutbox) Fail(ctx context.Context, email domain.Email, lastError string) error {
+	m.done <- domain.EmailFailed
+	return nil
+}
+
+func (m *memoryOutbox) EnsureIndexes(ctx context.Context) error { return nil }
+
+// flakyMailer fails the first sends.
+type flakyMailer struct {
+	failures int
+}
+
+func (f *flakyMailer) Send(ctx context.Context, email domain.Email) error {
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func TestOutboxRetriesFailedEmails(t *testing.T) {
+	outbox := &memoryOutbox{done: make(chan string, 1)}
+	mail := usecases.NewMailUsecase(outbox, &flakyMailer{failures: 2})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mail.StartWorkers(ctx, 1)
+
+	select {
+	case status := <-outbox.done:
+		if status != domain.EmailSent || outbox.retries != 2 {
+			t.Errorf("expected the email to be sent after 2 retries, got %s after %d", status, outbox.retries)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the email was never sent")
+	}
+}
@@ -0,0 +1,210 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"github/chera/fix-it/domain"
+	"github/chera/fix-it/infrastructure"
+	"github/chera/fix-it/usecases"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestTOTPMatchesRFC6238(t *testing.T) {
+	// the SHA-1 secret of the RFC test vectors, "12345678901234567890"
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for unix, want := range vectors {
+		code, err := infrastructure.TOTPCode(secret, infrastructure.TOTPStep(time.Unix(unix, 0)))
+		if err != nil || code != want {
+			t.Errorf("at %d expected %s, got %s %v", unix, want, code, err)
+		}
+	}
+
+	at := time.Unix(1111111109, 0)
+	previous, _ := infrastructure.TOTPCode(secret, infrastructure.TOTPStep(at)-1)
+	if step, ok := infrastructure.VerifyTOTP(secret, previous, at); !ok || step != infrastructure.TOTPStep(at)-1 {
+		t.Error("expected the code of the previous step to be accepted")
+	}
+
+	old, _ := infrastructure.TOTPCode(secret, infrastructure.TOTPStep(at)-2)
+	if _, ok := infrastructure.VerifyTOTP(secret, old, at); ok {
+		t.Error("expected older codes to be refused")
+	}
+
+	uri, _ := url.Parse(infrastructure.TOTPURI("Fix It", "abebe@example.com", secret))
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Fix It:abebe@example.com" || uri.Query().Get("secret") != secret {
+		t.Errorf("unexpected otpauth uri %s", uri)
+	}
+}
+
+func (m *memoryUsers) SetPendingMFASecret(ctx context.Context, userID, secret string) error {
+	user := m.users[userID]
+	user.MFA = &domain.MFA{PendingSecret: secret}
+	m.users[userID] = user
+	return nil
+}
+
+func (m *memoryUsers) EnableMFA(ctx context.Context, userID, secret string, recoveryCodes []string, step int64) error {
+	user := m.users[userID]
+	user.MFA = &domain.MFA{Enabled: true, Secret: secret, RecoveryCodes: recoveryCodes, LastStep: step}
+	m.users[userID] = user
+	return nil
+}
+
+func (m *memoryUsers) DisableMFA(ctx context.Context, userID string) error {
+	user := m.users[userID]
+	user.MFA = nil
+	m.users[userID] = user
+	return nil
+}
+
+func (m *memoryUsers) UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	mfa := m.users[userID].MFA
+	if mfa.LastStep >= step {
+		return false, nil
+	}
+	mfa.LastStep = step
+	return true, nil
+}
+
+func (m *memoryUsers) UseRecoveryCode(ctx context.Context, userID, hash string) (bool, error) {
+	mfa := m.users[userID].MFA
+	for i, code := range mfa.RecoveryCodes {
+		if code == hash {
+			mfa.RecoveryCodes = append(mfa.RecoveryCodes[:i], mfa.RecoveryCodes[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *memoryUsers) SaveMFAChallenge(ctx context.Context, challenge domain.MFAChallenge) error {
+	challenge.ID = primitive.NewObjectID()
+	m.challenges = append(m.challenges, challenge)
+	return nil
+}
+
+func (m *memoryUsers) AttemptMFAChallenge(ctx context.Context, hash string, maxAttempts int) (domain.MFAChallenge, error) {
+	for i, challenge := range m.challenges {
+		if challenge.Hash == hash && challenge.Attempts < maxAttempts && time.Now().Before(challenge.ExpiresAt) {
+			m.challenges[i].Attempts++
+			return m.challenges[i], nil
+		}
+	}
+	return domain.MFAChallenge{}, mongo.ErrNoDocuments
+}
+
+func (m *memoryUsers) DeleteMFAChallenge(ctx context.Context, id primitive.ObjectID) error {
+	for i, challenge := range m.challenges {
+		if challenge.ID == id {
+			m.challenges = append(m.challenges[:i], m.challenges[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func TestMFAEnrollmentAndLogin(t *testing.T) {
+	t.Setenv("JWT_SECRET_KEY", "test-secret")
+
+	user := testUser(t, "password")
+	users := newMemoryUsers(user)
+	usecase := usecases.NewUseCase(users, newMemoryTokens(), newMemoryLoginAttempts(), infrastructure.TokenConfig{AccessTTL: time.Minute, RefreshTTL: time.Hour}, infrastructure.LoginLimits{}, &memoryMail{})
+	ctx := context.Background()
+	userID := user.ID.Hex()
+
+	if challenge, err := usecase.MFAChallenge(ctx, userID); err != nil || challenge != "" {
+		t.Fatalf("expected no challenge without MFA, got %q %v", challenge, err)
+	}
+
+	secret, uri, err := usecase.EnrollMFA(ctx, userID)
+	if err != nil || !strings.Contains(uri, secret) {
+		t.Fatalf("unexpected enrollment %q %v", uri, err)
+	}
+
+	if _, err := usecase.ConfirmMFA(ctx, userID, "000000"); !errors.Is(err, usecases.ErrInvalidMFACode) {
+		t.Fatalf("expected a wrong code to be refused, got %v", err)
+	}
+
+	code, _ := infrastructure.TOTPCode(secret, infrastructure.TOTPStep(time.Now()))
+	recoveryCodes, err := usecase.ConfirmMFA(ctx, userID, code)
+	if err != nil || len(recoveryCodes) != 10 || users.users[userID].MFA.RecoveryCodes[0] == recoveryCodes[0] {
+		t.Fatalf("expected hashed recovery codes, got %v %v", recoveryCodes, err)
+	}
+
+	challenge, err := usecase.MFAChallenge(ctx, userID)
+	if err != nil || challenge == "" {
+		t.Fatalf("expected a challenge, got %v", err)
+	}
+
+	// the code that confirmed the enrollment can't be used again
+	if _, err := usecase.VerifyMFA(ctx, challenge, code); !errors.Is(err, usecases.ErrInvalidMFACode) {
+		t.Errorf("expected a used code to be refused, got %v", err)
+	}
+
+	tokens, err := usecase.VerifyMFA(ctx, challenge, strings.ToUpper(recoveryCodes[0]))
+	if err != nil || tokens.AccessToken == "" {
+		t.Fatalf("expected the recovery code to log in, got %v", err)
+	}
+
+	if _, err := usecase.VerifyMFA(ctx, challenge, recoveryCodes[1]); !errors.Is(err, usecases.ErrInvalidChallenge) {
+		t.Errorf("expected the challenge to be used up, got %v", err)
+	}
+
+	challenge, _ = usecase.MFAChallenge(ctx, userID)
+	if _, err := usecase.VerifyMFA(ctx, challenge, recoveryCodes[0]); !errors.Is(err, usecases.ErrInvalidMFACode) {
+		t.Errorf("expected a recovery code to work once, got %v", err)
+	}
+}
+
+func TestDisableMFACountsWrongPasswords(t *testing.T) {
+	t.Setenv("JWT_SECRET_KEY", "test-secret")
+
+	user := testUser(t, "password")
+	users := newMemoryUsers(user)
+	limits := infrastructure.LoginLimits{
+		Account: infrastructure.LoginPolicy{FreeFailures: 5, LockoutFailures: 3, LockoutDuration: time.Hour, Window: 2 * time.Hour},
+	}
+	usecase := usecases.NewUseCase(users, newMemoryTokens(), newMemoryLoginAttempts(), infrastructure.TokenConfig{}, limits, &memoryMail{})
+	ctx := context.Background()
+	userID := user.ID.Hex()
+
+	secret, _, err := usecase.EnrollMFA(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := infrastructure.TOTPCode(secret, infrastructure.TOTPStep(time.Now()))
+	if _, err := usecase.ConfirmMFA(ctx, userID, code); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := usecase.DisableMFA(ctx, userID, "wrong-password", ""); !errors.Is(err, usecases.ErrWrongPassword) {
+			t.Fatalf("expected attempt %d to be a wrong password, got %v", i+1, err)
+		}
+	}
+
+	// the password isn't checked any more, right or wrong
+	if err := usecase.DisableMFA(ctx, userID, "password", code); !errors.Is(err, usecases.ErrLoginThrottled) {
+		t.Errorf("expected the account to be locked, got %v", err)
+	}
+	if _, err := usecase.Login(ctx, domain.User{Email: user.Email, Password: "password"}, "10.0.0.1"); !errors.Is(err, usecases.ErrLoginThrottled) {
+		t.Errorf("expected the logins of the account to be locked too, got %v", err)
+	}
+	if !users.users[userID].MFA.Enabled {
+		t.Error("expected MFA to stay on")
+	}
+}
@@ -0,0 +1,427 @@
+package test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github/chera/fix-it/delivery/controller"
+	"github/chera/fix-it/domain"
+	"github/chera/fix-it/infrastructure"
+	"github/chera/fix-it/usecases"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// mockOIDC is an OpenID Connect provider with discovery, a key set and a
+// token endpoint checking PKCE. Users "log in" with authorize.
+type mockOIDC struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	// signs the ID tokens when set, a key the provider doesn't publish
+	signer *rsa.PrivateKey
+	codes  map[string]mockGrant
+	// when set the key set is served once the test closes it
+	slowKeys chan struct{}
+}
+
+type mockGrant struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newMockOIDC(t *testing.T) *mockOIDC {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mockOIDC{t: t, key: key, codes: map[string]mockGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		if m.slowKeys != nil {
+			<-m.slowKeys
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test-key",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", m.token)
+
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockOIDC) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	grant, ok := m.codes[r.PostForm.Get("code")]
+	delete(m.codes, r.PostForm.Get("code"))
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	signer := m.key
+	if m.signer != nil {
+		signer = m.signer
+	}
+
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, grant.claims)
+	idToken.Header["kid"] = "test-key"
+	signed, err := idToken.SignedString(signer)
+	if err != nil {
+		m.t.Error(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     signed,
+	})
+}
+
+// authorize is the user logging in at the provider, it returns the code
+// and state the provider sends them back with.
+func (m *mockOIDC) authorize(authURL, subject, email string, verified bool) (string, string) {
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	query := parsed.Query()
+
+	if query.Get("code_challenge_method") != "S256" || query.Get("client_id") != "fix-it" || query.Get("response_type") != "code" {
+		m.t.Fatalf("unexpected authorization request %s", authURL)
+	}
+
+	code := primitive.NewObjectID().Hex()
+	m.codes[code] = mockGrant{
+		challenge: query.Get("code_challenge"),
+		claims: jwt.MapClaims{
+			"iss":            m.server.URL,
+			"aud":            "fix-it",
+			"sub":            subject,
+			"email":          email,
+			"email_verified": verified,
+			"nonce":          query.Get("nonce"),
+			"iat":            time.Now().Unix(),
+			"exp":            time.Now().Add(time.Minute).Unix(),
+		},
+	}
+	return code, query.Get("state")
+}
+
+// memoryOIDC keeps logins and signups the way the mongo repository does.
+type memoryOIDC struct {
+	mu      sync.Mutex
+	logins  []domain.OIDCLogin
+	signups []domain.OIDCSignup
+}
+
+func (m *memoryOIDC) SaveLogin(ctx context.Context, login domain.OIDCLogin) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.logins = append(m.logins, login)
+	return nil
+}
+
+func (m *memoryOIDC) ConsumeLogin(ctx context.Context, hash string) (domain.OIDCLogin, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, login := range m.logins {
+		if login.Hash == hash {
+			m.logins = append(m.logins[:i], m.logins[i+1:]...)
+			return login, nil
+		}
+	}
+	return domain.OIDCLogin{}, mongo.ErrNoDocuments
+}
+
+func (m *memoryOIDC) SaveSignup(ctx context.Context, signup domain.OIDCSignup) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	signup.ID = primitive.NewObjectID()
+	m.signups = append(m.signups, signup)
+	return nil
+}
+
+func (m *memoryOIDC) FindSignup(ctx context.Context, hash string) (domain.OIDCSignup, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, signup := range m.signups {
+		if signup.Hash == hash {
+			return signup, nil
+		}
+	}
+	return domain.OIDCSignup{}, mongo.ErrNoDocuments
+}
+
+func (m *memoryOIDC) DeleteSignup(ctx context.Context, id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.signups = slices.DeleteFunc(m.signups, func(signup domain.OIDCSignup) bool { return signup.ID == id })
+	return nil
+}
+
+func (m *memoryOIDC) EnsureIndexes(ctx context.Context) error { return nil }
+
+func (m *memoryUsers) GetUserByIdentity(ctx context.Context, identity domain.Identity) (domain.User, error) {
+	for _, user := range m.users {
+		if slices.Contains(user.Identities, identity) {
+			return user, nil
+		}
+	}
+	return domain.User{}, mongo.ErrNoDocuments
+}
+
+func (m *memoryUsers) LinkIdentity(ctx context.Context, userID string, identity domain.Identity) error {
+	user := m.users[userID]
+	user.Identities = append(user.Identities, identity)
+	m.users[userID] = user
+	return nil
+}
+
+func (m *memoryUsers) CreateLinkedUser(ctx context.Context, user domain.User) (string, error) {
+	if _, err := m.GetUserByEmail(ctx, user.Email); err == nil {
+		return "", errors.New("user email already exist")
+	}
+	user.ID = primitive.NewObjectID()
+	m.users[user.ID.Hex()] = user
+	return user.ID.Hex(), nil
+}
+
+func TestOIDCLogin(t *testing.T) {
+	provider := newMockOIDC(t)
+	existing := testUser(t, "password")
+	users := newMemoryUsers(existing)
+	oidc := usecases.NewOIDCUsecase(&memoryOIDC{}, users, infrastructure.NewOIDCProvider(infrastructure.OIDCConfig{
+		Issuer:      provider.server.URL,
+		ClientID:    "fix-it",
+		RedirectURL: "http://front.example/oidc/callback",
+		Scopes:      []string{"openid", "email"},
+	}))
+	ctx := context.Background()
+
+	login := func(subject, email string, verified bool) (usecases.OIDCResult, error) {
+		authURL, browserState, err := oidc.Start(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, state := provider.authorize(authURL, subject, email, verified)
+		return oidc.Callback(ctx, code, state, browserState)
+	}
+
+	// the existing account is linked by its verified email
+	result, err := login("school-1", "abebe@example.com", true)
+	if err != nil || result.UserID != existing.ID.Hex() {
+		t.Fatalf("expected to log in as the existing user, got %+v %v", result, err)
+	}
+	if identities := users.users[existing.ID.Hex()].Identities; len(identities) != 1 || identities[0].Issuer != provider.server.URL {
+		t.Errorf("expected the provider account to be linked, got %v", identities)
+	}
+
+	// linked accounts are found by subject, whatever the email is now
+	if result, err := login("school-1", "abebe@school.example", false); err != nil || result.UserID != existing.ID.Hex() {
+		t.Errorf("expected the linked account, got %+v %v", result, err)
+	}
+
+	if _, err := login("school-2", "kebede@example.com", false); !errors.Is(err, usecases.ErrEmailNotVerified) {
+		t.Errorf("expected an unverified email to be refused, got %v", err)
+	}
+
+	authURL, _, _ := oidc.Start(ctx)
+	code, state := provider.authorize(authURL, "school-1", "abebe@example.com", true)
+	if _, err := oidc.Callback(ctx, code, "forged-state", "forged-state"); !errors.Is(err, usecases.ErrInvalidOIDCState) {
+		t.Errorf("expected an unknown state to be refused, got %v", err)
+	}
+	if _, err := oidc.Callback(ctx, "stolen-code", state, state); !errors.Is(err, usecases.ErrOIDCLoginFailed) {
+		t.Errorf("expected a code without its verifier to be refused, got %v", err)
+	}
+	if _, err := oidc.Callback(ctx, code, state, state); !errors.Is(err, usecases.ErrInvalidOIDCState) {
+		t.Errorf("expected a state to work once, got %v", err)
+	}
+
+	// a new user completes the profile the provider doesn't know
+	result, err = login("school-3", "Almaz.T@example.com", true)
+	if err != nil || result.SignupToken == "" || result.Username != "almaz_t" {
+		t.Fatalf("expected a signup, got %+v %v", result, err)
+	}
+
+	userID, err := oidc.CompleteProfile(ctx, result.SignupToken, domain.User{Username: "almaz", Age: 20, Academic: "Undergraduated"})
+	if err != nil || users.users[userID].Email != "Almaz.T@example.com" || len(users.users[userID].Identities) != 1 {
+		t.Fatalf("expected the user to be created, got %v", err)
+	}
+
+	if _, err := oidc.CompleteProfile(ctx, result.SignupToken, domain.User{Username: "almaz2", Age: 20, Academic: "Undergraduated"}); !errors.Is(err, usecases.ErrInvalidSignup) {
+		t.Errorf("expected a signup to work once, got %v", err)
+	}
+
+	if result, err := login("school-3", "Almaz.T@example.com", true); err != nil || result.UserID != userID {
+		t.Errorf("expected the new user to log in, got %+v %v", result, err)
+	}
+
+	// ID tokens signed with a key the provider didn't publish
+	provider.signer, _ = rsa.GenerateKey(rand.Reader, 2048)
+	if _, err := login("school-1", "abebe@example.com", true); !errors.Is(err, usecases.ErrOIDCLoginFailed) {
+		t.Errorf("expected a forged ID token to be refused, got %v", err)
+	}
+}
+
+// TestOIDCStateIsBoundToTheBrowser is the login CSRF: a code and state of a
+// login the attacker started are sent from the browser of the victim.
+func TestOIDCStateIsBoundToTheBrowser(t *testing.T) {
+	t.Setenv("JWT_SECRET_KEY", "test-secret")
+
+	provider := newMockOIDC(t)
+	users := newMemoryUsers(testUser(t, "password"))
+	oidc := usecases.NewOIDCUsecase(&memoryOIDC{}, users, infrastructure.NewOIDCProvider(infrastructure.OIDCConfig{
+		Issuer:      provider.server.URL,
+		ClientID:    "fix-it",
+		RedirectURL: "http://front.example/oidc/callback",
+		Scopes:      []string{"openid", "email"},
+	}))
+	userUsecase := usecases.NewUseCase(users, newMemoryTokens(), newMemoryLoginAttempts(), infrastructure.TokenConfig{AccessTTL: time.Minute, RefreshTTL: time.Hour}, infrastructure.LoginLimits{}, &memoryMail{})
+	oidcController := controller.NewOIDCController(oidc, userUsecase)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/u/oidc/start", oidcController.Start)
+	router.POST("/u/oidc/callback", oidcController.Callback)
+
+	// start returns the provider URL and keeps the state in the browser
+	start := func() (string, *http.Cookie) {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/u/oidc/start", nil))
+
+		var body struct {
+			URL string `json:"authorization_url"`
+		}
+		json.NewDecoder(recorder.Body).Decode(&body)
+
+		for _, cookie := range recorder.Result().Cookies() {
+			if cookie.Name == "oidc_state" {
+				if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+					t.Errorf("expected an HttpOnly SameSite cookie, got %+v", cookie)
+				}
+				return body.URL, cookie
+			}
+		}
+		t.Fatal("expected the state in a cookie")
+		return "", nil
+	}
+
+	callback := func(code, state string, cookie *http.Cookie) int {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/u/oidc/callback", strings.NewReader(`{"code":"`+code+`","state":"`+state+`"}`))
+		if cookie != nil {
+			request.AddCookie(cookie)
+		}
+		router.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	attackerURL, _ := start()
+	code, state := provider.authorize(attackerURL, "attacker", "attacker@example.com", true)
+
+	if status := callback(code, state, nil); status != http.StatusUnauthorized {
+		t.Errorf("expected a browser without the cookie to be refused, got %d", status)
+	}
+
+	_, victimCookie := start()
+	if status := callback(code, state, victimCookie); status != http.StatusUnauthorized {
+		t.Errorf("expected the state of another login to be refused, got %d", status)
+	}
+
+	ownURL, ownCookie := start()
+	code, state = provider.authorize(ownURL, "school-1", "abebe@example.com", true)
+	if status := callback(code, state, ownCookie); status != http.StatusOK {
+		t.Errorf("expected the browser that started the login to finish it, got %d", status)
+	}
+}
+
+func TestOIDCKeyFetchDoesNotBlockLogins(t *testing.T) {
+	provider := newMockOIDC(t)
+	provider.slowKeys = make(chan struct{})
+	oidc := usecases.NewOIDCUsecase(&memoryOIDC{}, newMemoryUsers(testUser(t, "password")), infrastructure.NewOIDCProvider(infrastructure.OIDCConfig{
+		Issuer:      provider.server.URL,
+		ClientID:    "fix-it",
+		RedirectURL: "http://front.example/oidc/callback",
+		Scopes:      []string{"openid", "email"},
+	}))
+	ctx := context.Background()
+
+	authURL, browserState, err := oidc.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state := provider.authorize(authURL, "school-1", "abebe@example.com", true)
+
+	finished := make(chan error, 1)
+	go func() {
+		_, err := oidc.Callback(ctx, code, state, browserState)
+		finished <- err
+	}()
+
+	// the callback waits for the key set, other users can still start
+	started := make(chan error, 1)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_, _, err := oidc.Start(ctx)
+		started <- err
+	}()
+
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Errorf("expected the login to start, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("starting a login waited for the key set")
+	}
+
+	close(provider.slowKeys)
+	if err := <-finished; err != nil {
+		t.Errorf("expected the callback to finish once the keys are in, got %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"github/chera/fix-it/domain"
+	"github/chera/fix-it/infrastructure"
+	"reflect"
+	"testing"
+)
+
+func TestParsePageRanges(t *testing.T) {
+	pages, err := infrastructure.ParsePageRanges("8-, 3-5,4,1", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(pages, []int{1, 3, 4, 5, 8, 9, 10}) || infrastructure.FormatPageRanges(pages) != "1,3-5,8-10" {
+		t.Errorf("unexpected pages %v", pages)
+	}
+
+	for _, spec := range []string{"0-2", "5-3", "a", "9-11"} {
+		if _, err := infrastructure.ParsePageRanges(spec, 10); err == nil {
+			t.Errorf("expected %q to be rejected", spec)
+		}
+	}
+
+	if infrastructure.CheckPageRanges("20-") != nil || infrastructure.CheckPageRanges("x-2") == nil {
+		t.Error("expected only the syntax to be checked before the page count is known")
+	}
+}
+
+func TestSelectPagesAndChapters(t *testing.T) {
+	pages := []domain.DocumentPage{
+		{Number: 1, Text: "Contents"},
+		{Number: 2, Text: "Chapter 1 Cells\nCells divide."},
+		{Number: 3, Text: "More on cells."},
+		{Number: 4, Text: "CHAPTER 2 Plants\nPlants grow."},
+	}
+
+	chapters := infrastructure.DetectChapters(pages, domain.MediaTypePDF)
+	expected := []domain.Chapter{{Title: "Chapter 1 Cells", FirstPage: 2, LastPage: 3}, {Title: "CHAPTER 2 Plants", FirstPage: 4, LastPage: 4}}
+	if !reflect.DeepEqual(chapters, expected) {
+		t.Fatalf("unexpected chapters %+v", chapters)
+	}
+
+	selected, ranges, err := infrastructure.SelectPages(pages, domain.PageSelection{Pages: "1", Chapters: []int{2}}, chapters)
+	if err != nil || ranges != "1,4" || len(selected) != 2 || selected[1].Number != 4 {
+		t.Errorf("unexpected selection %v %q %v", selected, ranges, err)
+	}
+
+	if _, ranges, _ := infrastructure.SelectPages(pages, domain.PageSelection{Pages: "1-4"}, chapters); ranges != "" {
+		t.Errorf("expected the whole document to need no ranges, got %q", ranges)
+	}
+
+	if _, _, err := infrastructure.SelectPages(pages, domain.PageSelection{Chapters: []int{3}}, chapters); err == nil {
+		t.Error("expected a missing chapter to be rejected")
+	}
+}
+
+// buildOutlinedPDF writes a PDF with a page per title and a bookmark to
+// every page, one of them through a named destination.
+func buildOutlinedPDF(titles ...string) []byte {
+	pageCount := len(titles)
+	// 1 catalog, 2 pages, 3 outlines, then a page and a bookmark per title
+	pageID := func(i int) int { return 4 + i*2 }
+	itemID := func(i int) int { return 5 + i*2 }
+
+	objects := map[int]string{
+		1: "<< /Type /Catalog /Pages 2 0 R /Outlines 3 0 R /Dests << /intro [4 0 R /Fit] >> >>",
+		3: fmt.Sprintf("<< /Type /Outlines /First %d 0 R /Last %d 0 R /Count %d >>", itemID(0), itemID(pageCount-1), pageCount),
+	}
+
+	kids := ""
+	for i, title := range titles {
+		kids += fmt.Sprintf("%d 0 R ", pageID(i))
+		objects[pageID(i)] = "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] >>"
+
+		dest := fmt.Sprintf("/Dest [%d 0 R /Fit]", pageID(i))
+		if i == 0 {
+			dest = "/Dest /intro"
+		}
+		next := ""
+		if i+1 < pageCount {
+			next = fmt.Sprintf("/Next %d 0 R", itemID(i+1))
+		}
+		objects[itemID(i)] = fmt.Sprintf("<< /Title (%s) /Parent 3 0 R %s %s >>", title, dest, next)
+	}
+	objects[2] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", kids, pageCount)
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+
+	offsets := make([]int, len(objects)+1)
+	for id := 1; id <= len(objects); id++ {
+		offsets[id] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", id, objects[id])
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for id := 1; id <= len(objects); id++ {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offsets[id])
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	return buf.Bytes()
+}
+
+func TestPDFOutlineChapters(t *testing.T) {
+	file := buildOutlinedPDF("Introduction", "Cells", "Plants")
+	pages := []domain.DocumentPage{{Number: 1}, {Number: 2}, {Number: 3}}
+
+	chapters := infrastructure.DocumentChapters(file, domain.MediaTypePDF, pages)
+	expected := []domain.Chapter{{Title: "Introduction", FirstPage: 1, LastPage: 1}, {Title: "Cells", FirstPage: 2, LastPage: 2}, {Title: "Plants", FirstPage: 3, LastPage: 3}}
+
+	if !reflect.DeepEqual(chapters, expected) {
+		t.Errorf("unexpected chapters %+v", chapters)
+	}
+
+	if chapters := infrastructure.PDFOutline([]byte("%PDF-1.4 broken")); chapters != nil {
+		t.Errorf("expected a broken file to have no outline, got %+v", chapters)
+	}
+}
@@ -0,0 +1,218 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"github/chera/fix-it/domain"
+	"github/chera/fix-it/infrastructure"
+	"github/chera/fix-it/repository"
+	"github/chera/fix-it/usecases"
+	"net/url"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// memoryUsers keeps users and password resets the way the mongo repository
+// does.
+type memoryUsers struct {
+	repository.UserRepository
+	users      map[string]domain.User
+	resets     []domain.PasswordReset
+	challenges []domain.MFAChallenge
+}
+
+func newMemoryUsers(users ...domain.User) *memoryUsers {
+	m := &memoryUsers{users: map[string]domain.User{}}
+	for _, user := range users {
+		m.users[user.ID.Hex()] = user
+	}
+	return m
+}
+
+func (m *memoryUsers) GetUserByEmail(ctx context.Context, email string) (domain.User, error) {
+	for _, user := range m.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return domain.User{}, errors.New("no such user")
+}
+
+func (m *memoryUsers) GetUserByID(ctx context.Context, userID string) (domain.User, error) {
+	user, ok := m.users[userID]
+	if !ok {
+		return domain.User{}, errors.New("no such user")
+	}
+	return user, nil
+}
+
+func (m *memoryUsers) UpdatePassword(ctx context.Context, userID, hashedPassword string) error {
+	user := m.users[userID]
+	user.Password = hashedPassword
+	m.users[userID] = user
+	return nil
+}
+
+func (m *memoryUsers) SavePasswordReset(ctx context.Context, reset domain.PasswordReset) error {
+	m.resets = append(m.resets, reset)
+	return nil
+}
+
+func (m *memoryUsers) ConsumePasswordReset(ctx context.Context, hash string) (domain.PasswordReset, error) {
+	for i, reset := range m.resets {
+		if reset.Hash == hash && reset.UsedAt == nil && time.Now().Before(reset.ExpiresAt) {
+			now := time.Now()
+			m.resets[i].UsedAt = &now
+			return reset, nil
+		}
+	}
+	return domain.PasswordReset{}, mongo.ErrNoDocuments
+}
+
+// memoryMail keeps queued emails instead of sending them.
+type memoryMail struct {
+	queued []any
+}
+
+func (m *memoryMail) Queue(ctx context.Context, to, template string, data any) error {
+	m.queued = append(m.queued, data)
+	return nil
+}
+
+func (m *memoryMail) StartWorkers(ctx context.Context, workers int) {}
+
+func testUser(t *testing.T, password string) domain.User {
+	hashed, err := infrastructure.HashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return domain.User{ID: primitive.NewObjectID(), Username: "abebe", Email: "abebe@example.com", Password: hashed}
+}
+
+func TestResetPasswordIsSingleUse(t *testing.T) {
+	t.Setenv("JWT_SECRET_KEY", "test-secret")
+
+	user := testUser(t, "old-password")
+	users := newMemoryUsers(user)
+	tokens := newMemoryTokens()
+	mail := &memoryMail{}
+	usecase := usecases.NewUseCase(users, tokens, newMemoryLoginAttempts(), infrastructure.TokenConfig{AccessTTL: time.Minute, RefreshTTL: time.Hour, ResetTTL: time.Hour}, infrastructure.LoginLimits{}, mail)
+	ctx := context.Background()
+
+	// unknown emails look the same as known ones
+	if err := usecase.ForgotPassword(ctx, "nobody@example.com"); err != nil || len(users.resets) != 0 {
+		t.Fatalf("expected no reset for an unknown email, got %v", err)
+	}
+
+	session, err := usecase.IssueTokens(ctx, user.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := usecase.ForgotPassword(ctx, user.Email); err != nil || len(mail.queued) != 1 {
+		t.Fatalf("expected a reset email, got %v", err)
+	}
+
+	email := mail.queued[0].(infrastructure.PasswordResetEmail)
+	link, err := url.Parse(email.Link)
+	if err != nil || email.ValidFor != "1 hour" {
+		t.Fatalf("unexpected reset email %+v", email)
+	}
+
+	users.SavePasswordReset(ctx, domain.PasswordReset{UserID: user.ID.Hex(), Hash: infrastructure.HashToken("expired-token"), ExpiresAt: time.Now().Add(-time.Minute)})
+
+	resetToken := link.Query().Get("token")
+	if err := usecase.ResetPassword(ctx, resetToken, "new-password"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !infrastructure.ComparePassword(users.users[user.ID.Hex()].Password, "new-password") {
+		t.Error("expected the password to be replaced")
+	}
+
+	if _, err := usecase.Refresh(ctx, session.RefreshToken); err == nil {
+		t.Error("expected the sessions to be revoked after a reset")
+	}
+
+	for _, token := range []string{resetToken, "expired-token", "unknown"} {
+		if err := usecase.ResetPassword(ctx, token, "another-password"); !errors.Is(err, usecases.ErrInvalidResetToken) {
+			t.Errorf("expected %s to be rejected, got %v", token, err)
+		}
+	}
+}
+
+func TestChangePasswordKeepsCurrentSession(t *testing.T) {
+	t.Setenv("JWT_SECRET_KEY", "test-secret")
+
+	user := testUser(t, "old-password")
+	users := newMemoryUsers(user)
+	tokens := newMemoryTokens()
+	usecase := usecases.NewUseCase(users, tokens, newMemoryLoginAttempts(), infrastructure.TokenConfig{AccessTTL: time.Minute, RefreshTTL: time.Hour}, infrastructure.LoginLimits{}, &memoryMail{})
+	ctx := context.Background()
+
+	current, _ := usecase.IssueTokens(ctx, user.ID.Hex())
+	other, _ := usecase.IssueTokens(ctx, user.ID.Hex())
+	currentAccessID := tokens.tokens[0].AccessID
+
+	if err := usecase.ChangePassword(ctx, user.ID.Hex(), "wrong-password", "new-password", currentAccessID); !errors.Is(err, usecases.ErrWrongPassword) {
+		t.Fatalf("expected the wrong password to be refused, got %v", err)
+	}
+
+	if err := usecase.ChangePassword(ctx, user.ID.Hex(), "old-password", "new-password", currentAccessID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := usecase.Refresh(ctx, current.RefreshToken); err != nil {
+		t.Errorf("expected the current session to survive, got %v", err)
+	}
+
+	if _, err := usecase.Refresh(ctx, other.RefreshToken); err == nil {
+		t.Error("expected the other session to be revoked")
+	}
+}
+
+func TestResetPasswordLiftsLockout(t *testing.T) {
+	t.Setenv("JWT_SECRET_KEY", "test-secret")
+
+	user := testUser(t, "old-password")
+	users := newMemoryUsers(user)
+	mail := &memoryMail{}
+	limits := infrastructure.LoginLimits{
+		Account: infrastructure.LoginPolicy{FreeFailures: 5, LockoutFailures: 3, LockoutDuration: time.Hour, Window: 2 * time.Hour},
+		IP:      infrastructure.LoginPolicy{FreeFailures: 10, LockoutFailures: 20, LockoutDuration: time.Hour, Window: 2 * time.Hour},
+	}
+	usecase := usecases.NewUseCase(users, newMemoryTokens(), newMemoryLoginAttempts(), infrastructure.TokenConfig{ResetTTL: time.Hour}, limits, mail)
+	ctx := context.Background()
+
+	login := func(password string) error {
+		_, err := usecase.Login(ctx, domain.User{Email: user.Email, Password: password}, "10.0.0.1")
+		return err
+	}
+
+	for i := 0; i < 3; i++ {
+		login("wrong-password")
+	}
+	if err := login("old-password"); !errors.Is(err, usecases.ErrLoginThrottled) {
+		t.Fatalf("expected the account to be locked, got %v", err)
+	}
+
+	if err := usecase.ForgotPassword(ctx, user.Email); err != nil {
+		t.Fatal(err)
+	}
+
+	link, err := url.Parse(mail.queued[len(mail.queued)-1].(infrastructure.PasswordResetEmail).Link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := usecase.ResetPassword(ctx, link.Query().Get("token"), "new-password"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := login("new-password"); err != nil {
+		t.Errorf("expected the new password to work right away, got %v", err)
+	}
+}
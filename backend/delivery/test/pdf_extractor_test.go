@@ -0,0 +1,103 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github/chera/fix-it/infrastructure"
+	"strings"
+	"testing"
+)
+
+// buildPDF writes a minimal PDF with one page per content stream.
+func buildPDF(contents ...string) []byte {
+	widths := strings.TrimSpace(strings.Repeat("500 ", 95))
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"",
+		fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /FirstChar 32 /LastChar 126 /Widths [%s] >>", widths),
+	}
+
+	var kids []string
+	for _, content := range contents {
+		pageID := len(objects) + 1
+		kids = append(kids, fmt.Sprintf("%d 0 R", pageID))
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", pageID+1),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		)
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids))
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	return buf.Bytes()
+}
+
+func textLine(x, y int, text string) string {
+	return fmt.Sprintf("BT /F1 10 Tf %d %d Td (%s) Tj ET\n", x, y, text)
+}
+
+func TestLocalPDFExtractorPages(t *testing.T) {
+	file := buildPDF(textLine(72, 700, "First page"), textLine(72, 700, "Second page"))
+
+	pages, err := infrastructure.NewLocalPDFExtractor().Extract(context.Background(), bytes.NewReader(file), "test.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pages) != 2 {
+		t.Fatalf("expected 2 pages, got %d", len(pages))
+	}
+
+	if pages[0].Number != 1 || pages[0].Text != "First page" || pages[1].Text != "Second page" {
+		t.Errorf("unexpected pages %+v", pages)
+	}
+}
+
+func TestLocalPDFExtractorColumns(t *testing.T) {
+	var content strings.Builder
+	content.WriteString(textLine(72, 740, "A Title That Spans The Whole Page Width Of The Document Here"))
+	for i := 0; i < 8; i++ {
+		y := 700 - i*14
+		content.WriteString(textLine(72, y, fmt.Sprintf("left %d", i)))
+		content.WriteString(textLine(340, y, fmt.Sprintf("right %d", i)))
+	}
+
+	pages, err := infrastructure.NewLocalPDFExtractor().Extract(context.Background(), bytes.NewReader(buildPDF(content.String())), "columns.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := pages[0].Text
+
+	if !strings.HasPrefix(text, "A Title") {
+		t.Errorf("expected the title first, got %q", text)
+	}
+
+	if strings.Index(text, "left 7") > strings.Index(text, "right 0") {
+		t.Errorf("expected the left column to be read before the right one, got %q", text)
+	}
+}
+
+func TestLocalPDFExtractorRejectsGarbage(t *testing.T) {
+	_, err := infrastructure.NewLocalPDFExtractor().Extract(context.Background(), strings.NewReader("not a pdf"), "bad.pdf")
+	if err == nil {
+		t.Error("expected an error for a file that is not a pdf")
+	}
+}
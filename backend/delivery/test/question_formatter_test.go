@@ -3,6 +3,7 @@ package test
 import (
 	"github/chera/fix-it/domain"
 	"github/chera/fix-it/infrastructure"
+	"reflect"
 	"testing"
 )
 
@@ -32,7 +33,7 @@ A
 	}
 
 	for i, question := range result {
-		if question != expected[i] {
+		if !reflect.DeepEqual(question, expected[i]) {
 			t.Errorf("At index %d, expected %v, got %v", i, expected[i], question)
 		}
 	}
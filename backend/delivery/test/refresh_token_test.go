@@ -0,0 +1,169 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"github/chera/fix-it/domain"
+	"github/chera/fix-it/infrastructure"
+	"github/chera/fix-it/usecases"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// memoryTokens keeps tokens the way the mongo repository does.
+type memoryTokens struct {
+	tokens  []domain.RefreshToken
+	revoked map[string]time.Time
+}
+
+func newMemoryTokens() *memoryTokens {
+	return &memoryTokens{revoked: map[string]time.Time{}}
+}
+
+func (m *memoryTokens) SaveRefreshToken(ctx context.Context, token domain.RefreshToken) error {
+	token.ID = primitive.NewObjectID()
+	m.tokens = append(m.tokens, token)
+	return nil
+}
+
+func (m *memoryTokens) FindRefreshToken(ctx context.Context, hash string) (domain.RefreshToken, error) {
+	for _, token := range m.tokens {
+		if token.Hash == hash {
+			return token, nil
+		}
+	}
+	return domain.RefreshToken{}, mongo.ErrNoDocuments
+}
+
+func (m *memoryTokens) ConsumeRefreshToken(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	for i := range m.tokens {
+		if m.tokens[i].ID == id && m.tokens[i].RevokedAt == nil {
+			now := time.Now()
+			m.tokens[i].RevokedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *memoryTokens) revoke(match func(domain.RefreshToken) bool) {
+	now := time.Now()
+	for i := range m.tokens {
+		if match(m.tokens[i]) {
+			m.revoked[m.tokens[i].AccessID] = m.tokens[i].AccessExpiresAt
+			if m.tokens[i].RevokedAt == nil {
+				m.tokens[i].RevokedAt = &now
+			}
+		}
+	}
+}
+
+func (m *memoryTokens) RevokeFamily(ctx context.Context, family string) error {
+	m.revoke(func(token domain.RefreshToken) bool { return token.Family == family })
+	return nil
+}
+
+func (m *memoryTokens) RevokeUserTokens(ctx context.Context, userID string) error {
+	m.revoke(func(token domain.RefreshToken) bool { return token.UserID == userID })
+	return nil
+}
+
+func (m *memoryTokens) RevokeOtherSessions(ctx context.Context, userID, accessID string) error {
+	family := ""
+	for _, token := range m.tokens {
+		if token.AccessID == accessID {
+			family = token.Family
+		}
+	}
+	m.revoke(func(token domain.RefreshToken) bool { return token.UserID == userID && token.Family != family })
+	return nil
+}
+
+func (m *memoryTokens) DenyAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	m.revoked[jti] = expiresAt
+	return nil
+}
+
+func (m *memoryTokens) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	_, ok := m.revoked[jti]
+	return ok, nil
+}
+
+func (m *memoryTokens) EnsureIndexes(ctx context.Context) error {
+	return nil
+}
+
+func TestRefreshTokenRotationAndReuse(t *testing.T) {
+	t.Setenv("JWT_SECRET_KEY", "test-secret")
+
+	tokens := newMemoryTokens()
+	users := usecases.NewUseCase(nil, tokens, newMemoryLoginAttempts(), infrastructure.TokenConfig{AccessTTL: time.Minute, RefreshTTL: time.Hour}, infrastructure.LoginLimits{}, &memoryMail{})
+	ctx := context.Background()
+
+	first, err := users.IssueTokens(ctx, "user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := users.Refresh(ctx, first.RefreshToken)
+	if err != nil || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("expected a new refresh token, got %+v %v", second, err)
+	}
+
+	// the replaced token comes back, somebody else has a copy of it
+	if _, err := users.Refresh(ctx, first.RefreshToken); !errors.Is(err, usecases.ErrRefreshTokenReused) {
+		t.Fatalf("expected reuse to be detected, got %v", err)
+	}
+
+	if _, err := users.Refresh(ctx, second.RefreshToken); !errors.Is(err, usecases.ErrRefreshTokenReused) {
+		t.Errorf("expected the whole family to be revoked, got %v", err)
+	}
+
+	if _, err := users.Refresh(ctx, "unknown"); !errors.Is(err, usecases.ErrInvalidRefreshToken) {
+		t.Errorf("expected unknown tokens to be rejected, got %v", err)
+	}
+
+	if len(tokens.revoked) != 2 {
+		t.Errorf("expected the access tokens of the family to be denied, got %v", tokens.revoked)
+	}
+}
+
+func TestAuthMiddleWareRejectsRevokedTokens(t *testing.T) {
+	t.Setenv("JWT_SECRET_KEY", "test-secret")
+	gin.SetMode(gin.TestMode)
+
+	tokens := newMemoryTokens()
+	router := gin.New()
+	router.GET("/me", infrastructure.AuthMiddleWare(tokens), func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, ctx.GetString("user_id"))
+	})
+
+	access, jti, err := infrastructure.GenerateJWT("user-1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+access)
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	if response := request(); response.Code != http.StatusOK || response.Body.String() != "user-1" {
+		t.Fatalf("expected the token to be accepted, got %d %s", response.Code, response.Body)
+	}
+
+	tokens.DenyAccessToken(context.Background(), jti, time.Now().Add(time.Minute))
+
+	if response := request(); response.Code != http.StatusUnauthorized {
+		t.Errorf("expected a revoked token to be rejected, got %d", response.Code)
+	}
+}
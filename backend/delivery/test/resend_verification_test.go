@@ -0,0 +1,109 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"github/chera/fix-it/delivery/controller"
+	"github/chera/fix-it/infrastructure"
+	"github/chera/fix-it/repository"
+	"github/chera/fix-it/usecases"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// pendingRegistrations throttles renewals the way the mongo repository does.
+type pendingRegistrations struct {
+	repository.UserRepository
+	sentAt  map[string]time.Time
+	resends map[string]int
+}
+
+func (p *pendingRegistrations) RenewVerification(ctx context.Context, email, token string, interval time.Duration, maxResends int) error {
+	sentAt, ok := p.sentAt[email]
+	if !ok {
+		return mongo.ErrNoDocuments
+	}
+
+	if time.Since(sentAt) < interval || p.resends[email] >= maxResends {
+		return repository.ErrVerificationThrottled
+	}
+
+	p.sentAt[email] = time.Now()
+	p.resends[email]++
+	return nil
+}
+
+func TestResendVerification(t *testing.T) {
+	t.Setenv("JWT_SECRET_KEY", "test-secret")
+
+	pending := &pendingRegistrations{
+		sentAt:  map[string]time.Time{"abebe@example.com": time.Now().Add(-time.Hour)},
+		resends: map[string]int{},
+	}
+	mail := &memoryMail{}
+	users := usecases.NewUseCase(pending, newMemoryTokens(), newMemoryLoginAttempts(), infrastructure.TokenConfig{}, infrastructure.LoginLimits{}, mail)
+	ctx := context.Background()
+
+	if err := users.ResendVerification(ctx, "nobody@example.com"); err != nil || len(mail.queued) != 0 {
+		t.Fatalf("expected unknown emails to be ignored, got %v", err)
+	}
+
+	if err := users.ResendVerification(ctx, "abebe@example.com"); err != nil || len(mail.queued) != 1 {
+		t.Fatalf("expected a new verification email, got %v", err)
+	}
+
+	link, err := url.Parse(mail.queued[0].(infrastructure.VerificationEmail).Link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if address, err := infrastructure.VerificationTokenValidate(link.Query().Get("token")); err != nil || address != "abebe@example.com" {
+		t.Errorf("expected a valid token in %s, got %v", link, err)
+	}
+
+	if err := users.ResendVerification(ctx, "abebe@example.com"); !errors.Is(err, usecases.ErrResendThrottled) || len(mail.queued) != 1 {
+		t.Errorf("expected the second resend to be throttled, got %v", err)
+	}
+}
+
+func TestResendVerificationController(t *testing.T) {
+	t.Setenv("JWT_SECRET_KEY", "test-secret")
+
+	pending := &pendingRegistrations{
+		sentAt:  map[string]time.Time{"abebe@example.com": time.Now().Add(-time.Hour)},
+		resends: map[string]int{},
+	}
+	users := usecases.NewUseCase(pending, newMemoryTokens(), newMemoryLoginAttempts(), infrastructure.TokenConfig{}, infrastructure.LoginLimits{}, &memoryMail{})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/u/resend-verification", controller.NewUserController(users).ResendVerification)
+
+	request := func(email string) (int, string) {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/u/resend-verification", strings.NewReader(`{"email":"`+email+`"}`)))
+
+		body, _ := io.ReadAll(recorder.Body)
+		return recorder.Code, string(body)
+	}
+
+	status, unknown := request("nobody@example.com")
+	if status != http.StatusOK {
+		t.Fatalf("expected 200 for an unknown email, got %d", status)
+	}
+
+	request("abebe@example.com")
+
+	// a throttled email can't be told from an unknown one
+	if status, throttled := request("abebe@example.com"); status != http.StatusOK || throttled != unknown {
+		t.Errorf("expected the answer of an unknown email, got %d %s", status, throttled)
+	}
+}
@@ -0,0 +1,93 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"github/chera/fix-it/domain"
+	"github/chera/fix-it/infrastructure"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func retrievalChunks() []domain.DocumentChunk {
+	texts := []string{
+		"Photosynthesis converts light energy into chemical energy stored in glucose.",
+		"The mitochondria is where cellular respiration releases energy from glucose.",
+		"Mitosis divides a cell nucleus into two identical nuclei.",
+	}
+
+	chunks := make([]domain.DocumentChunk, len(texts))
+	for i, text := range texts {
+		chunks[i] = domain.DocumentChunk{TextChunk: domain.TextChunk{Index: i, FirstPage: i + 1, LastPage: i + 1, Text: text}}
+	}
+	return chunks
+}
+
+func TestRankChunksWithBM25(t *testing.T) {
+	ranked := infrastructure.RankChunks("Where does cellular respiration happen?", nil, retrievalChunks(), 2)
+
+	if len(ranked) != 1 || ranked[0].FirstPage != 2 {
+		t.Fatalf("expected only the respiration passage, got %+v", ranked)
+	}
+
+	if ranked := infrastructure.RankChunks("quantum chromodynamics", nil, retrievalChunks(), 2); len(ranked) != 0 {
+		t.Errorf("expected no passage for an unrelated query, got %+v", ranked)
+	}
+}
+
+func TestRankChunksPrefersEmbeddings(t *testing.T) {
+	chunks := retrievalChunks()
+	chunks[0].Embedding = []float64{1, 0}
+	chunks[1].Embedding = []float64{0, 1}
+	chunks[2].Embedding = []float64{0.6, 0.8}
+
+	ranked := infrastructure.RankChunks("respiration", []float64{1, 0.1}, chunks, 1)
+
+	if len(ranked) != 1 || ranked[0].Index != 0 {
+		t.Errorf("expected the closest vector to win over the lexical match, got %+v", ranked)
+	}
+
+	// a chunk without a vector sends everything back to BM25
+	chunks[2].Embedding = nil
+	ranked = infrastructure.RankChunks("respiration", []float64{1, 0.1}, chunks, 1)
+
+	if len(ranked) != 1 || ranked[0].Index != 1 {
+		t.Errorf("expected the lexical match, got %+v", ranked)
+	}
+}
+
+func TestCitationsAttachToTheirQuestion(t *testing.T) {
+	chunk := domain.DocumentChunk{TextChunk: domain.TextChunk{FirstPage: 4, LastPage: 5, Text: strings.Repeat("energy ", 60)}}
+	citation := infrastructure.Cite(chunk, 2)
+
+	if citation.FirstPage != 4 || citation.LastPage != 5 || len(citation.Snippet) > 203 || !strings.HasSuffix(citation.Snippet, "...") {
+		t.Errorf("unexpected citation %+v", citation)
+	}
+
+	explanations := infrastructure.AttachCitations([]domain.QeustionAnswer{{QuestionNumber: 1}, {QuestionNumber: 2}}, []domain.Citation{citation})
+
+	if len(explanations[0].Sources) != 0 || len(explanations[1].Sources) != 1 {
+		t.Errorf("expected the citation on question 2 only, got %+v", explanations)
+	}
+}
+
+func TestOpenAIEmbedderOrdersVectors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/embeddings" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		fmt.Fprint(w, `{"data":[{"index":1,"embedding":[0,1]},{"index":0,"embedding":[1,0]}]}`)
+	}))
+	defer server.Close()
+
+	vectors, err := infrastructure.NewOpenAIEmbedder(server.URL, "", "nomic").Embed(context.Background(), []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vectors[0][0] != 1 || vectors[1][1] != 1 {
+		t.Errorf("expected vectors in input order, got %v", vectors)
+	}
+}
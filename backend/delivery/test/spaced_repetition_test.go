@@ -0,0 +1,82 @@
+package test
+
+import (
+	"github/chera/fix-it/infrastructure"
+	"testing"
+	"time"
+)
+
+func TestScheduleReviewFollowsSM2Intervals(t *testing.T) {
+	now := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	item := infrastructure.NewReviewItem(now)
+
+	for i, expected := range []int{1, 6, 15} {
+		if err := infrastructure.ScheduleReview(&item, infrastructure.QualityGood, now); err != nil {
+			t.Fatal(err)
+		}
+		if item.IntervalDays != expected {
+			t.Fatalf("review %d: expected an interval of %d days, got %d", i+1, expected, item.IntervalDays)
+		}
+		now = item.DueAt
+	}
+
+	if !item.DueAt.Equal(item.LastReviewedAt.AddDate(0, 0, 15)) {
+		t.Errorf("expected the item to be due 15 days after the last review, got %v", item.DueAt)
+	}
+}
+
+func TestScheduleReviewResetsOnLapse(t *testing.T) {
+	now := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	item := infrastructure.NewReviewItem(now)
+
+	infrastructure.ScheduleReview(&item, infrastructure.QualityPerfect, now)
+	infrastructure.ScheduleReview(&item, infrastructure.QualityPerfect, now)
+	ease := item.EaseFactor
+
+	if err := infrastructure.ScheduleReview(&item, infrastructure.QualityWrong, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if item.Repetitions != 0 || item.IntervalDays != 1 || item.Lapses != 1 {
+		t.Errorf("expected the item to start over, got %+v", item)
+	}
+
+	if item.EaseFactor >= ease {
+		t.Errorf("expected the ease factor to drop below %f, got %f", ease, item.EaseFactor)
+	}
+
+	for i := 0; i < 10; i++ {
+		infrastructure.ScheduleReview(&item, infrastructure.QualityBlackout, now)
+	}
+
+	if item.EaseFactor < 1.3 {
+		t.Errorf("expected the ease factor to stay at least 1.3, got %f", item.EaseFactor)
+	}
+
+	if err := infrastructure.ScheduleReview(&item, 6, now); err == nil {
+		t.Error("expected an out of range quality to be rejected")
+	}
+}
+
+func TestCountsAsReviewIgnoresEarlyCorrectAnswers(t *testing.T) {
+	now := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	item := infrastructure.NewReviewItem(now)
+
+	if !infrastructure.CountsAsReview(item, true, now) {
+		t.Error("expected a new item to count")
+	}
+
+	infrastructure.ScheduleReview(&item, infrastructure.QualityGood, now)
+
+	if infrastructure.CountsAsReview(item, true, now.Add(time.Hour)) {
+		t.Error("expected a correct answer before the due date not to count")
+	}
+
+	if !infrastructure.CountsAsReview(item, false, now.Add(time.Hour)) {
+		t.Error("expected a wrong answer before the due date to count")
+	}
+
+	if !infrastructure.CountsAsReview(item, true, item.DueAt) {
+		t.Error("expected a correct answer on the due date to count")
+	}
+}
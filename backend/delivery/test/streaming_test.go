@@ -0,0 +1,123 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github/chera/fix-it/infrastructure"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpenAIClientStreamsChunks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Stream bool `json:"stream"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		if !request.Stream {
+			t.Error("expected a streaming request")
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, token := range []string{"Hel", "lo", " world"} {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":%q}}]}\n\n", token)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	client := infrastructure.NewOpenAIClient(server.URL, "", "llama")
+
+	var chunks []string
+	result, err := client.GenerateStream(context.Background(), nil, "hi", nil, func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != "Hello world" || len(chunks) != 3 {
+		t.Errorf("expected three chunks making Hello world, got %q from %q", result, chunks)
+	}
+}
+
+func TestOpenAIClientStreamEndsWithTheContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n")
+		w.(http.Flusher).Flush()
+
+		// a model that stalls mid answer
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	var chunks []string
+	started := time.Now()
+	_, err := infrastructure.NewOpenAIClient(server.URL, "", "llama").GenerateStream(ctx, nil, "hi", nil, func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+
+	if err == nil || len(chunks) != 1 {
+		t.Errorf("expected the stream to end with the context after one chunk, got %q %v", chunks, err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("expected the deadline of the context to stop the stream, took %s", elapsed)
+	}
+}
+
+func TestStreamStructuredStopsWhenTheClientLeaves(t *testing.T) {
+	client := infrastructure.NewFakeLLMClient(`{"topics": [{"title": "Fractions", "explanation": "Practice adding fractions."}]}`)
+	gone := errors.New("client disconnected")
+
+	received := ""
+	raw, err := infrastructure.StreamStructured(context.Background(), client, nil, "give topics", infrastructure.TopicsSchema, func(raw string) error {
+		_, err := infrastructure.DecodeTopics(raw)
+		return err
+	}, func(chunk string) error {
+		if strings.Contains(received, "Fractions") {
+			return gone
+		}
+		received += chunk
+		return nil
+	})
+
+	if !errors.Is(err, gone) || raw != "" {
+		t.Errorf("expected the stream to stop with the client error, got %q, %v", raw, err)
+	}
+
+	if len(client.Prompts) != 1 {
+		t.Errorf("expected no repair after a cancelled stream, got %d prompts", len(client.Prompts))
+	}
+}
+
+func TestStreamStructuredReturnsTheStreamedResponse(t *testing.T) {
+	response := `{"topics": [{"title": "Fractions", "explanation": "Practice adding fractions."}]}`
+	client := infrastructure.NewFakeLLMClient(response)
+
+	received := ""
+	raw, err := infrastructure.StreamStructured(context.Background(), client, nil, "give topics", infrastructure.TopicsSchema, func(raw string) error {
+		_, err := infrastructure.DecodeTopics(raw)
+		return err
+	}, func(chunk string) error {
+		received += chunk
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if raw != response || received != response {
+		t.Errorf("expected the streamed chunks to make up the response, got %q and %q", raw, received)
+	}
+}
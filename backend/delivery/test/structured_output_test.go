@@ -0,0 +1,155 @@
+package test
+
+import (
+	"context"
+	"github/chera/fix-it/infrastructure"
+	"strings"
+	"testing"
+)
+
+func TestDecodeQuestionsValidates(t *testing.T) {
+	valid := "```json\n" + `{"questions":[{"question":"Who is Abdi?","a":"A peer coach","b":"A friend","c":"A stranger","d":"A coach","answer":"a"}]}` + "\n```"
+
+	questions, err := infrastructure.DecodeQuestions(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(questions) != 1 || questions[0].Answer != "A" || questions[0].A != "A peer coach" {
+		t.Errorf("unexpected questions %+v", questions)
+	}
+
+	invalid := []string{
+		`{"questions":[]}`,
+		`{"questions":[{"question":"Q","a":"1","b":"2","c":"3","d":"4","answer":"E"}]}`,
+		`{"questions":[{"question":"Q","a":"1","b":"2","c":"3","answer":"A"}]}`,
+		`{"questions":[{"question":"Q","a":"1","b":"2","c":"3","d":"4","answer":"A","extra":true}]}`,
+		`1, Who is Abdi?`,
+	}
+
+	for _, raw := range invalid {
+		if _, err := infrastructure.DecodeQuestions(raw); err == nil {
+			t.Errorf("expected %s to be rejected", raw)
+		}
+	}
+}
+
+func TestGenerateStructuredRepairs(t *testing.T) {
+	client := infrastructure.NewFakeLLMClient(
+		`{"topics":[{"title":"","explanation":"missing title"}]}`,
+		`{"topics":[{"title":"Fractions","explanation":"Practice adding fractions."}]}`,
+	)
+
+	raw, err := infrastructure.GenerateStructured(context.Background(), client, nil, "give topics", infrastructure.TopicsSchema, func(raw string) error {
+		_, err := infrastructure.DecodeTopics(raw)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topics := infrastructure.ParseTopicResponse(raw)
+	if len(topics.Topics) != 1 || topics.Topics[0].Title != "Fractions" {
+		t.Errorf("unexpected topics %+v", topics)
+	}
+
+	if len(client.Prompts) != 2 || !strings.Contains(client.Prompts[1], "could not be used") {
+		t.Errorf("expected a repair prompt, got %v", client.Prompts)
+	}
+}
+
+func TestParseResponsesFallBackToLegacyFormat(t *testing.T) {
+	questions := infrastructure.ParseQuestionsResponse("1, Who is Abdi?\nA, A\nB, B\nC, C\nD, D\nA\n")
+	if len(questions) != 1 || questions[0].Question != "Who is Abdi?" {
+		t.Errorf("expected the legacy question parser to be used, got %+v", questions)
+	}
+
+	answers := infrastructure.ParseExplanationResponse("Question Number: 1\nCorrect Answer: B\nYour Answer: A\nCorrectness: Incorrect\nExplanation: because")
+	if len(answers) != 1 || answers[0].CorrectAnswer != "B" {
+		t.Errorf("expected the legacy answer parser to be used, got %+v", answers)
+	}
+}
+
+func TestReadResponsesDropInvalidItems(t *testing.T) {
+	raw := `{"questions":[
+		{"question":"Who is Abdi?","a":"A peer coach","b":"A friend","c":"A stranger","d":"A coach","answer":"A"},
+		{"type":"essay","question":"Write about Abdi","answer":"anything"},
+		{"type":"true_false","question":"Abdi is a coach","answer":"True"}
+	]}`
+
+	questions, err := infrastructure.ReadQuestions(raw)
+	if err != nil || len(questions) != 2 || questions[1].Type != "true_false" {
+		t.Errorf("expected the essay question to be dropped, got %+v %v", questions, err)
+	}
+
+	// a stored quiz with a bad question is never read as the legacy format
+	if questions := infrastructure.ParseQuestionsResponse(raw); len(questions) != 2 || questions[0].Question != "Who is Abdi?" {
+		t.Errorf("expected the valid stored questions, got %+v", questions)
+	}
+
+	for _, raw := range []string{`{"questions":[{"question":"Q"`, `{"questions":[{"type":"essay","question":"Q","answer":"A"}]}`} {
+		if _, err := infrastructure.ReadQuestions(raw); err == nil {
+			t.Errorf("expected %s to be an error", raw)
+		}
+		if questions := infrastructure.ParseQuestionsResponse(raw); len(questions) != 0 {
+			t.Errorf("expected no questions from %s, got %+v", raw, questions)
+		}
+	}
+
+	answers, err := infrastructure.ReadExplanations(`{"answers":[
+		{"question_number":1,"correct_answer":"B","your_answer":"A","correctness":false,"explanation":"A is a friend"},
+		{"question_number":2,"correct_answer":"B","your_answer":"A","correctness":false,"explanation":""}
+	]}`)
+	if err != nil || len(answers) != 1 || answers[0].QuestionNumber != 1 {
+		t.Errorf("expected the answer without explanation to be dropped, got %+v %v", answers, err)
+	}
+
+	again, err := infrastructure.DecodeExplanations(infrastructure.EncodeExplanations(answers))
+	if err != nil || len(again) != 1 || again[0].Explanation != "A is a friend" {
+		t.Errorf("expected the answers to survive encoding, got %+v %v", again, err)
+	}
+
+	topics, err := infrastructure.ReadTopics(`{"topics":[{"title":"Coaching","explanation":"What coaches do."},{"title":"","explanation":"no title"}]}`)
+	if err != nil || len(topics.Topics) != 1 {
+		t.Errorf("expected the topic without title to be dropped, got %+v %v", topics, err)
+	}
+
+	if again, err := infrastructure.DecodeTopics(infrastructure.EncodeTopics(topics)); err != nil || len(again.Topics) != 1 || again.Topics[0].Title != "Coaching" {
+		t.Errorf("expected the topics to survive encoding, got %+v %v", again, err)
+	}
+
+	if _, err := infrastructure.ReadTopics(`{"topics":[{"title":"","explanation":""}]}`); err == nil {
+		t.Error("expected a response without a valid topic to be an error")
+	}
+}
+
+func TestSalvageFallsBackToLegacyFormat(t *testing.T) {
+	// a fresh reply still in the line based format after the repairs
+	salvaged, err := infrastructure.SalvageQuestions("1, Who is Abdi?\nA, A peer coach\nB, A friend\nC, A stranger\nD, A coach\nA\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if questions, err := infrastructure.ReadQuestions(salvaged); err != nil || len(questions) != 1 || questions[0].Question != "Who is Abdi?" {
+		t.Errorf("expected the legacy question stored as JSON, got %s %v", salvaged, err)
+	}
+
+	salvaged, err = infrastructure.SalvageExplanations("Question Number: 1\nCorrect Answer: B\nYour Answer: A\nCorrectness: Incorrect\nExplanation: because")
+	if answers, readErr := infrastructure.ReadExplanations(salvaged); err != nil || readErr != nil || len(answers) != 1 || answers[0].CorrectAnswer != "B" {
+		t.Errorf("expected the legacy answer stored as JSON, got %s %v", salvaged, err)
+	}
+
+	// the valid items of JSON come first
+	salvaged, err = infrastructure.SalvageQuestions(`{"questions":[{"type":"true_false","question":"Abdi is a coach","answer":"True"},{"type":"essay","question":"Q","answer":"A"}]}`)
+	if questions, _ := infrastructure.ReadQuestions(salvaged); err != nil || len(questions) != 1 {
+		t.Errorf("expected the valid question, got %s %v", salvaged, err)
+	}
+
+	for _, raw := range []string{`{"questions":[{"question":"Q"`, "I can't make a quiz of this"} {
+		if _, err := infrastructure.SalvageQuestions(raw); err == nil {
+			t.Errorf("expected nothing to be salvaged from %q", raw)
+		}
+	}
+	if _, err := infrastructure.SalvageTopics(`{"topics":[{"title":"","explanation":""}]}`); err == nil {
+		t.Error("expected a response without a valid topic to be an error")
+	}
+}
@@ -0,0 +1,164 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"github/chera/fix-it/domain"
+	"github/chera/fix-it/infrastructure"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func uploadErrorCode(err error) string {
+	var refused *infrastructure.UploadError
+	if errors.As(err, &refused) {
+		return refused.Code
+	}
+	return ""
+}
+
+func TestValidateUpload(t *testing.T) {
+	limits := infrastructure.UploadLimits{MaxBytes: 1 << 20, MaxPages: 2}
+	document := buildPDF(textLine(72, 700, "one"), textLine(72, 700, "two"))
+
+	mediaType, err := infrastructure.ValidateUpload(document, "notes.pdf", limits)
+	if err != nil || mediaType != domain.MediaTypePDF {
+		t.Fatalf("expected a valid pdf, got %q %v", mediaType, err)
+	}
+
+	// a password protected file, whose /U entry doesn't match the empty password
+	encrypted := bytes.Replace(document, []byte("/Root 1 0 R >>"), []byte("/Root 1 0 R /Encrypt << /Filter /Standard /V 1 /R 2 /O <"+strings.Repeat("11", 32)+"> /U <"+strings.Repeat("22", 32)+"> /P -4 >> /ID [<"+strings.Repeat("33", 16)+"> <"+strings.Repeat("33", 16)+">] >>"), 1)
+
+	cases := []struct {
+		name     string
+		content  []byte
+		filename string
+		limits   infrastructure.UploadLimits
+		code     string
+	}{
+		{"too large", document, "notes.pdf", infrastructure.UploadLimits{MaxBytes: 100}, infrastructure.UploadTooLarge},
+		{"unknown", []byte{0x7f, 'E', 'L', 'F', 0, 1, 2}, "notes.pdf", limits, infrastructure.UploadUnsupportedType},
+		{"mismatch", document, "notes.docx", limits, infrastructure.UploadTypeMismatch},
+		{"encrypted", encrypted, "notes.pdf", limits, infrastructure.UploadEncrypted},
+		{"corrupt", []byte("%PDF-1.4\nnot really a pdf"), "notes.pdf", limits, infrastructure.UploadCorrupt},
+		{"too many pages", buildPDF("", "", ""), "notes.pdf", limits, infrastructure.UploadTooManyPages},
+	}
+
+	for _, c := range cases {
+		_, err := infrastructure.ValidateUpload(c.content, c.filename, c.limits)
+		if code := uploadErrorCode(err); code != c.code {
+			t.Errorf("%s: expected %s, got %v", c.name, c.code, err)
+		}
+	}
+
+	// each entry is under the limit of one, together they are too much
+	bomb := map[string]string{"word/document.xml": testDOCX["word/document.xml"]}
+	for _, name := range []string{"word/media/one.xml", "word/media/two.xml", "word/media/three.xml"} {
+		bomb[name] = strings.Repeat("0", 25<<20)
+	}
+	inflating := zipFile(t, bomb)
+
+	if _, err := infrastructure.ValidateUpload(inflating, "notes.docx", limits); uploadErrorCode(err) != infrastructure.UploadTooLarge {
+		t.Errorf("expected a file unpacking to 75 MB to be too large, got %v", err)
+	}
+	if _, err := infrastructure.NewExtractorRegistry(nil).Extract(context.Background(), bytes.NewReader(inflating), "notes.docx"); err == nil {
+		t.Error("expected a file unpacking to 75 MB not to be extracted")
+	}
+
+	// text formats may be named after one another
+	if _, err := infrastructure.ValidateUpload([]byte("# Notes\n"), "notes.txt", limits); err != nil {
+		t.Errorf("expected markdown in a .txt file to pass, got %v", err)
+	}
+}
+
+// fakeClamd answers INSTREAM commands, reporting streams that contain
+// "EICAR" as infected.
+func fakeClamd(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+
+				command, err := reader.ReadString(0)
+				if err != nil || command != "zINSTREAM\x00" {
+					conn.Write([]byte("UNKNOWN COMMAND\x00"))
+					return
+				}
+
+				var stream bytes.Buffer
+				for {
+					var size uint32
+					if binary.Read(reader, binary.BigEndian, &size) != nil {
+						return
+					}
+					if size == 0 {
+						break
+					}
+					io.CopyN(&stream, reader, int64(size))
+				}
+
+				if bytes.Contains(stream.Bytes(), []byte("EICAR")) {
+					conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+				} else {
+					conn.Write([]byte("stream: OK\x00"))
+				}
+			}()
+		}
+	}()
+
+	return "tcp://" + listener.Addr().String()
+}
+
+func TestClamdScanner(t *testing.T) {
+	scanner := infrastructure.NewClamdScanner(fakeClamd(t))
+
+	// larger than one chunk so the stream is split
+	clean := bytes.Repeat([]byte("lecture notes "), 10000)
+	result, err := scanner.Scan(context.Background(), clean)
+	if err != nil || !result.Clean {
+		t.Fatalf("expected a clean file, got %+v %v", result, err)
+	}
+
+	result, err = scanner.Scan(context.Background(), append(clean, "EICAR"...))
+	if err != nil || result.Clean || result.Signature != "Eicar-Test-Signature" {
+		t.Fatalf("expected the file to be infected, got %+v %v", result, err)
+	}
+
+	if _, err := infrastructure.NewClamdScanner("tcp://127.0.0.1:1").Scan(context.Background(), clean); err == nil {
+		t.Error("expected an error when clamd can't be reached")
+	}
+}
+
+func TestNewScanner(t *testing.T) {
+	t.Setenv("MALWARE_SCANNER", "")
+	if scanner, err := infrastructure.NewScanner(); scanner != nil || err != nil {
+		t.Errorf("expected scanning to be off by default, got %v %v", scanner, err)
+	}
+
+	t.Setenv("MALWARE_SCANNER", "clamd")
+	if scanner, err := infrastructure.NewScanner(); scanner == nil || err != nil {
+		t.Errorf("expected a clamd scanner, got %v %v", scanner, err)
+	}
+
+	t.Setenv("MALWARE_SCANNER", "sophos")
+	if _, err := infrastructure.NewScanner(); err == nil {
+		t.Error("expected an unknown scanner to be an error")
+	}
+}
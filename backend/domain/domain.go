@@ -13,13 +13,112 @@ type User struct {
 	Email    string             `bson:"email" json:"email"`
 	Age      int                `bson:"age" json:"age"`
 	Academic string             `bson:"academic" json:"academic"`
+	MFA      *MFA               `bson:"mfa,omitempty" json:"-"`
+	// accounts of OpenID Connect providers the user logs in with
+	Identities []Identity `bson:"identities,omitempty" json:"-"`
 }
 
-type PDF struct {
-	ID      primitive.ObjectID `bson:"_id,omitempty"`
-	Title   string             `bson:"title"`
-	DropBox string             `bson:"dropbox"`
-	Created string             `bson:"created"`
+// Identity is an account of an OpenID Connect provider, the subject is its
+// id there.
+type Identity struct {
+	Issuer  string `bson:"issuer"`
+	Subject string `bson:"subject"`
+}
+
+// OIDCLogin is a login sent to the OpenID Connect provider, waiting for
+// the user to come back with a code. It is found by the hash of its state.
+type OIDCLogin struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	Hash      string             `bson:"hash"`
+	Nonce     string             `bson:"nonce"`
+	Verifier  string             `bson:"verifier"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+// OIDCSignup is a provider account without a user yet, waiting for the
+// profile the provider doesn't know about to be completed.
+type OIDCSignup struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	Hash      string             `bson:"hash"`
+	Identity  Identity           `bson:"identity"`
+	Email     string             `bson:"email"`
+	Username  string             `bson:"username"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+// MFA is the two-factor authentication of an account. The secret waits in
+// PendingSecret until a code of it is confirmed.
+type MFA struct {
+	Enabled       bool   `bson:"enabled"`
+	Secret        string `bson:"secret,omitempty"`
+	PendingSecret string `bson:"pending_secret,omitempty"`
+	// hashes of the recovery codes not used yet
+	RecoveryCodes []string `bson:"recovery_codes,omitempty"`
+	// the time step of the last code used, older codes are refused
+	LastStep int64 `bson:"last_step"`
+}
+
+// MFAChallenge is the second step of a login to an account with MFA, the
+// password was right and a code is expected.
+type MFAChallenge struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    string             `bson:"user_id"`
+	Hash      string             `bson:"hash"`
+	Attempts  int                `bson:"attempts"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+// LoginFailures counts the failed logins of an account or of an address,
+// Key is like "account:<user id>" or "ip:<address>".
+type LoginFailures struct {
+	Key         string    `bson:"_id"`
+	Failures    int       `bson:"failures"`
+	LockedUntil time.Time `bson:"locked_until"`
+	ExpiresAt   time.Time `bson:"expires_at"`
+}
+
+// Media types of the documents that can be uploaded.
+const (
+	MediaTypePDF      = "application/pdf"
+	MediaTypeDOCX     = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	MediaTypePPTX     = "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+	MediaTypeEPUB     = "application/epub+zip"
+	MediaTypeMarkdown = "text/markdown"
+	MediaTypeHTML     = "text/html"
+	MediaTypeText     = "text/plain"
+)
+
+// Document is an uploaded file. Documents were PDFs only at first, which is
+// why they are kept in the pdf collection and referenced as pdf_id.
+type Document struct {
+	ID    primitive.ObjectID `bson:"_id,omitempty"`
+	Title string             `bson:"title"`
+	// key of the original file in blob storage
+	DropBox string `bson:"dropbox"`
+	// empty for documents uploaded before other formats, which are PDFs
+	MediaType string `bson:"media_type,omitempty"`
+	Size      int64  `bson:"size,omitempty"`
+	// sha256 of the file, uploads of the same file are matched on it
+	Hash      string    `bson:"hash,omitempty"`
+	PageCount int       `bson:"page_count,omitempty"`
+	Chapters  []Chapter `bson:"chapters,omitempty"`
+	CreatedBy string    `bson:"created_by,omitempty"`
+	Created   string    `bson:"created"`
+}
+
+// Chapter is a part of a document found in its outline or its headings.
+type Chapter struct {
+	Title     string `bson:"title" json:"title"`
+	FirstPage int    `bson:"first_page" json:"first_page"`
+	LastPage  int    `bson:"last_page" json:"last_page"`
+}
+
+// PageSelection narrows a section down to part of its document. Pages are
+// ranges like "3-10,12" and Chapters are 1-based positions in the chapters
+// of the document. An empty selection is the whole document.
+type PageSelection struct {
+	Pages    string `bson:"pages,omitempty" json:"pages,omitempty"`
+	Chapters []int  `bson:"chapters,omitempty" json:"chapters,omitempty"`
 }
 
 type Section struct {
@@ -30,6 +129,12 @@ type Section struct {
 	ExplanationsID string             `bson:"explanations_id"`
 	AnswersID      string             `bson:"answers_id"`
 	CreatedBy      string             `bson:"created_by"`
+	JobID          string             `bson:"job_id,omitempty"`
+	ParentID       string             `bson:"parent_id,omitempty"`
+	RootID         string             `bson:"root_id,omitempty"`
+	Round          int                `bson:"round,omitempty"`
+	// pages of the document the quiz is on, empty for the whole document
+	Pages string `bson:"pages,omitempty"`
 }
 
 type Verification struct {
@@ -39,47 +144,166 @@ type Verification struct {
 	ExpiresAt time.Time          `bson:"expires_at"`
 }
 
+// RefreshToken is a refresh token handed out at login, of which only the
+// hash is stored. Every refresh replaces it with a new token of the same
+// family, so a replaced token being used again means it was stolen and
+// the whole family is revoked.
+type RefreshToken struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty"`
+	UserID string             `bson:"user_id"`
+	Family string             `bson:"family"`
+	Hash   string             `bson:"hash"`
+	// the access token issued along with it, denied when the family is revoked
+	AccessID        string     `bson:"access_id"`
+	AccessExpiresAt time.Time  `bson:"access_expires_at"`
+	ExpiresAt       time.Time  `bson:"expires_at"`
+	RevokedAt       *time.Time `bson:"revoked_at,omitempty"`
+	CreatedAt       time.Time  `bson:"created_at"`
+}
+
+// PasswordReset is a pending password reset. Like refresh tokens only the
+// hash of the emailed token is stored, and it can be used once.
+type PasswordReset struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    string             `bson:"user_id"`
+	Hash      string             `bson:"hash"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+	UsedAt    *time.Time         `bson:"used_at,omitempty"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+const (
+	EmailPending = "pending"
+	EmailSending = "sending"
+	EmailSent    = "sent"
+	EmailFailed  = "failed"
+)
+
+// Email is a message waiting in the outbox, or sent from it. Sending is
+// retried with backoff, so a mail server being down doesn't fail whatever
+// queued the email.
+type Email struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	To          string             `bson:"to"`
+	Subject     string             `bson:"subject"`
+	HTML        string             `bson:"html"`
+	Text        string             `bson:"text"`
+	Status      string             `bson:"status"`
+	Attempts    int                `bson:"attempts"`
+	LastError   string             `bson:"last_error,omitempty"`
+	NextRunAt   time.Time          `bson:"next_run_at"`
+	LockedUntil time.Time          `bson:"locked_until,omitempty"`
+	CreatedAt   time.Time          `bson:"created_at"`
+	SentAt      *time.Time         `bson:"sent_at,omitempty"`
+}
+
+// TokenPair is what a login or refresh returns.
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	// seconds until the access token expires
+	ExpiresIn int `json:"expires_in"`
+}
+
 type ConversationTurn struct {
 	User   string `bson:"user"`
 	Gemini string `bson:"gemini"`
+	// passages of the document the response was grounded in
+	Sources []Citation `bson:"sources,omitempty"`
 }
 
 type Conversation struct {
 	ID    primitive.ObjectID `bson:"_id,omitempty"`
 	Turns []ConversationTurn `bson:"conversation"`
+	// tutor chat is kept apart from Turns, whose positions are fixed
+	Chat           []ChatTurn `bson:"chat,omitempty"`
+	ChatSummary    string     `bson:"chat_summary,omitempty"`
+	ChatSummarized int        `bson:"chat_summarized,omitempty"`
 }
 
+type ChatTurn struct {
+	User      string     `bson:"user" json:"user"`
+	Tutor     string     `bson:"tutor" json:"tutor"`
+	Sources   []Citation `bson:"sources,omitempty" json:"sources,omitempty"`
+	CreatedAt time.Time  `bson:"created_at" json:"created_at"`
+}
+
+const (
+	QuestionSingleChoice = "single_choice"
+	QuestionMultiSelect  = "multi_select"
+	QuestionTrueFalse    = "true_false"
+	QuestionFillBlank    = "fill_in_the_blank"
+	QuestionShortAnswer  = "short_answer"
+)
+
+const (
+	DifficultyEasy   = "easy"
+	DifficultyMedium = "medium"
+	DifficultyHard   = "hard"
+)
+
+// Question is a single quiz question. Choice questions use the A to D
+// alternatives and store the correct letters in Answer ("A,C" for multi
+// select); fill in the blank and short answer questions store the expected
+// text in Answer with optional alternatives and grading keywords.
 type Question struct {
-	Question string `bson:"question"`
-	A        string `bson:"a"`
-	B        string `bson:"b"`
-	C        string `bson:"c"`
-	D        string `bson:"d"`
-	Answer   string `bson:"answer"`
+	Type            string   `bson:"type,omitempty"`
+	Question        string   `bson:"question"`
+	A               string   `bson:"a"`
+	B               string   `bson:"b"`
+	C               string   `bson:"c"`
+	D               string   `bson:"d"`
+	Answer          string   `bson:"answer"`
+	AcceptedAnswers []string `bson:"accepted_answers,omitempty"`
+	Keywords        []string `bson:"keywords,omitempty"`
+	SourceChunk     int      `bson:"source_chunk"`
+	// where in the document the question comes from, nil when the model's
+	// citation could not be found in the extracted text
+	Source *Citation `bson:"source,omitempty"`
+}
+
+type QuizOptions struct {
+	QuestionCount int      `bson:"question_count" json:"question_count"`
+	Difficulty    string   `bson:"difficulty" json:"difficulty"`
+	QuestionTypes []string `bson:"question_types" json:"question_types"`
 }
 
 type Quiz struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty"`
 	Taken     bool               `bson:"taken"`
 	Questions []Question         `bson:"questions"`
+	Options   QuizOptions        `bson:"options"`
 	CreatedBy string             `bson:"created_by"`
 }
 
+type QuestionResult struct {
+	QuestionNO int  `json:"question_no" bson:"question_no"`
+	Correct    bool `json:"correct" bson:"correct"`
+}
+
+type QuizGrade struct {
+	Score   int              `json:"score" bson:"score"`
+	Total   int              `json:"total" bson:"total"`
+	Results []QuestionResult `json:"results" bson:"results"`
+}
+
 type Answer struct {
 	QuestionNO int    `json:"question_no" bson:"question_no"`
 	Answer     string `json:"answer" bson:"answer"`
 }
 
 type AnswerList struct {
-	Answers []Answer `json:"answers" bson:"answers"`
+	Answers         []Answer `json:"answers" bson:"answers"`
+	DurationSeconds int      `json:"duration_seconds" bson:"duration_seconds,omitempty"`
 }
 
 type QeustionAnswer struct {
-	QuestionNumber int    `json:"question_number" bson:"question_number"`
-	CorrectAnswer  string `json:"correct_answer" bson:"correct_answer"`
-	YourAnswer     string `json:"your_answer" bson:"your_answer"`
-	Explanation    string `json:"explanation" bson:"explanation"`
-	Correctness    bool   `json:"correctness" bson:"correctness"`
+	QuestionNumber int        `json:"question_number" bson:"question_number"`
+	CorrectAnswer  string     `json:"correct_answer" bson:"correct_answer"`
+	YourAnswer     string     `json:"your_answer" bson:"your_answer"`
+	Explanation    string     `json:"explanation" bson:"explanation"`
+	Correctness    bool       `json:"correctness" bson:"correctness"`
+	Sources        []Citation `json:"sources,omitempty" bson:"sources,omitempty"`
 }
 
 type Topic struct {
@@ -88,5 +312,153 @@ type Topic struct {
 }
 
 type TopicList struct {
-	Topics []Topic
+	Topics  []Topic
+	Sources []Citation `json:",omitempty"`
+}
+
+type DocumentPage struct {
+	Number int    `bson:"number" json:"number"`
+	Text   string `bson:"text" json:"text"`
+}
+
+// Extraction caches the extracted text of a file by its content hash, so
+// uploading the same file again skips extraction.
+type Extraction struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	Hash      string             `bson:"hash"`
+	CreatedBy string             `bson:"created_by"`
+	Pages     []DocumentPage     `bson:"pages"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+const (
+	JobStageQueued     = "queued"
+	JobStageExtracting = "extracting"
+	JobStageGenerating = "generating"
+	JobStageSaving     = "saving"
+	JobStageDone       = "done"
+)
+
+const (
+	JobErrorExtraction   = "extraction_failed"
+	JobErrorGeneration   = "generation_failed"
+	JobErrorStorage      = "storage_failed"
+	JobErrorSelection    = "invalid_selection"
+	JobErrorTooManyPages = "too_many_pages"
+	JobErrorInternal     = "internal_error"
+)
+
+// JobError is the typed failure reported for an upload job. Retryable
+// errors are scheduled again with backoff, the others fail the job at once.
+type JobError struct {
+	Code      string `bson:"code" json:"code"`
+	Message   string `bson:"message" json:"message"`
+	Retryable bool   `bson:"-" json:"-"`
+}
+
+func (e *JobError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+type Job struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID   string             `bson:"user_id" json:"-"`
+	Filename string             `bson:"filename" json:"filename"`
+	File     []byte             `bson:"file,omitempty" json:"-"`
+	Options  QuizOptions        `bson:"options" json:"options"`
+	// skip the quiz and extraction of earlier uploads of the same file
+	ForceRegenerate bool `bson:"force_regenerate,omitempty" json:"force_regenerate,omitempty"`
+	// set when the section is made from a stored document instead of File
+	DocumentID  string        `bson:"document_id,omitempty" json:"document_id,omitempty"`
+	Selection   PageSelection `bson:"selection,omitempty" json:"selection"`
+	Status      string        `bson:"status" json:"status"`
+	Stage       string        `bson:"stage" json:"stage"`
+	Progress    int           `bson:"progress" json:"progress"`
+	Attempts    int           `bson:"attempts" json:"attempts"`
+	SectionID   string        `bson:"section_id,omitempty" json:"section_id,omitempty"`
+	Error       *JobError     `bson:"error,omitempty" json:"error,omitempty"`
+	NextRunAt   time.Time     `bson:"next_run_at" json:"-"`
+	LockedUntil time.Time     `bson:"locked_until" json:"-"`
+	// changes with every claim, only the worker holding the lease has it
+	ClaimToken string    `bson:"claim_token,omitempty" json:"-"`
+	CreatedAt  time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// TextChunk is a piece of a document small enough for one generation call.
+type TextChunk struct {
+	Index     int    `bson:"index" json:"index"`
+	FirstPage int    `bson:"first_page" json:"first_page"`
+	LastPage  int    `bson:"last_page" json:"last_page"`
+	Heading   string `bson:"heading,omitempty" json:"heading,omitempty"`
+	Text      string `bson:"text" json:"text"`
+}
+
+// DocumentChunk is a page-tagged passage of a stored document, kept so
+// generation can be grounded in the source text.
+type DocumentChunk struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	PDFID     string             `bson:"pdf_id"`
+	TextChunk `bson:",inline"`
+	Embedding []float64 `bson:"embedding,omitempty"`
+}
+
+// Citation points at the passage of a document a response relied on.
+type Citation struct {
+	QuestionNO int    `bson:"question_no,omitempty" json:"question_no,omitempty"`
+	FirstPage  int    `bson:"first_page" json:"first_page"`
+	LastPage   int    `bson:"last_page" json:"last_page"`
+	Snippet    string `bson:"snippet" json:"snippet"`
+}
+
+// Attempt is one submission of a quiz. The first attempt of a section is
+// the official one, later attempts are practice.
+type Attempt struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	SectionID       string             `bson:"section_id" json:"section_id"`
+	QuizID          string             `bson:"quiz_id" json:"quiz_id"`
+	UserID          string             `bson:"user_id" json:"-"`
+	Answers         []Answer           `bson:"answers" json:"answers"`
+	Results         []QuestionResult   `bson:"results" json:"results"`
+	Score           int                `bson:"score" json:"score"`
+	Total           int                `bson:"total" json:"total"`
+	DurationSeconds int                `bson:"duration_seconds" json:"duration_seconds"`
+	Official        bool               `bson:"official" json:"official"`
+	Explanation     []QeustionAnswer   `bson:"explanation,omitempty" json:"explanation,omitempty"`
+	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// ReviewItem schedules one question of a quiz for a user with SM-2.
+type ReviewItem struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID         string             `bson:"user_id" json:"-"`
+	SectionID      string             `bson:"section_id" json:"section_id"`
+	QuizID         string             `bson:"quiz_id" json:"quiz_id"`
+	QuestionNO     int                `bson:"question_no" json:"question_no"`
+	Question       Question           `bson:"question" json:"question"`
+	EaseFactor     float64            `bson:"ease_factor" json:"ease_factor"`
+	IntervalDays   int                `bson:"interval_days" json:"interval_days"`
+	Repetitions    int                `bson:"repetitions" json:"repetitions"`
+	Lapses         int                `bson:"lapses" json:"lapses"`
+	DueAt          time.Time          `bson:"due_at" json:"due_at"`
+	LastReviewedAt time.Time          `bson:"last_reviewed_at" json:"last_reviewed_at"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// RoundProgress is the official result of one round of a section and its
+// follow-up quizzes.
+type RoundProgress struct {
+	SectionID   string `json:"section_id"`
+	SectionName string `json:"section_name"`
+	Round       int    `json:"round"`
+	Taken       bool   `json:"taken"`
+	Score       int    `json:"score"`
+	Total       int    `json:"total"`
 }
@@ -1,15 +1,24 @@
 package infrastructure
 
 import (
+	"context"
 	"errors"
+	"log"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 )
 
-func AuthMiddleWare() gin.HandlerFunc {
+// TokenDenylist tells whether an access token was revoked before it
+// expired, by its jti.
+type TokenDenylist interface {
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+func AuthMiddleWare(denylist TokenDenylist) gin.HandlerFunc {
 	load_key, exist := os.LookupEnv("JWT_SECRET_KEY")
 
 	jwtKey := []byte(load_key)
@@ -24,7 +33,7 @@ func AuthMiddleWare() gin.HandlerFunc {
 		if tokenString == "" {
 			c.JSON(401, gin.H{"error": "No token found"})
 			c.Abort()
-
+			return
 		}
 
 		if strings.HasPrefix(tokenString, "Bearer") {
@@ -53,7 +62,27 @@ func AuthMiddleWare() gin.HandlerFunc {
 			return
 		}
 
+		// tokens issued before they had an id only live until they expire
+		if claims.StandardClaims.Id != "" {
+			revoked, err := denylist.IsRevoked(c, claims.StandardClaims.Id)
+
+			if err != nil {
+				log.Println(err.Error())
+				c.JSON(500, gin.H{"error": "Something Went wrong Please try again"})
+				c.Abort()
+				return
+			}
+
+			if revoked {
+				c.JSON(401, gin.H{"error": "Token has been revoked"})
+				c.Abort()
+				return
+			}
+		}
+
 		c.Set("user_id", claims.ID)
+		c.Set("token_id", claims.StandardClaims.Id)
+		c.Set("token_expires_at", time.Unix(claims.ExpiresAt, 0))
 		c.Next()
 
 	}
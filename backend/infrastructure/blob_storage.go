@@ -0,0 +1,128 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrBlobNotFound = errors.New("infrastructure/blob_storage: blob not found")
+
+// BlobStorage keeps the original files of uploads. Open returns a seekable
+// reader so files can be served with HTTP range requests.
+type BlobStorage interface {
+	Put(ctx context.Context, key string, content []byte, contentType string) error
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// NewBlobStorage builds the storage selected by BLOB_STORAGE. Supported
+// values are "local" (default), which keeps files under BLOB_DIR, and "s3",
+// which works with any S3 compatible service such as MinIO.
+func NewBlobStorage() (BlobStorage, error) {
+	backend := os.Getenv("BLOB_STORAGE")
+
+	switch backend {
+	case "", "local":
+		dir := os.Getenv("BLOB_DIR")
+		if dir == "" {
+			dir = "data/blobs"
+		}
+		return NewLocalBlobStorage(dir)
+	case "s3":
+		config := S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+		}
+		if config.Endpoint == "" || config.Bucket == "" {
+			return nil, errors.New("infrastructure/blob_storage: S3_ENDPOINT and S3_BUCKET are required")
+		}
+		return NewS3BlobStorage(config), nil
+	}
+
+	return nil, errors.New("infrastructure/blob_storage: unknown BLOB_STORAGE " + backend)
+}
+
+type localBlobStorage struct {
+	dir string
+}
+
+func NewLocalBlobStorage(dir string) (BlobStorage, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, errors.New("infrastructure/blob_storage: " + err.Error())
+	}
+	return &localBlobStorage{dir: dir}, nil
+}
+
+// path keeps keys inside the storage directory.
+func (s *localBlobStorage) path(key string) (string, error) {
+	if key == "" || key == "." || key == ".." || strings.ContainsAny(key, `/\`) {
+		return "", errors.New("infrastructure/blob_storage: invalid key " + key)
+	}
+	return filepath.Join(s.dir, key), nil
+}
+
+func (s *localBlobStorage) Put(ctx context.Context, key string, content []byte, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	// write next to the target first so a reader never sees half a file
+	tmp, err := os.CreateTemp(s.dir, ".upload-*")
+	if err != nil {
+		return errors.New("infrastructure/blob_storage: " + err.Error())
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return errors.New("infrastructure/blob_storage: " + err.Error())
+	}
+
+	if err := tmp.Close(); err != nil {
+		return errors.New("infrastructure/blob_storage: " + err.Error())
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errors.New("infrastructure/blob_storage: " + err.Error())
+	}
+
+	return nil
+}
+
+func (s *localBlobStorage) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, errors.New("infrastructure/blob_storage: " + err.Error())
+	}
+
+	return file, nil
+}
+
+func (s *localBlobStorage) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errors.New("infrastructure/blob_storage: " + err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package infrastructure
+
+import (
+	"fmt"
+	"github/chera/fix-it/domain"
+	"strings"
+)
+
+// ChatOverflow returns how many of the oldest turns have to leave the window
+// so that the rest fits in maxTurns turns and budget tokens. The newest turn
+// is always kept.
+func ChatOverflow(turns []domain.ChatTurn, maxTurns, budget int) int {
+	kept := 0
+	tokens := 0
+
+	for i := len(turns) - 1; i >= 0; i-- {
+		tokens += EstimateTokens(turns[i].User) + EstimateTokens(turns[i].Tutor)
+		if kept > 0 && (kept >= maxTurns || tokens > budget) {
+			break
+		}
+		kept++
+	}
+
+	return len(turns) - kept
+}
+
+// ChatHistory builds the model history for the next chat message: the quiz
+// turns of the section, the summary of older chat, then the chat turns that
+// are still in the window.
+func ChatHistory(conversation domain.Conversation, skip int) []domain.ConversationTurn {
+	history := append([]domain.ConversationTurn{}, conversation.Turns...)
+
+	if conversation.ChatSummary != "" {
+		history = append(history, domain.ConversationTurn{
+			User:   "Here is a summary of what we discussed so far:\n" + conversation.ChatSummary,
+			Gemini: "Thanks, I will keep that in mind.",
+		})
+	}
+
+	start := conversation.ChatSummarized + skip
+	if start > len(conversation.Chat) {
+		start = len(conversation.Chat)
+	}
+
+	for _, turn := range conversation.Chat[start:] {
+		history = append(history, domain.ConversationTurn{User: turn.User, Gemini: turn.Tutor})
+	}
+
+	return history
+}
+
+// ChatSummaryPrompt asks for the previous summary to be extended with turns.
+func ChatSummaryPrompt(previous string, turns []domain.ChatTurn) string {
+	var builder strings.Builder
+
+	builder.WriteString("Summarize the following tutoring conversation in a short paragraph. ")
+	builder.WriteString("Keep the questions the student asked, what they struggled with and what was explained. ")
+	builder.WriteString("Respond with the summary only.\n\n")
+
+	if previous != "" {
+		builder.WriteString("Summary of the earlier conversation:\n" + previous + "\n\n")
+	}
+
+	for _, turn := range turns {
+		fmt.Fprintf(&builder, "Student: %s\nTutor: %s\n", turn.User, turn.Tutor)
+	}
+
+	return builder.String()
+}
+
+// ChatPrompt wraps a student message with the tutor instructions.
+func ChatPrompt(message string) string {
+	return fmt.Sprintf(`You are a patient tutor helping me study the text and quiz above.
+	Answer my question using the text where you can and say so when the text does not cover it.
+	Dont any text decorations like bold, italic, underline, etc.
+
+	My question: %s`, message)
+}
@@ -0,0 +1,268 @@
+package infrastructure
+
+import (
+	"github/chera/fix-it/domain"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// GenerationConfig controls how large documents are split for generation.
+type GenerationConfig struct {
+	ChunkTokenBudget int
+	Concurrency      int
+	QuestionCount    int
+	ChatWindowTurns  int
+	ChatTokenBudget  int
+	// size of the passages kept for retrieval
+	RetrievalChunkBudget int
+	// reuse the extracted text of a file uploaded by any user, not only
+	// the uploader's own
+	ShareExtractions bool
+}
+
+// LoadGenerationConfig reads CHUNK_TOKEN_BUDGET, GENERATION_CONCURRENCY,
+// QUESTION_COUNT, CHAT_WINDOW_TURNS, CHAT_TOKEN_BUDGET,
+// RETRIEVAL_CHUNK_TOKENS and SHARE_EXTRACTIONS, falling back to defaults
+// for missing or invalid values.
+func LoadGenerationConfig() GenerationConfig {
+	return GenerationConfig{
+		ChunkTokenBudget:     envInt("CHUNK_TOKEN_BUDGET", 6000),
+		Concurrency:          envInt("GENERATION_CONCURRENCY", 3),
+		QuestionCount:        envInt("QUESTION_COUNT", 10),
+		ChatWindowTurns:      envInt("CHAT_WINDOW_TURNS", 8),
+		ChatTokenBudget:      envInt("CHAT_TOKEN_BUDGET", 3000),
+		RetrievalChunkBudget: envInt("RETRIEVAL_CHUNK_TOKENS", 400),
+		ShareExtractions:     os.Getenv("SHARE_EXTRACTIONS") == "true",
+	}
+}
+
+func envInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value < 1 {
+		return fallback
+	}
+	return value
+}
+
+// EstimateTokens approximates the token count of text, using the usual rule
+// of thumb of four characters per token.
+func EstimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+type chunkUnit struct {
+	page    int
+	heading bool
+	text    string
+}
+
+var headingPattern = regexp.MustCompile(`^((chapter|section|part|unit|lesson)\s+\w+|\d+(\.\d+)*\.?\s+\p{L})`)
+
+// isHeading guesses whether a line is a heading: short, not ending like a
+// sentence, and either numbered, introduced by a keyword or in capitals.
+func isHeading(line string) bool {
+	if len(line) == 0 || len(line) > 80 || strings.HasSuffix(line, ".") || strings.HasSuffix(line, ",") {
+		return false
+	}
+
+	if headingPattern.MatchString(strings.ToLower(line)) {
+		return true
+	}
+
+	letters, upper := 0, 0
+	for _, r := range line {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+
+	return letters >= 4 && upper == letters
+}
+
+// ChunkPages splits a document into chunks of at most budget tokens. Page
+// and heading boundaries are preferred over cutting inside a paragraph.
+func ChunkPages(pages []domain.DocumentPage, budget int) []domain.TextChunk {
+	var units []chunkUnit
+
+	for _, page := range pages {
+		var paragraph []string
+
+		flush := func() {
+			if len(paragraph) > 0 {
+				units = append(units, chunkUnit{page: page.Number, text: strings.Join(paragraph, "\n")})
+				paragraph = nil
+			}
+		}
+
+		for _, line := range strings.Split(page.Text, "\n") {
+			line = strings.TrimSpace(line)
+
+			switch {
+			case line == "":
+				flush()
+			case isHeading(line):
+				flush()
+				units = append(units, chunkUnit{page: page.Number, heading: true, text: line})
+			default:
+				paragraph = append(paragraph, line)
+			}
+		}
+		flush()
+	}
+
+	var chunks []domain.TextChunk
+	var current *domain.TextChunk
+	tokens := 0
+
+	closeChunk := func() {
+		if current != nil {
+			current.Text = strings.TrimSpace(current.Text)
+			chunks = append(chunks, *current)
+			current = nil
+			tokens = 0
+		}
+	}
+
+	for _, unit := range units {
+		for _, piece := range splitToBudget(unit.text, budget) {
+			size := EstimateTokens(piece) + 1
+
+			if current != nil && (tokens+size > budget || (unit.heading && tokens >= budget/2)) {
+				closeChunk()
+			}
+
+			if current == nil {
+				current = &domain.TextChunk{Index: len(chunks), FirstPage: unit.page}
+				if unit.heading {
+					current.Heading = unit.text
+				}
+			}
+
+			current.LastPage = unit.page
+			current.Text += piece + "\n\n"
+			tokens += size
+		}
+	}
+	closeChunk()
+
+	return chunks
+}
+
+// splitToBudget cuts a paragraph that is larger than the budget on word
+// boundaries.
+func splitToBudget(text string, budget int) []string {
+	if EstimateTokens(text) <= budget {
+		return []string{text}
+	}
+
+	var pieces []string
+	var builder strings.Builder
+
+	for _, word := range strings.Fields(text) {
+		if builder.Len() > 0 && EstimateTokens(builder.String())+EstimateTokens(word)+1 > budget {
+			pieces = append(pieces, builder.String())
+			builder.Reset()
+		}
+		if builder.Len() > 0 {
+			builder.WriteString(" ")
+		}
+		builder.WriteString(word)
+	}
+
+	if builder.Len() > 0 {
+		pieces = append(pieces, builder.String())
+	}
+
+	return pieces
+}
+
+// MergeQuestions combines the questions generated for each chunk, drops
+// near duplicates and picks up to limit questions round robin so every
+// chunk stays represented.
+func MergeQuestions(perChunk [][]domain.Question, limit int) []domain.Question {
+	var kept [][]domain.Question
+	var seen [][]string
+
+	for _, questions := range perChunk {
+		var unique []domain.Question
+
+		for _, question := range questions {
+			words := questionWords(question.Question)
+			if len(words) == 0 {
+				continue
+			}
+
+			duplicate := false
+			for _, other := range seen {
+				if jaccard(words, other) >= 0.8 {
+					duplicate = true
+					break
+				}
+			}
+
+			if !duplicate {
+				seen = append(seen, words)
+				unique = append(unique, question)
+			}
+		}
+
+		kept = append(kept, unique)
+	}
+
+	var merged []domain.Question
+	for round := 0; len(merged) < limit; round++ {
+		added := false
+		for _, questions := range kept {
+			if round < len(questions) && len(merged) < limit {
+				merged = append(merged, questions[round])
+				added = true
+			}
+		}
+		if !added {
+			break
+		}
+	}
+
+	return merged
+}
+
+func questionWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func jaccard(a, b []string) float64 {
+	set := map[string]bool{}
+	for _, word := range a {
+		set[word] = true
+	}
+
+	union := len(set)
+	intersection := 0
+	counted := map[string]bool{}
+
+	for _, word := range b {
+		if counted[word] {
+			continue
+		}
+		counted[word] = true
+
+		if set[word] {
+			intersection++
+		} else {
+			union++
+		}
+	}
+
+	if union == 0 {
+		return 0
+	}
+	return float64(intersection) / float64(union)
+}
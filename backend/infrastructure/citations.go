@@ -0,0 +1,117 @@
+package infrastructure
+
+import (
+	"fmt"
+	"github/chera/fix-it/domain"
+	"strings"
+	"unicode"
+)
+
+const (
+	// shorter quotes match too much of any document to prove anything
+	minQuoteWords  = 4
+	maxQuoteLength = 400
+)
+
+// MarkPages prefixes the text of every page with a [page N] marker so the
+// model can cite the pages its questions come from.
+func MarkPages(pages []domain.DocumentPage) []domain.DocumentPage {
+	marked := make([]domain.DocumentPage, len(pages))
+	for i, page := range pages {
+		marked[i] = domain.DocumentPage{Number: page.Number, Text: fmt.Sprintf("[page %d]\n%s", page.Number, page.Text)}
+	}
+	return marked
+}
+
+// PageChunks turns pages into one passage each, the finest grain a
+// citation can be checked at.
+func PageChunks(pages []domain.DocumentPage) []domain.TextChunk {
+	chunks := make([]domain.TextChunk, len(pages))
+	for i, page := range pages {
+		chunks[i] = domain.TextChunk{Index: i, FirstPage: page.Number, LastPage: page.Number, Text: page.Text}
+	}
+	return chunks
+}
+
+// VerifyCitations checks the quote of every question against the document.
+// A quote found away from the cited pages has its pages corrected, and a
+// citation whose quote is nowhere in the document is removed. It returns
+// how many citations were removed.
+func VerifyCitations(questions []domain.Question, document []domain.TextChunk) int {
+	normalized := make([]string, len(document))
+	for i, chunk := range document {
+		normalized[i] = normalizeQuote(chunk.Text)
+	}
+
+	// a quote running over a page break is only in two passages together
+	joined := make([]string, max(len(document)-1, 0))
+	for i := range joined {
+		joined[i] = normalizeQuote(document[i].Text + "\n" + document[i+1].Text)
+	}
+
+	rejected := 0
+
+	for i := range questions {
+		source := questions[i].Source
+		if source == nil {
+			continue
+		}
+
+		quote := normalizeQuote(source.Snippet)
+		if len(source.Snippet) > maxQuoteLength || len(strings.Fields(quote)) < minQuoteWords {
+			questions[i].Source = nil
+			rejected++
+			continue
+		}
+
+		near := func(firstPage, lastPage int) bool {
+			return lastPage >= source.FirstPage-1 && firstPage <= source.LastPage+1
+		}
+
+		found := false
+		var firstPage, lastPage int
+		for j, chunk := range document {
+			if !strings.Contains(normalized[j], quote) {
+				continue
+			}
+			// prefer the passage the model cited or one next to it
+			if !found || near(chunk.FirstPage, chunk.LastPage) {
+				found, firstPage, lastPage = true, chunk.FirstPage, chunk.LastPage
+			}
+		}
+
+		if !found {
+			for j := range joined {
+				if !strings.Contains(joined[j], quote) {
+					continue
+				}
+				if !found || near(document[j].FirstPage, document[j+1].LastPage) {
+					found, firstPage, lastPage = true, document[j].FirstPage, document[j+1].LastPage
+				}
+			}
+		}
+
+		if !found {
+			questions[i].Source = nil
+			rejected++
+			continue
+		}
+
+		if source.FirstPage <= 0 || !near(firstPage, lastPage) {
+			source.FirstPage = firstPage
+			source.LastPage = lastPage
+		}
+	}
+
+	return rejected
+}
+
+// normalizeQuote reduces text to lower case words so line breaks, spacing,
+// punctuation and hyphenation of the extraction don't matter.
+func normalizeQuote(text string) string {
+	text = strings.ReplaceAll(text, "-\n", "")
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return " " + strings.Join(words, " ") + " "
+}
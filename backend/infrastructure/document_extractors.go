@@ -0,0 +1,495 @@
+package infrastructure
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github/chera/fix-it/domain"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// an office file is a zip, don't let a small one inflate without bound,
+// neither in one entry nor in all of them
+const (
+	maxZipEntrySize = 32 << 20
+	maxZipTotalSize = 4 * maxInlineUpload
+)
+
+var errZipTooLarge = &UploadError{Code: UploadTooLarge, Message: fmt.Sprintf("the file unpacks to more than %d MB", maxZipTotalSize>>20)}
+
+var errNoText = errors.New("no text found in document")
+
+// ExtractorRegistry picks the extractor of an upload by its format, so every
+// format feeds the same question generation pipeline.
+type ExtractorRegistry struct {
+	extractors map[string]TextExtractor
+}
+
+// NewExtractorRegistry registers the local extractors of every supported
+// format, with pdfExtractor handling PDFs.
+func NewExtractorRegistry(pdfExtractor TextExtractor) *ExtractorRegistry {
+	registry := &ExtractorRegistry{extractors: map[string]TextExtractor{}}
+
+	registry.Register(domain.MediaTypePDF, pdfExtractor)
+	registry.Register(domain.MediaTypeDOCX, extractorFunc(extractDOCX))
+	registry.Register(domain.MediaTypePPTX, extractorFunc(extractPPTX))
+	registry.Register(domain.MediaTypeEPUB, extractorFunc(extractEPUB))
+	registry.Register(domain.MediaTypeMarkdown, extractorFunc(extractMarkdown))
+	registry.Register(domain.MediaTypeHTML, extractorFunc(extractHTML))
+	registry.Register(domain.MediaTypeText, extractorFunc(extractPlainText))
+
+	return registry
+}
+
+func (r *ExtractorRegistry) Register(mediaType string, extractor TextExtractor) {
+	r.extractors[mediaType] = extractor
+}
+
+func (r *ExtractorRegistry) Extract(ctx context.Context, file io.Reader, filename string) ([]domain.DocumentPage, error) {
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %w", err)
+	}
+
+	mediaType, err := DetectMediaType(content, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	extractor, ok := r.extractors[mediaType]
+	if !ok {
+		return nil, ErrUnsupportedFormat
+	}
+
+	return extractor.Extract(ctx, bytes.NewReader(content), filename)
+}
+
+// extractorFunc adapts the local extractors, which work on the whole file.
+type extractorFunc func(content []byte) ([]string, error)
+
+func (f extractorFunc) Extract(ctx context.Context, file io.Reader, filename string) ([]domain.DocumentPage, error) {
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %w", err)
+	}
+
+	texts, err := f(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var pages []domain.DocumentPage
+	empty := true
+
+	for i, text := range texts {
+		text = normalizeExtractedText(text)
+		if text != "" {
+			empty = false
+		}
+		pages = append(pages, domain.DocumentPage{Number: i + 1, Text: text})
+	}
+
+	if empty {
+		return nil, errNoText
+	}
+
+	return pages, nil
+}
+
+func openZip(content []byte) (map[string]*zip.File, error) {
+	archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, fmt.Errorf("error opening archive: %w", err)
+	}
+
+	// the reader refuses an entry inflating past its size, so the sizes
+	// are what reading the whole archive can cost
+	files := map[string]*zip.File{}
+	var size uint64
+	for _, file := range archive.File {
+		files[file.Name] = file
+		size += file.UncompressedSize64
+	}
+
+	if size > maxZipTotalSize {
+		return nil, errZipTooLarge
+	}
+
+	return files, nil
+}
+
+func readZipFile(file *zip.File, limit int64) ([]byte, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %w", file.Name, err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", file.Name, err)
+	}
+
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%s is too large", file.Name)
+	}
+
+	return data, nil
+}
+
+// officeText collects the text runs of an office XML part, a paragraph per
+// line. Page breaks start a new entry when pageBreaks is set.
+func officeText(data []byte, pageBreaks bool) ([]string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+
+	pages := []string{}
+	var current strings.Builder
+	inText := false
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading document xml: %w", err)
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			switch element.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				current.WriteString("\t")
+			case "br", "lastRenderedPageBreak":
+				pageBreak := element.Name.Local == "lastRenderedPageBreak"
+				for _, attr := range element.Attr {
+					if attr.Name.Local == "type" && attr.Value == "page" {
+						pageBreak = true
+					}
+				}
+				if pageBreak && pageBreaks {
+					pages = append(pages, current.String())
+					current.Reset()
+				} else if element.Name.Local == "br" {
+					current.WriteString("\n")
+				}
+			}
+		case xml.EndElement:
+			switch element.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				current.WriteString("\n")
+			}
+		case xml.CharData:
+			if inText {
+				current.Write(element)
+			}
+		}
+	}
+
+	return append(pages, current.String()), nil
+}
+
+func extractDOCX(content []byte) ([]string, error) {
+	files, err := openZip(content)
+	if err != nil {
+		return nil, err
+	}
+
+	document, ok := files["word/document.xml"]
+	if !ok {
+		return nil, errors.New("docx has no word/document.xml")
+	}
+
+	data, err := readZipFile(document, maxZipEntrySize)
+	if err != nil {
+		return nil, err
+	}
+
+	return officeText(data, true)
+}
+
+var slidePattern = regexp.MustCompile(`^ppt/slides/slide(\d+)\.xml$`)
+
+// extractPPTX gives one page per slide, in slide order.
+func extractPPTX(content []byte) ([]string, error) {
+	files, err := openZip(content)
+	if err != nil {
+		return nil, err
+	}
+
+	type slide struct {
+		number int
+		file   *zip.File
+	}
+
+	var slides []slide
+	for name, file := range files {
+		if match := slidePattern.FindStringSubmatch(name); match != nil {
+			number, _ := strconv.Atoi(match[1])
+			slides = append(slides, slide{number: number, file: file})
+		}
+	}
+
+	sort.Slice(slides, func(i, j int) bool { return slides[i].number < slides[j].number })
+
+	pages := make([]string, 0, len(slides))
+	for _, slide := range slides {
+		data, err := readZipFile(slide.file, maxZipEntrySize)
+		if err != nil {
+			return nil, err
+		}
+
+		text, err := officeText(data, false)
+		if err != nil {
+			return nil, err
+		}
+
+		pages = append(pages, strings.Join(text, ""))
+	}
+
+	return pages, nil
+}
+
+type epubContainer struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+type epubPackage struct {
+	Manifest []struct {
+		ID   string `xml:"id,attr"`
+		Href string `xml:"href,attr"`
+	} `xml:"manifest>item"`
+	Spine []struct {
+		IDRef string `xml:"idref,attr"`
+	} `xml:"spine>itemref"`
+}
+
+// extractEPUB gives one page per chapter in reading order, skipping
+// chapters without text such as covers.
+func extractEPUB(content []byte) ([]string, error) {
+	files, err := openZip(content)
+	if err != nil {
+		return nil, err
+	}
+
+	containerFile, ok := files["META-INF/container.xml"]
+	if !ok {
+		return nil, errors.New("epub has no META-INF/container.xml")
+	}
+
+	data, err := readZipFile(containerFile, maxZipEntrySize)
+	if err != nil {
+		return nil, err
+	}
+
+	var container epubContainer
+	if err := xml.Unmarshal(data, &container); err != nil || len(container.Rootfiles) == 0 {
+		return nil, errors.New("epub container does not name a package")
+	}
+
+	packagePath := container.Rootfiles[0].FullPath
+	packageFile, ok := files[packagePath]
+	if !ok {
+		return nil, errors.New("epub package " + packagePath + " is missing")
+	}
+
+	data, err = readZipFile(packageFile, maxZipEntrySize)
+	if err != nil {
+		return nil, err
+	}
+
+	var opf epubPackage
+	if err := xml.Unmarshal(data, &opf); err != nil {
+		return nil, fmt.Errorf("error reading epub package: %w", err)
+	}
+
+	hrefs := map[string]string{}
+	for _, item := range opf.Manifest {
+		hrefs[item.ID] = item.Href
+	}
+
+	var pages []string
+	for _, itemref := range opf.Spine {
+		href, err := url.PathUnescape(hrefs[itemref.IDRef])
+		if err != nil || href == "" {
+			continue
+		}
+
+		chapter, ok := files[path.Join(path.Dir(packagePath), href)]
+		if !ok {
+			continue
+		}
+
+		data, err := readZipFile(chapter, maxZipEntrySize)
+		if err != nil {
+			return nil, err
+		}
+
+		node, err := html.Parse(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", chapter.Name, err)
+		}
+
+		text := strings.Join(htmlSections(node, false), "\n")
+		if strings.TrimSpace(text) != "" {
+			pages = append(pages, text)
+		}
+	}
+
+	return pages, nil
+}
+
+// extractHTML gives one page per top level (h1 or h2) section.
+func extractHTML(content []byte) ([]string, error) {
+	node, err := html.Parse(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("error reading html: %w", err)
+	}
+
+	return htmlSections(node, true), nil
+}
+
+var htmlSkipped = map[string]bool{"head": true, "script": true, "style": true, "noscript": true, "template": true, "svg": true, "nav": true}
+
+var htmlBlocks = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "tr": true, "section": true, "article": true, "aside": true,
+	"blockquote": true, "pre": true, "table": true, "ul": true, "ol": true, "hr": true, "header": true, "footer": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "dt": true, "dd": true, "figcaption": true,
+}
+
+// htmlSections renders the visible text of a page, a block per line. With
+// split set every h1 and h2 starts a new section.
+func htmlSections(root *html.Node, split bool) []string {
+	sections := []string{}
+	var current strings.Builder
+
+	var walk func(node *html.Node, pre bool)
+	walk = func(node *html.Node, pre bool) {
+		if node.Type == html.ElementNode {
+			if htmlSkipped[node.Data] {
+				return
+			}
+			if split && (node.Data == "h1" || node.Data == "h2") && strings.TrimSpace(current.String()) != "" {
+				sections = append(sections, current.String())
+				current.Reset()
+			}
+			if htmlBlocks[node.Data] {
+				current.WriteString("\n")
+			}
+			pre = pre || node.Data == "pre"
+		}
+
+		if node.Type == html.TextNode {
+			if pre {
+				current.WriteString(node.Data)
+			} else if text := strings.Join(strings.Fields(node.Data), " "); text != "" {
+				if strings.TrimLeft(node.Data, " \t\r\n") != node.Data {
+					text = " " + text
+				}
+				if strings.TrimRight(node.Data, " \t\r\n") != node.Data {
+					text += " "
+				}
+				current.WriteString(text)
+			}
+		}
+
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child, pre)
+		}
+
+		if node.Type == html.ElementNode && htmlBlocks[node.Data] {
+			current.WriteString("\n")
+		}
+	}
+
+	walk(root, false)
+	sections = append(sections, current.String())
+
+	for i, section := range sections {
+		sections[i] = collapseBlankLines(section)
+	}
+
+	return sections
+}
+
+var (
+	blankLines        = regexp.MustCompile(`\n[ \t]*(\n[ \t]*)+`)
+	markdownImage     = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	markdownLink      = regexp.MustCompile(`\[([^\]]+)\]\([^)]*\)`)
+	markdownEmphasis  = regexp.MustCompile("(\\*\\*|__|`)")
+	markdownHeading   = regexp.MustCompile(`^#{1,6}\s+`)
+	markdownTopLevel  = regexp.MustCompile(`^#{1,2}\s+`)
+	markdownQuoteMark = regexp.MustCompile(`^\s*>\s?`)
+)
+
+func collapseBlankLines(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimSpace(blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+// extractMarkdown gives one page per top level (# or ##) section and drops
+// the markup that means nothing to a reader.
+func extractMarkdown(content []byte) ([]string, error) {
+	text := strings.ReplaceAll(strings.TrimPrefix(string(content), "\ufeff"), "\r\n", "\n")
+
+	sections := []string{}
+	var current strings.Builder
+	fenced := false
+
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") || strings.HasPrefix(strings.TrimSpace(line), "~~~") {
+			fenced = !fenced
+			continue
+		}
+
+		if !fenced {
+			if markdownTopLevel.MatchString(line) && strings.TrimSpace(current.String()) != "" {
+				sections = append(sections, current.String())
+				current.Reset()
+			}
+
+			line = markdownHeading.ReplaceAllString(line, "")
+			line = markdownQuoteMark.ReplaceAllString(line, "")
+			line = markdownImage.ReplaceAllString(line, "$1")
+			line = markdownLink.ReplaceAllString(line, "$1")
+			line = markdownEmphasis.ReplaceAllString(line, "")
+		}
+
+		current.WriteString(line + "\n")
+	}
+
+	sections = append(sections, current.String())
+
+	for i, section := range sections {
+		sections[i] = collapseBlankLines(section)
+	}
+
+	return sections, nil
+}
+
+// extractPlainText splits pages on form feeds, which is how text exports of
+// paged documents mark them.
+func extractPlainText(content []byte) ([]string, error) {
+	text := strings.ReplaceAll(strings.TrimPrefix(string(content), "\ufeff"), "\r\n", "\n")
+	return strings.Split(text, "\f"), nil
+}
@@ -0,0 +1,114 @@
+package infrastructure
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"github/chera/fix-it/domain"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrUnsupportedFormat = errors.New("infrastructure/document_format: unsupported file format")
+
+var mediaTypeExtensions = map[string]string{
+	domain.MediaTypePDF:      ".pdf",
+	domain.MediaTypeDOCX:     ".docx",
+	domain.MediaTypePPTX:     ".pptx",
+	domain.MediaTypeEPUB:     ".epub",
+	domain.MediaTypeMarkdown: ".md",
+	domain.MediaTypeHTML:     ".html",
+	domain.MediaTypeText:     ".txt",
+}
+
+// MediaTypeExtension is the file extension documents of a media type are
+// stored with.
+func MediaTypeExtension(mediaType string) string {
+	return mediaTypeExtensions[mediaType]
+}
+
+// DetectMediaType tells the format of an upload from its content. The
+// filename is only used to tell Markdown from plain text, which look the
+// same byte for byte.
+func DetectMediaType(content []byte, filename string) (string, error) {
+	switch {
+	case bytes.HasPrefix(content, []byte("%PDF-")):
+		return domain.MediaTypePDF, nil
+	case bytes.HasPrefix(content, []byte("PK\x03\x04")):
+		return detectZipFormat(content)
+	}
+
+	if !looksLikeText(content) {
+		return "", ErrUnsupportedFormat
+	}
+
+	extension := strings.ToLower(filepath.Ext(filename))
+
+	if extension == ".html" || extension == ".htm" || strings.HasPrefix(http.DetectContentType(content), "text/html") {
+		return domain.MediaTypeHTML, nil
+	}
+
+	if extension == ".md" || extension == ".markdown" {
+		return domain.MediaTypeMarkdown, nil
+	}
+
+	return domain.MediaTypeText, nil
+}
+
+// detectZipFormat tells the office and e-book formats apart by the files
+// they are required to contain.
+func detectZipFormat(content []byte) (string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return "", ErrUnsupportedFormat
+	}
+
+	names := map[string]*zip.File{}
+	for _, file := range archive.File {
+		names[file.Name] = file
+	}
+
+	if file, ok := names["mimetype"]; ok {
+		mimetype, err := readZipFile(file, 100)
+		if err == nil && strings.TrimSpace(string(mimetype)) == domain.MediaTypeEPUB {
+			return domain.MediaTypeEPUB, nil
+		}
+	}
+
+	if _, ok := names["word/document.xml"]; ok {
+		return domain.MediaTypeDOCX, nil
+	}
+
+	if _, ok := names["ppt/presentation.xml"]; ok {
+		return domain.MediaTypePPTX, nil
+	}
+
+	return "", ErrUnsupportedFormat
+}
+
+// looksLikeText accepts UTF-8 without control characters other than
+// whitespace, so binary formats don't end up in the text extractors.
+func looksLikeText(content []byte) bool {
+	sample := content
+	if len(sample) > 8192 {
+		sample = sample[:8192]
+		// don't reject a sample that cuts a character in half
+		for i := 0; i < utf8.UTFMax && !utf8.Valid(sample); i++ {
+			sample = sample[:len(sample)-1]
+		}
+	}
+
+	if len(bytes.TrimSpace(sample)) == 0 || !utf8.Valid(sample) {
+		return false
+	}
+
+	for _, b := range sample {
+		if b < 0x20 && b != '\n' && b != '\r' && b != '\t' && b != '\f' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,100 @@
+package infrastructure
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"github/chera/fix-it/domain"
+	htmltemplate "html/template"
+	"net/url"
+	"os"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Templates of the emails. Each has an html version shown in the layout
+// and a plain text version that also defines the subject.
+const (
+	EmailVerification  = "verification"
+	EmailPasswordReset = "password_reset"
+	EmailReviewDigest  = "review_digest"
+	EmailAccountLocked = "account_locked"
+)
+
+type VerificationEmail struct {
+	Link string
+}
+
+type PasswordResetEmail struct {
+	Link string
+	// how long the link works, like "1 hour"
+	ValidFor string
+}
+
+type AccountLockedEmail struct {
+	// how long logins are locked, like "15 minutes"
+	LockedFor string
+	Link      string
+}
+
+type ReviewDigestEmail struct {
+	Username string
+	Due      int
+	Sections []string
+	Link     string
+}
+
+//go:embed email_templates
+var emailTemplates embed.FS
+
+// RenderEmail fills in a template for one recipient.
+func RenderEmail(to, name string, data any) (domain.Email, error) {
+	html, err := htmltemplate.ParseFS(emailTemplates, "email_templates/layout.html", "email_templates/"+name+".html")
+	if err != nil {
+		return domain.Email{}, errors.New("infrastructure/email_templates: " + err.Error())
+	}
+
+	text, err := texttemplate.ParseFS(emailTemplates, "email_templates/"+name+".txt")
+	if err != nil {
+		return domain.Email{}, errors.New("infrastructure/email_templates: " + err.Error())
+	}
+
+	var htmlBody, textBody, subject bytes.Buffer
+
+	if err := html.ExecuteTemplate(&htmlBody, "layout.html", data); err != nil {
+		return domain.Email{}, errors.New("infrastructure/email_templates: " + err.Error())
+	}
+
+	if err := text.ExecuteTemplate(&textBody, name+".txt", data); err != nil {
+		return domain.Email{}, errors.New("infrastructure/email_templates: " + err.Error())
+	}
+
+	if err := text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return domain.Email{}, errors.New("infrastructure/email_templates: " + err.Error())
+	}
+
+	return domain.Email{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		HTML:    htmlBody.String(),
+		Text:    textBody.String(),
+	}, nil
+}
+
+// VerificationLink is the link of the verification email, handled by
+// /u/verify.
+func VerificationLink(token string) string {
+	return os.Getenv("BASE_URL") + "/u/verify?token=" + url.QueryEscape(token)
+}
+
+// PasswordResetLink leads to the page of the front end where a new password
+// is chosen.
+func PasswordResetLink(token string) string {
+	return os.Getenv("FRONT_BASE_URL") + "/reset-password?token=" + url.QueryEscape(token)
+}
+
+// ForgotPasswordLink leads to the page of the front end where a password
+// reset is asked for.
+func ForgotPasswordLink() string {
+	return os.Getenv("FRONT_BASE_URL") + "/forgot-password"
+}
@@ -0,0 +1,129 @@
+package infrastructure
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Embedder turns passages into vectors for semantic retrieval. Without one
+// retrieval falls back to BM25 ranking, which needs no model at all.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float64, error)
+	Name() string
+}
+
+// NewEmbedder builds the embedder selected by EMBEDDING_PROVIDER. Supported
+// values are "local" (default, no embedder) and "openai", which works with
+// any OpenAI compatible embeddings endpoint such as Ollama.
+func NewEmbedder() (Embedder, error) {
+	provider := os.Getenv("EMBEDDING_PROVIDER")
+
+	switch provider {
+	case "", "local", "bm25":
+		return nil, nil
+	case "openai":
+		model, exist := os.LookupEnv("EMBEDDING_MODEL")
+		if !exist {
+			return nil, errors.New("infrastructure/embedder: EMBEDDING_MODEL not found")
+		}
+		baseURL := os.Getenv("EMBEDDING_BASE_URL")
+		if baseURL == "" {
+			baseURL = os.Getenv("LLM_BASE_URL")
+		}
+		if baseURL == "" {
+			baseURL = "http://localhost:11434/v1"
+		}
+		apiKey := os.Getenv("EMBEDDING_API_KEY")
+		if apiKey == "" {
+			apiKey = os.Getenv("LLM_API_KEY")
+		}
+		return NewOpenAIEmbedder(baseURL, apiKey, model), nil
+	}
+
+	return nil, errors.New("infrastructure/embedder: unknown EMBEDDING_PROVIDER " + provider)
+}
+
+type openAIEmbedder struct {
+	baseURL    string
+	apiKey     string
+	model      string
+	httpClient *http.Client
+}
+
+func NewOpenAIEmbedder(baseURL, apiKey, model string) Embedder {
+	return &openAIEmbedder{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		apiKey:     apiKey,
+		model:      model,
+		httpClient: &http.Client{Timeout: 2 * time.Minute},
+	}
+}
+
+func (e *openAIEmbedder) Name() string {
+	return "openai/" + e.model
+}
+
+func (e *openAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	body, err := json.Marshal(map[string]interface{}{"model": e.model, "input": texts})
+	if err != nil {
+		return nil, fmt.Errorf("error encoding request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", e.baseURL+"/embeddings", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if e.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.apiKey)
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embedding server returned status %d: %s", resp.StatusCode, string(responseBody))
+	}
+
+	var result struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+	}
+
+	if err := json.Unmarshal(responseBody, &result); err != nil {
+		return nil, fmt.Errorf("error unmarshalling response: %w", err)
+	}
+
+	if len(result.Data) != len(texts) {
+		return nil, fmt.Errorf("embedding server returned %d vectors for %d texts", len(result.Data), len(texts))
+	}
+
+	vectors := make([][]float64, len(texts))
+	for _, item := range result.Data {
+		if item.Index < 0 || item.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding server returned index %d", item.Index)
+		}
+		vectors[item.Index] = item.Embedding
+	}
+
+	return vectors, nil
+}
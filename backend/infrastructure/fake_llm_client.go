@@ -0,0 +1,88 @@
+package infrastructure
+
+import (
+	"context"
+	"github/chera/fix-it/domain"
+	"strings"
+	"sync"
+)
+
+const fakeLLMResponse = `1, What does this document describe?
+A, A placeholder topic
+B, An unrelated topic
+C, Nothing at all
+D, All of the above
+A
+`
+
+// FakeLLMClient is a deterministic LLMClient for tests and offline
+// development. It replies with the queued Responses in order and falls back
+// to Respond (or a fixed quiz) once they run out.
+type FakeLLMClient struct {
+	Responses []string
+	Respond   func(prompt string) string
+	Prompts   []string
+
+	mu sync.Mutex
+}
+
+func NewFakeLLMClient(responses ...string) *FakeLLMClient {
+	return &FakeLLMClient{Responses: responses}
+}
+
+func (f *FakeLLMClient) ModelInfo() LLMModelInfo {
+	return LLMModelInfo{Provider: "fake", Model: "fake"}
+}
+
+func (f *FakeLLMClient) Generate(ctx context.Context, prompt string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.Prompts = append(f.Prompts, prompt)
+
+	if len(f.Responses) > 0 {
+		response := f.Responses[0]
+		f.Responses = f.Responses[1:]
+		return response, nil
+	}
+
+	if f.Respond != nil {
+		return f.Respond(prompt), nil
+	}
+
+	return fakeLLMResponse, nil
+}
+
+func (f *FakeLLMClient) GenerateWithHistory(ctx context.Context, history []domain.ConversationTurn, prompt string) (string, error) {
+	return f.Generate(ctx, BuildPromptWithContext(prompt, history))
+}
+
+func (f *FakeLLMClient) GenerateJSON(ctx context.Context, history []domain.ConversationTurn, prompt string, schema *ResponseSchema) (string, error) {
+	return f.GenerateWithHistory(ctx, history, prompt)
+}
+
+// GenerateStream replies like Generate and hands the response out word by
+// word.
+func (f *FakeLLMClient) GenerateStream(ctx context.Context, history []domain.ConversationTurn, prompt string, schema *ResponseSchema, onChunk func(chunk string) error) (string, error) {
+	response, err := f.GenerateWithHistory(ctx, history, prompt)
+	if err != nil {
+		return "", err
+	}
+
+	sent := ""
+	for _, word := range strings.SplitAfter(response, " ") {
+		if err := ctx.Err(); err != nil {
+			return sent, err
+		}
+		if err := onChunk(word); err != nil {
+			return sent, err
+		}
+		sent += word
+	}
+
+	return response, nil
+}
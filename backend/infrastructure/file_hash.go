@@ -0,0 +1,12 @@
+package infrastructure
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// ContentHash identifies a file by its bytes, whatever it was named.
+func ContentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
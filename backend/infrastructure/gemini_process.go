@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
@@ -57,6 +58,121 @@ func NewGeminiModel() (*genai.GenerativeModel, context.Context, error) {
 
 }
 
+// geminiClient adapts a genai.GenerativeModel to the LLMClient interface.
+type geminiClient struct {
+	model *genai.GenerativeModel
+	name  string
+}
+
+func NewGeminiClient(model *genai.GenerativeModel, name string) LLMClient {
+	return &geminiClient{
+		model: model,
+		name:  name,
+	}
+}
+
+func (g *geminiClient) ModelInfo() LLMModelInfo {
+	return LLMModelInfo{Provider: "gemini", Model: g.name}
+}
+
+func (g *geminiClient) Generate(ctx context.Context, prompt string) (string, error) {
+	resp, err := g.model.GenerateContent(ctx, genai.Text(prompt))
+
+	if err != nil {
+		return "", err
+	}
+
+	return ExtractGeminiResponse(resp), nil
+}
+
+func (g *geminiClient) GenerateWithHistory(ctx context.Context, history []domain.ConversationTurn, prompt string) (string, error) {
+	return g.Generate(ctx, BuildPromptWithContext(prompt, history))
+}
+
+func (g *geminiClient) GenerateJSON(ctx context.Context, history []domain.ConversationTurn, prompt string, schema *ResponseSchema) (string, error) {
+	// copy the model so the shared one keeps producing plain text
+	model := *g.model
+	model.ResponseMIMEType = "application/json"
+	model.ResponseSchema = geminiSchema(schema)
+
+	resp, err := model.GenerateContent(ctx, genai.Text(BuildPromptWithContext(prompt, history)))
+
+	if err != nil {
+		return "", err
+	}
+
+	return ExtractGeminiResponse(resp), nil
+}
+
+func (g *geminiClient) GenerateStream(ctx context.Context, history []domain.ConversationTurn, prompt string, schema *ResponseSchema, onChunk func(chunk string) error) (string, error) {
+	model := *g.model
+	if schema != nil {
+		model.ResponseMIMEType = "application/json"
+		model.ResponseSchema = geminiSchema(schema)
+	}
+
+	iter := model.GenerateContentStream(ctx, genai.Text(BuildPromptWithContext(prompt, history)))
+
+	var response strings.Builder
+	for {
+		resp, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return response.String(), err
+		}
+
+		chunk := ExtractGeminiResponse(resp)
+		if chunk == "" {
+			continue
+		}
+
+		response.WriteString(chunk)
+		if err := onChunk(chunk); err != nil {
+			return response.String(), err
+		}
+	}
+
+	return response.String(), nil
+}
+
+func geminiSchema(schema *ResponseSchema) *genai.Schema {
+	if schema == nil {
+		return nil
+	}
+
+	types := map[string]genai.Type{
+		"object":  genai.TypeObject,
+		"array":   genai.TypeArray,
+		"string":  genai.TypeString,
+		"integer": genai.TypeInteger,
+		"number":  genai.TypeNumber,
+		"boolean": genai.TypeBoolean,
+	}
+
+	converted := &genai.Schema{
+		Type:        types[schema.Type],
+		Description: schema.Description,
+		Enum:        schema.Enum,
+		Required:    schema.Required,
+		Items:       geminiSchema(schema.Items),
+	}
+
+	if len(schema.Enum) > 0 {
+		converted.Format = "enum"
+	}
+
+	if len(schema.Properties) > 0 {
+		converted.Properties = map[string]*genai.Schema{}
+		for name, property := range schema.Properties {
+			converted.Properties[name] = geminiSchema(property)
+		}
+	}
+
+	return converted
+}
+
 func ExtractTopicGemini(resp *genai.GenerateContentResponse) string {
 	geminiResponse := ""
 	if len(resp.Candidates) > 0 && len(resp.Candidates[0].Content.Parts) > 0 {
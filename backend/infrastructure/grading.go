@@ -0,0 +1,130 @@
+package infrastructure
+
+import (
+	"github/chera/fix-it/domain"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// GradeQuiz scores answers against the questions they refer to by their
+// 1 based question number. Unanswered questions count as wrong.
+func GradeQuiz(questions []domain.Question, answers []domain.Answer) domain.QuizGrade {
+	given := map[int]string{}
+	for _, answer := range answers {
+		given[answer.QuestionNO] = answer.Answer
+	}
+
+	grade := domain.QuizGrade{Total: len(questions)}
+
+	for i, question := range questions {
+		answer, ok := given[i+1]
+		correct := ok && GradeAnswer(question, answer)
+
+		if correct {
+			grade.Score++
+		}
+
+		grade.Results = append(grade.Results, domain.QuestionResult{QuestionNO: i + 1, Correct: correct})
+	}
+
+	return grade
+}
+
+// GradeAnswer checks a single answer according to the question type.
+func GradeAnswer(question domain.Question, answer string) bool {
+	switch question.Type {
+	case domain.QuestionMultiSelect:
+		return sameLetters(question.Answer, answer)
+	case domain.QuestionTrueFalse:
+		normalized := normalizeAnswer(answer)
+		expected := normalizeAnswer(question.Answer)
+		if normalized == "true" || normalized == "false" {
+			return normalized == normalizeAnswer(choiceText(question, expected))
+		}
+		return normalized == expected
+	case domain.QuestionFillBlank:
+		return matchesAccepted(question, answer)
+	case domain.QuestionShortAnswer:
+		return matchesAccepted(question, answer) || coversKeywords(question.Keywords, answer)
+	}
+
+	return normalizeAnswer(question.Answer) == normalizeAnswer(answer)
+}
+
+func choiceText(question domain.Question, letter string) string {
+	switch letter {
+	case "a":
+		return question.A
+	case "b":
+		return question.B
+	case "c":
+		return question.C
+	case "d":
+		return question.D
+	}
+	return ""
+}
+
+func sameLetters(expected, answer string) bool {
+	split := func(value string) []string {
+		letters := strings.FieldsFunc(normalizeAnswer(value), func(r rune) bool {
+			return !unicode.IsLetter(r)
+		})
+		sort.Strings(letters)
+		return letters
+	}
+
+	want, got := split(expected), split(answer)
+	if len(want) == 0 || len(want) != len(got) {
+		return false
+	}
+
+	for i := range want {
+		if want[i] != got[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesAccepted(question domain.Question, answer string) bool {
+	normalized := normalizeAnswer(answer)
+	if normalized == "" {
+		return false
+	}
+
+	for _, accepted := range append([]string{question.Answer}, question.AcceptedAnswers...) {
+		if normalizeAnswer(accepted) == normalized {
+			return true
+		}
+	}
+	return false
+}
+
+// coversKeywords accepts a free text answer mentioning at least 60% of the
+// grading keywords.
+func coversKeywords(keywords []string, answer string) bool {
+	if len(keywords) == 0 {
+		return false
+	}
+
+	normalized := " " + normalizeAnswer(answer) + " "
+	found := 0
+	for _, keyword := range keywords {
+		if strings.Contains(normalized, " "+normalizeAnswer(keyword)+" ") {
+			found++
+		}
+	}
+
+	return found*10 >= len(keywords)*6
+}
+
+// normalizeAnswer lower cases text and reduces punctuation and repeated
+// spaces to single spaces.
+func normalizeAnswer(value string) string {
+	words := strings.FieldsFunc(strings.ToLower(value), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	return strings.Join(words, " ")
+}
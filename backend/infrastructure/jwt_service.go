@@ -1,6 +1,10 @@
 package infrastructure
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"os"
 	"time"
@@ -13,25 +17,68 @@ type Claims struct {
 	jwt.StandardClaims
 }
 
-func GenerateJWT(user_id string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
+// TokenConfig is how long access, refresh and password reset tokens last.
+type TokenConfig struct {
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+	ResetTTL   time.Duration
+}
+
+// LoadTokenConfig reads ACCESS_TOKEN_MINUTES, REFRESH_TOKEN_DAYS and
+// PASSWORD_RESET_MINUTES.
+func LoadTokenConfig() TokenConfig {
+	return TokenConfig{
+		AccessTTL:  time.Duration(envInt("ACCESS_TOKEN_MINUTES", 15)) * time.Minute,
+		RefreshTTL: time.Duration(envInt("REFRESH_TOKEN_DAYS", 30)) * 24 * time.Hour,
+		ResetTTL:   time.Duration(envInt("PASSWORD_RESET_MINUTES", 60)) * time.Minute,
+	}
+}
+
+// GenerateJWT issues an access token for a user, with a unique id (jti) so
+// it can be revoked before it expires.
+func GenerateJWT(user_id string, ttl time.Duration) (string, string, error) {
+	jti, err := NewOpaqueToken()
+	if err != nil {
+		return "", "", errors.New("infrastructure/jwt_service: " + err.Error())
+	}
+
+	now := time.Now()
 	claims := &Claims{
 		ID: user_id,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
+			Id:        jti,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(ttl).Unix(),
 		},
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	value, exists := os.LookupEnv("JWT_SECRET_KEY")
 	if !exists {
-		return "", errors.New("infrastructure/jwt_service: could not found jwt_secret_key, it does not exist")
+		return "", "", errors.New("infrastructure/jwt_service: could not found jwt_secret_key, it does not exist")
 	}
 	jwtKey := []byte(value)
 	tokenString, err := token.SignedString(jwtKey)
 	if err != nil {
-		return "", errors.New("infrastructure/jwt_service: " + err.Error())
+		return "", "", errors.New("infrastructure/jwt_service: " + err.Error())
 	}
-	return tokenString, nil
+	return tokenString, jti, nil
+}
+
+// NewOpaqueToken is a random url safe token, for refresh tokens and token
+// ids.
+func NewOpaqueToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// HashToken is how opaque tokens are stored. They are random enough that a
+// plain hash can't be reversed, and unlike bcrypt it can be looked up.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
 func GenerateToken(email string) (string, error) {
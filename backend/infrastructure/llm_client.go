@@ -0,0 +1,59 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"github/chera/fix-it/domain"
+	"os"
+)
+
+// LLMModelInfo describes the provider and model an LLMClient talks to.
+type LLMModelInfo struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+}
+
+// LLMClient is the contract every language model backend has to satisfy.
+// The repositories only depend on this interface so the model can be
+// swapped by configuration or replaced with a fake in tests.
+type LLMClient interface {
+	Generate(ctx context.Context, prompt string) (string, error)
+	GenerateWithHistory(ctx context.Context, history []domain.ConversationTurn, prompt string) (string, error)
+	// GenerateJSON asks for a response constrained to schema where the
+	// provider supports it; the caller still has to validate the result.
+	GenerateJSON(ctx context.Context, history []domain.ConversationTurn, prompt string, schema *ResponseSchema) (string, error)
+	// GenerateStream passes the response to onChunk as it is produced and
+	// returns it whole. A nil schema streams plain text. Returning an error
+	// from onChunk stops the generation.
+	GenerateStream(ctx context.Context, history []domain.ConversationTurn, prompt string, schema *ResponseSchema, onChunk func(chunk string) error) (string, error)
+	ModelInfo() LLMModelInfo
+}
+
+// NewLLMClient builds the client selected by LLM_PROVIDER.
+// Supported values are "gemini" (default), "openai" and "fake".
+func NewLLMClient() (LLMClient, error) {
+	provider := os.Getenv("LLM_PROVIDER")
+
+	switch provider {
+	case "", "gemini":
+		model, _, err := NewGeminiModel()
+		if err != nil {
+			return nil, errors.New("infrastructure/llm_client: " + err.Error())
+		}
+		return NewGeminiClient(model, os.Getenv("GEMINI_MODEL")), nil
+	case "openai":
+		model, exist := os.LookupEnv("LLM_MODEL")
+		if !exist {
+			return nil, errors.New("infrastructure/llm_client: LLM_MODEL not found")
+		}
+		baseURL := os.Getenv("LLM_BASE_URL")
+		if baseURL == "" {
+			baseURL = "http://localhost:11434/v1"
+		}
+		return NewOpenAIClient(baseURL, os.Getenv("LLM_API_KEY"), model), nil
+	case "fake":
+		return NewFakeLLMClient(), nil
+	}
+
+	return nil, errors.New("infrastructure/llm_client: unknown LLM_PROVIDER " + provider)
+}
@@ -0,0 +1,67 @@
+package infrastructure
+
+import "time"
+
+// LoginPolicy is how failed logins slow down further attempts. The first
+// failures are free, then every failure doubles the wait before the next
+// attempt, up to a lockout.
+type LoginPolicy struct {
+	FreeFailures    int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	LockoutFailures int
+	LockoutDuration time.Duration
+	// failures are forgotten once there was none for this long
+	Window time.Duration
+}
+
+// LoginLimits are the policies of the accounts and of the addresses logins
+// come from. Addresses are allowed more, many people may share one.
+type LoginLimits struct {
+	Account LoginPolicy
+	IP      LoginPolicy
+}
+
+// LoadLoginLimits reads LOGIN_LOCKOUT_ATTEMPTS, LOGIN_LOCKOUT_MINUTES and
+// LOGIN_IP_LOCKOUT_ATTEMPTS.
+func LoadLoginLimits() LoginLimits {
+	lockout := time.Duration(envInt("LOGIN_LOCKOUT_MINUTES", 15)) * time.Minute
+
+	return LoginLimits{
+		Account: LoginPolicy{
+			FreeFailures:    3,
+			BaseDelay:       time.Second,
+			MaxDelay:        time.Minute,
+			LockoutFailures: envInt("LOGIN_LOCKOUT_ATTEMPTS", 10),
+			LockoutDuration: lockout,
+			Window:          lockout + time.Hour,
+		},
+		IP: LoginPolicy{
+			FreeFailures:    20,
+			BaseDelay:       time.Second,
+			MaxDelay:        time.Minute,
+			LockoutFailures: envInt("LOGIN_IP_LOCKOUT_ATTEMPTS", 100),
+			LockoutDuration: lockout,
+			Window:          lockout + time.Hour,
+		},
+	}
+}
+
+// Delay is how long to wait after a number of failures, and whether that
+// wait is a lockout.
+func (p LoginPolicy) Delay(failures int) (time.Duration, bool) {
+	if p.LockoutFailures > 0 && failures >= p.LockoutFailures {
+		return p.LockoutDuration, true
+	}
+
+	if failures <= p.FreeFailures {
+		return 0, false
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeFailures + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, p.MaxDelay), false
+}
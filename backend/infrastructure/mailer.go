@@ -0,0 +1,160 @@
+package infrastructure
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github/chera/fix-it/domain"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+// Mailer delivers an email right away. Emails are queued in the outbox and
+// handed to a mailer by its workers.
+type Mailer interface {
+	Send(ctx context.Context, email domain.Email) error
+}
+
+// NewMailer picks a mailer from the MAILER environment variable: smtp, the
+// default, file to write emails to MAIL_OUTBOX_DIR instead of sending them,
+// or log to only log them.
+func NewMailer() (Mailer, error) {
+	switch os.Getenv("MAILER") {
+	case "", "smtp":
+		return NewSMTPMailer(LoadSMTPConfig()), nil
+	case "file":
+		dir := os.Getenv("MAIL_OUTBOX_DIR")
+		if dir == "" {
+			dir = "data/outbox"
+		}
+		return NewFileMailer(dir, mailFrom())
+	case "log":
+		return logMailer{}, nil
+	default:
+		return nil, errors.New("infrastructure/mailer: unknown MAILER " + os.Getenv("MAILER"))
+	}
+}
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	// starttls, tls for implicit TLS (usually port 465), or none for local
+	// test servers like MailHog that have no certificate
+	TLS string
+}
+
+// LoadSMTPConfig reads SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD,
+// SMTP_FROM and SMTP_TLS. The gmail account in EMAIL and EMAIL_PASSWORD is
+// used when they aren't set.
+func LoadSMTPConfig() SMTPConfig {
+	config := SMTPConfig{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     envInt("SMTP_PORT", 587),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     mailFrom(),
+		TLS:      os.Getenv("SMTP_TLS"),
+	}
+
+	if config.Host == "" {
+		config.Host = "smtp.gmail.com"
+	}
+	if config.Username == "" && config.Password == "" {
+		config.Username = os.Getenv("EMAIL")
+		config.Password = os.Getenv("EMAIL_PASSWORD")
+	}
+	if config.TLS == "" {
+		config.TLS = "starttls"
+	}
+
+	return config
+}
+
+func mailFrom() string {
+	if from := os.Getenv("SMTP_FROM"); from != "" {
+		return from
+	}
+	return os.Getenv("EMAIL")
+}
+
+// gomailMessage is a multipart message with the text version first, mail
+// clients show the last alternative they can.
+func gomailMessage(from string, email domain.Email) *gomail.Message {
+	m := gomail.NewMessage()
+	m.SetHeader("From", from)
+	m.SetHeader("To", email.To)
+	m.SetHeader("Subject", email.Subject)
+	m.SetBody("text/plain", email.Text)
+	m.AddAlternative("text/html", email.HTML)
+	return m
+}
+
+type smtpMailer struct {
+	config SMTPConfig
+	dialer *gomail.Dialer
+}
+
+func NewSMTPMailer(config SMTPConfig) Mailer {
+	dialer := gomail.NewDialer(config.Host, config.Port, config.Username, config.Password)
+
+	switch config.TLS {
+	case "tls":
+		dialer.SSL = true
+	case "none":
+		// STARTTLS is still used when offered, without checking the certificate
+		dialer.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	return &smtpMailer{config: config, dialer: dialer}
+}
+
+func (m *smtpMailer) Send(ctx context.Context, email domain.Email) error {
+	if err := m.dialer.DialAndSend(gomailMessage(m.config.From, email)); err != nil {
+		return errors.New("infrastructure/mailer: " + err.Error())
+	}
+	return nil
+}
+
+type fileMailer struct {
+	dir  string
+	from string
+}
+
+// NewFileMailer writes every email to an .eml file in dir, for development
+// without a mail server.
+func NewFileMailer(dir, from string) (Mailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.New("infrastructure/mailer: " + err.Error())
+	}
+	return &fileMailer{dir: dir, from: from}, nil
+}
+
+func (m *fileMailer) Send(ctx context.Context, email domain.Email) error {
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405.000"), email.ID.Hex())
+
+	file, err := os.Create(filepath.Join(m.dir, name))
+	if err != nil {
+		return errors.New("infrastructure/mailer: " + err.Error())
+	}
+	defer file.Close()
+
+	if _, err := gomailMessage(m.from, email).WriteTo(file); err != nil {
+		return errors.New("infrastructure/mailer: " + err.Error())
+	}
+	return nil
+}
+
+type logMailer struct{}
+
+func (logMailer) Send(ctx context.Context, email domain.Email) error {
+	log.Printf("email to %s: %s\n%s", email.To, email.Subject, email.Text)
+	return nil
+}
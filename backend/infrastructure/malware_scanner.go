@@ -0,0 +1,120 @@
+package infrastructure
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// ScanResult is what a scanner found in a file. Signature names the
+// malware when the file isn't clean.
+type ScanResult struct {
+	Clean     bool
+	Signature string
+}
+
+// Scanner checks uploads for malware before they are stored or processed.
+type Scanner interface {
+	Scan(ctx context.Context, content []byte) (ScanResult, error)
+}
+
+// NewScanner picks a scanner from the MALWARE_SCANNER environment variable.
+// It returns nil when scanning is turned off, which is the default.
+func NewScanner() (Scanner, error) {
+	switch os.Getenv("MALWARE_SCANNER") {
+	case "clamd":
+		address := os.Getenv("CLAMD_ADDRESS")
+		if address == "" {
+			address = "tcp://localhost:3310"
+		}
+		return NewClamdScanner(address), nil
+	case "", "none":
+		return nil, nil
+	default:
+		return nil, errors.New("infrastructure/malware_scanner: unknown MALWARE_SCANNER " + os.Getenv("MALWARE_SCANNER"))
+	}
+}
+
+// clamd refuses streams over its StreamMaxLength, send it in pieces well
+// under any sensible setting
+const clamdChunkSize = 64 << 10
+
+type clamdScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamdScanner talks to a clamd daemon at an address like
+// "tcp://clamav:3310" or "unix:///var/run/clamav/clamd.ctl".
+func NewClamdScanner(address string) Scanner {
+	network, addr := "tcp", address
+	if scheme, rest, ok := strings.Cut(address, "://"); ok {
+		network, addr = scheme, rest
+	}
+
+	return &clamdScanner{network: network, address: addr, timeout: 2 * time.Minute}
+}
+
+// Scan sends the file with the INSTREAM command: length prefixed chunks
+// ended by an empty one.
+func (s *clamdScanner) Scan(ctx context.Context, content []byte) (ScanResult, error) {
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	conn, err := dialer.DialContext(ctx, s.network, s.address)
+	if err != nil {
+		return ScanResult{}, errors.New("infrastructure/malware_scanner: " + err.Error())
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(s.timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+
+	writer := bufio.NewWriter(conn)
+	writer.WriteString("zINSTREAM\x00")
+
+	var size [4]byte
+	for start := 0; start < len(content); start += clamdChunkSize {
+		end := min(start+clamdChunkSize, len(content))
+		binary.BigEndian.PutUint32(size[:], uint32(end-start))
+		writer.Write(size[:])
+		writer.Write(content[start:end])
+	}
+
+	binary.BigEndian.PutUint32(size[:], 0)
+	writer.Write(size[:])
+
+	if err := writer.Flush(); err != nil {
+		return ScanResult{}, errors.New("infrastructure/malware_scanner: " + err.Error())
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return ScanResult{}, errors.New("infrastructure/malware_scanner: " + err.Error())
+	}
+
+	return parseClamdReply(reply)
+}
+
+// parseClamdReply reads replies like "stream: OK" and
+// "stream: Eicar-Signature FOUND".
+func parseClamdReply(reply string) (ScanResult, error) {
+	reply = strings.TrimRight(reply, "\x00\n")
+	_, status, _ := strings.Cut(reply, ": ")
+
+	switch {
+	case status == "OK":
+		return ScanResult{Clean: true}, nil
+	case strings.HasSuffix(status, " FOUND"):
+		return ScanResult{Signature: strings.TrimSuffix(status, " FOUND")}, nil
+	default:
+		return ScanResult{}, errors.New("infrastructure/malware_scanner: clamd replied " + reply)
+	}
+}
@@ -0,0 +1,433 @@
+package infrastructure
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"golang.org/x/oauth2"
+)
+
+// OIDCConfig is the OpenID Connect provider users can log in with, and the
+// client registered at it for the api.
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// the page of the front end the provider sends users back to, it
+	// passes the code and state on to /u/oidc/callback
+	RedirectURL string
+	Scopes      []string
+}
+
+// LoadOIDCConfig reads OIDC_ISSUER, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET,
+// OIDC_REDIRECT_URL and OIDC_SCOPES.
+func LoadOIDCConfig() OIDCConfig {
+	redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+	if redirectURL == "" {
+		redirectURL = os.Getenv("FRONT_BASE_URL") + "/oidc/callback"
+	}
+
+	scopes := strings.Fields(os.Getenv("OIDC_SCOPES"))
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+
+	return OIDCConfig{
+		Issuer:       strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/"),
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  redirectURL,
+		Scopes:       scopes,
+	}
+}
+
+// OIDCIdentity is who the provider says logged in, from a verified ID
+// token.
+type OIDCIdentity struct {
+	Issuer            string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+// OIDCProvider runs the authorization code flow with PKCE.
+type OIDCProvider interface {
+	AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error)
+	Exchange(ctx context.Context, code, verifier, nonce string) (OIDCIdentity, error)
+}
+
+// NewOIDCProvider returns nil when OIDC_ISSUER isn't set. The provider is
+// discovered on first use, so the api starts while it is down.
+func NewOIDCProvider(config OIDCConfig) OIDCProvider {
+	if config.Issuer == "" {
+		return nil
+	}
+
+	return &oidcProvider{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+		keys:   map[string]any{},
+	}
+}
+
+// ID tokens are accepted this far off the clock of the provider
+const oidcClockSkew = time.Minute
+
+// the keys are fetched again for an unknown key id, at most this often
+const jwksRefreshInterval = time.Minute
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcProvider struct {
+	config OIDCConfig
+	client *http.Client
+
+	mu          sync.Mutex
+	discovery   *oidcDiscovery
+	keys        map[string]any
+	keysFetched time.Time
+	// closed when the key set being fetched is in
+	fetching chan struct{}
+}
+
+func (p *oidcProvider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return p.oauth2Config(discovery).AuthCodeURL(state,
+		oauth2.S256ChallengeOption(verifier),
+		oauth2.SetAuthURLParam("nonce", nonce),
+	), nil
+}
+
+// Exchange trades a code for the tokens of the provider and returns the
+// identity of the ID token once its signature and claims are checked.
+func (p *oidcProvider) Exchange(ctx context.Context, code, verifier, nonce string) (OIDCIdentity, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return OIDCIdentity{}, err
+	}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, p.client)
+	token, err := p.oauth2Config(discovery).Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return OIDCIdentity{}, errors.New("infrastructure/oidc: " + err.Error())
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return OIDCIdentity{}, errors.New("infrastructure/oidc: the provider returned no id_token")
+	}
+
+	claims, err := p.verifyIDToken(ctx, discovery, rawIDToken, nonce)
+	if err != nil {
+		return OIDCIdentity{}, err
+	}
+
+	identity := OIDCIdentity{Issuer: discovery.Issuer}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+	identity.PreferredUsername, _ = claims["preferred_username"].(string)
+
+	// some providers send it as a string
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		identity.EmailVerified = verified == "true"
+	}
+
+	if identity.Subject == "" {
+		return OIDCIdentity{}, errors.New("infrastructure/oidc: the id_token has no subject")
+	}
+
+	return identity, nil
+}
+
+func (p *oidcProvider) oauth2Config(discovery *oidcDiscovery) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		RedirectURL:  p.config.RedirectURL,
+		Scopes:       p.config.Scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  discovery.AuthorizationEndpoint,
+			TokenURL: discovery.TokenEndpoint,
+		},
+	}
+}
+
+// verifyIDToken checks the signature of an ID token with the keys of the
+// provider, then the claims OpenID Connect Core 3.1.3.7 asks for.
+func (p *oidcProvider) verifyIDToken(ctx context.Context, discovery *oidcDiscovery, raw, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+
+	// asymmetric algorithms only, "none" and HS256 with the public key as
+	// the secret are the classic ways around the signature
+	parser := jwt.Parser{
+		ValidMethods:         []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"},
+		UseJSONNumber:        true,
+		SkipClaimsValidation: true,
+	}
+
+	_, err := parser.ParseWithClaims(raw, claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, discovery, kid)
+	})
+	if err != nil {
+		return nil, errors.New("infrastructure/oidc: invalid id_token: " + err.Error())
+	}
+
+	if issuer, _ := claims["iss"].(string); issuer != discovery.Issuer {
+		return nil, errors.New("infrastructure/oidc: the id_token is of another issuer " + issuer)
+	}
+
+	var audience []string
+	switch aud := claims["aud"].(type) {
+	case string:
+		audience = []string{aud}
+	case []any:
+		for _, value := range aud {
+			if s, ok := value.(string); ok {
+				audience = append(audience, s)
+			}
+		}
+	}
+	if !slices.Contains(audience, p.config.ClientID) {
+		return nil, errors.New("infrastructure/oidc: the id_token is for another client")
+	}
+	if azp, ok := claims["azp"].(string); (len(audience) > 1 || ok) && azp != p.config.ClientID {
+		return nil, errors.New("infrastructure/oidc: the id_token was issued to another client")
+	}
+
+	now := time.Now()
+	expiresAt, ok := numericClaim(claims, "exp")
+	if !ok || now.After(expiresAt.Add(oidcClockSkew)) {
+		return nil, errors.New("infrastructure/oidc: the id_token expired")
+	}
+	if issuedAt, ok := numericClaim(claims, "iat"); ok && issuedAt.After(now.Add(oidcClockSkew)) {
+		return nil, errors.New("infrastructure/oidc: the id_token was issued in the future")
+	}
+
+	if claimed, _ := claims["nonce"].(string); claimed != nonce {
+		return nil, errors.New("infrastructure/oidc: the id_token has the wrong nonce")
+	}
+
+	return claims, nil
+}
+
+func numericClaim(claims jwt.MapClaims, name string) (time.Time, bool) {
+	number, ok := claims[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
+
+// discover reads the endpoints of the provider from its
+// .well-known/openid-configuration, once.
+func (p *oidcProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	if err := p.getJSON(ctx, p.config.Issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.config.Issuer {
+		return nil, errors.New("infrastructure/oidc: the provider says its issuer is " + discovery.Issuer)
+	}
+
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("infrastructure/oidc: the provider configuration is missing endpoints")
+	}
+
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+// key is the public key an ID token was signed with. Providers rotate their
+// keys, an unknown key id fetches them again. The fetch runs without the
+// lock, logins needing the keys meanwhile wait for it.
+func (p *oidcProvider) key(ctx context.Context, discovery *oidcDiscovery, kid string) (any, error) {
+	for {
+		p.mu.Lock()
+
+		if key := p.findKey(kid); key != nil {
+			p.mu.Unlock()
+			return key, nil
+		}
+
+		if fetching := p.fetching; fetching != nil {
+			p.mu.Unlock()
+			select {
+			case <-fetching:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		if time.Since(p.keysFetched) < jwksRefreshInterval {
+			p.mu.Unlock()
+			return nil, errors.New("unknown signing key " + kid)
+		}
+
+		fetching := make(chan struct{})
+		p.fetching = fetching
+		p.mu.Unlock()
+
+		keys, err := p.fetchKeys(ctx, discovery.JWKSURI)
+
+		p.mu.Lock()
+		if err == nil {
+			p.keys, p.keysFetched = keys, time.Now()
+		}
+		p.fetching = nil
+		close(fetching)
+		p.mu.Unlock()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// findKey allows a token without a key id when the provider has one key.
+func (p *oidcProvider) findKey(kid string) any {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+	return p.keys[kid]
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (p *oidcProvider) fetchKeys(ctx context.Context, uri string) (map[string]any, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, uri, &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]any{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			// a key of a kind we don't know doesn't spoil the others
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (any, error) {
+	decode := base64.RawURLEncoding.DecodeString
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, errors.New("unsupported curve " + k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("the point is not on the curve")
+		}
+		return key, nil
+	default:
+		return nil, errors.New("unsupported key type " + k.Kty)
+	}
+}
+
+func (p *oidcProvider) getJSON(ctx context.Context, uri string, into any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return errors.New("infrastructure/oidc: " + err.Error())
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return errors.New("infrastructure/oidc: " + err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("infrastructure/oidc: %s answered %s", uri, resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(into); err != nil {
+		return errors.New("infrastructure/oidc: " + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package infrastructure
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github/chera/fix-it/domain"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIChatRequest struct {
+	Model          string                 `json:"model"`
+	Messages       []openAIMessage        `json:"messages"`
+	ResponseFormat map[string]interface{} `json:"response_format,omitempty"`
+	Stream         bool                   `json:"stream,omitempty"`
+}
+
+type openAIStreamChunk struct {
+	Choices []struct {
+		Delta openAIMessage `json:"delta"`
+	} `json:"choices"`
+}
+
+type openAIChatResponse struct {
+	Choices []struct {
+		Message openAIMessage `json:"message"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// openAIClient talks to any server implementing the OpenAI chat completions
+// API, which includes llama.cpp's server and Ollama.
+type openAIClient struct {
+	baseURL    string
+	apiKey     string
+	model      string
+	httpClient *http.Client
+}
+
+func NewOpenAIClient(baseURL, apiKey, model string) LLMClient {
+	// a client timeout would cut streams that run long, the server only gets
+	// to connect and start answering in time and the context ends the rest
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	transport.ResponseHeaderTimeout = 5 * time.Minute
+
+	return &openAIClient{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		apiKey:     apiKey,
+		model:      model,
+		httpClient: &http.Client{Transport: transport},
+	}
+}
+
+func (c *openAIClient) ModelInfo() LLMModelInfo {
+	return LLMModelInfo{Provider: "openai", Model: c.model}
+}
+
+func (c *openAIClient) Generate(ctx context.Context, prompt string) (string, error) {
+	return c.GenerateWithHistory(ctx, nil, prompt)
+}
+
+func (c *openAIClient) GenerateWithHistory(ctx context.Context, history []domain.ConversationTurn, prompt string) (string, error) {
+	return c.chat(ctx, openAIChatRequest{
+		Model:    c.model,
+		Messages: openAIMessages(history, prompt),
+	})
+}
+
+func (c *openAIClient) GenerateJSON(ctx context.Context, history []domain.ConversationTurn, prompt string, schema *ResponseSchema) (string, error) {
+	return c.chat(ctx, openAIChatRequest{
+		Model:          c.model,
+		Messages:       openAIMessages(history, prompt),
+		ResponseFormat: openAIResponseFormat(schema),
+	})
+}
+
+func (c *openAIClient) GenerateStream(ctx context.Context, history []domain.ConversationTurn, prompt string, schema *ResponseSchema, onChunk func(chunk string) error) (string, error) {
+	request := openAIChatRequest{
+		Model:    c.model,
+		Messages: openAIMessages(history, prompt),
+		Stream:   true,
+	}
+
+	if schema != nil {
+		request.ResponseFormat = openAIResponseFormat(schema)
+	}
+
+	resp, err := c.send(ctx, request)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		responseBody, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("LLM server returned status %d: %s", resp.StatusCode, string(responseBody))
+	}
+
+	var response strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		data, found := strings.CutPrefix(scanner.Text(), "data:")
+		if !found {
+			continue
+		}
+
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk openAIStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return response.String(), fmt.Errorf("error unmarshalling stream chunk: %w", err)
+		}
+
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+
+		text := chunk.Choices[0].Delta.Content
+		response.WriteString(text)
+		if err := onChunk(text); err != nil {
+			return response.String(), err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return response.String(), fmt.Errorf("error reading stream: %w", err)
+	}
+
+	return response.String(), nil
+}
+
+func openAIResponseFormat(schema *ResponseSchema) map[string]interface{} {
+	if schema == nil {
+		return map[string]interface{}{"type": "json_object"}
+	}
+
+	return map[string]interface{}{
+		"type": "json_schema",
+		"json_schema": map[string]interface{}{
+			"name":   "response",
+			"schema": schema.JSONSchema(),
+		},
+	}
+}
+
+func (c *openAIClient) send(ctx context.Context, request openAIChatRequest) (*http.Response, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/chat/completions", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %w", err)
+	}
+
+	return resp, nil
+}
+
+func (c *openAIClient) chat(ctx context.Context, request openAIChatRequest) (string, error) {
+	resp, err := c.send(ctx, request)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("error reading response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("LLM server returned status %d: %s", resp.StatusCode, string(responseBody))
+	}
+
+	var result openAIChatResponse
+	if err := json.Unmarshal(responseBody, &result); err != nil {
+		return "", fmt.Errorf("error unmarshalling response: %w", err)
+	}
+
+	if result.Error != nil {
+		return "", errors.New("error from LLM server: " + result.Error.Message)
+	}
+
+	if len(result.Choices) == 0 {
+		return "", errors.New("LLM server returned no choices")
+	}
+
+	return result.Choices[0].Message.Content, nil
+}
+
+func openAIMessages(history []domain.ConversationTurn, prompt string) []openAIMessage {
+	messages := make([]openAIMessage, 0, len(history)*2+1)
+	for _, turn := range history {
+		messages = append(messages,
+			openAIMessage{Role: "user", Content: turn.User},
+			openAIMessage{Role: "assistant", Content: turn.Gemini},
+		)
+	}
+	return append(messages, openAIMessage{Role: "user", Content: prompt})
+}
@@ -0,0 +1,221 @@
+package infrastructure
+
+import (
+	"errors"
+	"fmt"
+	"github/chera/fix-it/domain"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type pageRange struct {
+	from, to int
+	// "20-" runs to the end of the document
+	open bool
+}
+
+func parseRangeSpec(spec string) ([]pageRange, error) {
+	var ranges []pageRange
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		first, last, isRange := strings.Cut(part, "-")
+
+		from, err := strconv.Atoi(strings.TrimSpace(first))
+		if err != nil || from < 1 {
+			return nil, fmt.Errorf("invalid page range %q", part)
+		}
+
+		current := pageRange{from: from, to: from}
+
+		if isRange && strings.TrimSpace(last) == "" {
+			current.open = true
+		} else if isRange {
+			current.to, err = strconv.Atoi(strings.TrimSpace(last))
+			if err != nil || current.to < from {
+				return nil, fmt.Errorf("invalid page range %q", part)
+			}
+		}
+
+		ranges = append(ranges, current)
+	}
+
+	return ranges, nil
+}
+
+// CheckPageRanges tells whether ranges like "3-10,12,20-" are well formed,
+// before the pages of the document are known.
+func CheckPageRanges(spec string) error {
+	_, err := parseRangeSpec(spec)
+	return err
+}
+
+// ParsePageRanges reads ranges like "3-10,12,20-" into sorted page numbers,
+// checking them against the pages of the document.
+func ParsePageRanges(spec string, pageCount int) ([]int, error) {
+	ranges, err := parseRangeSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := map[int]bool{}
+
+	for _, current := range ranges {
+		if current.open {
+			current.to = pageCount
+		}
+
+		if current.from > pageCount || current.to > pageCount {
+			return nil, fmt.Errorf("page range %d-%d is outside the %d pages of the document", current.from, current.to, pageCount)
+		}
+
+		for page := current.from; page <= current.to; page++ {
+			selected[page] = true
+		}
+	}
+
+	pages := make([]int, 0, len(selected))
+	for page := range selected {
+		pages = append(pages, page)
+	}
+	sort.Ints(pages)
+
+	return pages, nil
+}
+
+// FormatPageRanges writes sorted page numbers back as ranges.
+func FormatPageRanges(pages []int) string {
+	var ranges []string
+
+	for i := 0; i < len(pages); {
+		j := i
+		for j+1 < len(pages) && pages[j+1] == pages[j]+1 {
+			j++
+		}
+
+		if i == j {
+			ranges = append(ranges, strconv.Itoa(pages[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", pages[i], pages[j]))
+		}
+		i = j + 1
+	}
+
+	return strings.Join(ranges, ",")
+}
+
+// SelectPages keeps the pages a selection asks for and returns them with
+// the selection written as page ranges, which is empty when the whole
+// document is selected.
+func SelectPages(pages []domain.DocumentPage, selection domain.PageSelection, chapters []domain.Chapter) ([]domain.DocumentPage, string, error) {
+	if strings.TrimSpace(selection.Pages) == "" && len(selection.Chapters) == 0 {
+		return pages, "", nil
+	}
+
+	pageCount := 0
+	for _, page := range pages {
+		if page.Number > pageCount {
+			pageCount = page.Number
+		}
+	}
+
+	numbers, err := ParsePageRanges(selection.Pages, pageCount)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, chapter := range selection.Chapters {
+		if chapter < 1 || chapter > len(chapters) {
+			return nil, "", fmt.Errorf("chapter %d does not exist, the document has %d chapters", chapter, len(chapters))
+		}
+		for page := chapters[chapter-1].FirstPage; page <= chapters[chapter-1].LastPage; page++ {
+			numbers = append(numbers, page)
+		}
+	}
+
+	wanted := map[int]bool{}
+	for _, number := range numbers {
+		wanted[number] = true
+	}
+
+	var selected []domain.DocumentPage
+	var kept []int
+	hasText := false
+
+	for _, page := range pages {
+		if wanted[page.Number] {
+			selected = append(selected, page)
+			kept = append(kept, page.Number)
+			hasText = hasText || strings.TrimSpace(page.Text) != ""
+		}
+	}
+
+	if !hasText {
+		return nil, "", errors.New("the selected pages have no text")
+	}
+
+	if len(selected) == len(pages) {
+		return pages, "", nil
+	}
+
+	return selected, FormatPageRanges(kept), nil
+}
+
+var chapterHeading = regexp.MustCompile(`(?i)^(chapter|part|unit|lesson|module)\s+[\w.]+`)
+
+// DetectChapters finds the chapters of a document from its text. Formats
+// whose pages already are chapters or sections get one chapter per page,
+// the others a chapter per "Chapter 3" like heading at the start of a line.
+func DetectChapters(pages []domain.DocumentPage, mediaType string) []domain.Chapter {
+	var chapters []domain.Chapter
+
+	for _, page := range pages {
+		text := strings.TrimSpace(page.Text)
+
+		switch mediaType {
+		case domain.MediaTypeEPUB, domain.MediaTypeMarkdown, domain.MediaTypeHTML:
+			if text != "" {
+				title, _, _ := strings.Cut(text, "\n")
+				chapters = append(chapters, domain.Chapter{Title: Snippet(title, 80), FirstPage: page.Number})
+			}
+			continue
+		}
+
+		for _, line := range strings.Split(text, "\n") {
+			line = strings.TrimSpace(line)
+			if chapterHeading.MatchString(line) && len(line) <= 80 {
+				chapters = append(chapters, domain.Chapter{Title: line, FirstPage: page.Number})
+				break
+			}
+		}
+	}
+
+	return closeChapters(chapters, pages)
+}
+
+// closeChapters ends every chapter where the next one starts and the last
+// one at the end of the document.
+func closeChapters(chapters []domain.Chapter, pages []domain.DocumentPage) []domain.Chapter {
+	if len(chapters) == 0 || len(pages) == 0 {
+		return nil
+	}
+
+	lastPage := pages[len(pages)-1].Number
+
+	for i := range chapters {
+		chapters[i].LastPage = lastPage
+		if i+1 < len(chapters) && chapters[i+1].FirstPage > chapters[i].FirstPage {
+			chapters[i].LastPage = chapters[i+1].FirstPage - 1
+		} else if i+1 < len(chapters) {
+			chapters[i].LastPage = chapters[i].FirstPage
+		}
+	}
+
+	return chapters
+}
@@ -0,0 +1,288 @@
+package infrastructure
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github/chera/fix-it/domain"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// localPDFExtractor reads the text layer of a PDF in process, so documents
+// never leave the server.
+type localPDFExtractor struct{}
+
+func NewLocalPDFExtractor() TextExtractor {
+	return &localPDFExtractor{}
+}
+
+func (e *localPDFExtractor) Extract(ctx context.Context, file io.Reader, filename string) ([]domain.DocumentPage, error) {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %w", err)
+	}
+
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("error opening pdf: %w", err)
+	}
+
+	var pages []domain.DocumentPage
+	empty := true
+
+	for i := 1; i <= reader.NumPage(); i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		text, err := extractPageText(reader.Page(i))
+		if err != nil {
+			return nil, fmt.Errorf("error reading page %d: %w", i, err)
+		}
+
+		if text != "" {
+			empty = false
+		}
+
+		pages = append(pages, domain.DocumentPage{Number: i, Text: text})
+	}
+
+	if empty {
+		return nil, errors.New("no text found in pdf, it may only contain scanned images")
+	}
+
+	return pages, nil
+}
+
+func extractPageText(page pdf.Page) (text string, err error) {
+	// the pdf package panics on malformed content streams
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed page: %v", r)
+		}
+	}()
+
+	if page.V.IsNull() {
+		return "", nil
+	}
+
+	return normalizeExtractedText(layoutPage(page.Content().Text)), nil
+}
+
+// textSegment is a run of glyphs on one baseline without large gaps.
+type textSegment struct {
+	X, Right, Y, FontSize float64
+	Text                  string
+}
+
+// layoutPage rebuilds reading order from positioned glyphs. Lines are
+// grouped by baseline and, when a vertical gutter is found, text on either
+// side of it is read column by column between full width lines.
+func layoutPage(glyphs []pdf.Text) string {
+	segments := buildSegments(glyphs)
+	if len(segments) == 0 {
+		return ""
+	}
+
+	gutter, ok := findGutter(segments)
+	if !ok {
+		return joinSegments(segments)
+	}
+
+	var blocks []string
+	var left, right []textSegment
+
+	flush := func() {
+		if len(left) > 0 {
+			blocks = append(blocks, joinSegments(left))
+		}
+		if len(right) > 0 {
+			blocks = append(blocks, joinSegments(right))
+		}
+		left, right = nil, nil
+	}
+
+	for _, segment := range segments {
+		switch {
+		case segment.Right <= gutter:
+			left = append(left, segment)
+		case segment.X >= gutter:
+			right = append(right, segment)
+		default:
+			flush()
+			blocks = append(blocks, segment.Text)
+		}
+	}
+	flush()
+
+	return strings.Join(blocks, "\n")
+}
+
+func buildSegments(glyphs []pdf.Text) []textSegment {
+	var visible []pdf.Text
+	for _, glyph := range glyphs {
+		if strings.TrimSpace(glyph.S) != "" {
+			visible = append(visible, glyph)
+		}
+	}
+
+	sort.SliceStable(visible, func(i, j int) bool {
+		if visible[i].Y != visible[j].Y {
+			return visible[i].Y > visible[j].Y
+		}
+		return visible[i].X < visible[j].X
+	})
+
+	var segments []textSegment
+
+	for start := 0; start < len(visible); {
+		lineY := visible[start].Y
+		end := start + 1
+		for end < len(visible) && lineY-visible[end].Y <= math.Max(visible[start].FontSize*0.4, 1) {
+			end++
+		}
+
+		line := append([]pdf.Text(nil), visible[start:end]...)
+		sort.SliceStable(line, func(i, j int) bool { return line[i].X < line[j].X })
+
+		var current *textSegment
+		var builder strings.Builder
+
+		for _, glyph := range line {
+			size := math.Max(glyph.FontSize, 1)
+
+			if current != nil {
+				gap := glyph.X - current.Right
+				if gap > size*1.5 {
+					current.Text = builder.String()
+					segments = append(segments, *current)
+					current = nil
+					builder.Reset()
+				} else if gap > size*0.15 {
+					builder.WriteString(" ")
+				}
+			}
+
+			if current == nil {
+				current = &textSegment{X: glyph.X, Y: lineY, FontSize: size}
+			}
+
+			builder.WriteString(glyph.S)
+			current.Right = math.Max(current.Right, glyph.X+glyph.W)
+		}
+
+		if current != nil {
+			current.Text = builder.String()
+			segments = append(segments, *current)
+		}
+
+		start = end
+	}
+
+	return segments
+}
+
+// findGutter looks for an empty vertical band in the middle of the page
+// that separates two columns of text.
+func findGutter(segments []textSegment) (float64, bool) {
+	minX, maxX := math.MaxFloat64, 0.0
+	for _, segment := range segments {
+		minX = math.Min(minX, segment.X)
+		maxX = math.Max(maxX, segment.Right)
+	}
+
+	width := maxX - minX
+	if width < 200 || len(segments) < 10 {
+		return 0, false
+	}
+
+	coverage := make([]int, int(width)+1)
+	for _, segment := range segments {
+		from := int(segment.X - minX)
+		to := int(segment.Right - minX)
+		for b := from; b <= to && b < len(coverage); b++ {
+			coverage[b]++
+		}
+	}
+
+	// a few full width lines such as titles are allowed to cross the gutter
+	tolerance := max(1, len(segments)/20)
+	bestStart, bestLength, run := 0, 0, 0
+
+	for b := int(width * 0.25); b <= int(width*0.75); b++ {
+		if coverage[b] <= tolerance {
+			run++
+			if run > bestLength {
+				bestLength = run
+				bestStart = b - run + 1
+			}
+		} else {
+			run = 0
+		}
+	}
+
+	if bestLength < 10 {
+		return 0, false
+	}
+
+	gutter := minX + float64(bestStart) + float64(bestLength)/2
+
+	var left, right int
+	for _, segment := range segments {
+		if segment.Right <= gutter {
+			left++
+		} else if segment.X >= gutter {
+			right++
+		}
+	}
+
+	if left < len(segments)/5 || right < len(segments)/5 {
+		return 0, false
+	}
+
+	return gutter, true
+}
+
+// joinSegments writes segments top to bottom, putting the ones that share a
+// baseline on the same line.
+func joinSegments(segments []textSegment) string {
+	var lines []string
+	var current []string
+	lineY := math.NaN()
+
+	for _, segment := range segments {
+		if !math.IsNaN(lineY) && math.Abs(lineY-segment.Y) > 1 {
+			lines = append(lines, strings.Join(current, " "))
+			current = nil
+		}
+		lineY = segment.Y
+		current = append(current, segment.Text)
+	}
+
+	if len(current) > 0 {
+		lines = append(lines, strings.Join(current, " "))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+var ligatureReplacer = strings.NewReplacer(
+	"ﬀ", "ff",
+	"ﬁ", "fi",
+	"ﬂ", "fl",
+	"ﬃ", "ffi",
+	"ﬄ", "ffl",
+	"­", "",
+	"\x00", "",
+	"�", "",
+)
+
+func normalizeExtractedText(text string) string {
+	return strings.TrimSpace(ligatureReplacer.Replace(text))
+}
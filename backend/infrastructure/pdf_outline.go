@@ -0,0 +1,139 @@
+package infrastructure
+
+import (
+	"bytes"
+	"github/chera/fix-it/domain"
+	"reflect"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// an outline can link back to itself, stop walking it at some point
+const maxOutlineItems = 1000
+
+// DocumentChapters finds the chapters of an upload, from the bookmarks of a
+// PDF when it has them and from its text otherwise.
+func DocumentChapters(content []byte, mediaType string, pages []domain.DocumentPage) []domain.Chapter {
+	if mediaType == domain.MediaTypePDF {
+		if chapters := closeChapters(PDFOutline(content), pages); len(chapters) > 0 {
+			return chapters
+		}
+	}
+
+	return DetectChapters(pages, mediaType)
+}
+
+// PDFOutline reads the top level bookmarks of a PDF with the page each one
+// points to. Broken or missing outlines give no chapters.
+func PDFOutline(content []byte) (chapters []domain.Chapter) {
+	// the pdf reader panics on some malformed objects
+	defer func() {
+		if recover() != nil {
+			chapters = nil
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil
+	}
+
+	pageNumbers := map[uint32]int{}
+	for i := 1; i <= reader.NumPage(); i++ {
+		if id, ok := pdfObjectID(reader.Page(i).V); ok {
+			pageNumbers[id] = i
+		}
+	}
+
+	root := reader.Trailer().Key("Root")
+	item := root.Key("Outlines").Key("First")
+
+	for count := 0; !item.IsNull() && count < maxOutlineItems; count++ {
+		dest := item.Key("Dest")
+		if dest.IsNull() && item.Key("A").Key("S").Name() == "GoTo" {
+			dest = item.Key("A").Key("D")
+		}
+
+		dest = resolveDestination(root, dest)
+
+		if dest.Kind() == pdf.Array && dest.Len() > 0 {
+			if id, ok := pdfObjectID(dest.Index(0)); ok {
+				if page, ok := pageNumbers[id]; ok {
+					title := strings.TrimSpace(item.Key("Title").Text())
+					chapters = append(chapters, domain.Chapter{Title: title, FirstPage: page})
+				}
+			}
+		}
+
+		item = item.Key("Next")
+	}
+
+	return chapters
+}
+
+// resolveDestination looks up named destinations, in the Dests dictionary
+// of older files or the Dests name tree of newer ones.
+func resolveDestination(root, dest pdf.Value) pdf.Value {
+	var name string
+
+	switch dest.Kind() {
+	case pdf.Name:
+		name = dest.Name()
+	case pdf.String:
+		name = dest.RawString()
+	default:
+		return dest
+	}
+
+	resolved := root.Key("Dests").Key(name)
+	if resolved.IsNull() {
+		resolved = findInNameTree(root.Key("Names").Key("Dests"), name, 0)
+	}
+
+	// a destination may be wrapped in a dictionary
+	if resolved.Kind() == pdf.Dict {
+		resolved = resolved.Key("D")
+	}
+
+	return resolved
+}
+
+func findInNameTree(node pdf.Value, name string, depth int) pdf.Value {
+	if node.IsNull() || depth > 32 {
+		return pdf.Value{}
+	}
+
+	names := node.Key("Names")
+	for i := 0; i+1 < names.Len(); i += 2 {
+		if names.Index(i).RawString() == name {
+			return names.Index(i + 1)
+		}
+	}
+
+	kids := node.Key("Kids")
+	for i := 0; i < kids.Len(); i++ {
+		if found := findInNameTree(kids.Index(i), name, depth+1); !found.IsNull() {
+			return found
+		}
+	}
+
+	return pdf.Value{}
+}
+
+// pdfObjectID is the object number a value was read from. The pdf package
+// keeps it unexported, but it is the only way to tell which page a
+// bookmark points to.
+func pdfObjectID(value pdf.Value) (uint32, bool) {
+	ptr := reflect.ValueOf(value).FieldByName("ptr")
+	if !ptr.IsValid() {
+		return 0, false
+	}
+
+	id := ptr.FieldByName("id")
+	if !id.IsValid() || id.Uint() == 0 {
+		return 0, false
+	}
+
+	return uint32(id.Uint()), true
+}
@@ -2,16 +2,71 @@ package infrastructure
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github/chera/fix-it/domain"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"os"
+	"strings"
 )
 
-func UploadPDF(file multipart.File, filename string) (string, error) {
+// pdfCoExtractor sends documents to api.pdf.co for text extraction.
+type pdfCoExtractor struct {
+	apiKey string
+}
+
+func NewPDFCoExtractor(apiKey string) TextExtractor {
+	return &pdfCoExtractor{
+		apiKey: apiKey,
+	}
+}
+
+func (e *pdfCoExtractor) Extract(ctx context.Context, file io.Reader, filename string) ([]domain.DocumentPage, error) {
+	link, err := UploadPDF(file, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	textLink, err := ExtractText(link, e.apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", textLink, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+
+	textResponse, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading text: %w", err)
+	}
+	defer textResponse.Body.Close()
+
+	if textResponse.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(textResponse.Body)
+		return nil, fmt.Errorf("error downloading text, status: %d, body: %s", textResponse.StatusCode, string(body))
+	}
+
+	textBytes, err := io.ReadAll(textResponse.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading downloaded text: %w", err)
+	}
+
+	// PDF.co separates pages with form feeds
+	var pages []domain.DocumentPage
+	for i, text := range strings.Split(string(textBytes), "\f") {
+		pages = append(pages, domain.DocumentPage{Number: i + 1, Text: strings.TrimSpace(text)})
+	}
+
+	return pages, nil
+}
+
+func UploadPDF(file io.Reader, filename string) (string, error) {
 	apiKey, exist := os.LookupEnv("PDFCO_API_KEY")
 	baseURL := "https://api.pdf.co/v1"
 
@@ -76,23 +131,6 @@ func UploadPDF(file multipart.File, filename string) (string, error) {
 
 }
 
-func ProcessPDF(link string) (string, error) {
-
-	apiKey, exist := os.LookupEnv("PDFCO_API_KEY")
-
-	if !exist {
-		return "", errors.New("PDFCO_API_KEY not found")
-	}
-
-	the_text, err := ExtractText(link, apiKey)
-
-	if err != nil {
-		return "", err
-	}
-	return the_text, nil
-
-}
-
 func ExtractText(fileId string, apiKey string) (string, error) {
 	const pdfCoConvertToTextURL = "https://api.pdf.co/v1/pdf/convert/to/text"
 
@@ -0,0 +1,98 @@
+package infrastructure
+
+import (
+	"errors"
+	"fmt"
+	"github/chera/fix-it/domain"
+	"strings"
+)
+
+const MaxQuestionCount = 50
+
+var questionTypeInstructions = map[string]string{
+	domain.QuestionSingleChoice: `"single_choice": four alternatives a to d, answer is the letter of the one correct alternative`,
+	domain.QuestionMultiSelect:  `"multi_select": four alternatives a to d, answer is every correct letter separated by commas, for example "A,C"`,
+	domain.QuestionTrueFalse:    `"true_false": a statement, answer is True or False, leave the alternatives empty`,
+	domain.QuestionFillBlank:    `"fill_in_the_blank": a sentence with the missing words written as ____, answer is the missing text, accepted_answers lists other correct spellings`,
+	domain.QuestionShortAnswer:  `"short_answer": an open question answered in a sentence, answer is a model answer and keywords lists the key terms a correct answer must mention`,
+}
+
+// ValidateQuizOptions checks the requested quiz options and fills in the
+// defaults for the ones that were left out.
+func ValidateQuizOptions(options *domain.QuizOptions, defaultCount int) error {
+	if options.QuestionCount == 0 {
+		options.QuestionCount = defaultCount
+	}
+
+	if options.QuestionCount < 1 || options.QuestionCount > MaxQuestionCount {
+		return fmt.Errorf("infrastructure/quiz_options: question count must be between 1 and %d", MaxQuestionCount)
+	}
+
+	if options.Difficulty == "" {
+		options.Difficulty = domain.DifficultyMedium
+	}
+
+	options.Difficulty = strings.ToLower(options.Difficulty)
+	if options.Difficulty != domain.DifficultyEasy && options.Difficulty != domain.DifficultyMedium && options.Difficulty != domain.DifficultyHard {
+		return errors.New("infrastructure/quiz_options: difficulty must be easy, medium or hard")
+	}
+
+	if len(options.QuestionTypes) == 0 {
+		options.QuestionTypes = []string{domain.QuestionSingleChoice}
+	}
+
+	seen := map[string]bool{}
+	var types []string
+	for _, questionType := range options.QuestionTypes {
+		questionType = strings.ToLower(strings.TrimSpace(questionType))
+		if _, ok := questionTypeInstructions[questionType]; !ok {
+			return errors.New("infrastructure/quiz_options: unknown question type " + questionType)
+		}
+		if !seen[questionType] {
+			seen[questionType] = true
+			types = append(types, questionType)
+		}
+	}
+	options.QuestionTypes = types
+
+	return nil
+}
+
+// QuizInstructions describes the requested difficulty and question types
+// for the generation prompt.
+func QuizInstructions(options domain.QuizOptions) string {
+	instructions := fmt.Sprintf("The questions should be of %s difficulty.\n", options.Difficulty)
+
+	if len(options.QuestionTypes) > 1 {
+		instructions += "Use a mix of the following question types, set the type field of each question accordingly:\n"
+	} else {
+		instructions += "Every question must be of the following type:\n"
+	}
+
+	for _, questionType := range options.QuestionTypes {
+		instructions += "- " + questionTypeInstructions[questionType] + "\n"
+	}
+
+	return instructions
+}
+
+// SameQuizOptions reports whether two normalized option sets ask for the
+// same quiz, whatever the order of the question types.
+func SameQuizOptions(a, b domain.QuizOptions) bool {
+	if a.QuestionCount != b.QuestionCount || a.Difficulty != b.Difficulty || len(a.QuestionTypes) != len(b.QuestionTypes) {
+		return false
+	}
+
+	types := map[string]bool{}
+	for _, questionType := range a.QuestionTypes {
+		types[questionType] = true
+	}
+
+	for _, questionType := range b.QuestionTypes {
+		if !types[questionType] {
+			return false
+		}
+	}
+
+	return true
+}
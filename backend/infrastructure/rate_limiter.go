@@ -0,0 +1,196 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimiter is a token bucket per key. Allow takes a token and tells how
+// long to wait when there is none.
+type RateLimiter interface {
+	Allow(ctx context.Context, key string) (bool, time.Duration, error)
+}
+
+// NewRateLimiter picks a rate limiter from the RATE_LIMIT_BACKEND
+// environment variable: memory, the default, or redis at REDIS_URL so
+// several instances share the buckets. RATE_LIMIT_PER_MINUTE and
+// RATE_LIMIT_BURST size the buckets.
+func NewRateLimiter() (RateLimiter, error) {
+	perSecond := float64(envInt("RATE_LIMIT_PER_MINUTE", 30)) / 60
+	burst := envInt("RATE_LIMIT_BURST", 10)
+
+	switch os.Getenv("RATE_LIMIT_BACKEND") {
+	case "", "memory":
+		return NewMemoryRateLimiter(perSecond, burst), nil
+	case "redis":
+		client, err := NewRedisClient(os.Getenv("REDIS_URL"))
+		if err != nil {
+			return nil, err
+		}
+		return NewRedisRateLimiter(client, "ratelimit:", perSecond, burst), nil
+	default:
+		return nil, errors.New("infrastructure/rate_limiter: unknown RATE_LIMIT_BACKEND " + os.Getenv("RATE_LIMIT_BACKEND"))
+	}
+}
+
+// refill adds the tokens earned since the last request to a bucket and
+// takes one when there is one.
+func refill(tokens float64, elapsed time.Duration, perSecond float64, burst int) (float64, bool, time.Duration) {
+	tokens = math.Min(float64(burst), tokens+elapsed.Seconds()*perSecond)
+
+	if tokens >= 1 {
+		return tokens - 1, true, 0
+	}
+
+	wait := time.Duration((1 - tokens) / perSecond * float64(time.Second))
+	return tokens, false, wait
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type memoryRateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	perSecond float64
+	burst     int
+	lastSweep time.Time
+}
+
+// NewMemoryRateLimiter keeps the buckets in memory, for a single instance.
+func NewMemoryRateLimiter(perSecond float64, burst int) RateLimiter {
+	return &memoryRateLimiter{
+		buckets:   map[string]*bucket{},
+		perSecond: perSecond,
+		burst:     burst,
+		lastSweep: time.Now(),
+	}
+}
+
+func (m *memoryRateLimiter) Allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.sweep(now)
+
+	current, ok := m.buckets[key]
+	if !ok {
+		current = &bucket{tokens: float64(m.burst), last: now}
+		m.buckets[key] = current
+	}
+
+	tokens, allowed, wait := refill(current.tokens, now.Sub(current.last), m.perSecond, m.burst)
+	current.tokens, current.last = tokens, now
+
+	return allowed, wait, nil
+}
+
+// sweep forgets the buckets that filled up again, which are the same as
+// no bucket.
+func (m *memoryRateLimiter) sweep(now time.Time) {
+	full := time.Duration(float64(m.burst) / m.perSecond * float64(time.Second))
+	if now.Sub(m.lastSweep) < full {
+		return
+	}
+
+	for key, current := range m.buckets {
+		if now.Sub(current.last) >= full {
+			delete(m.buckets, key)
+		}
+	}
+	m.lastSweep = now
+}
+
+// the bucket is updated in a script so concurrent requests can't both take
+// the last token
+const tokenBucketScript = `
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local per_ms = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local tokens = tonumber(bucket[1]) or burst
+local last = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - last) * per_ms)
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / per_ms)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'last', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / per_ms))
+return {allowed, wait}
+`
+
+type redisRateLimiter struct {
+	client    *RedisClient
+	prefix    string
+	perSecond float64
+	burst     int
+}
+
+// NewRedisRateLimiter keeps the buckets in redis, or anything speaking its
+// protocol with Lua scripting like KeyDB or Valkey.
+func NewRedisRateLimiter(client *RedisClient, prefix string, perSecond float64, burst int) RateLimiter {
+	return &redisRateLimiter{client: client, prefix: prefix, perSecond: perSecond, burst: burst}
+}
+
+func (r *redisRateLimiter) Allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	reply, err := r.client.Do(ctx, "EVAL", tokenBucketScript, "1", r.prefix+key,
+		strconv.FormatFloat(r.perSecond/1000, 'g', -1, 64),
+		strconv.Itoa(r.burst),
+		strconv.FormatInt(time.Now().UnixMilli(), 10),
+	)
+	if err != nil {
+		return false, 0, err
+	}
+
+	values, ok := reply.([]any)
+	if !ok || len(values) != 2 {
+		return false, 0, fmt.Errorf("infrastructure/rate_limiter: unexpected reply %v", reply)
+	}
+
+	allowed, _ := values[0].(int64)
+	wait, _ := values[1].(int64)
+
+	return allowed == 1, time.Duration(wait) * time.Millisecond, nil
+}
+
+// RateLimitMiddleware limits the requests of every client address. When
+// the limiter can't be reached requests go through, an outage of it
+// shouldn't take the api down.
+func RateLimitMiddleware(limiter RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowed, wait, err := limiter.Allow(c, "ip:"+c.ClientIP())
+
+		if err != nil {
+			log.Println(err.Error())
+			c.Next()
+			return
+		}
+
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests, try again later"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
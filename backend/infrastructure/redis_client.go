@@ -0,0 +1,198 @@
+package infrastructure
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RedisClient is the little of the redis protocol (RESP2) the api needs,
+// over a single connection that is opened again when it breaks.
+type RedisClient struct {
+	address  string
+	username string
+	password string
+	db       int
+	timeout  time.Duration
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// NewRedisClient takes an URL like "redis://:password@localhost:6379/0".
+func NewRedisClient(rawURL string) (*RedisClient, error) {
+	if rawURL == "" {
+		rawURL = "redis://localhost:6379"
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Scheme != "redis" {
+		return nil, errors.New("infrastructure/redis_client: invalid REDIS_URL " + rawURL)
+	}
+
+	client := &RedisClient{address: parsed.Host, timeout: 5 * time.Second}
+	if parsed.Port() == "" {
+		client.address = net.JoinHostPort(parsed.Hostname(), "6379")
+	}
+
+	if parsed.User != nil {
+		client.username = parsed.User.Username()
+		client.password, _ = parsed.User.Password()
+	}
+
+	if db := strings.Trim(parsed.Path, "/"); db != "" {
+		client.db, err = strconv.Atoi(db)
+		if err != nil {
+			return nil, errors.New("infrastructure/redis_client: invalid database " + db)
+		}
+	}
+
+	return client, nil
+}
+
+// Do runs a command and returns its reply: a string, an int64, a []any or
+// nil. Error replies are returned as errors.
+func (r *RedisClient) Do(ctx context.Context, args ...string) (any, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conn == nil {
+		if err := r.connect(ctx); err != nil {
+			return nil, errors.New("infrastructure/redis_client: " + err.Error())
+		}
+	}
+
+	reply, err := r.roundTrip(ctx, args)
+
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		// the connection is in an unknown state
+		r.conn.Close()
+		r.conn = nil
+	}
+	if err != nil {
+		return nil, errors.New("infrastructure/redis_client: " + err.Error())
+	}
+
+	return reply, nil
+}
+
+func (r *RedisClient) connect(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: r.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", r.address)
+	if err != nil {
+		return err
+	}
+
+	r.conn, r.reader = conn, bufio.NewReader(conn)
+
+	var setup [][]string
+	if r.password != "" {
+		if r.username != "" {
+			setup = append(setup, []string{"AUTH", r.username, r.password})
+		} else {
+			setup = append(setup, []string{"AUTH", r.password})
+		}
+	}
+	if r.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(r.db)})
+	}
+
+	for _, args := range setup {
+		if _, err := r.roundTrip(ctx, args); err != nil {
+			conn.Close()
+			r.conn = nil
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *RedisClient) roundTrip(ctx context.Context, args []string) (any, error) {
+	deadline := time.Now().Add(r.timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	r.conn.SetDeadline(deadline)
+
+	// commands are arrays of bulk strings
+	var command strings.Builder
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	if _, err := io.WriteString(r.conn, command.String()); err != nil {
+		return nil, err
+	}
+
+	return readRedisReply(r.reader)
+}
+
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+func readRedisReply(reader *bufio.Reader) (any, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty reply")
+	}
+
+	kind, value := line[0], line[1:]
+
+	switch kind {
+	case '+':
+		return value, nil
+	case '-':
+		return nil, redisError(value)
+	case ':':
+		return strconv.ParseInt(value, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(value)
+		if err != nil || count < 0 {
+			return nil, err
+		}
+		values := make([]any, count)
+		for i := range values {
+			item, err := readRedisReply(reader)
+			// an error element is kept as the error, the rest of the
+			// array still has to be read
+			var replyErr redisError
+			if errors.As(err, &replyErr) {
+				item = replyErr
+			} else if err != nil {
+				return nil, err
+			}
+			values[i] = item
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("unexpected reply %q", line)
+	}
+}
@@ -0,0 +1,241 @@
+package infrastructure
+
+import (
+	"fmt"
+	"github/chera/fix-it/domain"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+
+	snippetLength = 200
+)
+
+// RankChunks returns the k chunks most relevant to query, best first. Chunks
+// are compared by cosine similarity when the query and every chunk have an
+// embedding, and by BM25 otherwise. Chunks with no relevance are left out.
+func RankChunks(query string, queryEmbedding []float64, chunks []domain.DocumentChunk, k int) []domain.DocumentChunk {
+	if len(chunks) == 0 || k < 1 {
+		return nil
+	}
+
+	var scores []float64
+	if canUseEmbeddings(queryEmbedding, chunks) {
+		scores = make([]float64, len(chunks))
+		for i, chunk := range chunks {
+			scores[i] = cosine(queryEmbedding, chunk.Embedding)
+		}
+	} else {
+		texts := make([]string, len(chunks))
+		for i, chunk := range chunks {
+			texts[i] = chunk.Text
+		}
+		scores = BM25Scores(query, texts)
+	}
+
+	order := make([]int, len(chunks))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return scores[order[a]] > scores[order[b]] })
+
+	var ranked []domain.DocumentChunk
+	for _, i := range order {
+		if len(ranked) == k || scores[i] <= 0 {
+			break
+		}
+		ranked = append(ranked, chunks[i])
+	}
+
+	return ranked
+}
+
+func canUseEmbeddings(query []float64, chunks []domain.DocumentChunk) bool {
+	if len(query) == 0 {
+		return false
+	}
+	for _, chunk := range chunks {
+		if len(chunk.Embedding) != len(query) {
+			return false
+		}
+	}
+	return true
+}
+
+func cosine(a, b []float64) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// BM25Scores scores every document against query with Okapi BM25.
+func BM25Scores(query string, documents []string) []float64 {
+	scores := make([]float64, len(documents))
+	terms := retrievalTerms(query)
+
+	if len(terms) == 0 || len(documents) == 0 {
+		return scores
+	}
+
+	frequencies := make([]map[string]int, len(documents))
+	lengths := make([]int, len(documents))
+	documentFrequency := map[string]int{}
+	total := 0
+
+	for i, document := range documents {
+		frequencies[i] = map[string]int{}
+		for _, term := range retrievalTerms(document) {
+			if frequencies[i][term] == 0 {
+				documentFrequency[term]++
+			}
+			frequencies[i][term]++
+			lengths[i]++
+		}
+		total += lengths[i]
+	}
+
+	average := float64(total) / float64(len(documents))
+	if average == 0 {
+		return scores
+	}
+
+	seen := map[string]bool{}
+	for _, term := range terms {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+
+		n := float64(documentFrequency[term])
+		idf := math.Log(1 + (float64(len(documents))-n+0.5)/(n+0.5))
+
+		for i := range documents {
+			tf := float64(frequencies[i][term])
+			if tf == 0 {
+				continue
+			}
+			scores[i] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(lengths[i])/average))
+		}
+	}
+
+	return scores
+}
+
+var retrievalStopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "are": true, "was": true, "what": true,
+	"which": true, "with": true, "that": true, "this": true, "from": true, "how": true,
+	"why": true, "does": true, "not": true, "its": true, "into": true, "can": true,
+}
+
+func retrievalTerms(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	terms := words[:0]
+	for _, word := range words {
+		if len(word) > 2 && !retrievalStopWords[word] {
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
+
+// Cite builds the citation of a chunk, optionally tied to a question.
+func Cite(chunk domain.DocumentChunk, questionNO int) domain.Citation {
+	return domain.Citation{
+		QuestionNO: questionNO,
+		FirstPage:  chunk.FirstPage,
+		LastPage:   chunk.LastPage,
+		Snippet:    Snippet(chunk.Text, snippetLength),
+	}
+}
+
+// Snippet shortens text to about limit bytes on a word boundary.
+func Snippet(text string, limit int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) <= limit {
+		return text
+	}
+
+	cut := strings.LastIndex(text[:limit], " ")
+	if cut <= 0 {
+		cut = limit
+	}
+	return text[:cut] + "..."
+}
+
+// GroundingPrompt lists the retrieved passages for the model to rely on.
+func GroundingPrompt(chunks []domain.DocumentChunk) string {
+	if len(chunks) == 0 {
+		return ""
+	}
+
+	var builder strings.Builder
+	builder.WriteString("Relevant passages from the document, use them and mention the page when you rely on one:\n")
+
+	for _, chunk := range chunks {
+		if chunk.FirstPage == chunk.LastPage {
+			fmt.Fprintf(&builder, "[page %d]\n%s\n\n", chunk.FirstPage, chunk.Text)
+		} else {
+			fmt.Fprintf(&builder, "[pages %d-%d]\n%s\n\n", chunk.FirstPage, chunk.LastPage, chunk.Text)
+		}
+	}
+
+	return builder.String()
+}
+
+// AttachCitations hands every explanation the citations of its question.
+func AttachCitations(explanations []domain.QeustionAnswer, sources []domain.Citation) []domain.QeustionAnswer {
+	for i := range explanations {
+		for _, source := range sources {
+			if source.QuestionNO == explanations[i].QuestionNumber {
+				explanations[i].Sources = append(explanations[i].Sources, source)
+			}
+		}
+	}
+	return explanations
+}
+
+// QuestionQuery is the retrieval query for a question: its text and the
+// text of its correct answer.
+func QuestionQuery(question domain.Question) string {
+	query := question.Question + " " + question.Answer
+
+	if question.Type != domain.QuestionFillBlank && question.Type != domain.QuestionShortAnswer {
+		for _, letter := range strings.Split(question.Answer, ",") {
+			query += " " + choiceText(question, strings.ToLower(strings.TrimSpace(letter)))
+		}
+	}
+
+	return query
+}
+
+// MergeRetrieved joins ranked passage lists, dropping repeated passages.
+func MergeRetrieved(lists [][]domain.DocumentChunk) []domain.DocumentChunk {
+	seen := map[int]bool{}
+	var merged []domain.DocumentChunk
+
+	for _, list := range lists {
+		for _, chunk := range list {
+			if !seen[chunk.Index] {
+				seen[chunk.Index] = true
+				merged = append(merged, chunk)
+			}
+		}
+	}
+
+	return merged
+}
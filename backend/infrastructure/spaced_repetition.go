@@ -0,0 +1,81 @@
+package infrastructure
+
+import (
+	"errors"
+	"github/chera/fix-it/domain"
+	"math"
+	"time"
+)
+
+// SM-2 answer qualities. Anything below QualityPass resets the item.
+const (
+	QualityBlackout = 0
+	QualityWrong    = 1
+	QualityPass     = 3
+	QualityGood     = 4
+	QualityPerfect  = 5
+
+	initialEaseFactor = 2.5
+	minimumEaseFactor = 1.3
+)
+
+// NewReviewItem returns an item that has never been reviewed.
+func NewReviewItem(now time.Time) domain.ReviewItem {
+	return domain.ReviewItem{
+		EaseFactor: initialEaseFactor,
+		DueAt:      now,
+		CreatedAt:  now,
+	}
+}
+
+// QualityFromResult maps a graded quiz answer to an SM-2 quality.
+func QualityFromResult(correct bool) int {
+	if correct {
+		return QualityGood
+	}
+	return QualityWrong
+}
+
+// ScheduleReview applies one SM-2 review of the given quality to item.
+func ScheduleReview(item *domain.ReviewItem, quality int, now time.Time) error {
+	if quality < QualityBlackout || quality > QualityPerfect {
+		return errors.New("infrastructure/spaced_repetition: quality must be between 0 and 5")
+	}
+
+	if item.EaseFactor == 0 {
+		item.EaseFactor = initialEaseFactor
+	}
+
+	if quality < QualityPass {
+		if item.Repetitions > 0 {
+			item.Lapses++
+		}
+		item.Repetitions = 0
+		item.IntervalDays = 1
+	} else {
+		switch item.Repetitions {
+		case 0:
+			item.IntervalDays = 1
+		case 1:
+			item.IntervalDays = 6
+		default:
+			item.IntervalDays = int(math.Round(float64(item.IntervalDays) * item.EaseFactor))
+		}
+		item.Repetitions++
+	}
+
+	q := float64(QualityPerfect - quality)
+	item.EaseFactor = math.Max(minimumEaseFactor, item.EaseFactor+0.1-q*(0.08+q*0.02))
+
+	item.LastReviewedAt = now
+	item.DueAt = now.AddDate(0, 0, item.IntervalDays)
+
+	return nil
+}
+
+// CountsAsReview reports whether an answer given now should move the item.
+// Answering correctly ahead of schedule would only inflate the interval, so
+// early answers count when they are wrong.
+func CountsAsReview(item domain.ReviewItem, correct bool, now time.Time) bool {
+	return item.LastReviewedAt.IsZero() || !correct || !now.Before(item.DueAt)
+}
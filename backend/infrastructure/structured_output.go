@@ -0,0 +1,537 @@
+package infrastructure
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github/chera/fix-it/domain"
+	"log"
+	"strings"
+)
+
+// ResponseSchema is the subset of JSON schema the providers agree on. It is
+// translated to each provider's own representation by the adapters.
+type ResponseSchema struct {
+	Type        string
+	Description string
+	Properties  map[string]*ResponseSchema
+	Required    []string
+	Items       *ResponseSchema
+	Enum        []string
+}
+
+// JSONSchema renders the schema as a standard JSON schema document.
+func (s *ResponseSchema) JSONSchema() map[string]interface{} {
+	schema := map[string]interface{}{"type": s.Type}
+
+	if s.Description != "" {
+		schema["description"] = s.Description
+	}
+	if len(s.Enum) > 0 {
+		schema["enum"] = s.Enum
+	}
+	if s.Items != nil {
+		schema["items"] = s.Items.JSONSchema()
+	}
+	if len(s.Properties) > 0 {
+		properties := map[string]interface{}{}
+		for name, property := range s.Properties {
+			properties[name] = property.JSONSchema()
+		}
+		schema["properties"] = properties
+		schema["additionalProperties"] = false
+	}
+	if len(s.Required) > 0 {
+		schema["required"] = s.Required
+	}
+
+	return schema
+}
+
+func stringSchema(description string) *ResponseSchema {
+	return &ResponseSchema{Type: "string", Description: description}
+}
+
+func objectSchema(properties map[string]*ResponseSchema) *ResponseSchema {
+	required := make([]string, 0, len(properties))
+	for name := range properties {
+		required = append(required, name)
+	}
+	return &ResponseSchema{Type: "object", Properties: properties, Required: required}
+}
+
+func listSchema(field string, item *ResponseSchema) *ResponseSchema {
+	return objectSchema(map[string]*ResponseSchema{
+		field: {Type: "array", Items: item},
+	})
+}
+
+var QuestionsSchema = listSchema("questions", &ResponseSchema{
+	Type: "object",
+	Properties: map[string]*ResponseSchema{
+		"type": {Type: "string", Enum: []string{
+			domain.QuestionSingleChoice, domain.QuestionMultiSelect, domain.QuestionTrueFalse,
+			domain.QuestionFillBlank, domain.QuestionShortAnswer,
+		}},
+		"question":         stringSchema("the question text, fill in the blank questions mark the blank with ____"),
+		"a":                stringSchema("alternative A, choice questions only"),
+		"b":                stringSchema("alternative B, choice questions only"),
+		"c":                stringSchema("alternative C, choice questions only"),
+		"d":                stringSchema("alternative D, choice questions only"),
+		"answer":           stringSchema("correct letter, comma separated letters for multi_select, True or False, or the expected text"),
+		"accepted_answers": {Type: "array", Items: stringSchema("another acceptable answer")},
+		"keywords":         {Type: "array", Items: stringSchema("a key term a correct short answer mentions")},
+		"pages":            {Type: "array", Items: &ResponseSchema{Type: "integer", Description: "a page number from the [page N] markers the question is based on"}},
+		"quote":            stringSchema("a short sentence copied exactly from the text that supports the answer"),
+	},
+	Required: []string{"type", "question", "answer"},
+})
+
+var ExplanationsSchema = listSchema("answers", objectSchema(map[string]*ResponseSchema{
+	"question_number": {Type: "integer"},
+	"correct_answer":  stringSchema("the correct answer"),
+	"your_answer":     stringSchema("the answer the user gave"),
+	"correctness":     {Type: "boolean", Description: "true when the user's answer is correct"},
+	"explanation":     stringSchema("why the given answer is wrong, empty when it is correct"),
+}))
+
+var TopicsSchema = listSchema("topics", objectSchema(map[string]*ResponseSchema{
+	"title":       stringSchema("title of the weak point"),
+	"explanation": stringSchema("detailed explanation of the topic"),
+}))
+
+// StructuredOutputAttempts is how many times a malformed response is sent
+// back to the model for repair before giving up.
+const StructuredOutputAttempts = 3
+
+// GenerateStructured asks for JSON matching schema and re-asks the model,
+// quoting the validation error, until validate accepts the response. The
+// last response is returned together with the error when every attempt
+// fails so callers can still keep the valid parts of it.
+func GenerateStructured(ctx context.Context, client LLMClient, history []domain.ConversationTurn, prompt string, schema *ResponseSchema, validate func(raw string) error) (string, error) {
+	raw, err := client.GenerateJSON(ctx, history, prompt, schema)
+
+	return repairStructured(ctx, client, history, prompt, schema, validate, raw, err)
+}
+
+// StreamStructured is GenerateStructured with the first response streamed
+// to onChunk. Repairs are not streamed, the caller gets the final response.
+func StreamStructured(ctx context.Context, client LLMClient, history []domain.ConversationTurn, prompt string, schema *ResponseSchema, validate func(raw string) error, onChunk func(chunk string) error) (string, error) {
+	raw, err := client.GenerateStream(ctx, history, prompt, schema, onChunk)
+
+	return repairStructured(ctx, client, history, prompt, schema, validate, raw, err)
+}
+
+func repairStructured(ctx context.Context, client LLMClient, history []domain.ConversationTurn, prompt string, schema *ResponseSchema, validate func(raw string) error, raw string, err error) (string, error) {
+	for attempt := 1; ; attempt++ {
+		if err != nil {
+			return "", err
+		}
+
+		invalid := validate(raw)
+		if invalid == nil {
+			return raw, nil
+		}
+
+		if attempt >= StructuredOutputAttempts {
+			return raw, fmt.Errorf("invalid structured output after %d attempts: %w", attempt, invalid)
+		}
+
+		repairHistory := append(append([]domain.ConversationTurn{}, history...), domain.ConversationTurn{User: prompt, Gemini: raw})
+		repair := fmt.Sprintf(`Your previous response could not be used: %s
+Reply again with only the corrected JSON, matching the requested schema exactly.`, invalid.Error())
+
+		raw, err = client.GenerateJSON(ctx, repairHistory, repair, schema)
+	}
+}
+
+// decodeStrict decodes a JSON response, tolerating markdown code fences but
+// rejecting unknown fields and trailing data.
+func decodeStrict(raw string, target interface{}) error {
+	raw = strings.TrimSpace(raw)
+	raw = strings.TrimPrefix(raw, "```json")
+	raw = strings.TrimPrefix(raw, "```")
+	raw = strings.TrimSuffix(raw, "```")
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(raw)))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(target); err != nil {
+		return fmt.Errorf("response is not valid JSON for the schema: %w", err)
+	}
+
+	if decoder.More() {
+		return errors.New("response has data after the JSON document")
+	}
+
+	return nil
+}
+
+type questionJSON struct {
+	Type            string   `json:"type"`
+	Question        string   `json:"question"`
+	A               string   `json:"a,omitempty"`
+	B               string   `json:"b,omitempty"`
+	C               string   `json:"c,omitempty"`
+	D               string   `json:"d,omitempty"`
+	Answer          string   `json:"answer"`
+	AcceptedAnswers []string `json:"accepted_answers,omitempty"`
+	Keywords        []string `json:"keywords,omitempty"`
+	Pages           []int    `json:"pages,omitempty"`
+	Quote           string   `json:"quote,omitempty"`
+}
+
+// DecodeQuestions reads a quiz response, any invalid question makes the
+// whole response invalid so the model is asked to repair it.
+func DecodeQuestions(raw string) ([]domain.Question, error) {
+	return decodeQuestions(raw, true)
+}
+
+// ReadQuestions reads a quiz response keeping the valid questions and
+// dropping the others. Only a response that isn't JSON or has no usable
+// question at all is an error.
+func ReadQuestions(raw string) ([]domain.Question, error) {
+	return decodeQuestions(raw, false)
+}
+
+func decodeQuestions(raw string, strict bool) ([]domain.Question, error) {
+	var payload struct {
+		Questions []questionJSON `json:"questions"`
+	}
+
+	if err := decodeStrict(raw, &payload); err != nil {
+		return nil, err
+	}
+
+	questions := make([]domain.Question, 0, len(payload.Questions))
+	for i, q := range payload.Questions {
+		question, err := validateQuestion(q)
+		if err != nil && strict {
+			return nil, fmt.Errorf("question %d: %w", i+1, err)
+		}
+		if err != nil {
+			log.Printf("infrastructure/structured_output: dropped question %d: %v", i+1, err)
+			continue
+		}
+		questions = append(questions, question)
+	}
+
+	if len(questions) == 0 {
+		return nil, errors.New("response has no questions")
+	}
+
+	return questions, nil
+}
+
+func validateQuestion(q questionJSON) (domain.Question, error) {
+	question := domain.Question{
+		Type:            q.Type,
+		Question:        strings.TrimSpace(q.Question),
+		A:               strings.TrimSpace(q.A),
+		B:               strings.TrimSpace(q.B),
+		C:               strings.TrimSpace(q.C),
+		D:               strings.TrimSpace(q.D),
+		Answer:          strings.TrimSpace(q.Answer),
+		AcceptedAnswers: q.AcceptedAnswers,
+		Keywords:        q.Keywords,
+	}
+
+	if question.Type == "" {
+		question.Type = domain.QuestionSingleChoice
+	}
+
+	// citations are checked against the document later, not here
+	if len(q.Pages) > 0 || strings.TrimSpace(q.Quote) != "" {
+		question.Source = &domain.Citation{Snippet: strings.TrimSpace(q.Quote)}
+		for i, page := range q.Pages {
+			if i == 0 || page < question.Source.FirstPage {
+				question.Source.FirstPage = page
+			}
+			if page > question.Source.LastPage {
+				question.Source.LastPage = page
+			}
+		}
+	}
+
+	if question.Question == "" {
+		return question, errors.New("has no text")
+	}
+
+	if question.Answer == "" {
+		return question, errors.New("has no answer")
+	}
+
+	fourOptions := question.A != "" && question.B != "" && question.C != "" && question.D != ""
+
+	switch question.Type {
+	case domain.QuestionSingleChoice:
+		question.Answer = strings.ToUpper(question.Answer)
+		if !fourOptions {
+			return question, errors.New("must have the four alternatives a, b, c and d")
+		}
+		if !validLetter(question.Answer) {
+			return question, fmt.Errorf("has answer %q, it must be one of A, B, C or D", q.Answer)
+		}
+	case domain.QuestionMultiSelect:
+		if !fourOptions {
+			return question, errors.New("must have the four alternatives a, b, c and d")
+		}
+		var letters []string
+		for _, letter := range strings.Split(strings.ToUpper(question.Answer), ",") {
+			letter = strings.TrimSpace(letter)
+			if !validLetter(letter) {
+				return question, fmt.Errorf("has answer %q, it must be comma separated letters from A to D", q.Answer)
+			}
+			letters = append(letters, letter)
+		}
+		question.Answer = strings.Join(letters, ",")
+	case domain.QuestionTrueFalse:
+		switch strings.ToLower(question.Answer) {
+		case "true", "a":
+			question.Answer = "A"
+		case "false", "b":
+			question.Answer = "B"
+		default:
+			return question, fmt.Errorf("has answer %q, it must be True or False", q.Answer)
+		}
+		question.A, question.B, question.C, question.D = "True", "False", "", ""
+	case domain.QuestionFillBlank:
+		if !strings.Contains(question.Question, "__") {
+			return question, errors.New("must mark the blank with ____")
+		}
+	case domain.QuestionShortAnswer:
+	default:
+		return question, fmt.Errorf("has unknown type %q", q.Type)
+	}
+
+	return question, nil
+}
+
+func validLetter(letter string) bool {
+	return letter == "A" || letter == "B" || letter == "C" || letter == "D"
+}
+
+// EncodeQuestions renders questions in the JSON layout DecodeQuestions reads.
+func EncodeQuestions(questions []domain.Question) string {
+	payload := struct {
+		Questions []questionJSON `json:"questions"`
+	}{}
+
+	for _, q := range questions {
+		encoded := questionJSON{
+			Type: q.Type, Question: q.Question, A: q.A, B: q.B, C: q.C, D: q.D,
+			Answer: q.Answer, AcceptedAnswers: q.AcceptedAnswers, Keywords: q.Keywords,
+		}
+		if q.Source != nil {
+			for page := q.Source.FirstPage; page <= q.Source.LastPage && page > 0; page++ {
+				encoded.Pages = append(encoded.Pages, page)
+			}
+			encoded.Quote = q.Source.Snippet
+		}
+		payload.Questions = append(payload.Questions, encoded)
+	}
+
+	encoded, _ := json.Marshal(payload)
+	return string(encoded)
+}
+
+// DecodeExplanations reads an explanation response, rejecting it whole when
+// an answer is invalid.
+func DecodeExplanations(raw string) ([]domain.QeustionAnswer, error) {
+	return decodeExplanations(raw, true)
+}
+
+// ReadExplanations reads an explanation response dropping the invalid
+// answers.
+func ReadExplanations(raw string) ([]domain.QeustionAnswer, error) {
+	return decodeExplanations(raw, false)
+}
+
+func decodeExplanations(raw string, strict bool) ([]domain.QeustionAnswer, error) {
+	var payload struct {
+		Answers []domain.QeustionAnswer `json:"answers"`
+	}
+
+	if err := decodeStrict(raw, &payload); err != nil {
+		return nil, err
+	}
+
+	answers := make([]domain.QeustionAnswer, 0, len(payload.Answers))
+	for i, answer := range payload.Answers {
+		err := validateExplanation(answer)
+		if err != nil && strict {
+			return nil, fmt.Errorf("answer %d %w", i+1, err)
+		}
+		if err != nil {
+			log.Printf("infrastructure/structured_output: dropped answer %d: %v", i+1, err)
+			continue
+		}
+		answers = append(answers, answer)
+	}
+
+	if len(answers) == 0 {
+		return nil, errors.New("response has no answers")
+	}
+
+	return answers, nil
+}
+
+func validateExplanation(answer domain.QeustionAnswer) error {
+	if answer.QuestionNumber < 1 {
+		return errors.New("has no question_number")
+	}
+	if !answer.Correctness && strings.TrimSpace(answer.Explanation) == "" {
+		return fmt.Errorf("for question %d is incorrect but has no explanation", answer.QuestionNumber)
+	}
+	return nil
+}
+
+// EncodeExplanations renders answers in the JSON layout DecodeExplanations
+// reads.
+func EncodeExplanations(answers []domain.QeustionAnswer) string {
+	encoded, _ := json.Marshal(struct {
+		Answers []domain.QeustionAnswer `json:"answers"`
+	}{answers})
+	return string(encoded)
+}
+
+type topicJSON struct {
+	Title       string `json:"title"`
+	Explanation string `json:"explanation"`
+}
+
+// DecodeTopics reads a topic response, rejecting it whole when a topic is
+// invalid. No topics at all is valid, every answer was right.
+func DecodeTopics(raw string) (domain.TopicList, error) {
+	return decodeTopics(raw, true)
+}
+
+// ReadTopics reads a topic response dropping the invalid topics.
+func ReadTopics(raw string) (domain.TopicList, error) {
+	return decodeTopics(raw, false)
+}
+
+func decodeTopics(raw string, strict bool) (domain.TopicList, error) {
+	var payload struct {
+		Topics []topicJSON `json:"topics"`
+	}
+
+	if err := decodeStrict(raw, &payload); err != nil {
+		return domain.TopicList{}, err
+	}
+
+	var topics domain.TopicList
+	for i, topic := range payload.Topics {
+		if strings.TrimSpace(topic.Title) == "" || strings.TrimSpace(topic.Explanation) == "" {
+			err := fmt.Errorf("topic %d needs both a title and an explanation", i+1)
+			if strict {
+				return domain.TopicList{}, err
+			}
+			log.Printf("infrastructure/structured_output: dropped %v", err)
+			continue
+		}
+		topics.Topics = append(topics.Topics, domain.Topic{Title: topic.Title, Explanation: topic.Explanation})
+	}
+
+	if len(payload.Topics) > 0 && len(topics.Topics) == 0 {
+		return domain.TopicList{}, errors.New("response has no valid topics")
+	}
+
+	return topics, nil
+}
+
+// EncodeTopics renders topics in the JSON layout DecodeTopics reads.
+func EncodeTopics(topics domain.TopicList) string {
+	payload := struct {
+		Topics []topicJSON `json:"topics"`
+	}{Topics: []topicJSON{}}
+
+	for _, topic := range topics.Topics {
+		payload.Topics = append(payload.Topics, topicJSON{Title: topic.Title, Explanation: topic.Explanation})
+	}
+
+	encoded, _ := json.Marshal(payload)
+	return string(encoded)
+}
+
+// SalvageQuestions keeps what can be used of a quiz response the model
+// couldn't repair: its valid questions, or failing that the questions the
+// legacy text parser finds, checked and encoded as JSON again.
+func SalvageQuestions(raw string) (string, error) {
+	questions, err := ReadQuestions(raw)
+	if err != nil {
+		// the legacy parser makes a question of any line, they're checked too
+		if questions, err = ReadQuestions(EncodeQuestions(ParseQuestions(raw))); err != nil {
+			return "", err
+		}
+	}
+	return EncodeQuestions(questions), nil
+}
+
+// SalvageExplanations keeps the answers of an explanation response the
+// model couldn't repair, like SalvageQuestions.
+func SalvageExplanations(raw string) (string, error) {
+	answers, err := ReadExplanations(raw)
+	if err != nil {
+		if answers, err = ReadExplanations(EncodeExplanations(ParseGeminiAnswer(raw))); err != nil {
+			return "", err
+		}
+	}
+	return EncodeExplanations(answers), nil
+}
+
+// SalvageTopics keeps the topics of a topic response the model couldn't
+// repair, like SalvageQuestions.
+func SalvageTopics(raw string) (string, error) {
+	topics, err := ReadTopics(raw)
+	if err != nil {
+		if topics, err = ReadTopics(EncodeTopics(ParseTopicGemini(raw))); err != nil || len(topics.Topics) == 0 {
+			return "", errors.New("response has no valid topics")
+		}
+	}
+	return EncodeTopics(topics), nil
+}
+
+// storedJSON tells responses stored as JSON from the line based ones stored
+// before, only those are still read with the legacy parsers.
+func storedJSON(raw string) bool {
+	raw = strings.TrimSpace(raw)
+	return strings.HasPrefix(raw, "{") || strings.HasPrefix(raw, "```")
+}
+
+// ParseQuestionsResponse reads a stored quiz response, keeping its valid
+// questions.
+func ParseQuestionsResponse(raw string) []domain.Question {
+	if !storedJSON(raw) {
+		return ParseQuestions(raw)
+	}
+	questions, err := ReadQuestions(raw)
+	if err != nil {
+		log.Println("infrastructure/structured_output: stored questions " + err.Error())
+	}
+	return questions
+}
+
+func ParseExplanationResponse(raw string) []domain.QeustionAnswer {
+	if !storedJSON(raw) {
+		return ParseGeminiAnswer(raw)
+	}
+	answers, err := ReadExplanations(raw)
+	if err != nil {
+		log.Println("infrastructure/structured_output: stored explanation " + err.Error())
+	}
+	return answers
+}
+
+func ParseTopicResponse(raw string) domain.TopicList {
+	if !storedJSON(raw) {
+		return ParseTopicGemini(raw)
+	}
+	topics, err := ReadTopics(raw)
+	if err != nil {
+		log.Println("infrastructure/structured_output: stored topics " + err.Error())
+	}
+	return topics
+}
@@ -0,0 +1,47 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"github/chera/fix-it/domain"
+	"io"
+	"os"
+	"strings"
+)
+
+// TextExtractor turns an uploaded document into its text, one entry per page.
+type TextExtractor interface {
+	Extract(ctx context.Context, file io.Reader, filename string) ([]domain.DocumentPage, error)
+}
+
+// NewTextExtractor builds the extractor of every supported format, with
+// PDFs handled by the extractor selected by TEXT_EXTRACTOR. Supported values
+// are "local" (default) and "pdfco".
+func NewTextExtractor() (TextExtractor, error) {
+	extractor := os.Getenv("TEXT_EXTRACTOR")
+
+	switch extractor {
+	case "", "local":
+		return NewExtractorRegistry(NewLocalPDFExtractor()), nil
+	case "pdfco":
+		apiKey, exist := os.LookupEnv("PDFCO_API_KEY")
+		if !exist {
+			return nil, errors.New("infrastructure/text_extractor: PDFCO_API_KEY not found")
+		}
+		return NewExtractorRegistry(NewPDFCoExtractor(apiKey)), nil
+	}
+
+	return nil, errors.New("infrastructure/text_extractor: unknown TEXT_EXTRACTOR " + extractor)
+}
+
+// JoinPages concatenates the text of every page into a single string.
+func JoinPages(pages []domain.DocumentPage) string {
+	texts := make([]string, 0, len(pages))
+	for _, page := range pages {
+		text := strings.TrimSpace(page.Text)
+		if text != "" {
+			texts = append(texts, text)
+		}
+	}
+	return strings.Join(texts, "\n\n")
+}
@@ -0,0 +1,127 @@
+package infrastructure
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP codes as authenticator apps make them by default: RFC 6238 with
+// SHA-1, 6 digits and 30 second steps.
+const (
+	totpDigits = 6
+	totpPeriod = 30
+	// codes of the step before and after are accepted too, for clocks that
+	// are a little off
+	totpSkew = 1
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret is a random 160 bit secret, base32 encoded like
+// authenticator apps expect it.
+func NewTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", errors.New("infrastructure/totp: " + err.Error())
+	}
+	return base32NoPadding.EncodeToString(secret), nil
+}
+
+// TOTPURI is the otpauth:// URI shown as a QR code to add the secret to an
+// authenticator app.
+func TOTPURI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// TOTPStep is the time step a moment falls in.
+func TOTPStep(at time.Time) int64 {
+	return at.Unix() / totpPeriod
+}
+
+// TOTPCode is the code of a secret for a time step.
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", errors.New("infrastructure/totp: invalid secret")
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation of RFC 4226
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// VerifyTOTP checks a code against the steps around at and returns the step
+// it belongs to. Callers keep the last step used, so a code can't be used
+// twice.
+func VerifyTOTP(secret, code string, at time.Time) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := TOTPStep(at)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		expected, err := TOTPCode(secret, step)
+		if err == nil && hmac.Equal([]byte(expected), []byte(code)) {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// NewRecoveryCodes are one time codes for when the authenticator app is
+// lost, like "k7q2-m9xd".
+func NewRecoveryCodes(count int) ([]string, error) {
+	// 32 letters and digits, without the ones easily mixed up
+	const alphabet = "abcdefghjkmnpqrstuvwxyz123456789"
+
+	codes := make([]string, count)
+	buf := make([]byte, 8)
+
+	for i := range codes {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, errors.New("infrastructure/totp: " + err.Error())
+		}
+		for j := range buf {
+			buf[j] = alphabet[int(buf[j])%len(alphabet)]
+		}
+		codes[i] = string(buf[:4]) + "-" + string(buf[4:])
+	}
+
+	return codes, nil
+}
+
+// HashRecoveryCode is how recovery codes are stored, ignoring case and the
+// dash people may leave out.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+	return HashToken(code)
+}
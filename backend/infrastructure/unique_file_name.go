@@ -5,6 +5,6 @@ import (
 	"time"
 )
 
-func GetUniqueFileName() string {
-	return time.Now().Format("20060102150405") + fmt.Sprintf("%06d", time.Now().Nanosecond()/1000) + ".pdf"
+func GetUniqueFileName(extension string) string {
+	return time.Now().Format("20060102150405") + fmt.Sprintf("%06d", time.Now().Nanosecond()/1000) + extension
 }
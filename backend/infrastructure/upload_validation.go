@@ -0,0 +1,169 @@
+package infrastructure
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"github/chera/fix-it/domain"
+	"path/filepath"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// Codes of the reasons an upload is refused.
+const (
+	UploadTooLarge           = "file_too_large"
+	UploadUnsupportedType    = "unsupported_type"
+	UploadTypeMismatch       = "type_mismatch"
+	UploadEncrypted          = "encrypted_pdf"
+	UploadCorrupt            = "corrupt_file"
+	UploadTooManyPages       = "too_many_pages"
+	UploadInfected           = "infected_file"
+	UploadScannerUnavailable = "scanner_unavailable"
+)
+
+// UploadError is why an upload was refused, with a code clients can act on.
+type UploadError struct {
+	Code    string
+	Message string
+}
+
+func (e *UploadError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// jobs keep the uploaded file inline, which has to fit in a mongo document
+const maxInlineUpload = 15 << 20
+
+type UploadLimits struct {
+	MaxBytes int64
+	MaxPages int
+}
+
+// LoadUploadLimits reads MAX_UPLOAD_MB and MAX_UPLOAD_PAGES. Uploads can't
+// be larger than 15 MB, which is also the default.
+func LoadUploadLimits() UploadLimits {
+	maxBytes := int64(envInt("MAX_UPLOAD_MB", 15)) << 20
+	if maxBytes > maxInlineUpload {
+		maxBytes = maxInlineUpload
+	}
+
+	return UploadLimits{
+		MaxBytes: maxBytes,
+		MaxPages: envInt("MAX_UPLOAD_PAGES", 500),
+	}
+}
+
+var extensionMediaTypes = map[string]string{
+	".pdf":      domain.MediaTypePDF,
+	".docx":     domain.MediaTypeDOCX,
+	".pptx":     domain.MediaTypePPTX,
+	".epub":     domain.MediaTypeEPUB,
+	".md":       domain.MediaTypeMarkdown,
+	".markdown": domain.MediaTypeMarkdown,
+	".html":     domain.MediaTypeHTML,
+	".htm":      domain.MediaTypeHTML,
+	".txt":      domain.MediaTypeText,
+}
+
+func isTextMediaType(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "text/")
+}
+
+// ValidateUpload checks an upload before anything is done with it and
+// returns its media type. Refusals are *UploadError.
+func ValidateUpload(content []byte, filename string, limits UploadLimits) (string, error) {
+	if int64(len(content)) > limits.MaxBytes {
+		return "", &UploadError{Code: UploadTooLarge, Message: fmt.Sprintf("files can be at most %d MB", limits.MaxBytes>>20)}
+	}
+
+	mediaType, err := DetectMediaType(content, filename)
+	if err != nil {
+		return "", &UploadError{Code: UploadUnsupportedType, Message: "upload a PDF, DOCX, PPTX, EPUB, Markdown, HTML or text file"}
+	}
+
+	// the content decides the format, but a file named like one format
+	// holding another is more likely broken or disguised than mislabeled
+	claimed, known := extensionMediaTypes[strings.ToLower(filepath.Ext(filename))]
+	if known && claimed != mediaType && !(isTextMediaType(claimed) && isTextMediaType(mediaType)) {
+		return "", &UploadError{Code: UploadTypeMismatch, Message: fmt.Sprintf("%s does not contain what its extension says", filename)}
+	}
+
+	pages := 0
+
+	switch mediaType {
+	case domain.MediaTypePDF:
+		pages, err = pdfPageCount(content)
+	case domain.MediaTypePPTX:
+		pages, err = pptxSlideCount(content)
+	case domain.MediaTypeDOCX, domain.MediaTypeEPUB:
+		_, err = openZipUpload(content, "the document")
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if limits.MaxPages > 0 && pages > limits.MaxPages {
+		return "", &UploadError{Code: UploadTooManyPages, Message: fmt.Sprintf("files can have at most %d pages, this one has %d", limits.MaxPages, pages)}
+	}
+
+	return mediaType, nil
+}
+
+// pdfPageCount opens a PDF far enough to tell whether it can be read at all.
+func pdfPageCount(content []byte) (pages int, err error) {
+	// the pdf reader panics on some malformed objects
+	defer func() {
+		if recover() != nil {
+			pages, err = 0, &UploadError{Code: UploadCorrupt, Message: "the pdf could not be read"}
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(content), int64(len(content)))
+
+	if errors.Is(err, pdf.ErrInvalidPassword) || (err != nil && strings.Contains(err.Error(), "encryption")) {
+		return 0, &UploadError{Code: UploadEncrypted, Message: "the pdf is password protected, upload it without a password"}
+	}
+
+	if err != nil {
+		return 0, &UploadError{Code: UploadCorrupt, Message: "the pdf could not be read, it may be damaged"}
+	}
+
+	if reader.NumPage() == 0 {
+		return 0, &UploadError{Code: UploadCorrupt, Message: "the pdf has no pages"}
+	}
+
+	return reader.NumPage(), nil
+}
+
+// openZipUpload opens an office file or e-book, refusing the ones that
+// unpack to too much.
+func openZipUpload(content []byte, what string) (map[string]*zip.File, error) {
+	archive, err := openZip(content)
+	if err == errZipTooLarge {
+		return nil, err
+	}
+	if err != nil {
+		return nil, &UploadError{Code: UploadCorrupt, Message: what + " could not be read"}
+	}
+	return archive, nil
+}
+
+func pptxSlideCount(content []byte) (int, error) {
+	files, err := openZipUpload(content, "the presentation")
+	if err != nil {
+		return 0, err
+	}
+
+	slides := 0
+	for name := range files {
+		if slidePattern.MatchString(name) {
+			slides++
+		}
+	}
+
+	return slides, nil
+}
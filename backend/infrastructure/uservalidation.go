@@ -38,6 +38,29 @@ func SignUpValidateUser(user domain.User) error {
 	return nil
 }
 
+// ProfileValidateUser checks what users of an OpenID Connect provider fill
+// in themselves, the provider gives the email and there is no password.
+func ProfileValidateUser(user domain.User) error {
+	if user.Username == "" {
+		return errors.New("infrastructure/uservalidation: username is required")
+	}
+
+	// usernames have to be ones SignInValidateUser accepts
+	if !regexp.MustCompile(`^[a-z0-9_]+$`).MatchString(user.Username) {
+		return errors.New("infrastructure/uservalidation: username can only have lowercase letters, digits and _")
+	}
+
+	if user.Age == 0 {
+		return errors.New("infrastructure/uservalidation: age is required")
+	}
+
+	if user.Academic != "Undergraduated" && user.Academic != "High School" {
+		return errors.New("infrastructure/uservalidation: academic must be Undergraduated or High School")
+	}
+
+	return nil
+}
+
 func SignInValidateUser(user *domain.User) error {
 	if user.Email == "" {
 		return errors.New("infrastructure/uservalidation: email is required")
@@ -64,3 +87,12 @@ func SignInValidateUser(user *domain.User) error {
 	return nil
 
 }
+
+// ValidatePassword checks a new password, when it is reset or changed.
+func ValidatePassword(password string) error {
+	if len(password) < 6 {
+		return errors.New("infrastructure/uservalidation: password must be at least 6 characters")
+	}
+
+	return nil
+}
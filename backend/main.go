@@ -10,6 +10,7 @@ import (
 	"github/chera/fix-it/usecases"
 	"log"
 	"os"
+	"strconv"
 
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -46,14 +47,56 @@ func main() {
 		}
 	}(client)
 
-	// Gemini model loading
-	gem_model, gem_context, err := infrastructure.NewGeminiModel()
+	// LLM client loading, the provider is selected by LLM_PROVIDER
+	llmClient, err := infrastructure.NewLLMClient()
 
 	if err != nil {
-		log.Fatalf("could not load gemini model: %v", err)
+		log.Fatalf("could not load llm client: %v", err)
 	}
 
-	fmt.Println("✅ Gemini model loaded successfully")
+	fmt.Printf("✅ %s model loaded successfully\n", llmClient.ModelInfo().Provider)
+
+	// text extraction backend, local unless TEXT_EXTRACTOR says otherwise
+	textExtractor, err := infrastructure.NewTextExtractor()
+
+	if err != nil {
+		log.Fatalf("could not load text extractor: %v", err)
+	}
+
+	embedder, err := infrastructure.NewEmbedder()
+
+	if err != nil {
+		log.Fatalf("could not load embedder: %v", err)
+	}
+
+	// original uploads, kept on disk unless BLOB_STORAGE says otherwise
+	blobs, err := infrastructure.NewBlobStorage()
+
+	if err != nil {
+		log.Fatalf("could not load blob storage: %v", err)
+	}
+
+	// email delivery, over smtp unless MAILER says otherwise
+	mailer, err := infrastructure.NewMailer()
+
+	if err != nil {
+		log.Fatalf("could not load mailer: %v", err)
+	}
+
+	// requests to /u are limited per address, in memory unless
+	// RATE_LIMIT_BACKEND says otherwise
+	limiter, err := infrastructure.NewRateLimiter()
+
+	if err != nil {
+		log.Fatalf("could not load rate limiter: %v", err)
+	}
+
+	// uploads are scanned for malware only when MALWARE_SCANNER says so
+	scanner, err := infrastructure.NewScanner()
+
+	if err != nil {
+		log.Fatalf("could not load malware scanner: %v", err)
+	}
 
 	my_database := client.Database("fix-it")
 
@@ -63,17 +106,68 @@ func main() {
 
 	fmt.Println("🚀 Fix-it server starting... Version 1.0.7")
 	userRepo := repository.NewUserRepository(my_database)
-	viewRepo := repository.NewViewController(my_database)
-	actionRepo := repository.NewActionRepository(my_database, gem_model, gem_context)
+	tokenRepo := repository.NewTokenRepository(my_database)
+
+	if err := tokenRepo.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("could not create token indexes: %v", err)
+	}
+
+	if err := userRepo.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("could not create user indexes: %v", err)
+	}
+
+	loginAttemptRepo := repository.NewLoginAttemptRepository(my_database)
+
+	if err := loginAttemptRepo.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("could not create login attempt indexes: %v", err)
+	}
+
+	oidcRepo := repository.NewOIDCRepository(my_database)
+
+	if err := oidcRepo.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("could not create oidc indexes: %v", err)
+	}
+
+	outboxRepo := repository.NewOutboxRepository(my_database)
+
+	if err := outboxRepo.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("could not create outbox indexes: %v", err)
+	}
+
+	viewRepo := repository.NewViewController(my_database, blobs)
+	actionRepo := repository.NewActionRepository(my_database, llmClient, textExtractor, embedder, blobs)
+	jobRepo := repository.NewJobRepository(my_database)
+	attemptRepo := repository.NewAttemptRepository(my_database)
+	reviewRepo := repository.NewReviewRepository(my_database)
 	viewusecase := usecases.NewViewUsecase(viewRepo)
-	userusecase := usecases.NewUseCase(userRepo)
-	actionusecase := usecases.NewActionUsecase(actionRepo)
+	mailusecase := usecases.NewMailUsecase(outboxRepo, mailer)
+	userusecase := usecases.NewUseCase(userRepo, tokenRepo, loginAttemptRepo, infrastructure.LoadTokenConfig(), infrastructure.LoadLoginLimits(), mailusecase)
+	// login with the identity provider of the school, off unless OIDC_ISSUER is set
+	oidcusecase := usecases.NewOIDCUsecase(oidcRepo, userRepo, infrastructure.NewOIDCProvider(infrastructure.LoadOIDCConfig()))
+	actionusecase := usecases.NewActionUsecase(actionRepo, infrastructure.LoadGenerationConfig(), infrastructure.LoadUploadLimits(), scanner)
+	jobusecase := usecases.NewJobUsecase(jobRepo, actionusecase)
+	attemptusecase := usecases.NewAttemptUsecase(attemptRepo, actionRepo, viewRepo)
+	reviewusecase := usecases.NewReviewUsecase(reviewRepo, viewRepo)
+
+	// upload workers, JOB_WORKERS of them pull jobs from the queue
+	workers, err := strconv.Atoi(os.Getenv("JOB_WORKERS"))
+	if err != nil || workers < 1 {
+		workers = 2
+	}
+	jobusecase.StartWorkers(context.Background(), workers)
+
+	// one worker is plenty for the emails of the outbox
+	mailusecase.StartWorkers(context.Background(), 1)
 
 	viewcontroller := controller.NewViewController(viewusecase, actionusecase)
 	usercontroller := controller.NewUserController(userusecase)
-	actioncontroller := controller.NewActionController(actionusecase, viewusecase)
+	actioncontroller := controller.NewActionController(actionusecase, viewusecase, jobusecase, attemptusecase, reviewusecase)
+	attemptcontroller := controller.NewAttemptController(attemptusecase, viewusecase)
+	reviewcontroller := controller.NewReviewController(reviewusecase)
+	chatcontroller := controller.NewChatController(actionusecase, viewusecase)
+	oidccontroller := controller.NewOIDCController(oidcusecase, userusecase)
 
-	router := router.SetUpRouter(usercontroller, actioncontroller, viewcontroller)
+	router := router.SetUpRouter(usercontroller, actioncontroller, viewcontroller, attemptcontroller, reviewcontroller, chatcontroller, oidccontroller, tokenRepo, limiter)
 
 	if err := router.Run(":8080"); err != nil {
 		log.Fatalf("could not run server: %v", err)
@@ -7,31 +7,43 @@ import (
 	"github/chera/fix-it/domain"
 	"github/chera/fix-it/infrastructure"
 	"io"
-	"mime/multipart"
-	"net/http"
+	"log"
+	"time"
 
-	"github.com/google/generative-ai-go/genai"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type ActionRepository interface {
-	UploadQuestions(ctx context.Context, questions []domain.Question, userID string) (string, error)
+	UploadQuestions(ctx context.Context, questions []domain.Question, options domain.QuizOptions, userID string) (string, error)
 	UploadConversation(ctx context.Context, conversation []domain.ConversationTurn) (string, error)
 	UploadSection(ctx context.Context, section domain.Section) (string, error)
-	QuizAnswer(ctx context.Context, quizID string, answer []domain.Answer) (int, bool, error)
-	CreateExplanation(ctx context.Context, explanationID string, answers domain.AnswerList) (string, error)
+	QuizAnswer(ctx context.Context, quizID string, answer []domain.Answer) (domain.QuizGrade, bool, error)
+	CreateExplanation(ctx context.Context, explanationID string, answers domain.AnswerList, onChunk func(chunk string) error) (string, error)
+	ExplainAnswers(ctx context.Context, conversationID string, answers []domain.Answer, onChunk func(chunk string) error) (string, []domain.Citation, error)
 	UpdateSection(ctx context.Context, section domain.Section) error
-	GetPdfLink(ctx context.Context, file multipart.File, filename string) (string, error)
+	ExtractText(ctx context.Context, file io.Reader, filename string) ([]domain.DocumentPage, error)
 
-	CreateTopic(ctx context.Context, answerID, conversationID string) (string, error)
+	CreateTopic(ctx context.Context, answerID, conversationID string, onChunk func(chunk string) error) (string, error)
 
-	UploadPDF(ctx context.Context, pdf domain.PDF) (string, error)
-	ProcessPDF(ctx context.Context, link string) (string, error)
-	UploadForGemini(processedText string) ([]domain.ConversationTurn, error)
+	UploadDocument(ctx context.Context, document domain.Document, content []byte) (string, error)
+	UploadForGemini(ctx context.Context, processedText string, options domain.QuizOptions) ([]domain.ConversationTurn, error)
+	GenerateFollowUp(ctx context.Context, conversationID string, topics domain.TopicList, options domain.QuizOptions) ([]domain.ConversationTurn, error)
+	Chat(ctx context.Context, conversationID, message string, maxTurns, budget int) (domain.ChatTurn, error)
 	FormatQeustion(question string) []domain.Question
+
+	FindSectionByJob(ctx context.Context, jobID string) (domain.Section, error)
+	RemoveUpload(ctx context.Context, quizID, conversationID, pdfID string) error
+	SaveChunks(ctx context.Context, pdfID string, pages []domain.DocumentPage, budget int) error
+	DocumentChunks(ctx context.Context, pdfID string) ([]domain.DocumentChunk, error)
+	FindSectionByHash(ctx context.Context, userID, hash string, quizOptions domain.QuizOptions, pages string) (domain.Section, error)
+	GetDocument(ctx context.Context, documentID, userID string) (domain.Document, error)
+	DocumentFile(ctx context.Context, document domain.Document) ([]byte, error)
+	CachedExtraction(ctx context.Context, hash, userID string) ([]domain.DocumentPage, error)
+	SaveExtraction(ctx context.Context, extraction domain.Extraction) error
 }
 
 type actionRepository struct {
@@ -40,28 +52,266 @@ type actionRepository struct {
 	UserConversation *mongo.Collection
 	UserSections     *mongo.Collection
 	UserAnswers      *mongo.Collection
-	GeminiModel      *genai.GenerativeModel
-	GeminiContext    context.Context
+	UserChunks       *mongo.Collection
+	UserExtractions  *mongo.Collection
+	LLM              infrastructure.LLMClient
+	Extractor        infrastructure.TextExtractor
+	// nil ranks passages with BM25
+	Embedder infrastructure.Embedder
+	Blobs    infrastructure.BlobStorage
 }
 
-func NewActionRepository(db *mongo.Database, model *genai.GenerativeModel, ctx context.Context) ActionRepository {
+func NewActionRepository(db *mongo.Database, llm infrastructure.LLMClient, extractor infrastructure.TextExtractor, embedder infrastructure.Embedder, blobs infrastructure.BlobStorage) ActionRepository {
 	return &actionRepository{
 		UserBooks:        db.Collection("pdf"),
 		UserQuiz:         db.Collection("quiz"),
 		UserConversation: db.Collection("conversation"),
 		UserSections:     db.Collection("section"),
 		UserAnswers:      db.Collection("answers"),
-		GeminiContext:    ctx,
-		GeminiModel:      model,
+		UserChunks:       db.Collection("chunks"),
+		UserExtractions:  db.Collection("extractions"),
+		LLM:              llm,
+		Extractor:        extractor,
+		Embedder:         embedder,
+		Blobs:            blobs,
+	}
+}
+
+func (r *actionRepository) ExtractText(ctx context.Context, file io.Reader, filename string) ([]domain.DocumentPage, error) {
+	pages, err := r.Extractor.Extract(ctx, file, filename)
+
+	if err != nil {
+		return nil, errors.New("repository/action_repository: " + err.Error())
+	}
+
+	return pages, nil
+}
+
+// passages retrieved per wrong answer and per chat message
+const (
+	passagesPerQuestion = 2
+	passagesPerMessage  = 3
+)
+
+// SaveChunks keeps the document split into page-tagged passages for
+// retrieval. Passages are embedded when an embedder is configured and kept
+// without vectors, to be ranked lexically, when that fails.
+func (r *actionRepository) SaveChunks(ctx context.Context, pdfID string, pages []domain.DocumentPage, budget int) error {
+	chunks := infrastructure.ChunkPages(pages, budget)
+
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	var vectors [][]float64
+
+	if r.Embedder != nil {
+		texts := make([]string, len(chunks))
+		for i, chunk := range chunks {
+			texts[i] = chunk.Text
+		}
+
+		embedded, err := r.Embedder.Embed(ctx, texts)
+		if err == nil && len(embedded) != len(chunks) {
+			err = fmt.Errorf("got %d vectors for %d passages", len(embedded), len(chunks))
+		}
+
+		if err != nil {
+			log.Println("repository/action_repository: could not embed passages: " + err.Error())
+		} else {
+			vectors = embedded
+		}
 	}
+
+	documents := make([]interface{}, len(chunks))
+	for i, chunk := range chunks {
+		document := domain.DocumentChunk{PDFID: pdfID, TextChunk: chunk}
+		if vectors != nil {
+			document.Embedding = vectors[i]
+		}
+		documents[i] = document
+	}
+
+	_, err := r.UserChunks.InsertMany(ctx, documents)
+
+	if err != nil {
+		return errors.New("repository/action_repository: " + err.Error())
+	}
+
+	return nil
 }
 
-func (r *actionRepository) GetPdfLink(ctx context.Context, file multipart.File, filename string) (string, error) {
-	return infrastructure.UploadPDF(file, filename)
+// DocumentChunks returns the stored passages of a document in reading order.
+func (r *actionRepository) DocumentChunks(ctx context.Context, pdfID string) ([]domain.DocumentChunk, error) {
+	chunks := []domain.DocumentChunk{}
+
+	cursor, err := r.UserChunks.Find(ctx, bson.M{"pdf_id": pdfID}, options.Find().SetSort(bson.M{"index": 1}))
+	if err == nil {
+		err = cursor.All(ctx, &chunks)
+	}
+
+	if err != nil {
+		return []domain.DocumentChunk{}, errors.New("repository/action_repository: " + err.Error())
+	}
 
+	return chunks, nil
 }
 
-func (r *actionRepository) CreateTopic(ctx context.Context, answerID, conversationID string) (string, error) {
+// chunksOnPages keeps the passages that overlap the pages of a section.
+func chunksOnPages(chunks []domain.DocumentChunk, pages string) []domain.DocumentChunk {
+	lastPage := 0
+	for _, chunk := range chunks {
+		if chunk.LastPage > lastPage {
+			lastPage = chunk.LastPage
+		}
+	}
+
+	numbers, err := infrastructure.ParsePageRanges(pages, lastPage)
+	if err != nil {
+		return chunks
+	}
+
+	selected := map[int]bool{}
+	for _, number := range numbers {
+		selected[number] = true
+	}
+
+	kept := []domain.DocumentChunk{}
+	for _, chunk := range chunks {
+		for page := chunk.FirstPage; page <= chunk.LastPage; page++ {
+			if selected[page] {
+				kept = append(kept, chunk)
+				break
+			}
+		}
+	}
+
+	return kept
+}
+
+// retrieve ranks the passages of the section document owning a conversation
+// against each query. Retrieval only improves the prompt, so failures are
+// logged and leave the generation ungrounded.
+func (r *actionRepository) retrieve(ctx context.Context, conversationID string, queries []string, k int) [][]domain.DocumentChunk {
+	if len(queries) == 0 {
+		return nil
+	}
+
+	var section domain.Section
+	err := r.UserSections.FindOne(ctx, bson.M{"explanations_id": conversationID}).Decode(&section)
+
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Println("repository/action_repository: " + err.Error())
+		}
+		return nil
+	}
+
+	chunks, err := r.DocumentChunks(ctx, section.PDFID)
+
+	if err != nil {
+		log.Println(err.Error())
+		return nil
+	}
+
+	if section.Pages != "" {
+		chunks = chunksOnPages(chunks, section.Pages)
+	}
+
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	var embeddings [][]float64
+
+	if r.Embedder != nil && len(chunks[0].Embedding) > 0 {
+		embeddings, err = r.Embedder.Embed(ctx, queries)
+		if err == nil && len(embeddings) != len(queries) {
+			err = fmt.Errorf("got %d vectors for %d queries", len(embeddings), len(queries))
+		}
+
+		if err != nil {
+			log.Println("repository/action_repository: could not embed queries: " + err.Error())
+			embeddings = nil
+		}
+	}
+
+	results := make([][]domain.DocumentChunk, len(queries))
+	for i, query := range queries {
+		var embedding []float64
+		if embeddings != nil {
+			embedding = embeddings[i]
+		}
+		results[i] = infrastructure.RankChunks(query, embedding, chunks, k)
+	}
+
+	return results
+}
+
+// groundAnswers retrieves passages for the questions answered wrong and
+// returns the prompt listing them along with their citations.
+func (r *actionRepository) groundAnswers(ctx context.Context, conversationID string, quiz []domain.ConversationTurn, answers []domain.Answer) (string, []domain.Citation) {
+	if len(quiz) == 0 {
+		return "", nil
+	}
+
+	questions := infrastructure.ParseQuestionsResponse(quiz[0].Gemini)
+
+	var queries []string
+	var numbers []int
+
+	for _, answer := range answers {
+		if answer.QuestionNO < 1 || answer.QuestionNO > len(questions) {
+			continue
+		}
+
+		question := questions[answer.QuestionNO-1]
+		if infrastructure.GradeAnswer(question, answer.Answer) {
+			continue
+		}
+
+		queries = append(queries, infrastructure.QuestionQuery(question))
+		numbers = append(numbers, answer.QuestionNO)
+	}
+
+	retrieved := r.retrieve(ctx, conversationID, queries, passagesPerQuestion)
+
+	var sources []domain.Citation
+	for i, chunks := range retrieved {
+		for _, chunk := range chunks {
+			sources = append(sources, infrastructure.Cite(chunk, numbers[i]))
+		}
+	}
+
+	return infrastructure.GroundingPrompt(infrastructure.MergeRetrieved(retrieved)), sources
+}
+
+// keepReadable returns a generated response, or when the model couldn't
+// repair it, what salvage can still read of it.
+func keepReadable(raw string, err error, salvage func(raw string) (string, error)) (string, error) {
+	if err == nil {
+		return raw, nil
+	}
+
+	if raw != "" {
+		if kept, salvageErr := salvage(raw); salvageErr == nil {
+			log.Println("repository/action_repository: keeping what could be read of the response: " + err.Error())
+			return kept, nil
+		}
+	}
+
+	return "", fmt.Errorf("error generating content: %v", err)
+}
+
+// generateStructured streams the response to onChunk when one is given.
+func (r *actionRepository) generateStructured(ctx context.Context, history []domain.ConversationTurn, prompt string, schema *infrastructure.ResponseSchema, validate func(raw string) error, onChunk func(chunk string) error) (string, error) {
+	if onChunk == nil {
+		return infrastructure.GenerateStructured(ctx, r.LLM, history, prompt, schema, validate)
+	}
+	return infrastructure.StreamStructured(ctx, r.LLM, history, prompt, schema, validate, onChunk)
+}
+
+func (r *actionRepository) CreateTopic(ctx context.Context, answerID, conversationID string, onChunk func(chunk string) error) (string, error) {
 
 	var answer domain.AnswerList
 	var conversation domain.Conversation
@@ -97,6 +347,7 @@ func (r *actionRepository) CreateTopic(ctx context.Context, answerID, conversati
 	}
 
 	answer_prompt := infrastructure.ParseAnswer(answer.Answers)
+	grounding, sources := r.groundAnswers(ctx, conversationID, conversation.Turns[0:1], answer.Answers)
 
 	curent_request := fmt.Sprintf(`Here is my answer \n
     %s \n
@@ -108,26 +359,22 @@ func (r *actionRepository) CreateTopic(ctx context.Context, answerID, conversati
 	4. The Explanation should not include the answer
 	5. The Explanation should be detailed and include other resources
 
-    Example Format:
-	Weak Point 1: Title of the topic.
-	Explanation : Explanation of the topic, You can also include other resources. 
-	
-	Weak Point 2: Title of the topic
-	Explanation : Explanation of the topic, You can also include other resources.
-
-    `, answer_prompt)
+    Respond only with JSON in this shape:
+	{"topics": [{"title": "Title of the topic", "explanation": "Explanation of the topic, You can also include other resources."}]}
 
-	prompt := infrastructure.BuildPromptWithContext(curent_request, conversation.Turns[0:1])
+    `, answer_prompt) + grounding
 
-	resp, err := r.GeminiModel.GenerateContent(r.GeminiContext, genai.Text(prompt))
+	gem_resp, err := r.generateStructured(ctx, conversation.Turns[0:1], curent_request, infrastructure.TopicsSchema, func(raw string) error {
+		_, err := infrastructure.DecodeTopics(raw)
+		return err
+	}, onChunk)
 
+	gem_resp, err = keepReadable(gem_resp, err, infrastructure.SalvageTopics)
 	if err != nil {
-		return "", fmt.Errorf("error generating content: %v", err)
+		return "", err
 	}
 
-	gem_resp := infrastructure.ExtractGeminiResponse(resp)
-
-	update := bson.M{"$push": bson.M{"conversation": domain.ConversationTurn{User: curent_request, Gemini: gem_resp}}}
+	update := bson.M{"$push": bson.M{"conversation": domain.ConversationTurn{User: curent_request, Gemini: gem_resp, Sources: sources}}}
 
 	_, err = r.UserConversation.UpdateOne(ctx, filters, update)
 
@@ -157,7 +404,7 @@ func (r *actionRepository) UpdateSection(ctx context.Context, section domain.Sec
 	return nil
 }
 
-func (r *actionRepository) CreateExplanation(ctx context.Context, explanationID string, answers domain.AnswerList) (string, error) {
+func (r *actionRepository) CreateExplanation(ctx context.Context, explanationID string, answers domain.AnswerList, onChunk func(chunk string) error) (string, error) {
 
 	ObjectID, err := primitive.ObjectIDFromHex(explanationID)
 	if err != nil {
@@ -179,37 +426,13 @@ func (r *actionRepository) CreateExplanation(ctx context.Context, explanationID
 		return "", errors.New("repository/action_repository: " + err.Error())
 	}
 
-	answer_prompt := infrastructure.ParseAnswer(answers.Answers)
-
-	curent_request := fmt.Sprintf(`Here is my answer \n
-    %s \n
-
-    For each question:
-
-    1. Indicate whether the answer is correct or incorrect.
-    2. If the answer is incorrect, provide the correct answer AND a detailed explanation of *why* the provided answer is wrong.
-    3. If the answer is correct, simply state "Correct".
-	4. Dont any text decorations like bold, italic, underline, etc.
-
-    Example Format:
-    Question Number: [question number]
-    Correct Answer: [correct answer]  (Only if the answer is incorrect)
-    Your Answer: [user's answer]
-    Correctness: [Correct/Incorrect]
-    Explanation: [explanation] (Only if the answer is incorrect)
-
-    `, answer_prompt)
-	prompt := infrastructure.BuildPromptWithContext(curent_request, conversation.Turns)
-
-	resp, err := r.GeminiModel.GenerateContent(r.GeminiContext, genai.Text(prompt))
+	curent_request, gem_resp, sources, err := r.explain(ctx, explanationID, conversation.Turns, answers.Answers, onChunk)
 
 	if err != nil {
-		return "", fmt.Errorf("error generating content: %v", err)
+		return "", err
 	}
 
-	gem_resp := infrastructure.ExtractGeminiResponse(resp)
-
-	update := bson.M{"$push": bson.M{"conversation": domain.ConversationTurn{User: curent_request, Gemini: gem_resp}}}
+	update := bson.M{"$push": bson.M{"conversation": domain.ConversationTurn{User: curent_request, Gemini: gem_resp, Sources: sources}}}
 
 	_, err = r.UserConversation.UpdateOne(ctx, filters, update)
 
@@ -225,43 +448,92 @@ func (r *actionRepository) CreateExplanation(ctx context.Context, explanationID
 	return aID.Hex(), nil
 }
 
-func (r *actionRepository) QuizAnswer(ctx context.Context, quizID string, answer []domain.Answer) (int, bool, error) {
+// ExplainAnswers explains answers against the quiz of a conversation without
+// storing the turn, so the turn layout the explanation and topic views rely
+// on stays that of the official attempt.
+func (r *actionRepository) ExplainAnswers(ctx context.Context, conversationID string, answers []domain.Answer, onChunk func(chunk string) error) (string, []domain.Citation, error) {
 
-	ObjectID, err := primitive.ObjectIDFromHex(quizID)
+	ObjectID, err := primitive.ObjectIDFromHex(conversationID)
+	if err != nil {
+		return "", nil, errors.New("repository/action_repository: " + err.Error())
+	}
+
+	var conversation domain.Conversation
+	err = r.UserConversation.FindOne(ctx, bson.M{"_id": ObjectID}).Decode(&conversation)
 
 	if err != nil {
-		return 0, false, errors.New("repository/action_repository: " + err.Error())
+		return "", nil, errors.New("repository/action_repository: " + err.Error())
 	}
 
-	filters := bson.M{"_id": ObjectID}
-	var score int = 0
+	if len(conversation.Turns) == 0 {
+		return "", nil, errors.New("repository/action_repository: conversation has no quiz")
+	}
 
-	var quizes domain.Quiz
-	err = r.UserQuiz.FindOne(ctx, filters).Decode(&quizes)
+	_, gem_resp, sources, err := r.explain(ctx, conversationID, conversation.Turns[0:1], answers, onChunk)
+
+	return gem_resp, sources, err
+}
+
+// explain asks the model to grade and explain answers given the quiz in
+// history and returns the request together with the raw response.
+func (r *actionRepository) explain(ctx context.Context, conversationID string, history []domain.ConversationTurn, answers []domain.Answer, onChunk func(chunk string) error) (string, string, []domain.Citation, error) {
+	answer_prompt := infrastructure.ParseAnswer(answers)
+	grounding, sources := r.groundAnswers(ctx, conversationID, history, answers)
 
+	curent_request := fmt.Sprintf(`Here is my answer \n
+    %s \n
+
+    For each question:
+
+    1. Indicate whether the answer is correct or incorrect.
+    2. If the answer is incorrect, provide the correct answer AND a detailed explanation of *why* the provided answer is wrong.
+    3. If the answer is correct, leave the explanation empty.
+	4. Dont any text decorations like bold, italic, underline, etc.
+
+    Respond only with JSON in this shape:
+    {"answers": [{"question_number": 1, "correct_answer": "B", "your_answer": "A", "correctness": false, "explanation": "why A is wrong"}]}
+
+    `, answer_prompt) + grounding
+	gem_resp, err := r.generateStructured(ctx, history, curent_request, infrastructure.ExplanationsSchema, func(raw string) error {
+		_, err := infrastructure.DecodeExplanations(raw)
+		return err
+	}, onChunk)
+
+	gem_resp, err = keepReadable(gem_resp, err, infrastructure.SalvageExplanations)
 	if err != nil {
-		return 0, false, errors.New("repository/action_repository: " + err.Error())
+		return "", "", nil, err
 	}
 
-	if !quizes.Taken {
-		update := bson.M{"$set": bson.M{"taken": true}}
+	return curent_request, gem_resp, sources, nil
+}
 
-		_, err = r.UserQuiz.UpdateOne(ctx, filters, update)
-		if err != nil {
-			return 0, false, errors.New("repository/action_repository: " + err.Error())
-		}
+func (r *actionRepository) QuizAnswer(ctx context.Context, quizID string, answer []domain.Answer) (domain.QuizGrade, bool, error) {
 
+	ObjectID, err := primitive.ObjectIDFromHex(quizID)
+
+	if err != nil {
+		return domain.QuizGrade{}, false, errors.New("repository/action_repository: " + err.Error())
 	}
 
-	for index, quiz := range quizes.Questions {
+	filters := bson.M{"_id": ObjectID}
 
-		if quiz.Answer == answer[index].Answer {
-			score++
-		}
+	var quizes domain.Quiz
+	err = r.UserQuiz.FindOne(ctx, filters).Decode(&quizes)
 
+	if err != nil {
+		return domain.QuizGrade{}, false, errors.New("repository/action_repository: " + err.Error())
 	}
 
-	return score, quizes.Taken, nil
+	// the submission that flips the flag is the official attempt, however
+	// many arrive together
+	update := bson.M{"$set": bson.M{"taken": true}}
+
+	result, err := r.UserQuiz.UpdateOne(ctx, bson.M{"_id": ObjectID, "taken": false}, update)
+	if err != nil {
+		return domain.QuizGrade{}, false, errors.New("repository/action_repository: " + err.Error())
+	}
+
+	return infrastructure.GradeQuiz(quizes.Questions, answer), result.MatchedCount == 0, nil
 }
 
 func (r *actionRepository) UploadSection(ctx context.Context, section domain.Section) (string, error) {
@@ -296,10 +568,11 @@ func (r *actionRepository) UploadConversation(ctx context.Context, conversation
 	return insertedID.Hex(), nil
 }
 
-func (r *actionRepository) UploadQuestions(ctx context.Context, questions []domain.Question, userID string) (string, error) {
+func (r *actionRepository) UploadQuestions(ctx context.Context, questions []domain.Question, options domain.QuizOptions, userID string) (string, error) {
 
 	var new_quiz domain.Quiz
 	new_quiz.Questions = questions
+	new_quiz.Options = options
 	new_quiz.CreatedBy = userID
 	new_quiz.Taken = false
 
@@ -316,83 +589,184 @@ func (r *actionRepository) UploadQuestions(ctx context.Context, questions []doma
 	return insertedID.Hex(), nil
 }
 
-func (r *actionRepository) ProcessPDF(ctx context.Context, link string) (string, error) {
+func (r *actionRepository) UploadForGemini(ctx context.Context, processedText string, options domain.QuizOptions) ([]domain.ConversationTurn, error) {
+	conversation := []domain.ConversationTurn{}
+	prompt := fmt.Sprintf(`
+			Generate %d questions based on the following text and indicate the correct answer of each.  
+			%s
+			Respond only with JSON in the shape shown below.  
+			Do not include any extra text or explanations.
 
-	processedTextLink, err := infrastructure.ProcessPDF(link)
+			Dont include the example questions in the output.
+			Dont any text decorations like bold, italic, underline, etc.
+			For every question give "pages", the numbers of the [page N] markers the question comes from,
+			and "quote", one short sentence copied exactly from the text that supports the answer.
+
+			Example Format: 
+			{"questions": [
+				{"type": "single_choice", "question": "What is the capital of France?", "a": "London", "b": "Paris", "c": "Rome", "d": "Berlin", "answer": "B", "pages": [3], "quote": "Paris is the capital and largest city of France."},
+				{"type": "true_false", "question": "Mount Everest is the highest mountain in the world.", "answer": "True"},
+				{"type": "fill_in_the_blank", "question": "Water boils at ____ degrees Celsius at sea level.", "answer": "100", "accepted_answers": ["one hundred"]}
+			]}
 
+			Text:
+			%s
+			`, options.QuestionCount, infrastructure.QuizInstructions(options), processedText)
+
+	gem_resp, err := infrastructure.GenerateStructured(ctx, r.LLM, nil, prompt, infrastructure.QuestionsSchema, func(raw string) error {
+		_, err := infrastructure.DecodeQuestions(raw)
+		return err
+	})
+
+	gem_resp, err = keepReadable(gem_resp, err, infrastructure.SalvageQuestions)
 	if err != nil {
-		return "", errors.New("repository/action_repository: " + err.Error())
+		return []domain.ConversationTurn{}, err
 	}
 
-	textResponse, err := http.Get(processedTextLink)
+	conversation = append(conversation, domain.ConversationTurn{User: prompt, Gemini: gem_resp})
+
+	return conversation, nil
+}
+
+// GenerateFollowUp asks for a new quiz on the weak points of a section. The
+// returned turn carries the original quiz request along so the follow-up
+// conversation keeps the document context for its own explanations.
+func (r *actionRepository) GenerateFollowUp(ctx context.Context, conversationID string, topics domain.TopicList, options domain.QuizOptions) ([]domain.ConversationTurn, error) {
+
+	ObjectID, err := primitive.ObjectIDFromHex(conversationID)
 	if err != nil {
-		return "", fmt.Errorf("error downloading text: %w", err)
+		return []domain.ConversationTurn{}, errors.New("repository/action_repository: " + err.Error())
 	}
-	defer textResponse.Body.Close()
 
-	if textResponse.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(textResponse.Body)
-		return "", fmt.Errorf("error downloading text, status: %d, body: %s", textResponse.StatusCode, string(body))
-	}
+	var conversation domain.Conversation
+	err = r.UserConversation.FindOne(ctx, bson.M{"_id": ObjectID}).Decode(&conversation)
 
-	textBytes, err := io.ReadAll(textResponse.Body)
 	if err != nil {
-		return "", fmt.Errorf("error reading downloaded text: %w", err)
+		return []domain.ConversationTurn{}, errors.New("repository/action_repository: " + err.Error())
 	}
 
-	extractedText := string(textBytes)
-	return extractedText, nil
+	if len(conversation.Turns) == 0 {
+		return []domain.ConversationTurn{}, errors.New("repository/action_repository: conversation has no quiz")
+	}
 
-}
+	weakPoints := ""
+	queries := make([]string, len(topics.Topics))
+	for i, topic := range topics.Topics {
+		weakPoints += fmt.Sprintf("%d. %s: %s\n", i+1, topic.Title, topic.Explanation)
+		queries[i] = topic.Title + " " + topic.Explanation
+	}
+
+	// the quiz turn of a long document doesn't carry its text
+	grounding := infrastructure.GroundingPrompt(infrastructure.MergeRetrieved(r.retrieve(ctx, conversationID, queries, passagesPerQuestion)))
 
-func (r *actionRepository) UploadForGemini(processedText string) ([]domain.ConversationTurn, error) {
-	conversation := []domain.ConversationTurn{}
 	prompt := fmt.Sprintf(`
-			Generate 10 multiple-choice questions based on the following text.  Each question should have 4 alternatives (A, B, C, D) and indicate the correct answer.  
-			Format the output precisely as shown in the example below.  
+			These are my weak points from the quiz above:
+			%s
+			Generate %d new questions based on the same text that focus on these weak points and indicate the correct answer of each.
+			Do not repeat the questions of the previous quiz.
+			%s
+			Respond only with JSON in the same shape as the previous quiz, including "pages" and an exact "quote" for every question.
 			Do not include any extra text or explanations.
+			Dont any text decorations like bold, italic, underline, etc.
+			`, weakPoints, options.QuestionCount, infrastructure.QuizInstructions(options)) + grounding
 
+	gem_resp, err := infrastructure.GenerateStructured(ctx, r.LLM, conversation.Turns[0:1], prompt, infrastructure.QuestionsSchema, func(raw string) error {
+		_, err := infrastructure.DecodeQuestions(raw)
+		return err
+	})
 
-			Dont include the example format in the output.
-			Dont any text decorations like bold, italic, underline, etc.
+	gem_resp, err = keepReadable(gem_resp, err, infrastructure.SalvageQuestions)
+	if err != nil {
+		return []domain.ConversationTurn{}, err
+	}
 
-			
-			Example Format: 
-			1, What is the capital of France?
-			A, London
-			B, Paris
-			C, Rome
-			D, Berlin
-			B
-
-			2, What is the highest mountain in the world?
-			A, K2
-			B, Kangchenjunga
-			C, Mount Everest
-			D, Lhotse
-			C
-			
+	return []domain.ConversationTurn{{User: conversation.Turns[0].User + "\n" + prompt, Gemini: gem_resp}}, nil
+}
 
-			Text:
-			%s
-			`, processedText)
+// Chat answers a student message with the section conversation as context.
+// Chat turns that no longer fit the window of maxTurns turns and budget
+// tokens are folded into a running summary first.
+func (r *actionRepository) Chat(ctx context.Context, conversationID, message string, maxTurns, budget int) (domain.ChatTurn, error) {
 
-	resp, err := r.GeminiModel.GenerateContent(r.GeminiContext, genai.Text(prompt))
+	ObjectID, err := primitive.ObjectIDFromHex(conversationID)
+	if err != nil {
+		return domain.ChatTurn{}, errors.New("repository/action_repository: " + err.Error())
+	}
+
+	filters := bson.M{"_id": ObjectID}
+
+	var conversation domain.Conversation
+	err = r.UserConversation.FindOne(ctx, filters).Decode(&conversation)
 
 	if err != nil {
-		return []domain.ConversationTurn{}, fmt.Errorf("error generating content: %v", err)
+		return domain.ChatTurn{}, errors.New("repository/action_repository: " + err.Error())
 	}
 
-	gem_resp := infrastructure.ExtractGeminiResponse(resp)
+	pending := conversation.Chat[conversation.ChatSummarized:]
+	skip := 0
 
-	conversation = append(conversation, domain.ConversationTurn{User: prompt, Gemini: gem_resp})
+	if overflow := infrastructure.ChatOverflow(pending, maxTurns-1, budget-infrastructure.EstimateTokens(message)); overflow > 0 {
+		summary, err := r.LLM.Generate(ctx, infrastructure.ChatSummaryPrompt(conversation.ChatSummary, pending[:overflow]))
 
-	return conversation, nil
+		if err != nil {
+			// drop the overflow for this reply only and summarize next time
+			log.Println("repository/action_repository: could not summarize chat: " + err.Error())
+			skip = overflow
+		} else {
+			conversation.ChatSummary = summary
+			conversation.ChatSummarized += overflow
+
+			update := bson.M{"$set": bson.M{"chat_summary": conversation.ChatSummary, "chat_summarized": conversation.ChatSummarized}}
+
+			if _, err := r.UserConversation.UpdateOne(ctx, filters, update); err != nil {
+				return domain.ChatTurn{}, errors.New("repository/action_repository: " + err.Error())
+			}
+		}
+	}
+
+	var passages []domain.DocumentChunk
+	var sources []domain.Citation
+
+	if retrieved := r.retrieve(ctx, conversationID, []string{message}, passagesPerMessage); len(retrieved) > 0 {
+		passages = retrieved[0]
+		for _, chunk := range passages {
+			sources = append(sources, infrastructure.Cite(chunk, 0))
+		}
+	}
+
+	prompt := infrastructure.GroundingPrompt(passages) + infrastructure.ChatPrompt(message)
+
+	reply, err := r.LLM.GenerateWithHistory(ctx, infrastructure.ChatHistory(conversation, skip), prompt)
+
+	if err != nil {
+		return domain.ChatTurn{}, fmt.Errorf("error generating content: %v", err)
+	}
+
+	turn := domain.ChatTurn{User: message, Tutor: reply, Sources: sources, CreatedAt: time.Now()}
+
+	_, err = r.UserConversation.UpdateOne(ctx, filters, bson.M{"$push": bson.M{"chat": turn}})
+
+	if err != nil {
+		return domain.ChatTurn{}, errors.New("repository/action_repository: " + err.Error())
+	}
+
+	return turn, nil
 }
-func (r *actionRepository) UploadPDF(ctx context.Context, pdf domain.PDF) (string, error) {
-	id, err := r.UserBooks.InsertOne(ctx, pdf)
+
+// UploadDocument stores the original file under document.DropBox and records it.
+func (r *actionRepository) UploadDocument(ctx context.Context, document domain.Document, content []byte) (string, error) {
+	document.Size = int64(len(content))
+
+	if err := r.Blobs.Put(ctx, document.DropBox, content, document.MediaType); err != nil {
+		return "", errors.New("repository/action_repository: " + err.Error())
+	}
+
+	id, err := r.UserBooks.InsertOne(ctx, document)
 
 	if err != nil {
+		if cleanupErr := r.Blobs.Delete(context.Background(), document.DropBox); cleanupErr != nil {
+			log.Println("repository/action_repository: " + cleanupErr.Error())
+		}
 		return "", errors.New("repository/action_repository: " + err.Error())
 	}
 
@@ -404,6 +778,249 @@ func (r *actionRepository) UploadPDF(ctx context.Context, pdf domain.PDF) (strin
 }
 
 func (r *actionRepository) FormatQeustion(question string) []domain.Question {
-	qeustions := infrastructure.ParseQuestions(question)
+	qeustions := infrastructure.ParseQuestionsResponse(question)
 	return qeustions
 }
+
+func (r *actionRepository) FindSectionByJob(ctx context.Context, jobID string) (domain.Section, error) {
+	var section domain.Section
+
+	err := r.UserSections.FindOne(ctx, bson.M{"job_id": jobID}).Decode(&section)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return domain.Section{}, err
+		}
+		return domain.Section{}, errors.New("repository/action_repository: " + err.Error())
+	}
+
+	return section, nil
+}
+
+// FindSectionByHash finds the newest first round section the user created
+// from the same pages of a file with the given hash whose quiz was
+// generated with the same options. It returns mongo.ErrNoDocuments when
+// there is none.
+func (r *actionRepository) FindSectionByHash(ctx context.Context, userID, hash string, quizOptions domain.QuizOptions, pages string) (domain.Section, error) {
+	var pdfs []domain.Document
+
+	cursor, err := r.UserBooks.Find(ctx, bson.M{"hash": hash, "created_by": userID}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err == nil {
+		err = cursor.All(ctx, &pdfs)
+	}
+
+	if err != nil {
+		return domain.Section{}, errors.New("repository/action_repository: " + err.Error())
+	}
+
+	if len(pdfs) == 0 {
+		return domain.Section{}, mongo.ErrNoDocuments
+	}
+
+	pdfIDs := make([]string, len(pdfs))
+	for i, pdf := range pdfs {
+		pdfIDs[i] = pdf.ID.Hex()
+	}
+
+	var sections []domain.Section
+
+	filter := bson.M{"created_by": userID, "pdf_id": bson.M{"$in": pdfIDs}, "parent_id": bson.M{"$exists": false}}
+	// a null filter also matches sections on the whole document, which have no pages
+	if pages == "" {
+		filter["pages"] = nil
+	} else {
+		filter["pages"] = pages
+	}
+	cursor, err = r.UserSections.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": -1}))
+	if err == nil {
+		err = cursor.All(ctx, &sections)
+	}
+
+	if err != nil {
+		return domain.Section{}, errors.New("repository/action_repository: " + err.Error())
+	}
+
+	for _, section := range sections {
+		quizID, err := primitive.ObjectIDFromHex(section.QuestionsID)
+		if err != nil {
+			continue
+		}
+
+		var quiz domain.Quiz
+		err = r.UserQuiz.FindOne(ctx, bson.M{"_id": quizID}, options.FindOne().SetProjection(bson.M{"options": 1})).Decode(&quiz)
+
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return domain.Section{}, errors.New("repository/action_repository: " + err.Error())
+		}
+
+		if infrastructure.SameQuizOptions(quiz.Options, quizOptions) {
+			return section, nil
+		}
+	}
+
+	return domain.Section{}, mongo.ErrNoDocuments
+}
+
+// GetDocument returns a document of the user. Documents stored before they
+// had an owner belong to the users with a section on them.
+func (r *actionRepository) GetDocument(ctx context.Context, documentID, userID string) (domain.Document, error) {
+	objectID, err := primitive.ObjectIDFromHex(documentID)
+	if err != nil {
+		return domain.Document{}, mongo.ErrNoDocuments
+	}
+
+	var document domain.Document
+	err = r.UserBooks.FindOne(ctx, bson.M{"_id": objectID}).Decode(&document)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return domain.Document{}, err
+		}
+		return domain.Document{}, errors.New("repository/action_repository: " + err.Error())
+	}
+
+	if document.CreatedBy == userID {
+		return document, nil
+	}
+
+	if document.CreatedBy != "" {
+		return domain.Document{}, mongo.ErrNoDocuments
+	}
+
+	count, err := r.UserSections.CountDocuments(ctx, bson.M{"pdf_id": documentID, "created_by": userID})
+	if err != nil {
+		return domain.Document{}, errors.New("repository/action_repository: " + err.Error())
+	}
+
+	if count == 0 {
+		return domain.Document{}, mongo.ErrNoDocuments
+	}
+
+	return document, nil
+}
+
+// DocumentFile reads the stored original of a document.
+func (r *actionRepository) DocumentFile(ctx context.Context, document domain.Document) ([]byte, error) {
+	if document.DropBox == "" {
+		return nil, errors.New("repository/action_repository: " + infrastructure.ErrBlobNotFound.Error())
+	}
+
+	file, err := r.Blobs.Open(ctx, document.DropBox)
+	if err != nil {
+		return nil, errors.New("repository/action_repository: " + err.Error())
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, errors.New("repository/action_repository: " + err.Error())
+	}
+
+	return content, nil
+}
+
+// CachedExtraction returns the newest extracted text of a file with the
+// given hash. An empty userID looks at the extractions of every user. It
+// returns mongo.ErrNoDocuments when the file was never extracted.
+func (r *actionRepository) CachedExtraction(ctx context.Context, hash, userID string) ([]domain.DocumentPage, error) {
+	filter := bson.M{"hash": hash}
+	if userID != "" {
+		filter["created_by"] = userID
+	}
+
+	var extraction domain.Extraction
+	err := r.UserExtractions.FindOne(ctx, filter, options.FindOne().SetSort(bson.M{"created_at": -1})).Decode(&extraction)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return []domain.DocumentPage{}, err
+		}
+		return []domain.DocumentPage{}, errors.New("repository/action_repository: " + err.Error())
+	}
+
+	return extraction.Pages, nil
+}
+
+// SaveExtraction keeps one extraction per file and user, replacing older ones.
+func (r *actionRepository) SaveExtraction(ctx context.Context, extraction domain.Extraction) error {
+	filter := bson.M{"hash": extraction.Hash, "created_by": extraction.CreatedBy}
+
+	_, err := r.UserExtractions.ReplaceOne(ctx, filter, extraction, options.Replace().SetUpsert(true))
+
+	if err != nil {
+		return errors.New("repository/action_repository: " + err.Error())
+	}
+
+	return nil
+}
+
+// removeFile deletes the stored original of a pdf record.
+func (r *actionRepository) removeFile(ctx context.Context, pdfID string) error {
+	objectID, err := primitive.ObjectIDFromHex(pdfID)
+	if err != nil {
+		return errors.New("repository/action_repository: " + err.Error())
+	}
+
+	var pdf domain.Document
+	err = r.UserBooks.FindOne(ctx, bson.M{"_id": objectID}).Decode(&pdf)
+
+	if err == mongo.ErrNoDocuments || (err == nil && pdf.DropBox == "") {
+		return nil
+	}
+
+	if err == nil {
+		err = r.Blobs.Delete(ctx, pdf.DropBox)
+	}
+
+	if err != nil {
+		return errors.New("repository/action_repository: " + err.Error())
+	}
+
+	return nil
+}
+
+// RemoveUpload deletes the documents created by an upload that could not be
+// finished, so a failed job does not leave orphans behind.
+func (r *actionRepository) RemoveUpload(ctx context.Context, quizID, conversationID, pdfID string) error {
+	targets := []struct {
+		collection *mongo.Collection
+		id         string
+	}{
+		{r.UserQuiz, quizID},
+		{r.UserConversation, conversationID},
+		{r.UserBooks, pdfID},
+	}
+
+	if pdfID != "" {
+		if err := r.removeFile(ctx, pdfID); err != nil {
+			return err
+		}
+	}
+
+	for _, target := range targets {
+		if target.id == "" {
+			continue
+		}
+
+		objectID, err := primitive.ObjectIDFromHex(target.id)
+		if err != nil {
+			return errors.New("repository/action_repository: " + err.Error())
+		}
+
+		_, err = target.collection.DeleteOne(ctx, bson.M{"_id": objectID})
+		if err != nil {
+			return errors.New("repository/action_repository: " + err.Error())
+		}
+	}
+
+	if pdfID != "" {
+		if _, err := r.UserChunks.DeleteMany(ctx, bson.M{"pdf_id": pdfID}); err != nil {
+			return errors.New("repository/action_repository: " + err.Error())
+		}
+	}
+
+	return nil
+}
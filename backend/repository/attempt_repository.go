@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github/chera/fix-it/domain"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type AttemptRepository interface {
+	CreateAttempt(ctx context.Context, attempt domain.Attempt) (string, error)
+	ListAttempts(ctx context.Context, sectionID, userID string) ([]domain.Attempt, error)
+	GetAttempt(ctx context.Context, attemptID, userID string) (domain.Attempt, error)
+	OfficialAttempt(ctx context.Context, sectionID, userID string) (domain.Attempt, error)
+	SetExplanation(ctx context.Context, attemptID string, explanation []domain.QeustionAnswer) error
+}
+
+type attemptRepository struct {
+	UserAttempts *mongo.Collection
+}
+
+func NewAttemptRepository(db *mongo.Database) AttemptRepository {
+	return &attemptRepository{
+		UserAttempts: db.Collection("attempts"),
+	}
+}
+
+func (r *attemptRepository) CreateAttempt(ctx context.Context, attempt domain.Attempt) (string, error) {
+	attempt.CreatedAt = time.Now()
+
+	id, err := r.UserAttempts.InsertOne(ctx, attempt)
+
+	if err != nil {
+		return "", errors.New("repository/attempt_repository: " + err.Error())
+	}
+
+	insertedID, ok := id.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return "", errors.New("repository/attempt_repository: could not convert inserted id")
+	}
+	return insertedID.Hex(), nil
+}
+
+func (r *attemptRepository) ListAttempts(ctx context.Context, sectionID, userID string) ([]domain.Attempt, error) {
+	attempts := []domain.Attempt{}
+
+	filter := bson.M{"section_id": sectionID, "user_id": userID}
+	opts := options.Find().
+		SetSort(bson.M{"created_at": 1}).
+		SetProjection(bson.M{"explanation": 0})
+
+	cursor, err := r.UserAttempts.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.New("repository/attempt_repository: " + err.Error())
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &attempts); err != nil {
+		return nil, errors.New("repository/attempt_repository: " + err.Error())
+	}
+
+	return attempts, nil
+}
+
+func (r *attemptRepository) GetAttempt(ctx context.Context, attemptID, userID string) (domain.Attempt, error) {
+	var attempt domain.Attempt
+
+	objectID, err := primitive.ObjectIDFromHex(attemptID)
+
+	if err != nil {
+		return domain.Attempt{}, errors.New("repository/attempt_repository: " + err.Error())
+	}
+
+	filter := bson.M{"_id": objectID, "user_id": userID}
+
+	err = r.UserAttempts.FindOne(ctx, filter).Decode(&attempt)
+
+	if err != nil {
+		return domain.Attempt{}, errors.New("repository/attempt_repository: " + err.Error())
+	}
+
+	return attempt, nil
+}
+
+func (r *attemptRepository) OfficialAttempt(ctx context.Context, sectionID, userID string) (domain.Attempt, error) {
+	var attempt domain.Attempt
+
+	filter := bson.M{"section_id": sectionID, "user_id": userID, "official": true}
+
+	err := r.UserAttempts.FindOne(ctx, filter).Decode(&attempt)
+
+	if err == mongo.ErrNoDocuments {
+		return domain.Attempt{}, err
+	}
+
+	if err != nil {
+		return domain.Attempt{}, errors.New("repository/attempt_repository: " + err.Error())
+	}
+
+	return attempt, nil
+}
+
+func (r *attemptRepository) SetExplanation(ctx context.Context, attemptID string, explanation []domain.QeustionAnswer) error {
+	objectID, err := primitive.ObjectIDFromHex(attemptID)
+
+	if err != nil {
+		return errors.New("repository/attempt_repository: " + err.Error())
+	}
+
+	update := bson.M{"$set": bson.M{"explanation": explanation}}
+
+	_, err = r.UserAttempts.UpdateOne(ctx, bson.M{"_id": objectID}, update)
+
+	if err != nil {
+		return errors.New("repository/attempt_repository: " + err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,223 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github/chera/fix-it/domain"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrNoJob     = errors.New("no job available")
+	ErrLeaseLost = errors.New("the job lease expired and another worker claimed it")
+)
+
+type JobRepository interface {
+	Enqueue(ctx context.Context, job domain.Job) (string, error)
+	Claim(ctx context.Context, lease time.Duration, maxAttempts int) (domain.Job, error)
+	FailAbandoned(ctx context.Context, maxAttempts int) (int64, error)
+	Renew(ctx context.Context, job domain.Job, lease time.Duration) error
+	UpdateProgress(ctx context.Context, job domain.Job, stage string, progress int, lease time.Duration) error
+	Complete(ctx context.Context, job domain.Job, sectionID string) error
+	Retry(ctx context.Context, job domain.Job, jobErr domain.JobError, nextRunAt time.Time) error
+	Fail(ctx context.Context, job domain.Job, jobErr domain.JobError) error
+	GetJob(ctx context.Context, jobID, userID string) (domain.Job, error)
+}
+
+type jobRepository struct {
+	UserJobs *mongo.Collection
+}
+
+func NewJobRepository(db *mongo.Database) JobRepository {
+	return &jobRepository{
+		UserJobs: db.Collection("jobs"),
+	}
+}
+
+func (r *jobRepository) Enqueue(ctx context.Context, job domain.Job) (string, error) {
+	now := time.Now()
+
+	job.Status = domain.JobQueued
+	job.Stage = domain.JobStageQueued
+	job.Progress = 0
+	job.Attempts = 0
+	job.NextRunAt = now
+	job.CreatedAt = now
+	job.UpdatedAt = now
+
+	id, err := r.UserJobs.InsertOne(ctx, job)
+
+	if err != nil {
+		return "", errors.New("repository/job_repository: " + err.Error())
+	}
+
+	insertedID, ok := id.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return "", errors.New("repository/job_repository: could not convert inserted id")
+	}
+	return insertedID.Hex(), nil
+}
+
+// Claim leases the oldest runnable job. Running jobs whose lease expired
+// belong to a worker that crashed and are picked up again, unless they
+// used up their attempts. The claim token of the job is only known to the
+// worker holding the lease, the updates of any other fail with ErrLeaseLost.
+func (r *jobRepository) Claim(ctx context.Context, lease time.Duration, maxAttempts int) (domain.Job, error) {
+	now := time.Now()
+
+	filter := bson.M{"$or": []bson.M{
+		{"status": domain.JobQueued, "next_run_at": bson.M{"$lte": now}},
+		{"status": domain.JobRunning, "locked_until": bson.M{"$lt": now}, "attempts": bson.M{"$lt": maxAttempts}},
+	}}
+
+	update := bson.M{
+		"$set": bson.M{
+			"status":       domain.JobRunning,
+			"locked_until": now.Add(lease),
+			"claim_token":  primitive.NewObjectID().Hex(),
+			"updated_at":   now,
+		},
+		"$inc": bson.M{"attempts": 1},
+	}
+
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.M{"next_run_at": 1}).
+		SetReturnDocument(options.After)
+
+	var job domain.Job
+	err := r.UserJobs.FindOneAndUpdate(ctx, filter, update, opts).Decode(&job)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return domain.Job{}, ErrNoJob
+		}
+		return domain.Job{}, errors.New("repository/job_repository: " + err.Error())
+	}
+
+	return job, nil
+}
+
+// FailAbandoned fails the running jobs whose lease expired after their last
+// attempt, a job that keeps crashing its worker is not picked up again.
+func (r *jobRepository) FailAbandoned(ctx context.Context, maxAttempts int) (int64, error) {
+	now := time.Now()
+
+	filter := bson.M{
+		"status":       domain.JobRunning,
+		"locked_until": bson.M{"$lt": now},
+		"attempts":     bson.M{"$gte": maxAttempts},
+	}
+
+	update := bson.M{"$set": bson.M{
+		"status":     domain.JobFailed,
+		"error":      domain.JobError{Code: domain.JobErrorInternal, Message: "the job stopped its worker too many times"},
+		"file":       nil,
+		"updated_at": now,
+	}}
+
+	result, err := r.UserJobs.UpdateMany(ctx, filter, update)
+
+	if err != nil {
+		return 0, errors.New("repository/job_repository: " + err.Error())
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// Renew extends the lease of a job still being worked on.
+func (r *jobRepository) Renew(ctx context.Context, job domain.Job, lease time.Duration) error {
+	now := time.Now()
+
+	return r.update(ctx, job, bson.M{
+		"locked_until": now.Add(lease),
+		"updated_at":   now,
+	})
+}
+
+func (r *jobRepository) UpdateProgress(ctx context.Context, job domain.Job, stage string, progress int, lease time.Duration) error {
+	now := time.Now()
+
+	return r.update(ctx, job, bson.M{
+		"stage":        stage,
+		"progress":     progress,
+		"locked_until": now.Add(lease),
+		"updated_at":   now,
+	})
+}
+
+func (r *jobRepository) Complete(ctx context.Context, job domain.Job, sectionID string) error {
+	return r.update(ctx, job, bson.M{
+		"status":     domain.JobSucceeded,
+		"stage":      domain.JobStageDone,
+		"progress":   100,
+		"section_id": sectionID,
+		"error":      nil,
+		"file":       nil,
+		"updated_at": time.Now(),
+	})
+}
+
+func (r *jobRepository) Retry(ctx context.Context, job domain.Job, jobErr domain.JobError, nextRunAt time.Time) error {
+	return r.update(ctx, job, bson.M{
+		"status":      domain.JobQueued,
+		"error":       jobErr,
+		"next_run_at": nextRunAt,
+		"updated_at":  time.Now(),
+	})
+}
+
+func (r *jobRepository) Fail(ctx context.Context, job domain.Job, jobErr domain.JobError) error {
+	return r.update(ctx, job, bson.M{
+		"status":     domain.JobFailed,
+		"error":      jobErr,
+		"file":       nil,
+		"updated_at": time.Now(),
+	})
+}
+
+func (r *jobRepository) GetJob(ctx context.Context, jobID, userID string) (domain.Job, error) {
+	objectID, err := primitive.ObjectIDFromHex(jobID)
+
+	if err != nil {
+		return domain.Job{}, errors.New("repository/job_repository: " + err.Error())
+	}
+
+	filter := bson.M{"_id": objectID, "user_id": userID}
+	projection := options.FindOne().SetProjection(bson.M{"file": 0})
+
+	var job domain.Job
+	err = r.UserJobs.FindOne(ctx, filter, projection).Decode(&job)
+
+	if err == mongo.ErrNoDocuments {
+		return domain.Job{}, err
+	}
+
+	if err != nil {
+		return domain.Job{}, errors.New("repository/job_repository: " + err.Error())
+	}
+
+	return job, nil
+}
+
+// update changes a running job as long as the claim of the worker holds,
+// or returns ErrLeaseLost.
+func (r *jobRepository) update(ctx context.Context, job domain.Job, set bson.M) error {
+	filter := bson.M{"_id": job.ID, "status": domain.JobRunning, "claim_token": job.ClaimToken}
+
+	result, err := r.UserJobs.UpdateOne(ctx, filter, bson.M{"$set": set})
+
+	if err != nil {
+		return errors.New("repository/job_repository: " + err.Error())
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrLeaseLost
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github/chera/fix-it/domain"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type LoginAttemptRepository interface {
+	LockedUntil(ctx context.Context, key string) (time.Time, error)
+	RecordFailure(ctx context.Context, key string, window time.Duration) (int, error)
+	Lock(ctx context.Context, key string, until time.Time) error
+	Reset(ctx context.Context, key string) error
+	EnsureIndexes(ctx context.Context) error
+}
+
+type loginAttemptRepository struct {
+	failures *mongo.Collection
+}
+
+func NewLoginAttemptRepository(db *mongo.Database) LoginAttemptRepository {
+	return &loginAttemptRepository{
+		failures: db.Collection("login_failures"),
+	}
+}
+
+// EnsureIndexes lets mongo forget failures once they are old.
+func (r *loginAttemptRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.failures.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return errors.New("repository/login_attempt_repository: " + err.Error())
+	}
+	return nil
+}
+
+// LockedUntil is when logins of a key are allowed again, the zero time when
+// they are.
+func (r *loginAttemptRepository) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	var failures domain.LoginFailures
+
+	err := r.failures.FindOne(ctx, bson.M{"_id": key}).Decode(&failures)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, errors.New("repository/login_attempt_repository: " + err.Error())
+	}
+
+	return failures.LockedUntil, nil
+}
+
+// RecordFailure counts a failed login and returns the failures of the key
+// so far. They are forgotten after window without any.
+func (r *loginAttemptRepository) RecordFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+	update := bson.M{
+		"$inc": bson.M{"failures": 1},
+		"$set": bson.M{"expires_at": time.Now().Add(window)},
+	}
+
+	var failures domain.LoginFailures
+
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := r.failures.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&failures)
+	if err != nil {
+		return 0, errors.New("repository/login_attempt_repository: " + err.Error())
+	}
+
+	return failures.Failures, nil
+}
+
+func (r *loginAttemptRepository) Lock(ctx context.Context, key string, until time.Time) error {
+	_, err := r.failures.UpdateOne(ctx, bson.M{"_id": key}, bson.M{"$max": bson.M{"locked_until": until}})
+	if err != nil {
+		return errors.New("repository/login_attempt_repository: " + err.Error())
+	}
+	return nil
+}
+
+func (r *loginAttemptRepository) Reset(ctx context.Context, key string) error {
+	_, err := r.failures.DeleteOne(ctx, bson.M{"_id": key})
+	if err != nil {
+		return errors.New("repository/login_attempt_repository: " + err.Error())
+	}
+	return nil
+}
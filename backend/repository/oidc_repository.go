@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github/chera/fix-it/domain"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type OIDCRepository interface {
+	SaveLogin(ctx context.Context, login domain.OIDCLogin) error
+	ConsumeLogin(ctx context.Context, hash string) (domain.OIDCLogin, error)
+	SaveSignup(ctx context.Context, signup domain.OIDCSignup) error
+	FindSignup(ctx context.Context, hash string) (domain.OIDCSignup, error)
+	DeleteSignup(ctx context.Context, id primitive.ObjectID) error
+	EnsureIndexes(ctx context.Context) error
+}
+
+type oidcRepository struct {
+	logins  *mongo.Collection
+	signups *mongo.Collection
+}
+
+func NewOIDCRepository(db *mongo.Database) OIDCRepository {
+	return &oidcRepository{
+		logins:  db.Collection("oidc_logins"),
+		signups: db.Collection("oidc_signups"),
+	}
+}
+
+// EnsureIndexes lets mongo delete logins and signups nobody came back for.
+func (r *oidcRepository) EnsureIndexes(ctx context.Context) error {
+	for _, collection := range []*mongo.Collection{r.logins, r.signups} {
+		_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+			{Keys: bson.D{{Key: "hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		})
+		if err != nil {
+			return errors.New("repository/oidc_repository: " + err.Error())
+		}
+	}
+	return nil
+}
+
+func (r *oidcRepository) SaveLogin(ctx context.Context, login domain.OIDCLogin) error {
+	if _, err := r.logins.InsertOne(ctx, login); err != nil {
+		return errors.New("repository/oidc_repository: " + err.Error())
+	}
+	return nil
+}
+
+// ConsumeLogin deletes an unexpired login and returns it, so a state works
+// once, or mongo.ErrNoDocuments when there is none.
+func (r *oidcRepository) ConsumeLogin(ctx context.Context, hash string) (domain.OIDCLogin, error) {
+	var login domain.OIDCLogin
+
+	err := r.logins.FindOneAndDelete(ctx, bson.M{"hash": hash, "expires_at": bson.M{"$gt": time.Now()}}).Decode(&login)
+	if err == mongo.ErrNoDocuments {
+		return login, err
+	}
+	if err != nil {
+		return login, errors.New("repository/oidc_repository: " + err.Error())
+	}
+
+	return login, nil
+}
+
+func (r *oidcRepository) SaveSignup(ctx context.Context, signup domain.OIDCSignup) error {
+	if _, err := r.signups.InsertOne(ctx, signup); err != nil {
+		return errors.New("repository/oidc_repository: " + err.Error())
+	}
+	return nil
+}
+
+// FindSignup returns an unexpired signup, or mongo.ErrNoDocuments. It stays
+// until DeleteSignup, a profile that isn't valid can be corrected.
+func (r *oidcRepository) FindSignup(ctx context.Context, hash string) (domain.OIDCSignup, error) {
+	var signup domain.OIDCSignup
+
+	err := r.signups.FindOne(ctx, bson.M{"hash": hash, "expires_at": bson.M{"$gt": time.Now()}}).Decode(&signup)
+	if err == mongo.ErrNoDocuments {
+		return signup, err
+	}
+	if err != nil {
+		return signup, errors.New("repository/oidc_repository: " + err.Error())
+	}
+
+	return signup, nil
+}
+
+func (r *oidcRepository) DeleteSignup(ctx context.Context, id primitive.ObjectID) error {
+	if _, err := r.signups.DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		return errors.New("repository/oidc_repository: " + err.Error())
+	}
+	return nil
+}
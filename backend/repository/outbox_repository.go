@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github/chera/fix-it/domain"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrNoEmail = errors.New("no email to send")
+
+type OutboxRepository interface {
+	Enqueue(ctx context.Context, email domain.Email) error
+	Claim(ctx context.Context, lease time.Duration) (domain.Email, error)
+	MarkSent(ctx context.Context, email domain.Email) error
+	Retry(ctx context.Context, email domain.Email, lastError string, nextRunAt time.Time) error
+	Fail(ctx context.Context, email domain.Email, lastError string) error
+	EnsureIndexes(ctx context.Context) error
+}
+
+type outboxRepository struct {
+	outbox *mongo.Collection
+}
+
+func NewOutboxRepository(db *mongo.Database) OutboxRepository {
+	return &outboxRepository{
+		outbox: db.Collection("outbox"),
+	}
+}
+
+// sent emails are kept for a while to look into delivery problems
+const sentEmailRetention = 7 * 24 * time.Hour
+
+func (r *outboxRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.outbox.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_run_at", Value: 1}}},
+		{Keys: bson.D{{Key: "sent_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(sentEmailRetention.Seconds()))},
+	})
+	if err != nil {
+		return errors.New("repository/outbox_repository: " + err.Error())
+	}
+	return nil
+}
+
+func (r *outboxRepository) Enqueue(ctx context.Context, email domain.Email) error {
+	now := time.Now()
+
+	email.Status = domain.EmailPending
+	email.Attempts = 0
+	email.NextRunAt = now
+	email.CreatedAt = now
+
+	if _, err := r.outbox.InsertOne(ctx, email); err != nil {
+		return errors.New("repository/outbox_repository: " + err.Error())
+	}
+	return nil
+}
+
+// Claim leases the oldest email due to be sent. Emails whose lease expired
+// belong to a worker that crashed while sending and are picked up again.
+func (r *outboxRepository) Claim(ctx context.Context, lease time.Duration) (domain.Email, error) {
+	now := time.Now()
+
+	filter := bson.M{"$or": []bson.M{
+		{"status": domain.EmailPending, "next_run_at": bson.M{"$lte": now}},
+		{"status": domain.EmailSending, "locked_until": bson.M{"$lt": now}},
+	}}
+
+	update := bson.M{
+		"$set": bson.M{
+			"status":       domain.EmailSending,
+			"locked_until": now.Add(lease),
+		},
+		"$inc": bson.M{"attempts": 1},
+	}
+
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.M{"next_run_at": 1}).
+		SetReturnDocument(options.After)
+
+	var email domain.Email
+	err := r.outbox.FindOneAndUpdate(ctx, filter, update, opts).Decode(&email)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return domain.Email{}, ErrNoEmail
+		}
+		return domain.Email{}, errors.New("repository/outbox_repository: " + err.Error())
+	}
+
+	return email, nil
+}
+
+func (r *outboxRepository) MarkSent(ctx context.Context, email domain.Email) error {
+	return r.update(ctx, email, bson.M{
+		"status":     domain.EmailSent,
+		"sent_at":    time.Now(),
+		"last_error": "",
+	})
+}
+
+func (r *outboxRepository) Retry(ctx context.Context, email domain.Email, lastError string, nextRunAt time.Time) error {
+	return r.update(ctx, email, bson.M{
+		"status":      domain.EmailPending,
+		"last_error":  lastError,
+		"next_run_at": nextRunAt,
+	})
+}
+
+func (r *outboxRepository) Fail(ctx context.Context, email domain.Email, lastError string) error {
+	return r.update(ctx, email, bson.M{
+		"status":     domain.EmailFailed,
+		"last_error": lastError,
+	})
+}
+
+func (r *outboxRepository) update(ctx context.Context, email domain.Email, set bson.M) error {
+	_, err := r.outbox.UpdateOne(ctx, bson.M{"_id": email.ID}, bson.M{"$set": set})
+	if err != nil {
+		return errors.New("repository/outbox_repository: " + err.Error())
+	}
+	return nil
+}
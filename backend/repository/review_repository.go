@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github/chera/fix-it/domain"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ReviewRepository interface {
+	FindReview(ctx context.Context, userID, quizID string, questionNO int) (domain.ReviewItem, error)
+	GetReview(ctx context.Context, reviewID, userID string) (domain.ReviewItem, error)
+	SaveReview(ctx context.Context, item domain.ReviewItem) error
+	DueReviews(ctx context.Context, userID string, now time.Time, limit int64) ([]domain.ReviewItem, error)
+}
+
+type reviewRepository struct {
+	UserReviews *mongo.Collection
+}
+
+func NewReviewRepository(db *mongo.Database) ReviewRepository {
+	return &reviewRepository{
+		UserReviews: db.Collection("reviews"),
+	}
+}
+
+func (r *reviewRepository) FindReview(ctx context.Context, userID, quizID string, questionNO int) (domain.ReviewItem, error) {
+	var item domain.ReviewItem
+
+	filter := bson.M{"user_id": userID, "quiz_id": quizID, "question_no": questionNO}
+
+	err := r.UserReviews.FindOne(ctx, filter).Decode(&item)
+
+	if err == mongo.ErrNoDocuments {
+		return domain.ReviewItem{}, err
+	}
+
+	if err != nil {
+		return domain.ReviewItem{}, errors.New("repository/review_repository: " + err.Error())
+	}
+
+	return item, nil
+}
+
+func (r *reviewRepository) GetReview(ctx context.Context, reviewID, userID string) (domain.ReviewItem, error) {
+	var item domain.ReviewItem
+
+	objectID, err := primitive.ObjectIDFromHex(reviewID)
+
+	if err != nil {
+		return domain.ReviewItem{}, errors.New("repository/review_repository: " + err.Error())
+	}
+
+	err = r.UserReviews.FindOne(ctx, bson.M{"_id": objectID, "user_id": userID}).Decode(&item)
+
+	if err != nil {
+		return domain.ReviewItem{}, errors.New("repository/review_repository: " + err.Error())
+	}
+
+	return item, nil
+}
+
+// SaveReview inserts a new item or replaces the stored one. Items are keyed
+// by user, quiz and question so concurrent submissions can't duplicate them.
+func (r *reviewRepository) SaveReview(ctx context.Context, item domain.ReviewItem) error {
+	filter := bson.M{"user_id": item.UserID, "quiz_id": item.QuizID, "question_no": item.QuestionNO}
+
+	if !item.ID.IsZero() {
+		filter = bson.M{"_id": item.ID}
+	}
+
+	_, err := r.UserReviews.ReplaceOne(ctx, filter, item, options.Replace().SetUpsert(true))
+
+	if err != nil {
+		return errors.New("repository/review_repository: " + err.Error())
+	}
+
+	return nil
+}
+
+func (r *reviewRepository) DueReviews(ctx context.Context, userID string, now time.Time, limit int64) ([]domain.ReviewItem, error) {
+	items := []domain.ReviewItem{}
+
+	filter := bson.M{"user_id": userID, "due_at": bson.M{"$lte": now}}
+	opts := options.Find().SetSort(bson.M{"due_at": 1}).SetLimit(limit)
+
+	cursor, err := r.UserReviews.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.New("repository/review_repository: " + err.Error())
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, errors.New("repository/review_repository: " + err.Error())
+	}
+
+	return items, nil
+}
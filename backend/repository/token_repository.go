@@ -0,0 +1,182 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github/chera/fix-it/domain"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type TokenRepository interface {
+	SaveRefreshToken(ctx context.Context, token domain.RefreshToken) error
+	FindRefreshToken(ctx context.Context, hash string) (domain.RefreshToken, error)
+	ConsumeRefreshToken(ctx context.Context, id primitive.ObjectID) (bool, error)
+	RevokeFamily(ctx context.Context, family string) error
+	RevokeUserTokens(ctx context.Context, userID string) error
+	RevokeOtherSessions(ctx context.Context, userID, accessID string) error
+	DenyAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+	EnsureIndexes(ctx context.Context) error
+}
+
+type tokenRepository struct {
+	refreshTokens *mongo.Collection
+	// ids of access tokens revoked before they expired
+	revokedTokens *mongo.Collection
+}
+
+func NewTokenRepository(db *mongo.Database) TokenRepository {
+	return &tokenRepository{
+		refreshTokens: db.Collection("refresh_tokens"),
+		revokedTokens: db.Collection("revoked_tokens"),
+	}
+}
+
+// EnsureIndexes makes token lookups fast and lets mongo delete tokens once
+// they expired.
+func (r *tokenRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.refreshTokens.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "family", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return errors.New("repository/token_repository: " + err.Error())
+	}
+
+	_, err = r.revokedTokens.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return errors.New("repository/token_repository: " + err.Error())
+	}
+
+	return nil
+}
+
+func (r *tokenRepository) SaveRefreshToken(ctx context.Context, token domain.RefreshToken) error {
+	_, err := r.refreshTokens.InsertOne(ctx, token)
+	if err != nil {
+		return errors.New("repository/token_repository: " + err.Error())
+	}
+	return nil
+}
+
+// FindRefreshToken returns mongo.ErrNoDocuments for unknown tokens.
+func (r *tokenRepository) FindRefreshToken(ctx context.Context, hash string) (domain.RefreshToken, error) {
+	var token domain.RefreshToken
+
+	err := r.refreshTokens.FindOne(ctx, bson.M{"hash": hash}).Decode(&token)
+	if err == mongo.ErrNoDocuments {
+		return token, err
+	}
+	if err != nil {
+		return token, errors.New("repository/token_repository: " + err.Error())
+	}
+
+	return token, nil
+}
+
+// ConsumeRefreshToken revokes a token that is being replaced. It tells
+// whether the token was still active, two refreshes racing with the same
+// token can't both succeed.
+func (r *tokenRepository) ConsumeRefreshToken(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	result, err := r.refreshTokens.UpdateOne(ctx,
+		bson.M{"_id": id, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	if err != nil {
+		return false, errors.New("repository/token_repository: " + err.Error())
+	}
+
+	return result.ModifiedCount == 1, nil
+}
+
+func (r *tokenRepository) RevokeFamily(ctx context.Context, family string) error {
+	return r.revoke(ctx, bson.M{"family": family})
+}
+
+func (r *tokenRepository) RevokeUserTokens(ctx context.Context, userID string) error {
+	return r.revoke(ctx, bson.M{"user_id": userID})
+}
+
+// RevokeOtherSessions revokes the sessions of a user except the one the
+// access token was issued to.
+func (r *tokenRepository) RevokeOtherSessions(ctx context.Context, userID, accessID string) error {
+	var current domain.RefreshToken
+
+	err := r.refreshTokens.FindOne(ctx, bson.M{"user_id": userID, "access_id": accessID}).Decode(&current)
+	if err == mongo.ErrNoDocuments {
+		return r.RevokeUserTokens(ctx, userID)
+	}
+	if err != nil {
+		return errors.New("repository/token_repository: " + err.Error())
+	}
+
+	return r.revoke(ctx, bson.M{"user_id": userID, "family": bson.M{"$ne": current.Family}})
+}
+
+// revoke revokes the refresh tokens matching filter and denies the access
+// tokens issued with them that may still be in use.
+func (r *tokenRepository) revoke(ctx context.Context, filter bson.M) error {
+	now := time.Now()
+
+	cursor, err := r.refreshTokens.Find(ctx, bson.M{"$and": []bson.M{filter, {"access_expires_at": bson.M{"$gt": now}}}})
+	if err != nil {
+		return errors.New("repository/token_repository: " + err.Error())
+	}
+
+	var tokens []domain.RefreshToken
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return errors.New("repository/token_repository: " + err.Error())
+	}
+
+	for _, token := range tokens {
+		if err := r.DenyAccessToken(ctx, token.AccessID, token.AccessExpiresAt); err != nil {
+			return err
+		}
+	}
+
+	_, err = r.refreshTokens.UpdateMany(ctx,
+		bson.M{"$and": []bson.M{filter, {"revoked_at": bson.M{"$exists": false}}}},
+		bson.M{"$set": bson.M{"revoked_at": now}},
+	)
+	if err != nil {
+		return errors.New("repository/token_repository: " + err.Error())
+	}
+
+	return nil
+}
+
+// DenyAccessToken keeps a token id on the denylist until the token expires.
+func (r *tokenRepository) DenyAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	if jti == "" {
+		return nil
+	}
+
+	_, err := r.revokedTokens.UpdateOne(ctx,
+		bson.M{"_id": jti},
+		bson.M{"$set": bson.M{"expires_at": expiresAt}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return errors.New("repository/token_repository: " + err.Error())
+	}
+
+	return nil
+}
+
+func (r *tokenRepository) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	count, err := r.revokedTokens.CountDocuments(ctx, bson.M{"_id": jti}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, errors.New("repository/token_repository: " + err.Error())
+	}
+	return count > 0, nil
+}
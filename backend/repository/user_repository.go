@@ -8,27 +8,126 @@ import (
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type UserRepository interface {
-	CreateUser(ctx context.Context, user domain.User) error
+	CreateUser(ctx context.Context, user domain.User) (string, error)
 	VerifyUser(ctx context.Context, email, token string) error
 	IsUserExist(ctx context.Context, username string) (bool, error)
 	GetUserByEmail(ctx context.Context, email string) (domain.User, error)
 	GetUserByUsername(ctx context.Context, username string) (domain.User, error)
+	GetUserByID(ctx context.Context, userID string) (domain.User, error)
+	GetUserByIdentity(ctx context.Context, identity domain.Identity) (domain.User, error)
+	LinkIdentity(ctx context.Context, userID string, identity domain.Identity) error
+	CreateLinkedUser(ctx context.Context, user domain.User) (string, error)
+	UpdatePassword(ctx context.Context, userID, hashedPassword string) error
+	SavePasswordReset(ctx context.Context, reset domain.PasswordReset) error
+	ConsumePasswordReset(ctx context.Context, hash string) (domain.PasswordReset, error)
+	RenewVerification(ctx context.Context, email, token string, interval time.Duration, maxResends int) error
+	SetPendingMFASecret(ctx context.Context, userID, secret string) error
+	EnableMFA(ctx context.Context, userID, secret string, recoveryCodes []string, step int64) error
+	DisableMFA(ctx context.Context, userID string) error
+	UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID, hash string) (bool, error)
+	SaveMFAChallenge(ctx context.Context, challenge domain.MFAChallenge) error
+	AttemptMFAChallenge(ctx context.Context, hash string, maxAttempts int) (domain.MFAChallenge, error)
+	DeleteMFAChallenge(ctx context.Context, id primitive.ObjectID) error
+	EnsureIndexes(ctx context.Context) error
 }
 
 type userRepository struct {
-	users        *mongo.Collection
-	verification *mongo.Collection
+	users          *mongo.Collection
+	verification   *mongo.Collection
+	passwordResets *mongo.Collection
+	mfaChallenges  *mongo.Collection
 }
 
+var ErrVerificationThrottled = errors.New("verification email was sent recently")
+
+// pending registrations are deleted once their verification token expired
+const pendingRegistrationTTL = 24 * time.Hour
+
 func NewUserRepository(db *mongo.Database) UserRepository {
 	return &userRepository{
-		users:        db.Collection("users"),
-		verification: db.Collection("verification"),
+		users:          db.Collection("users"),
+		verification:   db.Collection("verification"),
+		passwordResets: db.Collection("password_resets"),
+		mfaChallenges:  db.Collection("mfa_challenges"),
+	}
+}
+
+// EnsureIndexes lets mongo delete pending registrations, password resets
+// and MFA challenges once they expired, and keeps a single pending registration per email and
+// username and a single user per provider account.
+func (r *userRepository) EnsureIndexes(ctx context.Context) error {
+	for _, field := range []string{"email", "username"} {
+		if err := r.removeDuplicateRegistrations(ctx, field); err != nil {
+			return err
+		}
+	}
+
+	_, err := r.verification.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "username", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "createdAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(pendingRegistrationTTL.Seconds()))},
+	})
+	if err != nil {
+		return errors.New("repository/user_repository: " + err.Error())
+	}
+
+	for _, collection := range []*mongo.Collection{r.passwordResets, r.mfaChallenges} {
+		_, err = collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+			{Keys: bson.D{{Key: "hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		})
+		if err != nil {
+			return errors.New("repository/user_repository: " + err.Error())
+		}
+	}
+
+	// a provider account belongs to one user at most
+	_, err = r.users.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "identities", Value: 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{
+			"identities": bson.M{"$exists": true},
+		}),
+	})
+	if err != nil {
+		return errors.New("repository/user_repository: " + err.Error())
+	}
+
+	return nil
+}
+
+// removeDuplicateRegistrations keeps the newest of the pending registrations
+// made before they were unique, so the unique indexes can be built.
+func (r *userRepository) removeDuplicateRegistrations(ctx context.Context, field string) error {
+	cursor, err := r.verification.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$sort", Value: bson.M{"createdAt": -1}}},
+		{{Key: "$group", Value: bson.M{"_id": "$" + field, "ids": bson.M{"$push": "$_id"}, "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	})
+	if err != nil {
+		return errors.New("repository/user_repository: " + err.Error())
+	}
+
+	var duplicates []struct {
+		IDs []primitive.ObjectID `bson:"ids"`
 	}
+	if err := cursor.All(ctx, &duplicates); err != nil {
+		return errors.New("repository/user_repository: " + err.Error())
+	}
+
+	for _, duplicate := range duplicates {
+		if _, err := r.verification.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": duplicate.IDs[1:]}}); err != nil {
+			return errors.New("repository/user_repository: " + err.Error())
+		}
+	}
+
+	return nil
 }
 
 func (r *userRepository) VerifyUser(ctx context.Context, email, token string) error {
@@ -43,6 +142,17 @@ func (r *userRepository) VerifyUser(ctx context.Context, email, token string) er
 		return errors.New("repository/user_repository: " + err.Error())
 	}
 
+	// another registration with the username may have been verified first
+	exists, err := r.IsUserExist(ctx, user.Username)
+
+	if err != nil {
+		return errors.New("repository/user_repository: " + err.Error())
+	}
+
+	if exists {
+		return errors.New("username is taken please register again with another username")
+	}
+
 	_, err = r.users.InsertOne(ctx, user)
 
 	if err != nil {
@@ -61,28 +171,30 @@ func (r *userRepository) VerifyUser(ctx context.Context, email, token string) er
 
 }
 
-func (r *userRepository) CreateUser(ctx context.Context, user domain.User) error {
+// CreateUser stores a registration until it is verified and returns the
+// verification token to email.
+func (r *userRepository) CreateUser(ctx context.Context, user domain.User) (string, error) {
 
 	_, err := r.GetUserByEmail(ctx, user.Email)
 
 	if err == nil {
-		return errors.New("user email already exist")
+		return "", errors.New("user email already exist")
 	}
 
 	exists, err := r.IsUserExist(ctx, user.Username)
 
 	if err != nil {
-		return errors.New("repository/user_repository: " + err.Error())
+		return "", errors.New("repository/user_repository: " + err.Error())
 	}
 
 	if exists {
-		return errors.New("username is taken please change username")
+		return "", errors.New("username is taken please change username")
 	}
 
 	token, err := infrastructure.GenerateToken(user.Email)
 
 	if err != nil {
-		return errors.New("repository/user_repository: " + err.Error())
+		return "", errors.New("repository/user_repository: " + err.Error())
 	}
 
 	new_user := bson.M{
@@ -93,20 +205,29 @@ func (r *userRepository) CreateUser(ctx context.Context, user domain.User) error
 		"password":  user.Password,
 		"token":     token,
 		"createdAt": time.Now(),
+		"resends":   0,
 	}
 
-	err = infrastructure.SendEmail(user.Email, token)
+	// registering again replaces the registrations that were never verified,
+	// with the email or the username, whoever verifies first gets the account
+	filter := bson.M{"$or": []bson.M{{"email": user.Email}, {"username": user.Username}}}
+
+	_, err = r.verification.DeleteMany(ctx, filter)
 
 	if err != nil {
-		return errors.New("repository/user_repository: " + err.Error())
+		return "", errors.New("repository/user_repository: " + err.Error())
 	}
 
 	_, err = r.verification.InsertOne(ctx, new_user)
 
+	if mongo.IsDuplicateKeyError(err) {
+		return "", errors.New("this email or username is being registered right now, try again")
+	}
+
 	if err != nil {
-		return errors.New("repository/user_repository: " + err.Error())
+		return "", errors.New("repository/user_repository: " + err.Error())
 	}
-	return nil
+	return token, nil
 
 }
 
@@ -150,3 +271,281 @@ func (r *userRepository) GetUserByUsername(ctx context.Context, username string)
 	}
 	return user, nil
 }
+
+func (r *userRepository) GetUserByID(ctx context.Context, userID string) (domain.User, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return domain.User{}, errors.New("repository/user_repository: " + err.Error())
+	}
+
+	var user domain.User
+	err = r.users.FindOne(ctx, bson.M{"_id": id}).Decode(&user)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return domain.User{}, errors.New("no such user")
+		}
+		return domain.User{}, errors.New("repository/user_repository: " + err.Error())
+	}
+	return user, nil
+}
+
+// GetUserByIdentity returns mongo.ErrNoDocuments when no user is linked to
+// the provider account.
+func (r *userRepository) GetUserByIdentity(ctx context.Context, identity domain.Identity) (domain.User, error) {
+	var user domain.User
+	// identities are matched whole, like the unique index compares them
+	filter := bson.M{"identities": identity}
+
+	err := r.users.FindOne(ctx, filter).Decode(&user)
+
+	if err == mongo.ErrNoDocuments {
+		return user, err
+	}
+	if err != nil {
+		return user, errors.New("repository/user_repository: " + err.Error())
+	}
+	return user, nil
+}
+
+func (r *userRepository) LinkIdentity(ctx context.Context, userID string, identity domain.Identity) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return errors.New("repository/user_repository: " + err.Error())
+	}
+
+	_, err = r.users.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$addToSet": bson.M{"identities": identity}})
+
+	if mongo.IsDuplicateKeyError(err) {
+		return errors.New("this account of the provider is linked to another user")
+	}
+
+	if err != nil {
+		return errors.New("repository/user_repository: " + err.Error())
+	}
+
+	return nil
+}
+
+// CreateLinkedUser stores a user of a provider right away, the provider
+// verified the email. Registrations waiting for a verification of the same
+// email are dropped.
+func (r *userRepository) CreateLinkedUser(ctx context.Context, user domain.User) (string, error) {
+	_, err := r.GetUserByEmail(ctx, user.Email)
+
+	if err == nil {
+		return "", errors.New("user email already exist")
+	}
+
+	exists, err := r.IsUserExist(ctx, user.Username)
+
+	if err != nil {
+		return "", errors.New("repository/user_repository: " + err.Error())
+	}
+
+	if exists {
+		return "", errors.New("username is taken please change username")
+	}
+
+	_, err = r.verification.DeleteMany(ctx, bson.M{"email": user.Email})
+
+	if err != nil {
+		return "", errors.New("repository/user_repository: " + err.Error())
+	}
+
+	user.ID = primitive.NewObjectID()
+	_, err = r.users.InsertOne(ctx, user)
+
+	if mongo.IsDuplicateKeyError(err) {
+		return "", errors.New("this account of the provider is linked to another user")
+	}
+
+	if err != nil {
+		return "", errors.New("repository/user_repository: " + err.Error())
+	}
+
+	return user.ID.Hex(), nil
+}
+
+func (r *userRepository) UpdatePassword(ctx context.Context, userID, hashedPassword string) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return errors.New("repository/user_repository: " + err.Error())
+	}
+
+	_, err = r.users.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"password": hashedPassword}})
+	if err != nil {
+		return errors.New("repository/user_repository: " + err.Error())
+	}
+
+	return nil
+}
+
+// SavePasswordReset stores a reset, replacing the unused ones of the same
+// user so only the newest link works.
+func (r *userRepository) SavePasswordReset(ctx context.Context, reset domain.PasswordReset) error {
+	_, err := r.passwordResets.DeleteMany(ctx, bson.M{"user_id": reset.UserID, "used_at": bson.M{"$exists": false}})
+	if err != nil {
+		return errors.New("repository/user_repository: " + err.Error())
+	}
+
+	_, err = r.passwordResets.InsertOne(ctx, reset)
+	if err != nil {
+		return errors.New("repository/user_repository: " + err.Error())
+	}
+
+	return nil
+}
+
+// ConsumePasswordReset marks an unused, unexpired reset as used and returns
+// it, or mongo.ErrNoDocuments when there is none.
+func (r *userRepository) ConsumePasswordReset(ctx context.Context, hash string) (domain.PasswordReset, error) {
+	now := time.Now()
+
+	var reset domain.PasswordReset
+	err := r.passwordResets.FindOneAndUpdate(ctx,
+		bson.M{"hash": hash, "used_at": bson.M{"$exists": false}, "expires_at": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{"used_at": now}},
+	).Decode(&reset)
+
+	if err == mongo.ErrNoDocuments {
+		return reset, err
+	}
+	if err != nil {
+		return reset, errors.New("repository/user_repository: " + err.Error())
+	}
+
+	return reset, nil
+}
+
+// RenewVerification replaces the verification token of a pending
+// registration, unless one was sent less than interval ago or it was sent
+// maxResends times already, which gives ErrVerificationThrottled. Emails
+// without a pending registration give mongo.ErrNoDocuments.
+func (r *userRepository) RenewVerification(ctx context.Context, email, token string, interval time.Duration, maxResends int) error {
+	now := time.Now()
+
+	filter := bson.M{
+		"email":     email,
+		"createdAt": bson.M{"$lte": now.Add(-interval)},
+		"resends":   bson.M{"$not": bson.M{"$gte": maxResends}},
+	}
+
+	// the registration lives on for as long as the new token
+	update := bson.M{
+		"$set": bson.M{"token": token, "createdAt": now},
+		"$inc": bson.M{"resends": 1},
+	}
+
+	result, err := r.verification.UpdateOne(ctx, filter, update)
+
+	if err != nil {
+		return errors.New("repository/user_repository: " + err.Error())
+	}
+
+	if result.MatchedCount == 1 {
+		return nil
+	}
+
+	count, err := r.verification.CountDocuments(ctx, bson.M{"email": email})
+
+	if err != nil {
+		return errors.New("repository/user_repository: " + err.Error())
+	}
+
+	if count == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return ErrVerificationThrottled
+}
+
+func (r *userRepository) updateUser(ctx context.Context, userID string, filter, update bson.M) (bool, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return false, errors.New("repository/user_repository: " + err.Error())
+	}
+
+	filter["_id"] = id
+
+	result, err := r.users.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, errors.New("repository/user_repository: " + err.Error())
+	}
+
+	return result.MatchedCount == 1, nil
+}
+
+// SetPendingMFASecret keeps a new secret until a code of it is confirmed.
+func (r *userRepository) SetPendingMFASecret(ctx context.Context, userID, secret string) error {
+	_, err := r.updateUser(ctx, userID, bson.M{}, bson.M{"$set": bson.M{"mfa.pending_secret": secret}})
+	return err
+}
+
+func (r *userRepository) EnableMFA(ctx context.Context, userID, secret string, recoveryCodes []string, step int64) error {
+	_, err := r.updateUser(ctx, userID, bson.M{}, bson.M{"$set": bson.M{"mfa": domain.MFA{
+		Enabled:       true,
+		Secret:        secret,
+		RecoveryCodes: recoveryCodes,
+		LastStep:      step,
+	}}})
+	return err
+}
+
+func (r *userRepository) DisableMFA(ctx context.Context, userID string) error {
+	_, err := r.updateUser(ctx, userID, bson.M{}, bson.M{"$unset": bson.M{"mfa": ""}})
+	return err
+}
+
+// UseTOTPStep records the step of a code being used, and tells false when
+// a code of that step or a later one was used already.
+func (r *userRepository) UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	return r.updateUser(ctx, userID,
+		bson.M{"mfa.last_step": bson.M{"$lt": step}},
+		bson.M{"$set": bson.M{"mfa.last_step": step}},
+	)
+}
+
+// UseRecoveryCode removes a recovery code, and tells false when the user
+// doesn't have it.
+func (r *userRepository) UseRecoveryCode(ctx context.Context, userID, hash string) (bool, error) {
+	return r.updateUser(ctx, userID,
+		bson.M{"mfa.recovery_codes": hash},
+		bson.M{"$pull": bson.M{"mfa.recovery_codes": hash}},
+	)
+}
+
+func (r *userRepository) SaveMFAChallenge(ctx context.Context, challenge domain.MFAChallenge) error {
+	if _, err := r.mfaChallenges.InsertOne(ctx, challenge); err != nil {
+		return errors.New("repository/user_repository: " + err.Error())
+	}
+	return nil
+}
+
+// AttemptMFAChallenge counts an attempt at a challenge and returns it, or
+// mongo.ErrNoDocuments when it is unknown, expired or out of attempts.
+func (r *userRepository) AttemptMFAChallenge(ctx context.Context, hash string, maxAttempts int) (domain.MFAChallenge, error) {
+	var challenge domain.MFAChallenge
+
+	err := r.mfaChallenges.FindOneAndUpdate(ctx,
+		bson.M{"hash": hash, "expires_at": bson.M{"$gt": time.Now()}, "attempts": bson.M{"$lt": maxAttempts}},
+		bson.M{"$inc": bson.M{"attempts": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&challenge)
+
+	if err == mongo.ErrNoDocuments {
+		return challenge, err
+	}
+	if err != nil {
+		return challenge, errors.New("repository/user_repository: " + err.Error())
+	}
+
+	return challenge, nil
+}
+
+func (r *userRepository) DeleteMFAChallenge(ctx context.Context, id primitive.ObjectID) error {
+	if _, err := r.mfaChallenges.DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		return errors.New("repository/user_repository: " + err.Error())
+	}
+	return nil
+}
@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"github/chera/fix-it/domain"
 	"github/chera/fix-it/infrastructure"
+	"io"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type ViewRepository interface {
@@ -17,6 +19,8 @@ type ViewRepository interface {
 	GetExplanation(ctx context.Context, explanationID string) (domain.Conversation, error)
 	GetSection(ctx context.Context, sectionID, userID string) (domain.Section, error)
 	SectionList(ctx context.Context, userID string) ([]domain.Section, error)
+	SectionRounds(ctx context.Context, rootID, userID string) ([]domain.Section, error)
+	OpenDocument(ctx context.Context, documentID string) (domain.Document, io.ReadSeekCloser, error)
 }
 
 type viewRepository struct {
@@ -24,14 +28,16 @@ type viewRepository struct {
 	UserQuiz         *mongo.Collection
 	UserSections     *mongo.Collection
 	UserConversation *mongo.Collection
+	Blobs            infrastructure.BlobStorage
 }
 
-func NewViewController(db *mongo.Database) ViewRepository {
+func NewViewController(db *mongo.Database, blobs infrastructure.BlobStorage) ViewRepository {
 	return &viewRepository{
 		UserBooks:        db.Collection("pdf"),
 		UserQuiz:         db.Collection("quiz"),
 		UserConversation: db.Collection("conversation"),
 		UserSections:     db.Collection("section"),
+		Blobs:            blobs,
 	}
 }
 
@@ -83,7 +89,8 @@ func (r *viewRepository) GetTopic(ctx context.Context, conversationID string) (d
 
 	}
 
-	topicConvert := infrastructure.ParseTopicGemini(conversation.Turns[2].Gemini)
+	topicConvert := infrastructure.ParseTopicResponse(conversation.Turns[2].Gemini)
+	topicConvert.Sources = conversation.Turns[2].Sources
 
 	return topicConvert, nil
 }
@@ -111,6 +118,37 @@ func (r *viewRepository) GetSection(ctx context.Context, sectionID, userID strin
 	return section, nil
 }
 
+// OpenDocument returns the record of a document and a reader over its
+// original file. Documents uploaded before files were kept have no file and give
+// infrastructure.ErrBlobNotFound.
+func (r *viewRepository) OpenDocument(ctx context.Context, documentID string) (domain.Document, io.ReadSeekCloser, error) {
+	var document domain.Document
+
+	objectID, err := primitive.ObjectIDFromHex(documentID)
+
+	if err != nil {
+		return domain.Document{}, nil, err
+	}
+
+	err = r.UserBooks.FindOne(ctx, bson.M{"_id": objectID}).Decode(&document)
+
+	if err != nil {
+		return domain.Document{}, nil, err
+	}
+
+	if document.DropBox == "" {
+		return domain.Document{}, nil, infrastructure.ErrBlobNotFound
+	}
+
+	file, err := r.Blobs.Open(ctx, document.DropBox)
+
+	if err != nil {
+		return domain.Document{}, nil, err
+	}
+
+	return document, file, nil
+}
+
 func (r *viewRepository) GetQuiz(ctx context.Context, quizID string) (domain.Quiz, error) {
 	var quiz domain.Quiz
 
@@ -150,3 +188,32 @@ func (r *viewRepository) GetExplanation(ctx context.Context, explanationID strin
 
 	return conversation, nil
 }
+
+// SectionRounds returns a section and every follow-up generated from it,
+// oldest round first.
+func (r *viewRepository) SectionRounds(ctx context.Context, rootID, userID string) ([]domain.Section, error) {
+	sections := []domain.Section{}
+
+	objectID, err := primitive.ObjectIDFromHex(rootID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{
+		"created_by": userID,
+		"$or":        []bson.M{{"_id": objectID}, {"root_id": rootID}},
+	}
+
+	cursor, err := r.UserSections.Find(ctx, filter, options.Find().SetSort(bson.M{"round": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &sections); err != nil {
+		return nil, err
+	}
+
+	return sections, nil
+}
@@ -1,60 +1,122 @@
 package usecases
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"github/chera/fix-it/domain"
+	"github/chera/fix-it/infrastructure"
 	"github/chera/fix-it/repository"
-	"mime/multipart"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type ActionUsecase interface {
-	ProcessPDF(ctx context.Context, link string) (string, error)
-	UploadQuestions(ctx context.Context, questions []domain.Question, userID string) (string, error)
+	ExtractText(ctx context.Context, file io.Reader, filename string) ([]domain.DocumentPage, error)
+	UploadQuestions(ctx context.Context, questions []domain.Question, options domain.QuizOptions, userID string) (string, error)
 	UploadConversation(ctx context.Context, conversation []domain.ConversationTurn) (string, error)
 	UploadSection(ctx context.Context, section domain.Section) (string, error)
 	UpdateSection(ctx context.Context, section domain.Section) error
-	UploadPDF(ctx context.Context, pdf domain.PDF) (string, error)
-	QuizAnswer(ctx context.Context, quiz_id string, answers []domain.Answer) (int, bool, error)
+	UploadDocument(ctx context.Context, document domain.Document, content []byte) (string, error)
+	GetDocument(ctx context.Context, documentID, userID string) (domain.Document, error)
+	QuizAnswer(ctx context.Context, quiz_id string, answers []domain.Answer) (domain.QuizGrade, bool, error)
 
-	CreateExplanation(ctx context.Context, quizID string, answers domain.AnswerList) (string, error)
-	CreateTopic(ctx context.Context, answerID, conversationID string) (string, error)
+	CreateExplanation(ctx context.Context, quizID string, answers domain.AnswerList, onChunk func(chunk string) error) (string, error)
+	CreateTopic(ctx context.Context, answerID, conversationID string, onChunk func(chunk string) error) (string, error)
 
-	GetPdfLink(ctx context.Context, file multipart.File, filename string) (string, error)
-	UploadForGemini(processed_text string) ([]domain.Question, []domain.ConversationTurn, error)
+	UploadForGemini(ctx context.Context, pages []domain.DocumentPage, options domain.QuizOptions) ([]domain.Question, []domain.ConversationTurn, error)
+	ProcessUpload(ctx context.Context, job domain.Job, progress func(stage string, progress int)) (string, error)
+	ValidateUpload(ctx context.Context, content []byte, filename string) (string, error)
+	UploadLimits() infrastructure.UploadLimits
+	PrepareQuizOptions(options *domain.QuizOptions) error
+	CreateFollowUpQuiz(ctx context.Context, parent domain.Section, topics domain.TopicList, options domain.QuizOptions) (string, error)
+	Chat(ctx context.Context, conversationID, message string) (domain.ChatTurn, error)
 }
 
 type actionUsecase struct {
 	ActionRepository repository.ActionRepository
+	Config           infrastructure.GenerationConfig
+	Limits           infrastructure.UploadLimits
+	// Scanner is nil when uploads aren't scanned for malware
+	Scanner infrastructure.Scanner
 }
 
-func NewActionUsecase(repo repository.ActionRepository) ActionUsecase {
+func NewActionUsecase(repo repository.ActionRepository, config infrastructure.GenerationConfig, limits infrastructure.UploadLimits, scanner infrastructure.Scanner) ActionUsecase {
 	return &actionUsecase{
 		ActionRepository: repo,
+		Config:           config,
+		Limits:           limits,
+		Scanner:          scanner,
 	}
 }
 
-func (a *actionUsecase) UploadPDF(ctx context.Context, pdf domain.PDF) (string, error) {
-	return a.ActionRepository.UploadPDF(ctx, pdf)
+func (a *actionUsecase) UploadLimits() infrastructure.UploadLimits {
+	return a.Limits
 }
 
-func (a *actionUsecase) ProcessPDF(ctx context.Context, link string) (string, error) {
-	return a.ActionRepository.ProcessPDF(ctx, link)
+// ValidateUpload refuses files that are too large, not what they claim to
+// be, unreadable or infected, and returns the media type of the others.
+// Refusals are *infrastructure.UploadError.
+func (a *actionUsecase) ValidateUpload(ctx context.Context, content []byte, filename string) (string, error) {
+	mediaType, err := infrastructure.ValidateUpload(content, filename, a.Limits)
+	if err != nil {
+		return "", err
+	}
+
+	if a.Scanner == nil {
+		return mediaType, nil
+	}
+
+	result, err := a.Scanner.Scan(ctx, content)
+	if err != nil {
+		// an upload nobody could scan is not let through
+		log.Println("usecases/action_usecase.go: ValidateUpload " + err.Error())
+		return "", &infrastructure.UploadError{Code: infrastructure.UploadScannerUnavailable, Message: "uploads can't be checked right now, try again later"}
+	}
+
+	if !result.Clean {
+		log.Printf("usecases/action_usecase.go: ValidateUpload rejected %s, %s", filename, result.Signature)
+		return "", &infrastructure.UploadError{Code: infrastructure.UploadInfected, Message: "the file contains malware"}
+	}
+
+	return mediaType, nil
 }
 
-func (a *actionUsecase) CreateTopic(ctx context.Context, answerID, conversationID string) (string, error) {
-	return a.ActionRepository.CreateTopic(ctx, answerID, conversationID)
+func (a *actionUsecase) UploadDocument(ctx context.Context, document domain.Document, content []byte) (string, error) {
+	return a.ActionRepository.UploadDocument(ctx, document, content)
+}
+
+func (a *actionUsecase) GetDocument(ctx context.Context, documentID, userID string) (domain.Document, error) {
+	return a.ActionRepository.GetDocument(ctx, documentID, userID)
+}
+
+func (a *actionUsecase) ExtractText(ctx context.Context, file io.Reader, filename string) ([]domain.DocumentPage, error) {
+	return a.ActionRepository.ExtractText(ctx, file, filename)
+}
+
+func (a *actionUsecase) CreateTopic(ctx context.Context, answerID, conversationID string, onChunk func(chunk string) error) (string, error) {
+	return a.ActionRepository.CreateTopic(ctx, answerID, conversationID, onChunk)
+}
+
+func (a *actionUsecase) Chat(ctx context.Context, conversationID, message string) (domain.ChatTurn, error) {
+	return a.ActionRepository.Chat(ctx, conversationID, message, a.Config.ChatWindowTurns, a.Config.ChatTokenBudget)
 }
 
 func (a *actionUsecase) UpdateSection(ctx context.Context, section domain.Section) error {
 	return a.ActionRepository.UpdateSection(ctx, section)
 }
 
-func (a *actionUsecase) CreateExplanation(ctx context.Context, quizID string, answers domain.AnswerList) (string, error) {
-	return a.ActionRepository.CreateExplanation(ctx, quizID, answers)
+func (a *actionUsecase) CreateExplanation(ctx context.Context, quizID string, answers domain.AnswerList, onChunk func(chunk string) error) (string, error) {
+	return a.ActionRepository.CreateExplanation(ctx, quizID, answers, onChunk)
 }
 
-func (a *actionUsecase) QuizAnswer(ctx context.Context, quiz_id string, answers []domain.Answer) (int, bool, error) {
+func (a *actionUsecase) QuizAnswer(ctx context.Context, quiz_id string, answers []domain.Answer) (domain.QuizGrade, bool, error) {
 	return a.ActionRepository.QuizAnswer(ctx, quiz_id, answers)
 
 }
@@ -72,29 +134,439 @@ func (a *actionUsecase) UploadConversation(ctx context.Context, conversation []d
 	return conversationId, nil
 }
 
-func (a *actionUsecase) UploadQuestions(ctx context.Context, questions []domain.Question, userID string) (string, error) {
-	questionId, err := a.ActionRepository.UploadQuestions(ctx, questions, userID)
+func (a *actionUsecase) UploadQuestions(ctx context.Context, questions []domain.Question, options domain.QuizOptions, userID string) (string, error) {
+	questionId, err := a.ActionRepository.UploadQuestions(ctx, questions, options, userID)
 	if err != nil {
 		return "", errors.New("usecases/action_usecase.go: UploadQuestions " + err.Error())
 	}
 	return questionId, nil
 }
 
-func (a *actionUsecase) UploadForGemini(processedText string) ([]domain.Question, []domain.ConversationTurn, error) {
+// PrepareQuizOptions validates requested quiz options and applies the
+// configured defaults.
+func (a *actionUsecase) PrepareQuizOptions(options *domain.QuizOptions) error {
+	return infrastructure.ValidateQuizOptions(options, a.Config.QuestionCount)
+}
+
+// UploadForGemini generates the quiz for a document. Documents larger than
+// the token budget are split into chunks that are generated in parallel and
+// merged into a single quiz.
+func (a *actionUsecase) UploadForGemini(ctx context.Context, pages []domain.DocumentPage, options domain.QuizOptions) ([]domain.Question, []domain.ConversationTurn, error) {
+
+	// the markers let the model cite pages, and are checked against the plain text
+	chunks := infrastructure.ChunkPages(infrastructure.MarkPages(pages), a.Config.ChunkTokenBudget)
+	document := infrastructure.PageChunks(pages)
+
+	if len(chunks) == 0 {
+		return []domain.Question{}, []domain.ConversationTurn{}, errors.New("usecases/action_usecase.go: UploadForGemini document has no text")
+	}
+
+	if len(chunks) == 1 {
+		conversation, err := a.ActionRepository.UploadForGemini(ctx, chunks[0].Text, options)
+
+		if err != nil {
+			return []domain.Question{}, []domain.ConversationTurn{}, errors.New("usecases/action_usecase.go: UploadForGemini " + err.Error())
+		}
+
+		questions := a.ActionRepository.FormatQeustion(conversation[0].Gemini)
+		a.verifyCitations(questions, document)
+
+		return questions, conversation, nil
+	}
+
+	perChunk := options
+	perChunk.QuestionCount = (options.QuestionCount + len(chunks) - 1) / len(chunks)
+	results := make([][]domain.Question, len(chunks))
+	failures := make([]error, len(chunks))
+
+	var wg sync.WaitGroup
+	limit := make(chan struct{}, a.Config.Concurrency)
+
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func(i int, chunk domain.TextChunk) {
+			defer wg.Done()
+
+			limit <- struct{}{}
+			defer func() { <-limit }()
+
+			conversation, err := a.ActionRepository.UploadForGemini(ctx, chunk.Text, perChunk)
+			if err != nil {
+				failures[i] = err
+				return
+			}
+
+			questions := a.ActionRepository.FormatQeustion(conversation[0].Gemini)
+			for q := range questions {
+				questions[q].SourceChunk = chunk.Index
+			}
+			results[i] = questions
+		}(i, chunk)
+	}
+
+	wg.Wait()
+
+	failed := 0
+	for i, err := range failures {
+		if err != nil {
+			failed++
+			log.Printf("usecases/action_usecase.go: UploadForGemini chunk %d: %v", i, err)
+		}
+	}
+
+	if failed == len(chunks) {
+		return []domain.Question{}, []domain.ConversationTurn{}, errors.New("usecases/action_usecase.go: UploadForGemini " + failures[0].Error())
+	}
+
+	questions := infrastructure.MergeQuestions(results, options.QuestionCount)
+	a.verifyCitations(questions, document)
+
+	// the explanations, topics and chat that follow replay this turn, so it
+	// names the parts instead of carrying the whole document again and they
+	// retrieve the passages they need
+	conversation := []domain.ConversationTurn{{
+		User:   fmt.Sprintf("Generate %d questions from the document, split into %d parts.\n%s\nThe passages of the document are given with each request that needs them.", options.QuestionCount, len(chunks), infrastructure.QuizInstructions(options)),
+		Gemini: infrastructure.EncodeQuestions(questions),
+	}}
+
+	return questions, conversation, nil
+}
+
+// ProcessUpload runs the whole upload pipeline for a job and returns the id
+// of the created section. Failures are reported as *domain.JobError and
+// anything stored before the failure is removed again.
+func (a *actionUsecase) ProcessUpload(ctx context.Context, job domain.Job, progress func(stage string, progress int)) (string, error) {
+	jobID := job.ID.Hex()
+
+	// a previous attempt may have finished right before its worker died
+	section, err := a.ActionRepository.FindSectionByJob(ctx, jobID)
+	if err == nil {
+		return section.ID.Hex(), nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return "", &domain.JobError{Code: domain.JobErrorStorage, Message: err.Error(), Retryable: true}
+	}
+
+	// jobs queued before quiz options existed carry none
+	if err := a.PrepareQuizOptions(&job.Options); err != nil {
+		return "", &domain.JobError{Code: domain.JobErrorGeneration, Message: err.Error()}
+	}
+
+	progress(domain.JobStageExtracting, 10)
+
+	document, pages, jobErr := a.uploadSource(ctx, job)
+	if jobErr != nil {
+		return "", jobErr
+	}
+
+	selected, ranges, err := infrastructure.SelectPages(pages, job.Selection, document.Chapters)
+	if err != nil {
+		return "", &domain.JobError{Code: domain.JobErrorSelection, Message: err.Error()}
+	}
+
+	// the same pages with the same options get the quiz generated before
+	if !job.ForceRegenerate {
+		section, err := a.ActionRepository.FindSectionByHash(ctx, job.UserID, document.Hash, job.Options, ranges)
+		if err == nil {
+			return section.ID.Hex(), nil
+		}
+		if err != mongo.ErrNoDocuments {
+			log.Println(err.Error())
+		}
+	}
+
+	progress(domain.JobStageGenerating, 30)
+
+	questions, conversation, err := a.UploadForGemini(ctx, selected, job.Options)
+	if err != nil {
+		return "", &domain.JobError{Code: domain.JobErrorGeneration, Message: err.Error(), Retryable: true}
+	}
+
+	if len(questions) == 0 {
+		return "", &domain.JobError{Code: domain.JobErrorGeneration, Message: "no questions could be generated", Retryable: true}
+	}
+
+	progress(domain.JobStageSaving, 80)
+
+	// pdfID is only set for a document this job stores, a stored document
+	// used by other sections is never removed
+	var quizID, conversationID, pdfID string
+
+	storageError := func(err error) (string, error) {
+		if cleanupErr := a.ActionRepository.RemoveUpload(context.Background(), quizID, conversationID, pdfID); cleanupErr != nil {
+			err = errors.New(err.Error() + ", cleanup: " + cleanupErr.Error())
+		}
+		return "", &domain.JobError{Code: domain.JobErrorStorage, Message: err.Error(), Retryable: true}
+	}
+
+	quizID, err = a.UploadQuestions(ctx, questions, job.Options, job.UserID)
+	if err != nil {
+		return storageError(err)
+	}
+
+	conversationID, err = a.UploadConversation(ctx, conversation)
+	if err != nil {
+		return storageError(err)
+	}
+
+	documentID := job.DocumentID
+
+	if documentID == "" {
+		document.DropBox = infrastructure.GetUniqueFileName(infrastructure.MediaTypeExtension(document.MediaType))
+		document.Created = time.Now().Format(time.RFC3339)
+
+		pdfID, err = a.UploadDocument(ctx, document, job.File)
+		if err != nil {
+			return storageError(err)
+		}
+		documentID = pdfID
+
+		if err := a.ActionRepository.SaveChunks(ctx, pdfID, pages, a.Config.RetrievalChunkBudget); err != nil {
+			return storageError(err)
+		}
+	}
+
+	sectionID, err := a.UploadSection(ctx, domain.Section{
+		SectionName:    sectionName(document, job.Selection, ranges),
+		PDFID:          documentID,
+		QuestionsID:    quizID,
+		ExplanationsID: conversationID,
+		CreatedBy:      job.UserID,
+		JobID:          jobID,
+		Pages:          ranges,
+	})
+	if err != nil {
+		return storageError(err)
+	}
+
+	return sectionID, nil
+}
+
+// uploadSource returns the document a job makes a section from with its
+// text. A new upload gets a document that is stored with the section.
+func (a *actionUsecase) uploadSource(ctx context.Context, job domain.Job) (domain.Document, []domain.DocumentPage, *domain.JobError) {
+	if job.DocumentID != "" {
+		return a.storedSource(ctx, job)
+	}
+
+	mediaType, err := infrastructure.DetectMediaType(job.File, job.Filename)
+	if err != nil {
+		return domain.Document{}, nil, &domain.JobError{Code: domain.JobErrorExtraction, Message: err.Error()}
+	}
+
+	document := domain.Document{
+		Title:     job.Filename,
+		MediaType: mediaType,
+		Hash:      infrastructure.ContentHash(job.File),
+		CreatedBy: job.UserID,
+	}
+
+	pages, ok := a.cachedText(ctx, job, document.Hash)
+	if !ok {
+		pages, err = a.extractText(ctx, job.UserID, document.Hash, job.Filename, job.File)
+		if err != nil {
+			return domain.Document{}, nil, &domain.JobError{Code: domain.JobErrorExtraction, Message: err.Error()}
+		}
+	}
+
+	document.PageCount = pageCount(pages)
+
+	// only PDFs and presentations are counted before extraction
+	if a.Limits.MaxPages > 0 && document.PageCount > a.Limits.MaxPages {
+		return domain.Document{}, nil, &domain.JobError{Code: domain.JobErrorTooManyPages, Message: fmt.Sprintf("the document has %d pages, at most %d are allowed", document.PageCount, a.Limits.MaxPages)}
+	}
+
+	document.Chapters = infrastructure.DocumentChapters(job.File, mediaType, pages)
+
+	return document, pages, nil
+}
+
+// storedSource returns a stored document of the user with its text, from
+// the extraction cache when possible and from the stored file otherwise.
+func (a *actionUsecase) storedSource(ctx context.Context, job domain.Job) (domain.Document, []domain.DocumentPage, *domain.JobError) {
+	document, err := a.ActionRepository.GetDocument(ctx, job.DocumentID, job.UserID)
+	if err == mongo.ErrNoDocuments {
+		return domain.Document{}, nil, &domain.JobError{Code: domain.JobErrorExtraction, Message: "document not found"}
+	}
+	if err != nil {
+		return domain.Document{}, nil, &domain.JobError{Code: domain.JobErrorStorage, Message: err.Error(), Retryable: true}
+	}
+
+	if document.Hash != "" {
+		if pages, ok := a.cachedText(ctx, job, document.Hash); ok {
+			if len(document.Chapters) == 0 {
+				document.Chapters = infrastructure.DetectChapters(pages, document.MediaType)
+			}
+			return document, pages, nil
+		}
+	}
+
+	// documents stored before the extraction cache have to be read again
+	content, err := a.ActionRepository.DocumentFile(ctx, document)
+	if err != nil {
+		return domain.Document{}, nil, &domain.JobError{Code: domain.JobErrorExtraction, Message: err.Error()}
+	}
+
+	if document.Hash == "" {
+		document.Hash = infrastructure.ContentHash(content)
+	}
+
+	pages, err := a.extractText(ctx, job.UserID, document.Hash, document.Title, content)
+	if err != nil {
+		return domain.Document{}, nil, &domain.JobError{Code: domain.JobErrorExtraction, Message: err.Error()}
+	}
+
+	if len(document.Chapters) == 0 {
+		document.Chapters = infrastructure.DocumentChapters(content, document.MediaType, pages)
+	}
+
+	return document, pages, nil
+}
+
+// cachedText returns the extraction of an earlier upload of the same file
+// unless the job asks to regenerate.
+func (a *actionUsecase) cachedText(ctx context.Context, job domain.Job, hash string) ([]domain.DocumentPage, bool) {
+	if job.ForceRegenerate {
+		return nil, false
+	}
+
+	owner := job.UserID
+	if a.Config.ShareExtractions {
+		owner = ""
+	}
+
+	pages, err := a.ActionRepository.CachedExtraction(ctx, hash, owner)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Println(err.Error())
+	}
+
+	return pages, err == nil && len(pages) > 0
+}
 
-	conversation, err := a.ActionRepository.UploadForGemini(processedText)
+// extractText extracts the text of a file and caches it by its hash.
+func (a *actionUsecase) extractText(ctx context.Context, userID, hash, filename string, content []byte) ([]domain.DocumentPage, error) {
+	pages, err := a.ActionRepository.ExtractText(ctx, bytes.NewReader(content), filename)
+	if err != nil {
+		return nil, err
+	}
 
+	// the cache only saves work, the upload goes on without it
+	err = a.ActionRepository.SaveExtraction(ctx, domain.Extraction{
+		Hash:      hash,
+		CreatedBy: userID,
+		Pages:     pages,
+		CreatedAt: time.Now(),
+	})
 	if err != nil {
-		return []domain.Question{}, []domain.ConversationTurn{}, errors.New("usecases/action_usecase.go: UploadForGemini " + err.Error())
+		log.Println(err.Error())
 	}
 
-	question := conversation[0].Gemini
+	return pages, nil
+}
 
-	formatted_question := a.ActionRepository.FormatQeustion(question)
+func pageCount(pages []domain.DocumentPage) int {
+	if len(pages) == 0 {
+		return 0
+	}
+	return pages[len(pages)-1].Number
+}
 
-	return formatted_question, conversation, nil
+// sectionName names a section after its document and the part of it the
+// quiz is on.
+func sectionName(document domain.Document, selection domain.PageSelection, ranges string) string {
+	if ranges == "" {
+		return document.Title
+	}
+
+	if len(selection.Chapters) == 1 && strings.TrimSpace(selection.Pages) == "" {
+		return fmt.Sprintf("%s - %s", document.Title, document.Chapters[selection.Chapters[0]-1].Title)
+	}
+
+	return fmt.Sprintf("%s (pages %s)", document.Title, ranges)
 }
 
-func (a *actionUsecase) GetPdfLink(ctx context.Context, file multipart.File, filename string) (string, error) {
-	return a.ActionRepository.GetPdfLink(ctx, file, filename)
+// verifyCitations drops the citations of generated questions that the
+// document does not back up.
+func (a *actionUsecase) verifyCitations(questions []domain.Question, document []domain.TextChunk) {
+	if rejected := infrastructure.VerifyCitations(questions, document); rejected > 0 {
+		log.Printf("usecases/action_usecase.go: dropped %d citations not found in the document", rejected)
+	}
+}
+
+// CreateFollowUpQuiz generates a quiz on the weak points of parent and stores
+// it as a new section of the next round, sharing the parent's document.
+func (a *actionUsecase) CreateFollowUpQuiz(ctx context.Context, parent domain.Section, topics domain.TopicList, options domain.QuizOptions) (string, error) {
+	if len(topics.Topics) == 0 {
+		return "", errors.New("usecases/action_usecase.go: CreateFollowUpQuiz no weak points to focus on")
+	}
+
+	if err := a.PrepareQuizOptions(&options); err != nil {
+		return "", errors.New("usecases/action_usecase.go: CreateFollowUpQuiz " + err.Error())
+	}
+
+	conversation, err := a.ActionRepository.GenerateFollowUp(ctx, parent.ExplanationsID, topics, options)
+
+	if err != nil {
+		return "", errors.New("usecases/action_usecase.go: CreateFollowUpQuiz " + err.Error())
+	}
+
+	questions := a.ActionRepository.FormatQeustion(conversation[0].Gemini)
+
+	if len(questions) == 0 {
+		return "", errors.New("usecases/action_usecase.go: CreateFollowUpQuiz no questions could be generated")
+	}
+
+	// without the stored passages no citation can be checked, so none are kept
+	stored, err := a.ActionRepository.DocumentChunks(ctx, parent.PDFID)
+	if err != nil {
+		log.Println(err.Error())
+	}
+
+	document := make([]domain.TextChunk, len(stored))
+	for i, chunk := range stored {
+		document[i] = chunk.TextChunk
+	}
+	a.verifyCitations(questions, document)
+
+	var quizID, conversationID string
+
+	storageError := func(err error) (string, error) {
+		if cleanupErr := a.ActionRepository.RemoveUpload(context.Background(), quizID, conversationID, ""); cleanupErr != nil {
+			err = errors.New(err.Error() + ", cleanup: " + cleanupErr.Error())
+		}
+		return "", errors.New("usecases/action_usecase.go: CreateFollowUpQuiz " + err.Error())
+	}
+
+	quizID, err = a.UploadQuestions(ctx, questions, options, parent.CreatedBy)
+	if err != nil {
+		return storageError(err)
+	}
+
+	conversationID, err = a.UploadConversation(ctx, conversation)
+	if err != nil {
+		return storageError(err)
+	}
+
+	rootID := parent.RootID
+	if rootID == "" {
+		rootID = parent.ID.Hex()
+	}
+	round := parent.Round + 1
+
+	sectionID, err := a.UploadSection(ctx, domain.Section{
+		SectionName:    fmt.Sprintf("%s (follow-up %d)", parent.SectionName, round),
+		PDFID:          parent.PDFID,
+		QuestionsID:    quizID,
+		ExplanationsID: conversationID,
+		CreatedBy:      parent.CreatedBy,
+		ParentID:       parent.ID.Hex(),
+		RootID:         rootID,
+		Round:          round,
+		Pages:          parent.Pages,
+	})
+	if err != nil {
+		return storageError(err)
+	}
+
+	return sectionID, nil
 }
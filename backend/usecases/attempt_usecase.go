@@ -0,0 +1,154 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"github/chera/fix-it/domain"
+	"github/chera/fix-it/infrastructure"
+	"github/chera/fix-it/repository"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type AttemptUsecase interface {
+	RecordAttempt(ctx context.Context, section domain.Section, userID string, answers domain.AnswerList, grade domain.QuizGrade, official bool) (string, error)
+	ListAttempts(ctx context.Context, sectionID, userID string) ([]domain.Attempt, error)
+	GetAttempt(ctx context.Context, attemptID, userID string) (domain.Attempt, error)
+	ExplainAttempt(ctx context.Context, attemptID, userID string, onChunk func(chunk string) error) ([]domain.QeustionAnswer, error)
+	SectionProgress(ctx context.Context, section domain.Section, userID string) ([]domain.RoundProgress, error)
+}
+
+type attemptUsecase struct {
+	AttemptRepository repository.AttemptRepository
+	ActionRepository  repository.ActionRepository
+	ViewRepository    repository.ViewRepository
+}
+
+func NewAttemptUsecase(attemptRepo repository.AttemptRepository, actionRepo repository.ActionRepository, viewRepo repository.ViewRepository) AttemptUsecase {
+	return &attemptUsecase{
+		AttemptRepository: attemptRepo,
+		ActionRepository:  actionRepo,
+		ViewRepository:    viewRepo,
+	}
+}
+
+func (a *attemptUsecase) RecordAttempt(ctx context.Context, section domain.Section, userID string, answers domain.AnswerList, grade domain.QuizGrade, official bool) (string, error) {
+	duration := answers.DurationSeconds
+	if duration < 0 {
+		duration = 0
+	}
+
+	attemptID, err := a.AttemptRepository.CreateAttempt(ctx, domain.Attempt{
+		SectionID:       section.ID.Hex(),
+		QuizID:          section.QuestionsID,
+		UserID:          userID,
+		Answers:         answers.Answers,
+		Results:         grade.Results,
+		Score:           grade.Score,
+		Total:           grade.Total,
+		DurationSeconds: duration,
+		Official:        official,
+	})
+
+	if err != nil {
+		return "", errors.New("usecases/attempt_usecase.go: RecordAttempt " + err.Error())
+	}
+
+	return attemptID, nil
+}
+
+func (a *attemptUsecase) ListAttempts(ctx context.Context, sectionID, userID string) ([]domain.Attempt, error) {
+	return a.AttemptRepository.ListAttempts(ctx, sectionID, userID)
+}
+
+func (a *attemptUsecase) GetAttempt(ctx context.Context, attemptID, userID string) (domain.Attempt, error) {
+	return a.AttemptRepository.GetAttempt(ctx, attemptID, userID)
+}
+
+// ExplainAttempt returns the explanation of an attempt, generating and
+// storing it the first time it is asked for. The official attempt reuses the
+// explanation already kept in the section conversation when there is one.
+// A newly generated explanation is streamed to onChunk when it is given.
+func (a *attemptUsecase) ExplainAttempt(ctx context.Context, attemptID, userID string, onChunk func(chunk string) error) ([]domain.QeustionAnswer, error) {
+	attempt, err := a.AttemptRepository.GetAttempt(ctx, attemptID, userID)
+
+	if err != nil {
+		return nil, errors.New("usecases/attempt_usecase.go: ExplainAttempt " + err.Error())
+	}
+
+	if len(attempt.Explanation) > 0 {
+		return attempt.Explanation, nil
+	}
+
+	section, err := a.ViewRepository.GetSection(ctx, attempt.SectionID, userID)
+
+	if err != nil {
+		return nil, errors.New("usecases/attempt_usecase.go: ExplainAttempt " + err.Error())
+	}
+
+	var explanation []domain.QeustionAnswer
+
+	if attempt.Official {
+		conversation, err := a.ViewRepository.GetExplanation(ctx, section.ExplanationsID)
+		if err == nil && len(conversation.Turns) >= 2 {
+			explanation = infrastructure.AttachCitations(infrastructure.ParseExplanationResponse(conversation.Turns[1].Gemini), conversation.Turns[1].Sources)
+		}
+	}
+
+	if len(explanation) == 0 {
+		raw, sources, err := a.ActionRepository.ExplainAnswers(ctx, section.ExplanationsID, attempt.Answers, onChunk)
+
+		if err != nil {
+			return nil, errors.New("usecases/attempt_usecase.go: ExplainAttempt " + err.Error())
+		}
+
+		explanation = infrastructure.AttachCitations(infrastructure.ParseExplanationResponse(raw), sources)
+	}
+
+	if err := a.AttemptRepository.SetExplanation(ctx, attemptID, explanation); err != nil {
+		return nil, errors.New("usecases/attempt_usecase.go: ExplainAttempt " + err.Error())
+	}
+
+	return explanation, nil
+}
+
+// SectionProgress returns the official score of every round the section
+// belongs to, from the original quiz through its follow-ups.
+func (a *attemptUsecase) SectionProgress(ctx context.Context, section domain.Section, userID string) ([]domain.RoundProgress, error) {
+	rootID := section.RootID
+	if rootID == "" {
+		rootID = section.ID.Hex()
+	}
+
+	sections, err := a.ViewRepository.SectionRounds(ctx, rootID, userID)
+
+	if err != nil {
+		return nil, errors.New("usecases/attempt_usecase.go: SectionProgress " + err.Error())
+	}
+
+	progress := make([]domain.RoundProgress, 0, len(sections))
+
+	for _, round := range sections {
+		entry := domain.RoundProgress{
+			SectionID:   round.ID.Hex(),
+			SectionName: round.SectionName,
+			Round:       round.Round,
+		}
+
+		attempt, err := a.AttemptRepository.OfficialAttempt(ctx, entry.SectionID, userID)
+
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, errors.New("usecases/attempt_usecase.go: SectionProgress " + err.Error())
+		}
+
+		if err == nil {
+			entry.Taken = true
+			entry.Score = attempt.Score
+			entry.Total = attempt.Total
+		}
+
+		progress = append(progress, entry)
+	}
+
+	return progress, nil
+}
@@ -0,0 +1,201 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github/chera/fix-it/domain"
+	"github/chera/fix-it/repository"
+	"log"
+	"runtime/debug"
+	"time"
+)
+
+type JobUsecase interface {
+	EnqueueUpload(ctx context.Context, userID, filename string, file []byte, options domain.QuizOptions, selection domain.PageSelection, forceRegenerate bool) (string, error)
+	EnqueueSection(ctx context.Context, userID string, document domain.Document, options domain.QuizOptions, selection domain.PageSelection, forceRegenerate bool) (string, error)
+	GetJob(ctx context.Context, jobID, userID string) (domain.Job, error)
+	StartWorkers(ctx context.Context, workers int)
+}
+
+type jobUsecase struct {
+	JobRepository repository.JobRepository
+	ActionUsecase ActionUsecase
+	MaxAttempts   int
+	Lease         time.Duration
+	PollInterval  time.Duration
+	RetryBackoff  time.Duration
+}
+
+func NewJobUsecase(repo repository.JobRepository, actionusecase ActionUsecase) JobUsecase {
+	return &jobUsecase{
+		JobRepository: repo,
+		ActionUsecase: actionusecase,
+		MaxAttempts:   3,
+		Lease:         5 * time.Minute,
+		PollInterval:  2 * time.Second,
+		RetryBackoff:  30 * time.Second,
+	}
+}
+
+func (j *jobUsecase) EnqueueUpload(ctx context.Context, userID, filename string, file []byte, options domain.QuizOptions, selection domain.PageSelection, forceRegenerate bool) (string, error) {
+	jobID, err := j.JobRepository.Enqueue(ctx, domain.Job{
+		UserID:          userID,
+		Filename:        filename,
+		File:            file,
+		Options:         options,
+		Selection:       selection,
+		ForceRegenerate: forceRegenerate,
+	})
+
+	if err != nil {
+		return "", errors.New("usecases/job_usecase.go: EnqueueUpload " + err.Error())
+	}
+
+	return jobID, nil
+}
+
+// EnqueueSection queues a new section on part of a stored document, which
+// goes through the same pipeline as an upload without the file.
+func (j *jobUsecase) EnqueueSection(ctx context.Context, userID string, document domain.Document, options domain.QuizOptions, selection domain.PageSelection, forceRegenerate bool) (string, error) {
+	jobID, err := j.JobRepository.Enqueue(ctx, domain.Job{
+		UserID:          userID,
+		Filename:        document.Title,
+		DocumentID:      document.ID.Hex(),
+		Options:         options,
+		Selection:       selection,
+		ForceRegenerate: forceRegenerate,
+	})
+
+	if err != nil {
+		return "", errors.New("usecases/job_usecase.go: EnqueueSection " + err.Error())
+	}
+
+	return jobID, nil
+}
+
+func (j *jobUsecase) GetJob(ctx context.Context, jobID, userID string) (domain.Job, error) {
+	return j.JobRepository.GetJob(ctx, jobID, userID)
+}
+
+// StartWorkers runs the given number of workers until ctx is cancelled.
+func (j *jobUsecase) StartWorkers(ctx context.Context, workers int) {
+	for i := 0; i < workers; i++ {
+		go j.work(ctx)
+	}
+}
+
+func (j *jobUsecase) work(ctx context.Context) {
+	for {
+		if failed, err := j.JobRepository.FailAbandoned(ctx, j.MaxAttempts); err != nil {
+			log.Println("usecases/job_usecase.go: FailAbandoned " + err.Error())
+		} else if failed > 0 {
+			log.Printf("usecases/job_usecase.go: failed %d jobs out of attempts", failed)
+		}
+
+		job, err := j.JobRepository.Claim(ctx, j.Lease, j.MaxAttempts)
+
+		if err != nil {
+			if err != repository.ErrNoJob {
+				log.Println("usecases/job_usecase.go: Claim " + err.Error())
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(j.PollInterval):
+			}
+			continue
+		}
+
+		j.run(ctx, job)
+	}
+}
+
+func (j *jobUsecase) run(ctx context.Context, job domain.Job) {
+	jobID := job.ID.Hex()
+
+	// the pipeline stops once another worker has the job
+	pipelineCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	leaseError := func(err error) {
+		log.Println("usecases/job_usecase.go: job " + jobID + " " + err.Error())
+		if err == repository.ErrLeaseLost {
+			cancel()
+		}
+	}
+
+	progress := func(stage string, progress int) {
+		if err := j.JobRepository.UpdateProgress(ctx, job, stage, progress, j.Lease); err != nil {
+			leaseError(err)
+		}
+	}
+
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		j.renew(pipelineCtx, job, leaseError)
+	}()
+
+	sectionID, err := j.process(pipelineCtx, job, progress)
+
+	cancel()
+	<-renewed
+
+	if err == nil {
+		if err := j.JobRepository.Complete(ctx, job, sectionID); err != nil {
+			log.Println("usecases/job_usecase.go: Complete " + err.Error())
+		}
+		return
+	}
+
+	log.Printf("usecases/job_usecase.go: job %s attempt %d failed: %v", jobID, job.Attempts, err)
+
+	var jobErr *domain.JobError
+	if !errors.As(err, &jobErr) {
+		jobErr = &domain.JobError{Code: domain.JobErrorInternal, Message: err.Error(), Retryable: true}
+	}
+
+	if jobErr.Retryable && job.Attempts < j.MaxAttempts {
+		backoff := j.RetryBackoff * time.Duration(1<<(job.Attempts-1))
+		err = j.JobRepository.Retry(ctx, job, *jobErr, time.Now().Add(backoff))
+	} else {
+		err = j.JobRepository.Fail(ctx, job, *jobErr)
+	}
+
+	if err != nil {
+		log.Println("usecases/job_usecase.go: " + err.Error())
+	}
+}
+
+// renew extends the lease of the job every third of it until ctx is done,
+// so a long generation isn't taken for a crashed worker.
+func (j *jobUsecase) renew(ctx context.Context, job domain.Job, onError func(err error)) {
+	ticker := time.NewTicker(j.Lease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := j.JobRepository.Renew(ctx, job, j.Lease); err != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// process runs the upload pipeline of a job. A panic fails the attempt
+// instead of taking the worker down with it.
+func (j *jobUsecase) process(ctx context.Context, job domain.Job, progress func(stage string, progress int)) (sectionID string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("usecases/job_usecase.go: job %s panicked: %v\n%s", job.ID.Hex(), r, debug.Stack())
+			err = &domain.JobError{Code: domain.JobErrorInternal, Message: fmt.Sprint("panic: ", r), Retryable: true}
+		}
+	}()
+
+	return j.ActionUsecase.ProcessUpload(ctx, job, progress)
+}
@@ -0,0 +1,93 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"github/chera/fix-it/infrastructure"
+	"github/chera/fix-it/repository"
+	"log"
+	"time"
+)
+
+type MailUsecase interface {
+	Queue(ctx context.Context, to, template string, data any) error
+	StartWorkers(ctx context.Context, workers int)
+}
+
+type mailUsecase struct {
+	OutboxRepository repository.OutboxRepository
+	Mailer           infrastructure.Mailer
+	MaxAttempts      int
+	Lease            time.Duration
+	PollInterval     time.Duration
+	RetryBackoff     time.Duration
+}
+
+func NewMailUsecase(repo repository.OutboxRepository, mailer infrastructure.Mailer) MailUsecase {
+	return &mailUsecase{
+		OutboxRepository: repo,
+		Mailer:           mailer,
+		MaxAttempts:      6,
+		Lease:            time.Minute,
+		PollInterval:     5 * time.Second,
+		RetryBackoff:     30 * time.Second,
+	}
+}
+
+// Queue renders an email and puts it in the outbox, the workers send it.
+func (m *mailUsecase) Queue(ctx context.Context, to, template string, data any) error {
+	email, err := infrastructure.RenderEmail(to, template, data)
+	if err != nil {
+		return errors.New("usecases/mail_usecase.go: Queue " + err.Error())
+	}
+
+	if err := m.OutboxRepository.Enqueue(ctx, email); err != nil {
+		return errors.New("usecases/mail_usecase.go: Queue " + err.Error())
+	}
+
+	return nil
+}
+
+// StartWorkers runs the given number of workers until ctx is cancelled.
+func (m *mailUsecase) StartWorkers(ctx context.Context, workers int) {
+	for i := 0; i < workers; i++ {
+		go m.work(ctx)
+	}
+}
+
+func (m *mailUsecase) work(ctx context.Context) {
+	for {
+		email, err := m.OutboxRepository.Claim(ctx, m.Lease)
+
+		if err != nil {
+			if err != repository.ErrNoEmail {
+				log.Println("usecases/mail_usecase.go: Claim " + err.Error())
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(m.PollInterval):
+			}
+			continue
+		}
+
+		err = m.Mailer.Send(ctx, email)
+
+		switch {
+		case err == nil:
+			err = m.OutboxRepository.MarkSent(ctx, email)
+		case email.Attempts < m.MaxAttempts:
+			log.Printf("usecases/mail_usecase.go: email %s attempt %d failed: %v", email.ID.Hex(), email.Attempts, err)
+			backoff := m.RetryBackoff * time.Duration(1<<(email.Attempts-1))
+			err = m.OutboxRepository.Retry(ctx, email, err.Error(), time.Now().Add(backoff))
+		default:
+			log.Printf("usecases/mail_usecase.go: giving up on email %s: %v", email.ID.Hex(), err)
+			err = m.OutboxRepository.Fail(ctx, email, err.Error())
+		}
+
+		if err != nil {
+			log.Println("usecases/mail_usecase.go: " + err.Error())
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package usecases
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"github/chera/fix-it/domain"
+	"github/chera/fix-it/infrastructure"
+	"github/chera/fix-it/repository"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/oauth2"
+)
+
+type OIDCUsecase interface {
+	Start(ctx context.Context) (string, string, error)
+	Callback(ctx context.Context, code, state, browserState string) (OIDCResult, error)
+	CompleteProfile(ctx context.Context, signupToken string, profile domain.User) (string, error)
+}
+
+// OIDCResult is the user a provider login was for, or when there is none
+// yet the token to complete the profile with.
+type OIDCResult struct {
+	UserID      string
+	SignupToken string
+	// what the provider told about a new user, to fill in the profile form
+	Email    string
+	Username string
+}
+
+var (
+	ErrOIDCDisabled     = errors.New("login with the identity provider is not set up")
+	ErrInvalidOIDCState = errors.New("the login expired, start again")
+	ErrOIDCLoginFailed  = errors.New("the identity provider refused the login")
+	ErrEmailNotVerified = errors.New("the identity provider has no verified email for this account")
+	ErrInvalidSignup    = errors.New("the signup expired, log in with the identity provider again")
+)
+
+// the provider has to send the user back within a few minutes, the profile
+// can take a little longer
+const (
+	oidcLoginTTL  = 10 * time.Minute
+	oidcSignupTTL = 30 * time.Minute
+)
+
+type oidcUsecase struct {
+	OIDCRepository repository.OIDCRepository
+	UserRepository repository.UserRepository
+	Provider       infrastructure.OIDCProvider
+}
+
+// NewOIDCUsecase takes a nil provider when OIDC isn't configured, every
+// method returns ErrOIDCDisabled then.
+func NewOIDCUsecase(repo repository.OIDCRepository, users repository.UserRepository, provider infrastructure.OIDCProvider) OIDCUsecase {
+	return &oidcUsecase{
+		OIDCRepository: repo,
+		UserRepository: users,
+		Provider:       provider,
+	}
+}
+
+// Start returns the URL of the provider to send the user to and the state
+// of the login, which the browser has to keep to finish it. The state,
+// nonce and PKCE verifier of the login are kept until the callback.
+func (o *oidcUsecase) Start(ctx context.Context) (string, string, error) {
+	if o.Provider == nil {
+		return "", "", ErrOIDCDisabled
+	}
+
+	state, err := infrastructure.NewOpaqueToken()
+	if err != nil {
+		return "", "", errors.New("usecases/oidc_usecase.go: Start " + err.Error())
+	}
+
+	nonce, err := infrastructure.NewOpaqueToken()
+	if err != nil {
+		return "", "", errors.New("usecases/oidc_usecase.go: Start " + err.Error())
+	}
+
+	verifier := oauth2.GenerateVerifier()
+
+	err = o.OIDCRepository.SaveLogin(ctx, domain.OIDCLogin{
+		Hash:      infrastructure.HashToken(state),
+		Nonce:     nonce,
+		Verifier:  verifier,
+		ExpiresAt: time.Now().Add(oidcLoginTTL),
+	})
+	if err != nil {
+		return "", "", errors.New("usecases/oidc_usecase.go: Start " + err.Error())
+	}
+
+	url, err := o.Provider.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return "", "", errors.New("usecases/oidc_usecase.go: Start " + err.Error())
+	}
+
+	return url, state, nil
+}
+
+// Callback finishes a login with the code the provider sent the user back
+// with. The user linked to the provider account is logged in, or the one
+// with its email when the provider verified it, which links them. Anyone
+// else has to complete a profile first. browserState is the state the
+// browser kept from Start, a login someone else started is refused.
+func (o *oidcUsecase) Callback(ctx context.Context, code, state, browserState string) (OIDCResult, error) {
+	if o.Provider == nil {
+		return OIDCResult{}, ErrOIDCDisabled
+	}
+
+	if browserState == "" || subtle.ConstantTimeCompare([]byte(state), []byte(browserState)) != 1 {
+		return OIDCResult{}, ErrInvalidOIDCState
+	}
+
+	login, err := o.OIDCRepository.ConsumeLogin(ctx, infrastructure.HashToken(state))
+	if err == mongo.ErrNoDocuments {
+		return OIDCResult{}, ErrInvalidOIDCState
+	}
+	if err != nil {
+		return OIDCResult{}, errors.New("usecases/oidc_usecase.go: Callback " + err.Error())
+	}
+
+	account, err := o.Provider.Exchange(ctx, code, login.Verifier, login.Nonce)
+	if err != nil {
+		log.Println("usecases/oidc_usecase.go: Callback " + err.Error())
+		return OIDCResult{}, ErrOIDCLoginFailed
+	}
+
+	identity := domain.Identity{Issuer: account.Issuer, Subject: account.Subject}
+
+	user, err := o.UserRepository.GetUserByIdentity(ctx, identity)
+	if err == nil {
+		return OIDCResult{UserID: user.ID.Hex()}, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return OIDCResult{}, errors.New("usecases/oidc_usecase.go: Callback " + err.Error())
+	}
+
+	// an unverified email could be anyone's, it can't find the account
+	if account.Email == "" || !account.EmailVerified {
+		return OIDCResult{}, ErrEmailNotVerified
+	}
+
+	if user, err := o.UserRepository.GetUserByEmail(ctx, account.Email); err == nil {
+		if err := o.UserRepository.LinkIdentity(ctx, user.ID.Hex(), identity); err != nil {
+			return OIDCResult{}, errors.New("usecases/oidc_usecase.go: Callback " + err.Error())
+		}
+		log.Printf("usecases/oidc_usecase.go: linked user %s to %s at %s", user.ID.Hex(), identity.Subject, identity.Issuer)
+		return OIDCResult{UserID: user.ID.Hex()}, nil
+	}
+
+	token, err := infrastructure.NewOpaqueToken()
+	if err != nil {
+		return OIDCResult{}, errors.New("usecases/oidc_usecase.go: Callback " + err.Error())
+	}
+
+	signup := domain.OIDCSignup{
+		Hash:      infrastructure.HashToken(token),
+		Identity:  identity,
+		Email:     account.Email,
+		Username:  suggestUsername(account),
+		ExpiresAt: time.Now().Add(oidcSignupTTL),
+	}
+	if err := o.OIDCRepository.SaveSignup(ctx, signup); err != nil {
+		return OIDCResult{}, errors.New("usecases/oidc_usecase.go: Callback " + err.Error())
+	}
+
+	return OIDCResult{SignupToken: token, Email: signup.Email, Username: signup.Username}, nil
+}
+
+var notUsername = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// suggestUsername makes a username from the preferred username or the
+// email, with the characters usernames can have.
+func suggestUsername(account infrastructure.OIDCIdentity) string {
+	name := account.PreferredUsername
+	if name == "" {
+		name, _, _ = strings.Cut(account.Email, "@")
+	}
+	return strings.Trim(notUsername.ReplaceAllString(strings.ToLower(name), "_"), "_")
+}
+
+// CompleteProfile creates the user of a signup with the username, age and
+// academic level the provider doesn't know, and returns its id. The
+// profile has to be valid already.
+func (o *oidcUsecase) CompleteProfile(ctx context.Context, signupToken string, profile domain.User) (string, error) {
+	signup, err := o.OIDCRepository.FindSignup(ctx, infrastructure.HashToken(signupToken))
+	if err == mongo.ErrNoDocuments {
+		return "", ErrInvalidSignup
+	}
+	if err != nil {
+		return "", errors.New("usecases/oidc_usecase.go: CompleteProfile " + err.Error())
+	}
+
+	userID, err := o.UserRepository.CreateLinkedUser(ctx, domain.User{
+		Username:   profile.Username,
+		Email:      signup.Email,
+		Age:        profile.Age,
+		Academic:   profile.Academic,
+		Identities: []domain.Identity{signup.Identity},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := o.OIDCRepository.DeleteSignup(ctx, signup.ID); err != nil {
+		log.Println("usecases/oidc_usecase.go: CompleteProfile " + err.Error())
+	}
+
+	return userID, nil
+}
@@ -0,0 +1,131 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"github/chera/fix-it/domain"
+	"github/chera/fix-it/infrastructure"
+	"github/chera/fix-it/repository"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// the due queue is capped so a backlog after a holiday stays manageable
+const maxDueReviews = 50
+
+type ReviewUsecase interface {
+	RecordQuiz(ctx context.Context, section domain.Section, userID string, grade domain.QuizGrade) error
+	DueReviews(ctx context.Context, userID string) ([]domain.ReviewItem, error)
+	GradeReview(ctx context.Context, reviewID, userID string, answer *string, quality *int) (domain.ReviewItem, bool, error)
+}
+
+type reviewUsecase struct {
+	ReviewRepository repository.ReviewRepository
+	ViewRepository   repository.ViewRepository
+}
+
+func NewReviewUsecase(reviewRepo repository.ReviewRepository, viewRepo repository.ViewRepository) ReviewUsecase {
+	return &reviewUsecase{
+		ReviewRepository: reviewRepo,
+		ViewRepository:   viewRepo,
+	}
+}
+
+// RecordQuiz feeds a graded quiz submission into the review schedule of
+// every question it covered.
+func (r *reviewUsecase) RecordQuiz(ctx context.Context, section domain.Section, userID string, grade domain.QuizGrade) error {
+	quiz, err := r.ViewRepository.GetQuiz(ctx, section.QuestionsID)
+
+	if err != nil {
+		return errors.New("usecases/review_usecase.go: RecordQuiz " + err.Error())
+	}
+
+	now := time.Now()
+
+	for _, result := range grade.Results {
+		if result.QuestionNO < 1 || result.QuestionNO > len(quiz.Questions) {
+			continue
+		}
+
+		item, err := r.ReviewRepository.FindReview(ctx, userID, section.QuestionsID, result.QuestionNO)
+
+		if err == mongo.ErrNoDocuments {
+			item = infrastructure.NewReviewItem(now)
+			item.UserID = userID
+			item.SectionID = section.ID.Hex()
+			item.QuizID = section.QuestionsID
+			item.QuestionNO = result.QuestionNO
+		} else if err != nil {
+			return errors.New("usecases/review_usecase.go: RecordQuiz " + err.Error())
+		}
+
+		if !infrastructure.CountsAsReview(item, result.Correct, now) {
+			continue
+		}
+
+		item.Question = quiz.Questions[result.QuestionNO-1]
+
+		if err := infrastructure.ScheduleReview(&item, infrastructure.QualityFromResult(result.Correct), now); err != nil {
+			return errors.New("usecases/review_usecase.go: RecordQuiz " + err.Error())
+		}
+
+		if err := r.ReviewRepository.SaveReview(ctx, item); err != nil {
+			return errors.New("usecases/review_usecase.go: RecordQuiz " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+// DueReviews returns the items due now with their answers hidden.
+func (r *reviewUsecase) DueReviews(ctx context.Context, userID string) ([]domain.ReviewItem, error) {
+	items, err := r.ReviewRepository.DueReviews(ctx, userID, time.Now(), maxDueReviews)
+
+	if err != nil {
+		return nil, errors.New("usecases/review_usecase.go: DueReviews " + err.Error())
+	}
+
+	for i := range items {
+		items[i].Question.Answer = ""
+		items[i].Question.AcceptedAnswers = nil
+		items[i].Question.Keywords = nil
+	}
+
+	return items, nil
+}
+
+// GradeReview schedules the next review of an item. The item is graded from
+// the given answer, or from a self-assessed SM-2 quality when no answer is
+// given. It also reports whether the answer was correct.
+func (r *reviewUsecase) GradeReview(ctx context.Context, reviewID, userID string, answer *string, quality *int) (domain.ReviewItem, bool, error) {
+	item, err := r.ReviewRepository.GetReview(ctx, reviewID, userID)
+
+	if err != nil {
+		return domain.ReviewItem{}, false, errors.New("usecases/review_usecase.go: GradeReview " + err.Error())
+	}
+
+	var score int
+	var correct bool
+
+	switch {
+	case answer != nil:
+		correct = infrastructure.GradeAnswer(item.Question, *answer)
+		score = infrastructure.QualityFromResult(correct)
+	case quality != nil:
+		score = *quality
+		correct = score >= infrastructure.QualityPass
+	default:
+		return domain.ReviewItem{}, false, errors.New("usecases/review_usecase.go: GradeReview answer or quality is required")
+	}
+
+	if err := infrastructure.ScheduleReview(&item, score, time.Now()); err != nil {
+		return domain.ReviewItem{}, false, err
+	}
+
+	if err := r.ReviewRepository.SaveReview(ctx, item); err != nil {
+		return domain.ReviewItem{}, false, errors.New("usecases/review_usecase.go: GradeReview " + err.Error())
+	}
+
+	return item, correct, nil
+}
@@ -3,25 +3,83 @@ package usecases
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github/chera/fix-it/domain"
 	"github/chera/fix-it/infrastructure"
 	repository "github/chera/fix-it/repository"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type UserUsecase interface {
 	Register(ctx context.Context, user domain.User) error
-	Login(ctx context.Context, user domain.User) (string, error)
+	Login(ctx context.Context, user domain.User, ip string) (string, error)
 	Verify(ctx context.Context, token string) error
-	GenerateToken(user_id string) (string, error)
+	IssueTokens(ctx context.Context, user_id string) (domain.TokenPair, error)
+	Refresh(ctx context.Context, refreshToken string) (domain.TokenPair, error)
+	Logout(ctx context.Context, userID, refreshToken, accessID string, accessExpiresAt time.Time) error
+	LogoutAll(ctx context.Context, userID, accessID string, accessExpiresAt time.Time) error
+	ForgotPassword(ctx context.Context, email string) error
+	ResendVerification(ctx context.Context, email string) error
+	EnrollMFA(ctx context.Context, userID string) (string, string, error)
+	ConfirmMFA(ctx context.Context, userID, code string) ([]string, error)
+	DisableMFA(ctx context.Context, userID, password, code string) error
+	MFAChallenge(ctx context.Context, userID string) (string, error)
+	VerifyMFA(ctx context.Context, challenge, code string) (domain.TokenPair, error)
+	ResetPassword(ctx context.Context, token, password string) error
+	ChangePassword(ctx context.Context, userID, currentPassword, newPassword, accessID string) error
+}
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	// a refresh token that was already replaced was used again
+	ErrRefreshTokenReused = errors.New("refresh token reused, the session was revoked")
+	ErrInvalidResetToken  = errors.New("invalid or expired password reset link")
+	ErrWrongPassword      = errors.New("current password is incorrect")
+	ErrResendThrottled    = errors.New("a verification email was sent recently")
+	ErrMFAEnabled         = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnrolled     = errors.New("two-factor authentication is not being set up")
+	ErrInvalidMFACode     = errors.New("invalid authentication code")
+	ErrInvalidChallenge   = errors.New("the login expired, log in again")
+	ErrLoginThrottled     = errors.New("too many failed logins, try again later")
+)
+
+// LoginThrottledError is returned while logins wait after failed ones, it
+// matches ErrLoginThrottled.
+type LoginThrottledError struct {
+	RetryAfter time.Duration
 }
 
+func (e *LoginThrottledError) Error() string { return ErrLoginThrottled.Error() }
+
+func (e *LoginThrottledError) Unwrap() error { return ErrLoginThrottled }
+
+// a verification email can be sent again after a minute, a few times
+const (
+	resendInterval = time.Minute
+	maxResends     = 5
+)
+
 type userUsecase struct {
-	UserRepository repository.UserRepository
+	UserRepository  repository.UserRepository
+	TokenRepository repository.TokenRepository
+	LoginAttempts   repository.LoginAttemptRepository
+	Config          infrastructure.TokenConfig
+	Limits          infrastructure.LoginLimits
+	Mail            MailUsecase
 }
 
-func NewUseCase(repo repository.UserRepository) UserUsecase {
+func NewUseCase(repo repository.UserRepository, tokens repository.TokenRepository, attempts repository.LoginAttemptRepository, config infrastructure.TokenConfig, limits infrastructure.LoginLimits, mail MailUsecase) UserUsecase {
 	return &userUsecase{
-		UserRepository: repo,
+		UserRepository:  repo,
+		TokenRepository: tokens,
+		LoginAttempts:   attempts,
+		Config:          config,
+		Limits:          limits,
+		Mail:            mail,
 	}
 }
 
@@ -42,19 +100,40 @@ func (u *userUsecase) Verify(ctx context.Context, token string) error {
 	return nil
 }
 
+// Register stores a registration and queues its verification email, a
+// mail server that is down doesn't fail it.
 func (u *userUsecase) Register(ctx context.Context, user domain.User) error {
-	// Add user registration logic here
-	return u.UserRepository.CreateUser(ctx, user)
+	token, err := u.UserRepository.CreateUser(ctx, user)
+	if err != nil {
+		return err
+	}
 
+	err = u.Mail.Queue(ctx, user.Email, infrastructure.EmailVerification, infrastructure.VerificationEmail{
+		Link: infrastructure.VerificationLink(token),
+	})
+	if err != nil {
+		return errors.New("usecases/user_usecase.go: Register " + err.Error())
+	}
+
+	return nil
 }
 
-func (u *userUsecase) Login(ctx context.Context, user domain.User) (string, error) {
+// Login checks the password of a user. Failures are counted for the
+// account and for the address they come from, both have to wait longer
+// after each one and are locked out after many, without the password being
+// checked at all.
+func (u *userUsecase) Login(ctx context.Context, user domain.User, ip string) (string, error) {
 
 	err := infrastructure.SignInValidateUser(&user)
 	if err != nil {
 		return "", errors.New("usecases/user_usecase.go: Login " + err.Error())
 	}
 
+	ipKey := "ip:" + ip
+	if err := u.checkLoginLock(ctx, ipKey); err != nil {
+		return "", err
+	}
+
 	var storedUser domain.User
 	var u_error error
 
@@ -65,23 +144,556 @@ func (u *userUsecase) Login(ctx context.Context, user domain.User) (string, erro
 	}
 
 	if u_error != nil {
+		u.loginFailed(ctx, ipKey, u.Limits.IP, nil)
 		return "", errors.New(u_error.Error())
 	}
 
+	accountKey := "account:" + storedUser.ID.Hex()
+	if err := u.checkLoginLock(ctx, accountKey); err != nil {
+		return "", err
+	}
+
 	equal := infrastructure.ComparePassword(storedUser.Password, user.Password)
 
 	if !equal {
+		u.loginFailed(ctx, ipKey, u.Limits.IP, nil)
+		u.loginFailed(ctx, accountKey, u.Limits.Account, &storedUser)
 		return "", errors.New("no such user")
 	}
 
+	// the failures are only forgotten in IssueTokens, an account with MFA
+	// isn't logged in yet
 	return storedUser.ID.Hex(), nil
 }
 
-func (u *userUsecase) GenerateToken(user_id string) (string, error) {
-	// Generate token
-	token, err := infrastructure.GenerateJWT(user_id)
+func (u *userUsecase) checkLoginLock(ctx context.Context, key string) error {
+	lockedUntil, err := u.LoginAttempts.LockedUntil(ctx, key)
 	if err != nil {
-		return "", errors.New("usecases/user_usecase.go: Login " + err.Error())
+		return errors.New("usecases/user_usecase.go: Login " + err.Error())
+	}
+
+	if wait := time.Until(lockedUntil); wait > 0 {
+		return &LoginThrottledError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// loginFailed counts a failure and makes the next login wait. The owner of
+// an account is emailed when it gets locked out. Errors are only logged,
+// they shouldn't change the answer to the login.
+func (u *userUsecase) loginFailed(ctx context.Context, key string, policy infrastructure.LoginPolicy, user *domain.User) {
+	failures, err := u.LoginAttempts.RecordFailure(ctx, key, policy.Window)
+	if err != nil {
+		log.Println("usecases/user_usecase.go: Login " + err.Error())
+		return
+	}
+
+	delay, locked := policy.Delay(failures)
+	if delay == 0 {
+		return
+	}
+
+	if err := u.LoginAttempts.Lock(ctx, key, time.Now().Add(delay)); err != nil {
+		log.Println("usecases/user_usecase.go: Login " + err.Error())
+		return
+	}
+
+	// only the failure that locks is told about, not the ones after a
+	// lockout ended
+	if !locked || failures != policy.LockoutFailures {
+		return
+	}
+
+	log.Printf("usecases/user_usecase.go: Login locked %s after %d failed logins", key, failures)
+
+	if user == nil {
+		return
+	}
+
+	err = u.Mail.Queue(ctx, user.Email, infrastructure.EmailAccountLocked, infrastructure.AccountLockedEmail{
+		LockedFor: formatDuration(delay),
+		Link:      infrastructure.ForgotPasswordLink(),
+	})
+	if err != nil {
+		log.Println("usecases/user_usecase.go: Login " + err.Error())
+	}
+}
+
+// IssueTokens starts a session for a user, with a new refresh token family.
+// The login is complete then, the failed logins of the account are
+// forgotten.
+func (u *userUsecase) IssueTokens(ctx context.Context, user_id string) (domain.TokenPair, error) {
+	if err := u.LoginAttempts.Reset(ctx, "account:"+user_id); err != nil {
+		log.Println("usecases/user_usecase.go: IssueTokens " + err.Error())
+	}
+
+	family, err := infrastructure.NewOpaqueToken()
+	if err != nil {
+		return domain.TokenPair{}, errors.New("usecases/user_usecase.go: IssueTokens " + err.Error())
+	}
+
+	pair, err := u.issueTokens(ctx, user_id, family)
+	if err != nil {
+		return domain.TokenPair{}, errors.New("usecases/user_usecase.go: IssueTokens " + err.Error())
+	}
+	return pair, nil
+}
+
+func (u *userUsecase) issueTokens(ctx context.Context, userID, family string) (domain.TokenPair, error) {
+	accessToken, accessID, err := infrastructure.GenerateJWT(userID, u.Config.AccessTTL)
+	if err != nil {
+		return domain.TokenPair{}, err
+	}
+
+	refreshToken, err := infrastructure.NewOpaqueToken()
+	if err != nil {
+		return domain.TokenPair{}, err
+	}
+
+	now := time.Now()
+	err = u.TokenRepository.SaveRefreshToken(ctx, domain.RefreshToken{
+		UserID:          userID,
+		Family:          family,
+		Hash:            infrastructure.HashToken(refreshToken),
+		AccessID:        accessID,
+		AccessExpiresAt: now.Add(u.Config.AccessTTL),
+		ExpiresAt:       now.Add(u.Config.RefreshTTL),
+		CreatedAt:       now,
+	})
+	if err != nil {
+		return domain.TokenPair{}, err
+	}
+
+	return domain.TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(u.Config.AccessTTL.Seconds()),
+	}, nil
+}
+
+// Refresh replaces a refresh token with a new one of the same family, along
+// with a new access token. Using a token that was already replaced revokes
+// the family, whoever holds its newest token is logged out too.
+func (u *userUsecase) Refresh(ctx context.Context, refreshToken string) (domain.TokenPair, error) {
+	stored, err := u.TokenRepository.FindRefreshToken(ctx, infrastructure.HashToken(refreshToken))
+	if err == mongo.ErrNoDocuments {
+		return domain.TokenPair{}, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return domain.TokenPair{}, errors.New("usecases/user_usecase.go: Refresh " + err.Error())
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		return domain.TokenPair{}, ErrInvalidRefreshToken
+	}
+
+	consumed := false
+	if stored.RevokedAt == nil {
+		consumed, err = u.TokenRepository.ConsumeRefreshToken(ctx, stored.ID)
+		if err != nil {
+			return domain.TokenPair{}, errors.New("usecases/user_usecase.go: Refresh " + err.Error())
+		}
+	}
+
+	if !consumed {
+		log.Printf("usecases/user_usecase.go: Refresh reused refresh token, revoking the sessions of family %s", stored.Family)
+		if err := u.TokenRepository.RevokeFamily(ctx, stored.Family); err != nil {
+			return domain.TokenPair{}, errors.New("usecases/user_usecase.go: Refresh " + err.Error())
+		}
+		return domain.TokenPair{}, ErrRefreshTokenReused
+	}
+
+	pair, err := u.issueTokens(ctx, stored.UserID, stored.Family)
+	if err != nil {
+		return domain.TokenPair{}, errors.New("usecases/user_usecase.go: Refresh " + err.Error())
+	}
+	return pair, nil
+}
+
+// Logout ends the session of a refresh token and revokes the access token
+// the request was made with. Tokens of other users are left alone.
+func (u *userUsecase) Logout(ctx context.Context, userID, refreshToken, accessID string, accessExpiresAt time.Time) error {
+	if refreshToken != "" {
+		stored, err := u.TokenRepository.FindRefreshToken(ctx, infrastructure.HashToken(refreshToken))
+		if err != nil && err != mongo.ErrNoDocuments {
+			return errors.New("usecases/user_usecase.go: Logout " + err.Error())
+		}
+
+		if err == nil && stored.UserID == userID {
+			if err := u.TokenRepository.RevokeFamily(ctx, stored.Family); err != nil {
+				return errors.New("usecases/user_usecase.go: Logout " + err.Error())
+			}
+		}
+	}
+
+	if err := u.TokenRepository.DenyAccessToken(ctx, accessID, accessExpiresAt); err != nil {
+		return errors.New("usecases/user_usecase.go: Logout " + err.Error())
+	}
+	return nil
+}
+
+// LogoutAll ends every session of a user.
+func (u *userUsecase) LogoutAll(ctx context.Context, userID, accessID string, accessExpiresAt time.Time) error {
+	if err := u.TokenRepository.RevokeUserTokens(ctx, userID); err != nil {
+		return errors.New("usecases/user_usecase.go: LogoutAll " + err.Error())
+	}
+
+	if err := u.TokenRepository.DenyAccessToken(ctx, accessID, accessExpiresAt); err != nil {
+		return errors.New("usecases/user_usecase.go: LogoutAll " + err.Error())
+	}
+	return nil
+}
+
+// a login waits for the code of an account with MFA for a few minutes, and
+// for a few attempts
+const (
+	mfaChallengeTTL      = 5 * time.Minute
+	mfaChallengeAttempts = 5
+	recoveryCodeCount    = 10
+)
+
+// EnrollMFA starts setting up two-factor authentication and returns the
+// secret with its otpauth:// URI. It isn't enabled until ConfirmMFA.
+func (u *userUsecase) EnrollMFA(ctx context.Context, userID string) (string, string, error) {
+	user, err := u.UserRepository.GetUserByID(ctx, userID)
+	if err != nil {
+		return "", "", errors.New("usecases/user_usecase.go: EnrollMFA " + err.Error())
+	}
+
+	if user.MFA != nil && user.MFA.Enabled {
+		return "", "", ErrMFAEnabled
+	}
+
+	secret, err := infrastructure.NewTOTPSecret()
+	if err != nil {
+		return "", "", errors.New("usecases/user_usecase.go: EnrollMFA " + err.Error())
+	}
+
+	if err := u.UserRepository.SetPendingMFASecret(ctx, userID, secret); err != nil {
+		return "", "", errors.New("usecases/user_usecase.go: EnrollMFA " + err.Error())
+	}
+
+	issuer := os.Getenv("MFA_ISSUER")
+	if issuer == "" {
+		issuer = "Fix It"
+	}
+
+	return secret, infrastructure.TOTPURI(issuer, user.Email, secret), nil
+}
+
+// ConfirmMFA enables two-factor authentication once a code of the new
+// secret shows the authenticator app has it, and returns the recovery
+// codes. They are only stored hashed, this is the one time they are shown.
+func (u *userUsecase) ConfirmMFA(ctx context.Context, userID, code string) ([]string, error) {
+	user, err := u.UserRepository.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, errors.New("usecases/user_usecase.go: ConfirmMFA " + err.Error())
+	}
+
+	if user.MFA != nil && user.MFA.Enabled {
+		return nil, ErrMFAEnabled
+	}
+
+	if user.MFA == nil || user.MFA.PendingSecret == "" {
+		return nil, ErrMFANotEnrolled
+	}
+
+	step, ok := infrastructure.VerifyTOTP(user.MFA.PendingSecret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, err := infrastructure.NewRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, errors.New("usecases/user_usecase.go: ConfirmMFA " + err.Error())
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = infrastructure.HashRecoveryCode(code)
+	}
+
+	if err := u.UserRepository.EnableMFA(ctx, userID, user.MFA.PendingSecret, hashes, step); err != nil {
+		return nil, errors.New("usecases/user_usecase.go: ConfirmMFA " + err.Error())
+	}
+
+	return codes, nil
+}
+
+// DisableMFA turns two-factor authentication off, with the password and a
+// code so a stolen session alone can't.
+func (u *userUsecase) DisableMFA(ctx context.Context, userID, password, code string) error {
+	user, err := u.UserRepository.GetUserByID(ctx, userID)
+	if err != nil {
+		return errors.New("usecases/user_usecase.go: DisableMFA " + err.Error())
+	}
+
+	// wrong passwords and codes count like failed logins, a stolen session
+	// could try them without end otherwise
+	accountKey := "account:" + userID
+	if err := u.checkLoginLock(ctx, accountKey); err != nil {
+		return err
+	}
+
+	if !infrastructure.ComparePassword(user.Password, password) {
+		u.loginFailed(ctx, accountKey, u.Limits.Account, &user)
+		return ErrWrongPassword
+	}
+
+	if user.MFA == nil || !user.MFA.Enabled {
+		return u.UserRepository.DisableMFA(ctx, userID)
+	}
+
+	if err := u.checkMFACode(ctx, user, code); err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			u.loginFailed(ctx, accountKey, u.Limits.Account, &user)
+		}
+		return err
+	}
+
+	if err := u.UserRepository.DisableMFA(ctx, userID); err != nil {
+		return errors.New("usecases/user_usecase.go: DisableMFA " + err.Error())
+	}
+
+	return nil
+}
+
+// MFAChallenge is the token a login to an account with MFA returns instead
+// of tokens, to be sent back with a code. It is empty for the others.
+func (u *userUsecase) MFAChallenge(ctx context.Context, userID string) (string, error) {
+	user, err := u.UserRepository.GetUserByID(ctx, userID)
+	if err != nil {
+		return "", errors.New("usecases/user_usecase.go: MFAChallenge " + err.Error())
+	}
+
+	if user.MFA == nil || !user.MFA.Enabled {
+		return "", nil
+	}
+
+	challenge, err := infrastructure.NewOpaqueToken()
+	if err != nil {
+		return "", errors.New("usecases/user_usecase.go: MFAChallenge " + err.Error())
+	}
+
+	err = u.UserRepository.SaveMFAChallenge(ctx, domain.MFAChallenge{
+		UserID:    userID,
+		Hash:      infrastructure.HashToken(challenge),
+		ExpiresAt: time.Now().Add(mfaChallengeTTL),
+	})
+	if err != nil {
+		return "", errors.New("usecases/user_usecase.go: MFAChallenge " + err.Error())
+	}
+
+	return challenge, nil
+}
+
+// VerifyMFA finishes a login with the code of an authenticator app or a
+// recovery code.
+func (u *userUsecase) VerifyMFA(ctx context.Context, challenge, code string) (domain.TokenPair, error) {
+	stored, err := u.UserRepository.AttemptMFAChallenge(ctx, infrastructure.HashToken(challenge), mfaChallengeAttempts)
+	if err == mongo.ErrNoDocuments {
+		return domain.TokenPair{}, ErrInvalidChallenge
+	}
+	if err != nil {
+		return domain.TokenPair{}, errors.New("usecases/user_usecase.go: VerifyMFA " + err.Error())
+	}
+
+	user, err := u.UserRepository.GetUserByID(ctx, stored.UserID)
+	if err != nil {
+		return domain.TokenPair{}, errors.New("usecases/user_usecase.go: VerifyMFA " + err.Error())
+	}
+
+	// wrong codes count like wrong passwords, a new challenge doesn't give
+	// new guesses
+	accountKey := "account:" + stored.UserID
+	if err := u.checkLoginLock(ctx, accountKey); err != nil {
+		return domain.TokenPair{}, err
+	}
+
+	if err := u.checkMFACode(ctx, user, code); err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			u.loginFailed(ctx, accountKey, u.Limits.Account, &user)
+		}
+		return domain.TokenPair{}, err
+	}
+
+	if err := u.UserRepository.DeleteMFAChallenge(ctx, stored.ID); err != nil {
+		log.Println("usecases/user_usecase.go: VerifyMFA " + err.Error())
+	}
+
+	return u.IssueTokens(ctx, stored.UserID)
+}
+
+// checkMFACode accepts a code of the authenticator app that wasn't used
+// yet, or a recovery code which is used up.
+func (u *userUsecase) checkMFACode(ctx context.Context, user domain.User, code string) error {
+	if user.MFA == nil || !user.MFA.Enabled {
+		return ErrInvalidMFACode
+	}
+
+	userID := user.ID.Hex()
+
+	if step, ok := infrastructure.VerifyTOTP(user.MFA.Secret, code, time.Now()); ok {
+		fresh, err := u.UserRepository.UseTOTPStep(ctx, userID, step)
+		if err != nil {
+			return errors.New("usecases/user_usecase.go: checkMFACode " + err.Error())
+		}
+		if !fresh {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	used, err := u.UserRepository.UseRecoveryCode(ctx, userID, infrastructure.HashRecoveryCode(code))
+	if err != nil {
+		return errors.New("usecases/user_usecase.go: checkMFACode " + err.Error())
+	}
+	if !used {
+		return ErrInvalidMFACode
+	}
+
+	log.Printf("usecases/user_usecase.go: user %s used a recovery code", userID)
+	return nil
+}
+
+// ResendVerification emails a new verification link for a pending
+// registration. Emails without one are ignored, like in ForgotPassword.
+func (u *userUsecase) ResendVerification(ctx context.Context, email string) error {
+	token, err := infrastructure.GenerateToken(email)
+	if err != nil {
+		return errors.New("usecases/user_usecase.go: ResendVerification " + err.Error())
+	}
+
+	err = u.UserRepository.RenewVerification(ctx, email, token, resendInterval, maxResends)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err == repository.ErrVerificationThrottled {
+		return ErrResendThrottled
+	}
+	if err != nil {
+		return errors.New("usecases/user_usecase.go: ResendVerification " + err.Error())
+	}
+
+	err = u.Mail.Queue(ctx, email, infrastructure.EmailVerification, infrastructure.VerificationEmail{
+		Link: infrastructure.VerificationLink(token),
+	})
+	if err != nil {
+		return errors.New("usecases/user_usecase.go: ResendVerification " + err.Error())
+	}
+
+	return nil
+}
+
+// ForgotPassword emails a password reset link when the email belongs to an
+// account. Whether it does is never told, so it can't be used to find out
+// who has an account.
+func (u *userUsecase) ForgotPassword(ctx context.Context, email string) error {
+	user, err := u.UserRepository.GetUserByEmail(ctx, email)
+	if err != nil {
+		log.Println("usecases/user_usecase.go: ForgotPassword " + err.Error())
+		return nil
+	}
+
+	token, err := infrastructure.NewOpaqueToken()
+	if err != nil {
+		return errors.New("usecases/user_usecase.go: ForgotPassword " + err.Error())
+	}
+
+	now := time.Now()
+	err = u.UserRepository.SavePasswordReset(ctx, domain.PasswordReset{
+		UserID:    user.ID.Hex(),
+		Hash:      infrastructure.HashToken(token),
+		ExpiresAt: now.Add(u.Config.ResetTTL),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return errors.New("usecases/user_usecase.go: ForgotPassword " + err.Error())
+	}
+
+	err = u.Mail.Queue(ctx, user.Email, infrastructure.EmailPasswordReset, infrastructure.PasswordResetEmail{
+		Link:     infrastructure.PasswordResetLink(token),
+		ValidFor: formatDuration(u.Config.ResetTTL),
+	})
+	if err != nil {
+		return errors.New("usecases/user_usecase.go: ForgotPassword " + err.Error())
+	}
+
+	return nil
+}
+
+// ResetPassword sets a new password with a reset link and logs the account
+// out everywhere, whoever knew the old password included. A lockout of the
+// account is lifted.
+func (u *userUsecase) ResetPassword(ctx context.Context, token, password string) error {
+	reset, err := u.UserRepository.ConsumePasswordReset(ctx, infrastructure.HashToken(token))
+	if err == mongo.ErrNoDocuments {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return errors.New("usecases/user_usecase.go: ResetPassword " + err.Error())
+	}
+
+	if err := u.setPassword(ctx, reset.UserID, password); err != nil {
+		return errors.New("usecases/user_usecase.go: ResetPassword " + err.Error())
+	}
+
+	if err := u.TokenRepository.RevokeUserTokens(ctx, reset.UserID); err != nil {
+		return errors.New("usecases/user_usecase.go: ResetPassword " + err.Error())
+	}
+
+	// the failures were made against the old password, a lockout they caused
+	// shouldn't keep the owner out of the new one
+	if err := u.LoginAttempts.Reset(ctx, "account:"+reset.UserID); err != nil {
+		log.Println("usecases/user_usecase.go: ResetPassword " + err.Error())
+	}
+
+	return nil
+}
+
+// ChangePassword replaces the password of a logged in user who knows the
+// current one, and logs out the other sessions.
+func (u *userUsecase) ChangePassword(ctx context.Context, userID, currentPassword, newPassword, accessID string) error {
+	user, err := u.UserRepository.GetUserByID(ctx, userID)
+	if err != nil {
+		return errors.New("usecases/user_usecase.go: ChangePassword " + err.Error())
+	}
+
+	if !infrastructure.ComparePassword(user.Password, currentPassword) {
+		return ErrWrongPassword
+	}
+
+	if err := u.setPassword(ctx, userID, newPassword); err != nil {
+		return errors.New("usecases/user_usecase.go: ChangePassword " + err.Error())
+	}
+
+	if err := u.TokenRepository.RevokeOtherSessions(ctx, userID, accessID); err != nil {
+		return errors.New("usecases/user_usecase.go: ChangePassword " + err.Error())
+	}
+
+	return nil
+}
+
+func (u *userUsecase) setPassword(ctx context.Context, userID, password string) error {
+	hashedPassword, err := infrastructure.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return u.UserRepository.UpdatePassword(ctx, userID, hashedPassword)
+}
+
+// formatDuration writes durations of emails like "1 hour" or "30 minutes".
+func formatDuration(duration time.Duration) string {
+	if duration >= time.Hour && duration%time.Hour == 0 {
+		if hours := int(duration.Hours()); hours != 1 {
+			return fmt.Sprintf("%d hours", hours)
+		}
+		return "1 hour"
+	}
+
+	if minutes := int(duration.Minutes()); minutes != 1 {
+		return fmt.Sprintf("%d minutes", minutes)
 	}
-	return token, nil
+	return "1 minute"
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"github/chera/fix-it/domain"
 	"github/chera/fix-it/repository"
+	"io"
 )
 
 type ViewUsecase interface {
@@ -13,6 +14,7 @@ type ViewUsecase interface {
 	GetSection(ctx context.Context, sectionID string, userID string) (domain.Section, error)
 
 	SectionList(ctx context.Context, userID string) ([]domain.Section, error)
+	OpenDocument(ctx context.Context, documentID string) (domain.Document, io.ReadSeekCloser, error)
 }
 
 type viewusecase struct {
@@ -44,3 +46,7 @@ func (v *viewusecase) GetExplanation(ctx context.Context, explanationID string)
 func (v *viewusecase) GetSection(ctx context.Context, sectionID string, userID string) (domain.Section, error) {
 	return v.ViewRepository.GetSection(ctx, sectionID, userID)
 }
+
+func (v *viewusecase) OpenDocument(ctx context.Context, documentID string) (domain.Document, io.ReadSeekCloser, error) {
+	return v.ViewRepository.OpenDocument(ctx, documentID)
+}
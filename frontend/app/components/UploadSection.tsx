@@ -1,6 +1,7 @@
 import { useState, useRef } from 'react';
 import { motion, AnimatePresence } from 'framer-motion';
 import Quiz from './Quiz';
+import { waitForJob } from '../lib/jobs';
 
 interface UploadSectionProps {
   onUploadSuccess?: (sectionId: string) => void;
@@ -140,11 +141,15 @@ export default function UploadSection({ onUploadSuccess }: UploadSectionProps) {
         throw new Error(data.message || 'Upload failed');
       }
 
+      const newSectionId = data.job_id
+        ? await waitForJob(baseUrl, token, data.job_id, setUploadProgress)
+        : data.section_id;
+
       // Store section ID and show success message
-      if (data.section_id) {
-        localStorage.setItem('lastSectionId', data.section_id);
-        setSectionId(data.section_id);
-        onUploadSuccess?.(data.section_id);
+      if (newSectionId) {
+        localStorage.setItem('lastSectionId', newSectionId);
+        setSectionId(newSectionId);
+        onUploadSuccess?.(newSectionId);
         setUploadSuccess(true);
       }
       
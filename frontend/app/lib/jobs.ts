@@ -0,0 +1,35 @@
+// Uploads are processed in the background; poll the job until it settles
+// and resolve with the id of the section it produced.
+export const waitForJob = async (
+  baseUrl: string,
+  token: string,
+  jobId: string,
+  onProgress?: (progress: number) => void
+): Promise<string> => {
+  for (;;) {
+    await new Promise((resolve) => setTimeout(resolve, 2000));
+
+    const response = await fetch(`${baseUrl}/a/jobs/${jobId}`, {
+      headers: {
+        'Authorization': `Bearer ${token}`,
+        'Accept': 'application/json'
+      }
+    });
+
+    const job = await response.json();
+
+    if (!response.ok) {
+      throw new Error(job.error || 'Failed to check upload status');
+    }
+
+    onProgress?.(job.progress);
+
+    if (job.status === 'succeeded') {
+      return job.section_id;
+    }
+
+    if (job.status === 'failed') {
+      throw new Error(job.error?.message || 'There is problem processing your pdf, try again!');
+    }
+  }
+};
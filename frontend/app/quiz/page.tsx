@@ -4,6 +4,7 @@ import { useState, useEffect } from 'react';
 import { motion, AnimatePresence } from 'framer-motion';
 import Link from 'next/link';
 import Image from 'next/image';
+import { waitForJob } from '../lib/jobs';
 
 interface Question {
   Question: string;
@@ -210,10 +211,14 @@ export default function QuizPage() {
         throw new Error(data.message || 'Upload failed');
       }
 
+      const newSectionId = data.job_id
+        ? await waitForJob(baseUrl, token, data.job_id, setUploadProgress)
+        : data.section_id;
+
       // Store section ID and fetch quiz
-      if (data.section_id) {
-        localStorage.setItem('lastSectionId', data.section_id);
-        await fetchQuiz(data.section_id);
+      if (newSectionId) {
+        localStorage.setItem('lastSectionId', newSectionId);
+        await fetchQuiz(newSectionId);
       }
       
       // Reset state